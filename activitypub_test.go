@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setupActivityPubTest opens a fresh database and serves the blog's actor
+// and inbox
+func setupActivityPubTest(t *testing.T) *httptest.Server {
+	t.Helper()
+	setupTestDB(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/activitypub/actor", handleActivityPubActor)
+	mux.HandleFunc("/activitypub/inbox", handleActivityPubInbox)
+	blog := httptest.NewServer(mux)
+	t.Cleanup(blog.Close)
+	return blog
+}
+
+// stubActor is another server's account with an inbox that records what the
+// blog delivers to it
+type stubActor struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu        sync.Mutex
+	status    int
+	delivered []map[string]interface{}
+	gone      bool
+
+	// documents are served as they are at other paths
+	documents map[string]interface{}
+}
+
+func newStubActor(t *testing.T) *stubActor {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubActor{t: t, key: key, status: http.StatusAccepted, documents: make(map[string]interface{})}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/actor", s.serveActor)
+	mux.HandleFunc("/inbox", s.serveInbox)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		doc, ok := s.documents[r.URL.Path]
+		s.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeActivityJSON(w, doc)
+	})
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *stubActor) id() string    { return s.server.URL + "/actor" }
+func (s *stubActor) inbox() string { return s.server.URL + "/inbox" }
+func (s *stubActor) keyID() string { return s.id() + "#main-key" }
+
+func (s *stubActor) setStatus(status int) {
+	s.mu.Lock()
+	s.status = status
+	s.mu.Unlock()
+}
+
+// deliveries returns the activities received since the last call
+func (s *stubActor) deliveries() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivered := s.delivered
+	s.delivered = nil
+	return delivered
+}
+
+func (s *stubActor) serveActor(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	gone := s.gone
+	s.mu.Unlock()
+	if gone {
+		http.Error(w, "Gone", http.StatusGone)
+		return
+	}
+	writeActivityJSON(w, map[string]interface{}{
+		"id":    s.id(),
+		"type":  "Person",
+		"inbox": s.inbox(),
+		"publicKey": activityPubKeyField{
+			ID:           s.keyID(),
+			Owner:        s.id(),
+			PublicKeyPem: s.publicKeyPEM(),
+		},
+	})
+}
+
+func (s *stubActor) publicKeyPEM() string {
+	der, err := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// serveInbox checks the blog's signature against the key its actor
+// publishes, as a remote server would, before recording the activity
+func (s *stubActor) serveInbox(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	signer, err := verifyActivityPubSignature(r, body, s.server.URL)
+	if err != nil {
+		s.t.Errorf("delivery with a bad signature: %v", err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var activity map[string]interface{}
+	if err := json.Unmarshal(body, &activity); err != nil {
+		s.t.Errorf("delivery is not JSON: %v", err)
+	}
+	if activity["actor"] != signer.ID {
+		s.t.Errorf("activity from %v signed by %s", activity["actor"], signer.ID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status < 300 {
+		s.delivered = append(s.delivered, activity)
+	}
+	w.WriteHeader(s.status)
+}
+
+// post sends an activity to an inbox, signed with the stub's key
+func (s *stubActor) post(inbox string, activity map[string]interface{}) int {
+	return s.postSigned(inbox, activity, s.keyID(), s.key)
+}
+
+func (s *stubActor) postSigned(inbox string, activity map[string]interface{}, keyID string, key *rsa.PrivateKey) int {
+	s.t.Helper()
+	body, _ := json.Marshal(activity)
+	req, err := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", activityJSONType)
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Digest", bodyDigest(body))
+	headers := []string{"(request-target)", "host", "date", "digest"}
+	hashed := sha256.Sum256([]byte(httpSigningString(headers, req.Method, req.URL.RequestURI(), req.URL.Host, req.Header)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(signature)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// follow makes the stub follow the blog and takes delivery of the Accept
+func (s *stubActor) follow(blog *httptest.Server) {
+	s.t.Helper()
+	status := s.post(blog.URL+"/activitypub/inbox", map[string]interface{}{
+		"id":     s.id() + "#follow",
+		"type":   "Follow",
+		"actor":  s.id(),
+		"object": activityPubActorURL(blog.URL),
+	})
+	if status != http.StatusAccepted {
+		s.t.Fatalf("Follow returned %d", status)
+	}
+	sendQueuedActivities()
+	s.deliveries()
+}
+
+func countFollowers(t *testing.T) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM activitypub_followers").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestActivityPubFollowAndUndo(t *testing.T) {
+	blog := setupActivityPubTest(t)
+	remote := newStubActor(t)
+	inbox := blog.URL + "/activitypub/inbox"
+	follow := map[string]interface{}{
+		"id":     remote.id() + "#follow",
+		"type":   "Follow",
+		"actor":  remote.id(),
+		"object": activityPubActorURL(blog.URL),
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := remote.postSigned(inbox, follow, remote.keyID(), otherKey); status != http.StatusUnauthorized {
+		t.Errorf("Follow signed with the wrong key returned %d", status)
+	}
+	if countFollowers(t) != 0 {
+		t.Fatal("follower added without a valid signature")
+	}
+
+	if status := remote.post(inbox, follow); status != http.StatusAccepted {
+		t.Fatalf("Follow returned %d", status)
+	}
+	var followerInbox, followID string
+	if err := db.QueryRow("SELECT inbox, follow_id FROM activitypub_followers WHERE actor_id = ?", remote.id()).Scan(&followerInbox, &followID); err != nil {
+		t.Fatalf("follower not stored: %v", err)
+	}
+	if followerInbox != remote.inbox() || followID != remote.id()+"#follow" {
+		t.Errorf("stored inbox %q and follow %q", followerInbox, followID)
+	}
+
+	sendQueuedActivities()
+	delivered := remote.deliveries()
+	if len(delivered) != 1 || delivered[0]["type"] != "Accept" {
+		t.Fatalf("expected an Accept, got %v", delivered)
+	}
+	if object, _ := delivered[0]["object"].(map[string]interface{}); object["id"] != remote.id()+"#follow" {
+		t.Errorf("Accept of %v", delivered[0]["object"])
+	}
+
+	// Unfollowing by the Follow's ID alone also counts
+	if status := remote.post(inbox, map[string]interface{}{
+		"id":     remote.id() + "#undo",
+		"type":   "Undo",
+		"actor":  remote.id(),
+		"object": remote.id() + "#follow",
+	}); status != http.StatusAccepted {
+		t.Fatalf("Undo returned %d", status)
+	}
+	if countFollowers(t) != 0 {
+		t.Error("follower not removed by Undo")
+	}
+
+	remote.follow(blog)
+	if status := remote.post(inbox, map[string]interface{}{
+		"id":     remote.id() + "#undo",
+		"type":   "Undo",
+		"actor":  remote.id(),
+		"object": follow,
+	}); status != http.StatusAccepted {
+		t.Fatalf("Undo returned %d", status)
+	}
+	if countFollowers(t) != 0 {
+		t.Error("follower not removed by Undo")
+	}
+
+	// A deleted account is removed once its server confirms it's gone. The
+	// unsigned Delete only queues one lookup, however often it's sent, and
+	// none for accounts that don't follow the blog.
+	remote.follow(blog)
+	remote.mu.Lock()
+	remote.gone = true
+	remote.mu.Unlock()
+	stranger := newStubActor(t)
+	for _, actor := range []*stubActor{remote, remote, stranger} {
+		if status := actor.post(inbox, map[string]interface{}{
+			"id":     actor.id() + "#delete",
+			"type":   "Delete",
+			"actor":  actor.id(),
+			"object": actor.id(),
+		}); status != http.StatusAccepted {
+			t.Fatalf("Delete returned %d", status)
+		}
+	}
+	if countFollowers(t) != 1 {
+		t.Error("follower removed before its server was asked")
+	}
+	if checked := runActivityPubGoneChecks(); checked != 1 {
+		t.Errorf("%d lookups queued, want 1", checked)
+	}
+	if countFollowers(t) != 0 {
+		t.Error("deleted follower not removed")
+	}
+}
+
+// runActivityPubGoneChecks runs the queued lookups of deleted followers and
+// returns how many there were
+func runActivityPubGoneChecks() int {
+	for n := 0; ; n++ {
+		select {
+		case c := <-activityPubGoneChecks:
+			checkActivityPubGone(c)
+		default:
+			return n
+		}
+	}
+}
+
+// TestActivityPubRejectsForgedActors checks that one server can't pass off
+// its key as belonging to an account on another
+func TestActivityPubRejectsForgedActors(t *testing.T) {
+	blog := setupActivityPubTest(t)
+	alice := newStubActor(t)
+	alice.follow(blog)
+	evil := newStubActor(t)
+	inbox := blog.URL + "/activitypub/inbox"
+
+	// An actor document claiming to be Alice, with the attacker's key
+	evil.documents["/alice"] = map[string]interface{}{
+		"id":    alice.id(),
+		"type":  "Person",
+		"inbox": evil.inbox(),
+		"publicKey": activityPubKeyField{
+			ID:           evil.server.URL + "/alice#main-key",
+			Owner:        alice.id(),
+			PublicKeyPem: evil.publicKeyPEM(),
+		},
+	}
+	// A key document naming Alice as its owner
+	evil.documents["/key"] = map[string]interface{}{
+		"id":           evil.server.URL + "/key",
+		"owner":        alice.id(),
+		"publicKeyPem": evil.publicKeyPEM(),
+	}
+
+	for _, keyID := range []string{evil.server.URL + "/alice#main-key", evil.server.URL + "/key"} {
+		follow := map[string]interface{}{
+			"id":     alice.id() + "#forged-follow",
+			"type":   "Follow",
+			"actor":  alice.id(),
+			"object": activityPubActorURL(blog.URL),
+		}
+		if status := evil.postSigned(inbox, follow, keyID, evil.key); status != http.StatusUnauthorized {
+			t.Errorf("Follow signed with %s returned %d", keyID, status)
+		}
+		undo := map[string]interface{}{
+			"id":     alice.id() + "#forged-undo",
+			"type":   "Undo",
+			"actor":  alice.id(),
+			"object": alice.id() + "#follow",
+		}
+		if status := evil.postSigned(inbox, undo, keyID, evil.key); status != http.StatusUnauthorized {
+			t.Errorf("Undo signed with %s returned %d", keyID, status)
+		}
+	}
+
+	var followerInbox string
+	if err := db.QueryRow("SELECT inbox FROM activitypub_followers WHERE actor_id = ?", alice.id()).Scan(&followerInbox); err != nil {
+		t.Fatalf("Alice's follow was removed: %v", err)
+	}
+	if followerInbox != alice.inbox() {
+		t.Errorf("Alice's inbox replaced with %s", followerInbox)
+	}
+}
+
+func TestActivityPubEntryDelivery(t *testing.T) {
+	blog := setupActivityPubTest(t)
+	remote := newStubActor(t)
+	remote.follow(blog)
+
+	result, err := db.Exec("INSERT INTO entries (title, content, photo_path, media_type, slug, content_format) VALUES ('Hello', 'First post', '', 'photo', 'hello', 'markdown')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	entryID := int(id)
+	objectID := activityPubObjectURL(blog.URL, entryID)
+
+	expect := func(activityType string) map[string]interface{} {
+		t.Helper()
+		queueEntryActivities(entryID, blog.URL)
+		sendQueuedActivities()
+		delivered := remote.deliveries()
+		if len(delivered) != 1 {
+			t.Fatalf("expected one %s, got %v", activityType, delivered)
+		}
+		activity := delivered[0]
+		if activity["type"] != activityType || activity["actor"] != activityPubActorURL(blog.URL) {
+			t.Fatalf("expected a %s from the blog, got %v", activityType, activity)
+		}
+		object, _ := activity["object"].(map[string]interface{})
+		if object["id"] != objectID {
+			t.Errorf("%s of %v, want %s", activityType, object["id"], objectID)
+		}
+		return object
+	}
+
+	if note := expect("Create"); note["type"] != "Note" || !strings.Contains(fmt.Sprint(note["content"]), "First post") {
+		t.Errorf("unexpected Create object %v", note)
+	}
+
+	if _, err := db.Exec("UPDATE entries SET content = 'Edited post' WHERE id = ?", entryID); err != nil {
+		t.Fatal(err)
+	}
+	if note := expect("Update"); !strings.Contains(fmt.Sprint(note["content"]), "Edited post") {
+		t.Errorf("unexpected Update object %v", note)
+	}
+
+	if _, err := db.Exec("UPDATE entries SET status = 'draft' WHERE id = ?", entryID); err != nil {
+		t.Fatal(err)
+	}
+	if tombstone := expect("Delete"); tombstone["type"] != "Tombstone" {
+		t.Errorf("unexpected Delete object %v", tombstone)
+	}
+
+	// Publishing it again is a new post; deleting it takes it back down
+	if _, err := db.Exec("UPDATE entries SET status = 'published' WHERE id = ?", entryID); err != nil {
+		t.Fatal(err)
+	}
+	expect("Create")
+	if _, err := db.Exec("DELETE FROM entries WHERE id = ?", entryID); err != nil {
+		t.Fatal(err)
+	}
+	expect("Delete")
+
+	queueEntryActivities(entryID, blog.URL)
+	sendQueuedActivities()
+	if delivered := remote.deliveries(); len(delivered) != 0 {
+		t.Errorf("deleted entry announced again: %v", delivered)
+	}
+}
+
+type queuedDelivery struct {
+	status      string
+	attempts    int
+	nextAttempt time.Time
+}
+
+func getQueuedDelivery(t *testing.T, id int64) queuedDelivery {
+	t.Helper()
+	var d queuedDelivery
+	if err := db.QueryRow("SELECT status, attempts, next_attempt_at FROM activitypub_deliveries WHERE id = ?", id).Scan(&d.status, &d.attempts, &d.nextAttempt); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestActivityPubDeliveryRetries(t *testing.T) {
+	blog := setupActivityPubTest(t)
+	remote := newStubActor(t)
+	remote.follow(blog)
+
+	queue := func() int64 {
+		t.Helper()
+		queueActivityForFollowers(newEntryActivity("Create", map[string]string{"id": "x", "type": "Note"}, "x", blog.URL))
+		var id int64
+		if err := db.QueryRow("SELECT MAX(id) FROM activitypub_deliveries").Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	makeDue := func(id int64) {
+		t.Helper()
+		if _, err := db.Exec("UPDATE activitypub_deliveries SET next_attempt_at = ? WHERE id = ?", time.Now().UTC().Add(-time.Second).Format(sqliteTimeLayout), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Server errors are retried after 4, 16, 64... minutes
+	remote.setStatus(http.StatusServiceUnavailable)
+	id := queue()
+	for attempt, backoff := range []time.Duration{4 * time.Minute, 16 * time.Minute} {
+		before := time.Now().UTC()
+		sendQueuedActivities()
+		d := getQueuedDelivery(t, id)
+		if d.status != "pending" || d.attempts != attempt+1 {
+			t.Fatalf("after attempt %d: %+v", attempt+1, d)
+		}
+		if wait := d.nextAttempt.Sub(before); wait < backoff-time.Second || wait > backoff+2*time.Second {
+			t.Errorf("after attempt %d: retry in %v, want %v", attempt+1, wait, backoff)
+		}
+
+		// Nothing is sent again before it's due
+		sendQueuedActivities()
+		if d := getQueuedDelivery(t, id); d.attempts != attempt+1 {
+			t.Fatalf("retried early: %+v", d)
+		}
+		makeDue(id)
+	}
+
+	remote.setStatus(http.StatusAccepted)
+	sendQueuedActivities()
+	if d := getQueuedDelivery(t, id); d.status != "sent" || d.attempts != 3 {
+		t.Errorf("after a successful retry: %+v", d)
+	}
+	if delivered := remote.deliveries(); len(delivered) != 1 {
+		t.Errorf("expected one delivery, got %d", len(delivered))
+	}
+
+	// Rate limits count as failures too, and the queue gives up eventually
+	remote.setStatus(http.StatusTooManyRequests)
+	id = queue()
+	if _, err := db.Exec("UPDATE activitypub_deliveries SET attempts = ? WHERE id = ?", maxActivityPubAttempts-1, id); err != nil {
+		t.Fatal(err)
+	}
+	sendQueuedActivities()
+	if d := getQueuedDelivery(t, id); d.status != "failed" || d.attempts != maxActivityPubAttempts {
+		t.Errorf("after the last attempt: %+v", d)
+	}
+
+	// Other client errors aren't retried
+	remote.setStatus(http.StatusBadRequest)
+	id = queue()
+	sendQueuedActivities()
+	if d := getQueuedDelivery(t, id); d.status != "failed" || d.attempts != 1 {
+		t.Errorf("after a rejected delivery: %+v", d)
+	}
+
+	// A gone inbox drops the follower
+	remote.setStatus(http.StatusGone)
+	id = queue()
+	sendQueuedActivities()
+	if d := getQueuedDelivery(t, id); d.status != "gone" {
+		t.Errorf("after the inbox was gone: %+v", d)
+	}
+	if countFollowers(t) != 0 {
+		t.Error("follower with a gone inbox not removed")
+	}
+
+	// Finished deliveries are forgotten after 30 days
+	old := time.Now().UTC().Add(-31 * 24 * time.Hour).Format(sqliteTimeLayout)
+	if _, err := db.Exec("UPDATE activitypub_deliveries SET created_at = ?", old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE activitypub_deliveries SET status = 'pending', next_attempt_at = ? WHERE id = ?", time.Now().UTC().Add(time.Hour).Format(sqliteTimeLayout), id); err != nil {
+		t.Fatal(err)
+	}
+	sendQueuedActivities()
+	var left int
+	if err := db.QueryRow("SELECT COUNT(*) FROM activitypub_deliveries").Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 1 {
+		t.Errorf("%d deliveries left after pruning, want only the pending one", left)
+	}
+}
@@ -155,7 +155,9 @@ const adminTemplate = `<!DOCTYPE html>
             cursor: pointer;
         }
         input[type="text"],
-        input[type="password"] {
+        input[type="password"],
+        input[type="datetime-local"],
+        select {
             width: 100%;
             padding: 12px 16px;
             border: 1px solid #dbdbdb;
@@ -166,11 +168,15 @@ const adminTemplate = `<!DOCTYPE html>
             transition: border-color 0.2s, background-color 0.2s;
         }
         input[type="text"]:hover,
-        input[type="password"]:hover {
+        input[type="password"]:hover,
+        input[type="datetime-local"]:hover,
+        select:hover {
             border-color: #a8a8a8;
         }
         input[type="text"]:focus,
-        input[type="password"]:focus {
+        input[type="password"]:focus,
+        input[type="datetime-local"]:focus,
+        select:focus {
             outline: none;
             border-color: #0095f6;
             background-color: #ffffff;
@@ -218,6 +224,16 @@ const adminTemplate = `<!DOCTYPE html>
         .preview-container.active { display: block; }
         .preview-image { max-width: 100%; height: auto; border-radius: 4px; display: block; }
 
+        /* Media items */
+        .media-alt-inputs input { margin-top: 8px; }
+        .media-list { display: flex; flex-direction: column; gap: 8px; margin-top: 8px; }
+        .media-item { display: flex; align-items: center; gap: 10px; padding: 8px; border: 1px solid #dbdbdb; border-radius: 8px; }
+        .media-item.removed { opacity: 0.4; }
+        .media-item-preview { width: 48px; height: 48px; flex-shrink: 0; border-radius: 4px; object-fit: cover; background: #f0f0f0; display: flex; align-items: center; justify-content: center; font-size: 11px; color: #8e8e8e; }
+        .media-item input[type="text"] { flex: 1; min-width: 0; padding: 8px 10px; font-size: 13px; }
+        .media-item button { padding: 6px 10px; font-size: 12px; background-color: #efefef; color: #262626; }
+        .media-item label { display: flex; align-items: center; gap: 4px; font-size: 12px; color: #8e8e8e; margin: 0; font-weight: normal; white-space: nowrap; }
+
         /* Buttons */
         button, .btn {
             background-color: #000000;
@@ -270,6 +286,48 @@ const adminTemplate = `<!DOCTYPE html>
             max-height: 80px;
             overflow: hidden;
         }
+        .admin-search {
+            display: flex;
+            gap: 8px;
+            margin-bottom: 16px;
+        }
+        .admin-search input[type="text"] {
+            flex: 1;
+        }
+        .entry-tag-list {
+            display: flex;
+            flex-wrap: wrap;
+            gap: 6px;
+            margin-bottom: 12px;
+        }
+        .entry-tag-badge {
+            font-size: 12px;
+            color: #0095f6;
+            background-color: #e8f4fd;
+            padding: 2px 8px;
+            border-radius: 10px;
+            text-decoration: none;
+        }
+        .entry-status-badge {
+            display: inline-block;
+            font-size: 12px;
+            font-weight: 600;
+            padding: 2px 8px;
+            border-radius: 10px;
+            margin-top: 6px;
+        }
+        .entry-status-badge.draft {
+            color: #856404;
+            background-color: #fff3cd;
+        }
+        .entry-status-badge.scheduled {
+            color: #0c5460;
+            background-color: #d1ecf1;
+        }
+        .entry-status-badge.unlisted {
+            color: #555;
+            background-color: #f0f0f0;
+        }
         .entry-media-badge {
             display: inline-flex;
             align-items: center;
@@ -290,7 +348,38 @@ const adminTemplate = `<!DOCTYPE html>
             object-fit: cover;
         }
         .entry-actions { display: flex; gap: 8px; }
-        .entry-actions button { flex: 1; padding: 8px 16px; font-size: 13px; }
+        .entry-actions button, .entry-actions .btn { flex: 1; padding: 8px 16px; font-size: 13px; text-align: center; }
+
+        /* Revision History */
+        .revision-layout { display: flex; gap: 24px; align-items: flex-start; }
+        .revision-list { width: 240px; flex-shrink: 0; list-style: none; border: 1px solid #dbdbdb; border-radius: 8px; overflow: hidden; }
+        .revision-list a { display: block; padding: 12px 16px; color: #262626; text-decoration: none; font-size: 13px; border-bottom: 1px solid #efefef; }
+        .revision-list li:last-child a { border-bottom: none; }
+        .revision-list a:hover { background-color: #fafafa; }
+        .revision-list a.active { background-color: #e8f4fd; }
+        .revision-list .revision-title { display: block; color: #8e8e8e; margin-top: 2px; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+        .revision-detail { flex: 1; min-width: 0; }
+        .revision-notes { font-size: 13px; color: #8e8e8e; margin-bottom: 12px; }
+
+        /* Webmentions */
+        .webmention-filters { display: flex; gap: 8px; margin-bottom: 16px; }
+        .webmention-filters a { font-size: 13px; padding: 4px 12px; border-radius: 14px; color: #262626; background-color: #f0f0f0; text-decoration: none; }
+        .webmention-filters a.active { color: #ffffff; background-color: #0095f6; }
+        .webmention-source { font-size: 13px; word-break: break-all; margin-bottom: 8px; }
+        .webmention-source a { color: #0095f6; text-decoration: none; }
+        .entry-actions form { flex: 1; display: flex; }
+        .entry-actions form button { flex: 1; }
+        .diff { border: 1px solid #dbdbdb; border-radius: 8px; overflow: hidden; font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; font-size: 13px; margin-bottom: 16px; }
+        .diff-line { padding: 2px 12px; white-space: pre-wrap; word-break: break-word; min-height: 1.4em; }
+        .diff-line.added { background-color: #e6ffed; color: #22863a; }
+        .diff-line.removed { background-color: #ffeef0; color: #b31d28; }
+        .diff-line.added::before { content: "+ "; }
+        .diff-line.removed::before { content: "- "; }
+        .diff-line.same::before { content: "  "; }
+        @media (max-width: 768px) {
+            .revision-layout { flex-direction: column; }
+            .revision-list { width: 100%; }
+        }
 
         /* Pagination */
         .pagination {
@@ -508,12 +597,27 @@ const adminTemplate = `<!DOCTYPE html>
                             All Posts
                         </a>
                     </li>
+                    <li>
+                        <a href="/admin?view=drafts" class="{{if eq .View "drafts"}}active{{end}}">
+                            <svg viewBox="0 0 24 24"><circle cx="12" cy="12" r="10"></circle><polyline points="12 6 12 12 16 14"></polyline></svg>
+                            Drafts &amp; Scheduled
+                        </a>
+                    </li>
+                    {{if .User.CanPublish}}
+                    <li>
+                        <a href="/admin?view=webmentions" class="{{if eq .View "webmentions"}}active{{end}}">
+                            <svg viewBox="0 0 24 24"><path d="M21 11.5a8.38 8.38 0 0 1-.9 3.8 8.5 8.5 0 0 1-7.6 4.7 8.38 8.38 0 0 1-3.8-.9L3 21l1.9-5.7a8.38 8.38 0 0 1-.9-3.8 8.5 8.5 0 0 1 4.7-7.6 8.38 8.38 0 0 1 3.8-.9h.5a8.48 8.48 0 0 1 8 8v.5z"></path></svg>
+                            Webmentions
+                        </a>
+                    </li>
+                    {{end}}
                 </ul>
             </div>
 
             <div class="sidebar-section">
                 <div class="sidebar-section-title">Settings</div>
                 <ul class="sidebar-nav">
+                    {{if .User.CanChangeSettings}}
                     <li>
                         <a href="/admin/settings">
                             <svg viewBox="0 0 24 24"><path d="M3 9l9-7 9 7v11a2 2 0 0 1-2 2H5a2 2 0 0 1-2-2z"></path><polyline points="9 22 9 12 15 12 15 22"></polyline></svg>
@@ -526,18 +630,21 @@ const adminTemplate = `<!DOCTYPE html>
                             Appearance
                         </a>
                     </li>
+                    {{end}}
                     <li>
                         <a href="/admin/settings/security">
                             <svg viewBox="0 0 24 24"><rect x="3" y="11" width="18" height="11" rx="2" ry="2"></rect><path d="M7 11V7a5 5 0 0 1 10 0v4"></path></svg>
                             Security
                         </a>
                     </li>
+                    {{if .User.IsOwner}}
                     <li>
                         <a href="/admin/settings/backup">
                             <svg viewBox="0 0 24 24"><path d="M21 15v4a2 2 0 0 1-2 2H5a2 2 0 0 1-2-2v-4"></path><polyline points="7 10 12 15 17 10"></polyline><line x1="12" y1="15" x2="12" y2="3"></line></svg>
                             Backup
                         </a>
                     </li>
+                    {{end}}
                 </ul>
             </div>
 
@@ -563,17 +670,33 @@ const adminTemplate = `<!DOCTYPE html>
             <div class="message {{.MessageType}}">{{.Message}}</div>
             {{end}}
 
-            {{if eq .View "posts"}}
-            <!-- Posts List View -->
+            {{if or (eq .View "posts") (eq .View "drafts")}}
+            <!-- Posts List View (all posts, or drafts and scheduled posts) -->
             <div class="content-header">
-                <h1>Posts</h1>
+                <h1>{{.PageTitle}}</h1>
                 <a href="/admin?view=new" class="btn btn-primary">+ New Post</a>
             </div>
 
             <div class="content-container">
+            {{if eq .View "posts"}}
+            <form method="GET" action="/admin" class="admin-search">
+                <input type="hidden" name="view" value="posts">
+                <input type="text" name="q" value="{{.SearchQuery}}" placeholder="Search posts">
+                <button type="submit" class="btn">Search</button>
+                {{if .SearchQuery}}<a href="/admin?view=posts" class="btn btn-secondary">Clear</a>{{end}}
+            </form>
+            {{end}}
             <div class="pagination-info">
+                {{if eq .View "drafts"}}
                 {{if .TotalEntries}}
-                Showing {{.StartEntry}}-{{.EndEntry}} of {{.TotalEntries}} posts
+                {{.TotalEntries}} unpublished post{{if ne .TotalEntries 1}}s{{end}}
+                {{else}}
+                No drafts or scheduled posts
+                {{end}}
+                {{else if .TotalEntries}}
+                Showing {{.StartEntry}}-{{.EndEntry}} of {{.TotalEntries}} posts{{if .SearchQuery}} matching &ldquo;{{.SearchQuery}}&rdquo;{{end}}
+                {{else if .SearchQuery}}
+                No posts matching &ldquo;{{.SearchQuery}}&rdquo;
                 {{else}}
                 No posts yet
                 {{end}}
@@ -586,10 +709,22 @@ const adminTemplate = `<!DOCTYPE html>
                     <div class="entry-header">
                         <div>
                             <div class="entry-title">{{if .Title}}{{.Title}}{{else}}Untitled{{end}}</div>
-                            <div class="entry-meta">{{.CreatedAt.Format "Jan 2, 2006 at 3:04 PM"}}</div>
+                            <div class="entry-meta">{{.CreatedAt.Format "Jan 2, 2006 at 3:04 PM"}}{{if .Author}} &middot; {{.Author}}{{end}}</div>
+                            {{if eq .Status "draft"}}
+                            <span class="entry-status-badge draft">Draft</span>
+                            {{else if .PublishAt}}
+                            <span class="entry-status-badge scheduled">Scheduled for {{.PublishAt.Format "Jan 2, 2006 at 3:04 PM"}}</span>
+                            {{else if eq .Status "unlisted"}}
+                            <span class="entry-status-badge unlisted">Unlisted</span>
+                            {{end}}
                         </div>
                     </div>
                     <div class="entry-content">{{.Content}}</div>
+                    {{if .Tags}}
+                    <div class="entry-tag-list">
+                        {{range .Tags}}<a href="/tags/{{.}}/" class="entry-tag-badge" target="_blank">#{{.}}</a>{{end}}
+                    </div>
+                    {{end}}
                     {{if .PhotoPath}}
                     <div class="entry-media-badge">
                         {{if eq .MediaType "video"}}
@@ -602,13 +737,15 @@ const adminTemplate = `<!DOCTYPE html>
                         <svg viewBox="0 0 24 24" width="14" height="14" stroke="currentColor" fill="none" stroke-width="2"><rect x="3" y="3" width="18" height="18" rx="2" ry="2"></rect><circle cx="8.5" cy="8.5" r="1.5"></circle><polyline points="21 15 16 10 5 21"></polyline></svg>
                         Photo
                         {{end}}
+                        {{if gt (len .Media) 1}}&middot; {{len .Media}} items{{end}}
                     </div>
                     {{if and .PhotoPath (or (eq .MediaType "photo") (eq .MediaType ""))}}
                     <img src="/uploads/{{.PhotoPath}}" alt="" class="entry-photo">
                     {{end}}
                     {{end}}
                     <div class="entry-actions">
-                        <button onclick="openEditModal({{.ID}}, '{{jsEscape .Content}}')">Edit</button>
+                        <button onclick="openEditModal({{.ID}}, '{{jsEscape .Content}}', '{{joinTags .Tags}}', '{{.Status}}', '{{isoTime .PublishAt}}', '{{if .SlugPinned}}{{.Slug}}{{end}}', {{.Media}})">Edit</button>
+                        <a href="/admin?view=revisions&id={{.ID}}" class="btn btn-secondary">History</a>
                         <button class="btn-danger" onclick="openDeleteModal({{.ID}})">Delete</button>
                     </div>
                 </div>
@@ -618,7 +755,7 @@ const adminTemplate = `<!DOCTYPE html>
                     <svg viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="1">
                         <path d="M12 20h9M16.5 3.5a2.121 2.121 0 0 1 3 3L7 19l-4 1 1-4L16.5 3.5z"></path>
                     </svg>
-                    <p>No posts yet. Create your first post!</p>
+                    <p>{{if eq .View "drafts"}}Nothing waiting to be published.{{else}}No posts yet. Create your first post!{{end}}</p>
                     <a href="/admin?view=new" class="btn" style="margin-top: 16px;">Create Post</a>
                 </div>
                 {{end}}
@@ -627,7 +764,7 @@ const adminTemplate = `<!DOCTYPE html>
             {{if and .Entries (gt .TotalPages 1)}}
             <div class="pagination">
                 {{if gt .CurrentPage 1}}
-                <a href="/admin?view=posts&page={{subtract .CurrentPage 1}}">Previous</a>
+                <a href="/admin?view=posts&page={{subtract .CurrentPage 1}}{{if .SearchQuery}}&q={{.SearchQuery}}{{end}}">Previous</a>
                 {{else}}
                 <span class="disabled">Previous</span>
                 {{end}}
@@ -636,12 +773,12 @@ const adminTemplate = `<!DOCTYPE html>
                 {{if eq . $.CurrentPage}}
                 <span class="active">{{.}}</span>
                 {{else}}
-                <a href="/admin?view=posts&page={{.}}">{{.}}</a>
+                <a href="/admin?view=posts&page={{.}}{{if $.SearchQuery}}&q={{$.SearchQuery}}{{end}}">{{.}}</a>
                 {{end}}
                 {{end}}
 
                 {{if lt .CurrentPage .TotalPages}}
-                <a href="/admin?view=posts&page={{add .CurrentPage 1}}">Next</a>
+                <a href="/admin?view=posts&page={{add .CurrentPage 1}}{{if .SearchQuery}}&q={{.SearchQuery}}{{end}}">Next</a>
                 {{else}}
                 <span class="disabled">Next</span>
                 {{end}}
@@ -649,6 +786,114 @@ const adminTemplate = `<!DOCTYPE html>
             {{end}}
             </div>
 
+            {{else if eq .View "revisions"}}
+            <!-- Revision History View -->
+            <div class="content-header">
+                <h1>Revision History</h1>
+                <a href="/admin?view=posts" class="btn btn-secondary">&larr; All Posts</a>
+            </div>
+
+            <div class="content-container">
+                <div class="pagination-info">
+                    <strong>{{if .RevisionEntry.Title}}{{.RevisionEntry.Title}}{{else}}Untitled{{end}}</strong>
+                    &middot; <a href="/posts/{{.RevisionEntry.Slug}}/" target="_blank">View post</a>
+                </div>
+
+                {{if .Revisions}}
+                <div class="revision-layout">
+                    <ul class="revision-list">
+                        {{range .Revisions}}
+                        <li>
+                            <a href="/admin?view=revisions&id={{.EntryID}}&rev={{.ID}}" class="{{if eq .ID $.SelectedRevision.ID}}active{{end}}">
+                                {{.CreatedAt.Format "Jan 2, 2006 at 3:04 PM"}}
+                                <span class="revision-title">{{if .Title}}{{.Title}}{{else}}Untitled{{end}}</span>
+                            </a>
+                        </li>
+                        {{end}}
+                    </ul>
+
+                    {{with .SelectedRevision}}
+                    <div class="revision-detail">
+                        <div class="revision-notes">
+                            Changes from the version replaced on {{.CreatedAt.Format "Jan 2, 2006 at 3:04 PM"}} to the current version.
+                            {{if $.RevisionMedia}}<br>Media differs from the current version ({{len .Media}} item{{if ne (len .Media) 1}}s{{end}} in this revision).{{end}}
+                            {{if ne (joinTags .Tags) (joinTags $.RevisionEntry.Tags)}}<br>Tags in this revision: {{if .Tags}}{{joinTags .Tags}}{{else}}none{{end}}{{end}}
+                        </div>
+                        <div class="diff">
+                            {{range $.RevisionDiff}}<div class="diff-line {{.Op}}">{{.Text}}</div>{{end}}
+                        </div>
+                        <form method="POST" action="/admin/revisions/restore" onsubmit="return confirm('Restore this revision? The current version will be kept in the history.');">
+                            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                            <input type="hidden" name="revision_id" value="{{.ID}}">
+                            <button type="submit" class="btn-primary">Restore this revision</button>
+                        </form>
+                    </div>
+                    {{end}}
+                </div>
+                {{else}}
+                <div class="empty-state">
+                    <p>No earlier versions yet. A revision is saved each time this post is edited.</p>
+                </div>
+                {{end}}
+            </div>
+
+            {{else if eq .View "webmentions"}}
+            <!-- Webmentions View -->
+            <div class="content-header">
+                <h1>Webmentions</h1>
+            </div>
+
+            <div class="content-container">
+                <div class="webmention-filters">
+                    <a href="/admin?view=webmentions" class="{{if eq .WebmentionFilter "pending"}}active{{end}}">Pending</a>
+                    <a href="/admin?view=webmentions&status=approved" class="{{if eq .WebmentionFilter "approved"}}active{{end}}">Approved</a>
+                    <a href="/admin?view=webmentions&status=rejected" class="{{if eq .WebmentionFilter "rejected"}}active{{end}}">Rejected</a>
+                </div>
+
+                {{if .Webmentions}}
+                {{range .Webmentions}}
+                <div class="entry-card">
+                    <div class="entry-header">
+                        <div>
+                            <div class="entry-title">{{.AuthorName}} {{if eq .Type "reply"}}replied to{{else if eq .Type "like"}}liked{{else if eq .Type "repost"}}reposted{{else if eq .Type "bookmark"}}bookmarked{{else}}mentioned{{end}} {{if .EntryTitle}}&ldquo;{{.EntryTitle}}&rdquo;{{else}}a post{{end}}</div>
+                            <div class="entry-meta">{{.CreatedAt.Format "Jan 2, 2006 at 3:04 PM"}}{{if .EntrySlug}} &middot; <a href="/posts/{{.EntrySlug}}/" target="_blank">View post</a>{{end}}</div>
+                        </div>
+                    </div>
+                    <div class="webmention-source"><a href="{{.Source}}" target="_blank" rel="noopener noreferrer nofollow">{{.Source}}</a></div>
+                    {{if .Content}}<div class="entry-content">{{.Content}}</div>{{end}}
+                    <div class="entry-actions">
+                        {{if ne .Status "approved"}}
+                        <form method="POST" action="/admin/webmentions/moderate">
+                            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                            <input type="hidden" name="id" value="{{.ID}}">
+                            <input type="hidden" name="filter" value="{{$.WebmentionFilter}}">
+                            <button type="submit" name="action" value="approve" class="btn-primary">Approve</button>
+                        </form>
+                        {{end}}
+                        {{if ne .Status "rejected"}}
+                        <form method="POST" action="/admin/webmentions/moderate">
+                            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                            <input type="hidden" name="id" value="{{.ID}}">
+                            <input type="hidden" name="filter" value="{{$.WebmentionFilter}}">
+                            <button type="submit" name="action" value="reject" class="btn-secondary">Reject</button>
+                        </form>
+                        {{end}}
+                        <form method="POST" action="/admin/webmentions/moderate" onsubmit="return confirm('Delete this webmention?');">
+                            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                            <input type="hidden" name="id" value="{{.ID}}">
+                            <input type="hidden" name="filter" value="{{$.WebmentionFilter}}">
+                            <button type="submit" name="action" value="delete" class="btn-danger">Delete</button>
+                        </form>
+                    </div>
+                </div>
+                {{end}}
+                {{else}}
+                <div class="empty-state">
+                    <p>{{if eq .WebmentionFilter "pending"}}No webmentions waiting for review. Replies, likes, and mentions from other sites show up here.{{else}}No {{.WebmentionFilter}} webmentions.{{end}}</p>
+                </div>
+                {{end}}
+            </div>
+
             {{else if eq .View "new"}}
             <!-- New Post View -->
             <div class="content-header">
@@ -657,12 +902,42 @@ const adminTemplate = `<!DOCTYPE html>
 
             <div class="content-container">
                 <form method="POST" action="/admin/create" enctype="multipart/form-data" id="createForm">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                     <div class="form-group">
-                        <label for="content">Content (max 2000 characters)</label>
+                        <label for="content">Content (max 2000 characters, Markdown supported)</label>
                         <textarea name="content" id="content" maxlength="2000" placeholder="What's on your mind?" required></textarea>
                         <div class="file-info"><span id="charCount">0</span> / 2000 characters</div>
                     </div>
 
+                    <div class="form-group">
+                        <label for="tags">Tags (optional)</label>
+                        <input type="text" name="tags" id="tags" placeholder="travel, photography">
+                        <div class="file-info">Comma-separated, up to 10 tags</div>
+                    </div>
+
+                    <div class="form-group">
+                        <label for="slug">Custom URL (optional)</label>
+                        <input type="text" name="slug" id="slug" placeholder="my-post" maxlength="80">
+                        <div class="file-info">/posts/&lt;custom-url&gt;/ &mdash; leave empty to generate it from the first line. A custom URL stays the same when you edit the post.</div>
+                    </div>
+
+                    <div class="form-group">
+                        <label for="status">Visibility</label>
+                        <select name="status" id="status" onchange="togglePublishAt('status', 'publishAtGroup')">
+                            <option value="published">Published</option>
+                            <option value="draft">Draft</option>
+                            <option value="unlisted">Unlisted (only people with the link)</option>
+                        </select>
+                        {{if not .User.CanPublish}}<div class="file-info">New posts are saved as drafts until an editor publishes them.</div>{{end}}
+                    </div>
+
+                    <div class="form-group" id="publishAtGroup">
+                        <label for="publishAt">Schedule (optional)</label>
+                        <input type="datetime-local" name="publish_at" id="publishAt">
+                        <input type="hidden" name="timezone_offset" class="timezoneOffset">
+                        <div class="file-info">Leave empty to publish immediately</div>
+                    </div>
+
                     <div class="form-group">
                         <label>Media (optional)</label>
                         <div style="display: flex; gap: 20px; margin-top: 8px;">
@@ -684,7 +959,7 @@ const adminTemplate = `<!DOCTYPE html>
                     </div>
 
                     <div class="form-group" id="fileUploadGroup">
-                        <input type="file" name="media" id="media" accept="image/*" style="display: none;">
+                        <input type="file" name="media" id="media" accept="image/*" multiple style="display: none;">
                         <div class="custom-file-upload" id="fileUploadBtn" onclick="document.getElementById('media').click()">
                             <svg width="20" height="20" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round" style="margin-right: 8px;">
                                 <path d="M21 15v4a2 2 0 0 1-2 2H5a2 2 0 0 1-2-2v-4"></path>
@@ -696,6 +971,8 @@ const adminTemplate = `<!DOCTYPE html>
                         <div class="preview-container" id="photoPreview">
                             <img src="" alt="Preview" class="preview-image" id="previewImg">
                         </div>
+                        <div class="file-info">Select several files to post a gallery</div>
+                        <div class="media-alt-inputs" id="mediaAltInputs"></div>
                     </div>
 
                     <!-- Audio Source Options -->
@@ -772,12 +1049,38 @@ const adminTemplate = `<!DOCTYPE html>
         <div class="modal-content">
             <div class="modal-header"><h2>Edit Post</h2></div>
             <form method="POST" action="/admin/update" enctype="multipart/form-data">
+                <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                 <input type="hidden" name="id" id="editId">
                 <div class="form-group">
-                    <label for="editContent">Content (max 2000 characters)</label>
+                    <label for="editContent">Content (max 2000 characters, Markdown supported)</label>
                     <textarea name="content" id="editContent" maxlength="2000" required></textarea>
                     <div class="file-info"><span id="editCharCount">0</span> / 2000 characters</div>
                 </div>
+                <div class="form-group">
+                    <label for="editTags">Tags (optional)</label>
+                    <input type="text" name="tags" id="editTags" placeholder="travel, photography">
+                    <div class="file-info">Comma-separated, up to 10 tags</div>
+                </div>
+                <div class="form-group">
+                    <label for="editSlug">Custom URL (optional)</label>
+                    <input type="text" name="slug" id="editSlug" placeholder="my-post" maxlength="80">
+                    <div class="file-info">Leave empty to generate it from the first line. Old URLs redirect to the new one.</div>
+                </div>
+                <div class="form-group">
+                    <label for="editStatus">Visibility</label>
+                    <select name="status" id="editStatus" onchange="togglePublishAt('editStatus', 'editPublishAtGroup')">
+                        <option value="published">Published</option>
+                        <option value="draft">Draft</option>
+                        <option value="unlisted">Unlisted (only people with the link)</option>
+                    </select>
+                    {{if not .User.CanPublish}}<div class="file-info">Your changes are saved as a draft until an editor publishes them.</div>{{end}}
+                </div>
+                <div class="form-group" id="editPublishAtGroup">
+                    <label for="editPublishAt">Schedule (optional)</label>
+                    <input type="datetime-local" name="publish_at" id="editPublishAt">
+                    <input type="hidden" name="timezone_offset" class="timezoneOffset">
+                    <div class="file-info">Leave empty to publish immediately</div>
+                </div>
                 <div class="form-group">
                     <label>Media (optional)</label>
                     <div style="display: flex; gap: 20px; margin-top: 8px;">
@@ -797,19 +1100,25 @@ const adminTemplate = `<!DOCTYPE html>
                         </label>
                     </div>
                 </div>
+                <div class="form-group" id="editMediaListGroup">
+                    <label>Current media</label>
+                    <div class="media-list" id="editMediaList"></div>
+                </div>
                 <div class="form-group">
-                    <input type="file" name="media" id="editMedia" accept="image/*" style="display: none;">
+                    <input type="file" name="media" id="editMedia" accept="image/*" multiple style="display: none;">
                     <div class="custom-file-upload" onclick="document.getElementById('editMedia').click()">
                         <svg width="20" height="20" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round" style="margin-right: 8px;">
                             <path d="M21 15v4a2 2 0 0 1-2 2H5a2 2 0 0 1-2-2v-4"></path>
                             <polyline points="17 8 12 3 7 8"></polyline>
                             <line x1="12" y1="3" x2="12" y2="15"></line>
                         </svg>
-                        <span id="editMediaFileName">Choose file</span>
+                        <span id="editMediaFileName">Add files</span>
                     </div>
                     <div class="preview-container" id="editPhotoPreview">
                         <img src="" alt="Preview" class="preview-image" id="editPreviewImg">
                     </div>
+                    <div class="file-info">New files are added after the current media</div>
+                    <div class="media-alt-inputs" id="editMediaAltInputs"></div>
                 </div>
                 <div class="form-group" id="editThumbnailUploadGroup" style="display: none;">
                     <label>Thumbnail / Cover Image (optional)</label>
@@ -845,6 +1154,7 @@ const adminTemplate = `<!DOCTYPE html>
             <div class="modal-header"><h2>Delete Post</h2></div>
             <p style="margin-bottom: 20px;">Are you sure you want to delete this post? This action cannot be undone.</p>
             <form method="POST" action="/admin/delete">
+                <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                 <input type="hidden" name="id" id="deleteId">
                 <div class="modal-actions">
                     <button type="button" class="btn-secondary" onclick="closeDeleteModal()">Cancel</button>
@@ -963,9 +1273,10 @@ const adminTemplate = `<!DOCTYPE html>
             mediaEl.addEventListener('change', function(e) {
                 const file = e.target.files[0];
                 const fileNameSpan = document.getElementById('mediaFileName');
+                renderAltInputs(e.target.files, 'mediaAltInputs');
 
                 if (file) {
-                    fileNameSpan.textContent = file.name;
+                    fileNameSpan.textContent = e.target.files.length > 1 ? e.target.files.length + ' files selected' : file.name;
                     if (file.type.startsWith('image/')) {
                         const reader = new FileReader();
                         reader.onload = function(e) {
@@ -988,9 +1299,10 @@ const adminTemplate = `<!DOCTYPE html>
             editMediaEl.addEventListener('change', function(e) {
                 const file = e.target.files[0];
                 const fileNameSpan = document.getElementById('editMediaFileName');
+                renderAltInputs(e.target.files, 'editMediaAltInputs');
 
                 if (file) {
-                    fileNameSpan.textContent = file.name;
+                    fileNameSpan.textContent = e.target.files.length > 1 ? e.target.files.length + ' files selected' : file.name;
                     if (file.type.startsWith('image/')) {
                         const reader = new FileReader();
                         reader.onload = function(e) {
@@ -1002,12 +1314,67 @@ const adminTemplate = `<!DOCTYPE html>
                         document.getElementById('editPhotoPreview').classList.remove('active');
                     }
                 } else {
-                    fileNameSpan.textContent = 'Choose file';
+                    fileNameSpan.textContent = 'Add files';
                     document.getElementById('editPhotoPreview').classList.remove('active');
                 }
             });
         }
 
+        // One alt text field per selected file; the server pairs them up in order
+        function renderAltInputs(files, containerId) {
+            const container = document.getElementById(containerId);
+            container.innerHTML = '';
+            Array.from(files).forEach(function(file) {
+                if (!file.type.startsWith('image/')) {
+                    // Keep the fields lined up with the files
+                    const hidden = document.createElement('input');
+                    hidden.type = 'hidden';
+                    hidden.name = 'media_alt';
+                    container.appendChild(hidden);
+                    return;
+                }
+                const input = document.createElement('input');
+                input.type = 'text';
+                input.name = 'media_alt';
+                input.maxLength = 300;
+                input.placeholder = 'Alt text for ' + file.name;
+                container.appendChild(input);
+            });
+        }
+
+        // Existing media in the edit modal: reorder, describe or remove items
+        function renderEditMediaList(media) {
+            const list = document.getElementById('editMediaList');
+            list.innerHTML = '';
+            media.forEach(function(item) {
+                const row = document.createElement('div');
+                row.className = 'media-item';
+                let preview = '<div class="media-item-preview">' + item.MediaType + '</div>';
+                if (item.MediaType === 'photo') {
+                    preview = '<img src="' + item.URL + '" alt="" class="media-item-preview">';
+                } else if (item.Thumbnail) {
+                    preview = '<img src="' + item.Thumbnail + '" alt="" class="media-item-preview">';
+                }
+                row.innerHTML = preview +
+                    '<input type="hidden" name="media_order" value="' + item.ID + '">' +
+                    '<input type="text" name="media_alt_' + item.ID + '" placeholder="Alt text" maxlength="300">' +
+                    '<button type="button" onclick="moveMediaItem(this, -1)" aria-label="Move up">&uarr;</button>' +
+                    '<button type="button" onclick="moveMediaItem(this, 1)" aria-label="Move down">&darr;</button>' +
+                    '<label><input type="checkbox" name="media_remove" value="' + item.ID + '" onchange="this.closest(\'.media-item\').classList.toggle(\'removed\', this.checked)"> Remove</label>';
+                row.querySelector('input[type="text"]').value = item.AltText;
+                list.appendChild(row);
+            });
+            document.getElementById('editMediaListGroup').style.display = media.length > 0 ? 'block' : 'none';
+        }
+
+        function moveMediaItem(button, direction) {
+            const row = button.closest('.media-item');
+            const sibling = direction < 0 ? row.previousElementSibling : row.nextElementSibling;
+            if (sibling) {
+                row.parentNode.insertBefore(row, direction < 0 ? sibling : sibling.nextElementSibling);
+            }
+        }
+
         // Thumbnail file change handlers
         const thumbnailEl = document.getElementById('thumbnail');
         if (thumbnailEl) {
@@ -1055,10 +1422,40 @@ const adminTemplate = `<!DOCTYPE html>
             });
         }
 
+        // Scheduling only applies to published posts
+        function togglePublishAt(selectId, groupId) {
+            const isPublished = document.getElementById(selectId).value === 'published';
+            document.getElementById(groupId).style.display = isPublished ? 'block' : 'none';
+        }
+
+        // Format a date for a datetime-local input in the browser's timezone
+        function toLocalDateTimeValue(date) {
+            const local = new Date(date.getTime() - date.getTimezoneOffset() * 60000);
+            return local.toISOString().slice(0, 16);
+        }
+
+        // Scheduled times are entered in local time; tell the server the offset
+        document.querySelectorAll('.timezoneOffset').forEach(function(input) {
+            input.value = new Date().getTimezoneOffset();
+        });
+
         // Modal functions
-        function openEditModal(id, content) {
+        function openEditModal(id, content, tags, status, publishAt, customSlug, media) {
             document.getElementById('editId').value = id;
             document.getElementById('editContent').value = content;
+            document.getElementById('editTags').value = tags;
+            document.getElementById('editSlug').value = customSlug;
+            document.getElementById('editStatus').value = status || 'published';
+            document.getElementById('editPublishAt').value = publishAt ? toLocalDateTimeValue(new Date(publishAt)) : '';
+            togglePublishAt('editStatus', 'editPublishAtGroup');
+            media = media || [];
+            renderEditMediaList(media);
+            document.getElementById('editMedia').value = '';
+            document.getElementById('editMediaFileName').textContent = 'Add files';
+            document.getElementById('editPhotoPreview').classList.remove('active');
+            renderAltInputs([], 'editMediaAltInputs');
+            const hasCoverMedia = media.some(function(item) { return item.MediaType !== 'photo'; });
+            document.getElementById('editThumbnailUploadGroup').style.display = hasCoverMedia ? 'block' : 'none';
             document.getElementById('editCharCount').textContent = content.length;
             document.getElementById('editModal').classList.add('active');
         }
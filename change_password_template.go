@@ -147,6 +147,7 @@ const changePasswordTemplate = `<!DOCTYPE html>
         {{end}}
 
         <form method="POST" action="/change-password">
+            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
             <div class="form-group">
                 <label for="new_password">New Password:</label>
                 <input type="password" id="new_password" name="new_password" required autofocus>
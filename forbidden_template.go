@@ -0,0 +1,83 @@
+package main
+
+const forbiddenTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Request Blocked - Blog</title>
+    <style>
+        * { margin: 0; padding: 0; box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif;
+            background-color: #fafafa;
+            color: #262626;
+            line-height: 1.6;
+            min-height: 100vh;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            padding: 20px;
+        }
+        .container {
+            max-width: 400px;
+            width: 100%;
+            background-color: #ffffff;
+            border: 1px solid #dbdbdb;
+            border-radius: 8px;
+            padding: 40px 32px;
+        }
+        h1 {
+            font-size: 28px;
+            font-weight: 600;
+            color: #262626;
+            margin-bottom: 20px;
+            text-align: center;
+        }
+        .message {
+            padding: 12px 16px;
+            margin-bottom: 24px;
+            border-radius: 8px;
+            font-size: 14px;
+            line-height: 20px;
+            background-color: #f8d7da;
+            color: #721c24;
+            border: 1px solid #f5c6cb;
+        }
+        a.button {
+            display: block;
+            background-color: #000000;
+            color: #ffffff;
+            padding: 12px 24px;
+            border-radius: 8px;
+            font-size: 14px;
+            font-weight: 600;
+            text-align: center;
+            text-decoration: none;
+            transition: transform 0.2s;
+        }
+        a.button:hover {
+            transform: translateY(-1px);
+        }
+        @media (max-width: 768px) {
+            body {
+                padding: 0;
+                align-items: flex-start;
+            }
+            .container {
+                border: none;
+                border-radius: 0;
+                min-height: 100vh;
+                padding: 40px 24px;
+            }
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>Request Blocked</h1>
+        <div class="message">{{.Message}}</div>
+        <a href="/admin" class="button">Back to Admin</a>
+    </div>
+</body>
+</html>`
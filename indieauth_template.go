@@ -0,0 +1,205 @@
+package main
+
+const indieAuthTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Sign in - {{.SiteTitle}}</title>
+    <style>
+        * { margin: 0; padding: 0; box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif;
+            background-color: #fafafa;
+            color: #262626;
+            line-height: 1.6;
+            min-height: 100vh;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            padding: 20px;
+        }
+        .container {
+            max-width: 400px;
+            width: 100%;
+            background-color: #ffffff;
+            border: 1px solid #dbdbdb;
+            border-radius: 8px;
+            padding: 40px 32px;
+        }
+        h1 {
+            font-size: 28px;
+            font-weight: 600;
+            color: #262626;
+            margin-bottom: 8px;
+            text-align: center;
+        }
+        .subtitle {
+            font-size: 14px;
+            color: #8e8e8e;
+            text-align: center;
+            margin-bottom: 32px;
+        }
+        .message {
+            padding: 12px 16px;
+            margin-bottom: 20px;
+            border-radius: 8px;
+            font-size: 14px;
+            line-height: 18px;
+        }
+        .error {
+            background-color: #f8d7da;
+            color: #721c24;
+            border: 1px solid #f5c6cb;
+        }
+        .form-group {
+            margin-bottom: 20px;
+        }
+        label {
+            display: block;
+            margin-bottom: 8px;
+            font-weight: 600;
+            font-size: 14px;
+            color: #262626;
+        }
+        input[type="text"], input[type="password"] {
+            width: 100%;
+            padding: 12px 16px;
+            border: 1px solid #dbdbdb;
+            border-radius: 8px;
+            font-size: 14px;
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif;
+            background-color: #fafafa;
+            transition: border-color 0.2s, background-color 0.2s;
+        }
+        input[type="text"]:hover, input[type="password"]:hover {
+            border-color: #a8a8a8;
+        }
+        input[type="text"]:focus, input[type="password"]:focus {
+            outline: none;
+            border-color: #0095f6;
+            background-color: #ffffff;
+        }
+        button {
+            background-color: #000000;
+            color: #ffffff;
+            padding: 12px 24px;
+            border: none;
+            cursor: pointer;
+            border-radius: 8px;
+            font-size: 14px;
+            font-weight: 600;
+            width: 100%;
+            transition: transform 0.2s;
+        }
+        button:hover {
+            transform: translateY(-1px);
+        }
+        .client {
+            font-size: 14px;
+            text-align: center;
+            margin-bottom: 24px;
+            word-break: break-all;
+        }
+        .client strong {
+            font-size: 16px;
+        }
+        .scopes {
+            border: 1px solid #dbdbdb;
+            border-radius: 8px;
+            padding: 8px 16px;
+            margin-bottom: 20px;
+        }
+        .scopes label {
+            display: flex;
+            align-items: center;
+            gap: 8px;
+            margin: 8px 0;
+            font-weight: normal;
+            cursor: pointer;
+        }
+        .note {
+            font-size: 12px;
+            color: #8e8e8e;
+            margin-bottom: 20px;
+        }
+        .actions {
+            display: flex;
+            gap: 12px;
+        }
+        button.secondary {
+            background-color: #8e8e8e;
+        }
+        @media (max-width: 768px) {
+            body {
+                padding: 0;
+                align-items: flex-start;
+            }
+            .container {
+                border: none;
+                border-radius: 0;
+                min-height: 100vh;
+                padding: 40px 24px;
+            }
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>Sign in</h1>
+        <div class="subtitle">{{.Me}}</div>
+
+        {{if .Error}}
+        <div class="message error">{{.Error}}</div>
+        {{end}}
+
+        {{if .ClientHost}}
+        <div class="client">
+            <strong>{{.ClientHost}}</strong> wants to sign in as this site{{if .Scopes}} and to:{{end}}
+        </div>
+
+        <form method="POST" action="/indieauth/auth">
+            <input type="hidden" name="client_id" value="{{.ClientID}}">
+            <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+            <input type="hidden" name="state" value="{{.State}}">
+            <input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+            <input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+
+            {{if .Scopes}}
+            <div class="scopes">
+                {{range .Scopes}}
+                <label>
+                    <input type="hidden" name="requested_scope" value="{{.Name}}">
+                    <input type="checkbox" name="scope" value="{{.Name}}"{{if .Checked}} checked{{end}}>
+                    {{.Description}}
+                </label>
+                {{end}}
+            </div>
+            {{end}}
+
+            <div class="note">You will be sent back to {{.RedirectURI}}</div>
+
+            {{if .NeedsPassword}}
+            <div class="form-group">
+                <label for="username">Username:</label>
+                <input type="text" id="username" name="username" autocomplete="username" autocapitalize="none" autofocus>
+            </div>
+            <div class="form-group">
+                <label for="password">Password:</label>
+                <input type="password" id="password" name="password" autocomplete="current-password">
+            </div>
+            <div class="form-group">
+                <label for="code">Authentication code (if two-factor is on):</label>
+                <input type="text" id="code" name="code" inputmode="numeric" autocomplete="one-time-code" autocapitalize="none">
+            </div>
+            {{end}}
+
+            <div class="actions">
+                <button type="submit" name="action" value="deny" class="secondary" formnovalidate>Cancel</button>
+                <button type="submit" name="action" value="approve">Allow</button>
+            </div>
+        </form>
+        {{end}}
+    </div>
+</body>
+</html>`
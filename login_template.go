@@ -62,7 +62,19 @@ const loginTemplate = `<!DOCTYPE html>
             font-size: 14px;
             color: #262626;
         }
-        input[type="password"] {
+        label.remember {
+            display: flex;
+            align-items: center;
+            gap: 8px;
+            font-weight: normal;
+            cursor: pointer;
+        }
+        .hint {
+            font-size: 12px;
+            color: #8e8e8e;
+            margin-top: 6px;
+        }
+        input[type="text"], input[type="password"] {
             width: 100%;
             padding: 12px 16px;
             border: 1px solid #dbdbdb;
@@ -72,10 +84,10 @@ const loginTemplate = `<!DOCTYPE html>
             background-color: #fafafa;
             transition: border-color 0.2s, background-color 0.2s;
         }
-        input[type="password"]:hover {
+        input[type="text"]:hover, input[type="password"]:hover {
             border-color: #a8a8a8;
         }
-        input[type="password"]:focus {
+        input[type="text"]:focus, input[type="password"]:focus {
             outline: none;
             border-color: #0095f6;
             background-color: #ffffff;
@@ -95,6 +107,17 @@ const loginTemplate = `<!DOCTYPE html>
         button:hover {
             transform: translateY(-1px);
         }
+        button.secondary {
+            background-color: #ffffff;
+            color: #262626;
+            border: 1px solid #dbdbdb;
+        }
+        .divider {
+            font-size: 13px;
+            color: #8e8e8e;
+            text-align: center;
+            margin: 16px 0;
+        }
         @media (max-width: 768px) {
             body {
                 padding: 0;
@@ -112,23 +135,102 @@ const loginTemplate = `<!DOCTYPE html>
 <body>
     <div class="container">
         <h1>Login</h1>
-        <div class="subtitle">Enter password to continue</div>
+        <div class="subtitle">{{if .LoginToken}}Enter the code from your authenticator app{{else}}Sign in to continue{{end}}</div>
 
         {{if .Error}}
         <div class="message error">{{.Error}}</div>
         {{end}}
 
+        {{if .LoginToken}}
+        <form method="POST" action="/login">
+            <input type="hidden" name="login_token" value="{{.LoginToken}}">
+            {{if .Redirect}}
+            <input type="hidden" name="redirect" value="{{.Redirect}}">
+            {{end}}
+            <div class="form-group">
+                <label for="code">Authentication code:</label>
+                <input type="text" id="code" name="code" inputmode="numeric" autocomplete="one-time-code" autocapitalize="none" required autofocus>
+                <div class="hint">Lost your device? Enter one of your recovery codes instead.</div>
+            </div>
+
+            <button type="submit">Verify</button>
+        </form>
+        {{else}}
         <form method="POST" action="/login">
             {{if .Redirect}}
             <input type="hidden" name="redirect" value="{{.Redirect}}">
             {{end}}
+            <div class="form-group">
+                <label for="username">Username:</label>
+                <input type="text" id="username" name="username" value="{{.Username}}" autocomplete="username" autocapitalize="none" required{{if not .Username}} autofocus{{end}}>
+            </div>
             <div class="form-group">
                 <label for="password">Password:</label>
-                <input type="password" id="password" name="password" required autofocus>
+                <input type="password" id="password" name="password" autocomplete="current-password" required{{if .Username}} autofocus{{end}}>
+            </div>
+            <div class="form-group">
+                <label class="remember">
+                    <input type="checkbox" name="remember" value="true">
+                    Remember me for 30 days
+                </label>
             </div>
 
             <button type="submit">Login</button>
         </form>
+        <div id="passkeyLogin" style="display: none;">
+            <div class="divider">or</div>
+            <button type="button" class="secondary" onclick="signInWithPasskey()">Sign in with a passkey</button>
+        </div>
+        <script>
+            function fromBase64URL(s) {
+                const binary = atob(s.replace(/-/g, '+').replace(/_/g, '/'));
+                return Uint8Array.from(binary, function(c) { return c.charCodeAt(0); });
+            }
+
+            function toBase64URL(buffer) {
+                const binary = String.fromCharCode.apply(null, new Uint8Array(buffer));
+                return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+            }
+
+            async function signInWithPasskey() {
+                try {
+                    const options = await (await fetch('/login/passkey/begin', {method: 'POST'})).json();
+                    options.challenge = fromBase64URL(options.challenge);
+
+                    const credential = await navigator.credentials.get({publicKey: options});
+                    const response = await fetch('/login/passkey/finish', {
+                        method: 'POST',
+                        headers: {'Content-Type': 'application/json'},
+                        body: JSON.stringify({
+                            id: credential.id,
+                            remember: document.querySelector('input[name="remember"]').checked,
+                            redirect: {{.Redirect}},
+                            response: {
+                                clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                                authenticatorData: toBase64URL(credential.response.authenticatorData),
+                                signature: toBase64URL(credential.response.signature),
+                                userHandle: credential.response.userHandle ? toBase64URL(credential.response.userHandle) : ''
+                            }
+                        })
+                    });
+                    const result = await response.json();
+                    if (!response.ok) {
+                        alert(result.error_description || 'Sign-in with the passkey failed');
+                        return;
+                    }
+                    window.location = result.redirect;
+                } catch (err) {
+                    if (err.name !== 'NotAllowedError') {
+                        alert('Sign-in with the passkey failed: ' + err.message);
+                    }
+                }
+            }
+
+            if (window.PublicKeyCredential) {
+                document.getElementById('passkeyLogin').style.display = 'block';
+            }
+        </script>
+        {{end}}
     </div>
 </body>
 </html>`
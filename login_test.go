@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func resetLoginThrottle() {
+	loginThrottleMutex.Lock()
+	defer loginThrottleMutex.Unlock()
+	loginFailuresByIP = make(map[string]*loginFailures)
+	loginGlobalFailures = make(map[string][]time.Time)
+	loginGlobalNextTry = make(map[string]time.Time)
+}
+
+func postLogin(ip, password string) int {
+	form := url.Values{"username": {"admin"}, "password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = ip + ":40000"
+	rec := httptest.NewRecorder()
+	handleLogin(rec, req)
+	return rec.Code
+}
+
+// TestLoginThrottleParallel checks that a burst of simultaneous guesses gets
+// no more tries than the same guesses made one after another
+func TestLoginThrottleParallel(t *testing.T) {
+	setupTestDB(t)
+	resetLoginThrottle()
+	t.Cleanup(resetLoginThrottle)
+
+	const burst = 20
+	codes := make(chan int, burst)
+	var wg sync.WaitGroup
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- postLogin("192.0.2.1", "wrong")
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	tried := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			tried++
+		case http.StatusTooManyRequests:
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if tried != loginFreeAttempts {
+		t.Errorf("%d of %d parallel guesses were tried, want %d", tried, burst, loginFreeAttempts)
+	}
+
+	loginThrottleMutex.Lock()
+	f := loginFailuresByIP[loginThrottleKey(loginFormAdmin, "192.0.2.1")]
+	loginThrottleMutex.Unlock()
+	if f == nil || f.Count != loginFreeAttempts || f.InFlight != 0 {
+		t.Errorf("throttle state after the burst: %+v", f)
+	}
+
+	// Other addresses and the right password still get in
+	if code := postLogin("192.0.2.2", "admin"); code != http.StatusSeeOther {
+		t.Errorf("sign-in from another address returned %d", code)
+	}
+}
+
+// TestLoginLockoutSparesForwarders checks that a proxy missing from
+// TRUSTED_PROXIES isn't locked out along with everyone behind it
+func TestLoginLockoutSparesForwarders(t *testing.T) {
+	setupTestDB(t)
+	resetLoginThrottle()
+	t.Cleanup(resetLoginThrottle)
+	t.Cleanup(func() {
+		forwardingPeersMutex.Lock()
+		forwardingPeers = make(map[string]time.Time)
+		forwardingPeersMutex.Unlock()
+	})
+
+	request := func(ip string, forwarded bool) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = ip + ":40000"
+		if forwarded {
+			req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		}
+		return req
+	}
+
+	for _, ip := range []string{"192.0.2.10", "192.0.2.11"} {
+		loginThrottleMutex.Lock()
+		loginFailuresByIP[loginThrottleKey(loginFormAdmin, ip)] = &loginFailures{
+			Count:       loginLockoutAttempts,
+			LastFailure: time.Now().Add(-2 * time.Minute),
+		}
+		loginThrottleMutex.Unlock()
+	}
+
+	if wait := loginWait(request("192.0.2.10", false), loginFormAdmin); wait < 25*time.Minute {
+		t.Errorf("client not locked out after %d failures, wait %v", loginLockoutAttempts, wait)
+	}
+	// Forwarded requests come from an untrusted peer, so the header is
+	// ignored, but the peer isn't locked out
+	req := request("192.0.2.11", true)
+	if ip := requestIP(req); ip != "192.0.2.11" {
+		t.Errorf("untrusted X-Forwarded-For used: %s", ip)
+	}
+	if wait := loginWait(req, loginFormAdmin); wait != 0 {
+		t.Errorf("forwarding peer locked out, wait %v", wait)
+	}
+	releaseLoginAttempt(req, loginFormAdmin)
+}
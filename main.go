@@ -3,35 +3,56 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/x509"
 	"database/sql"
+	"encoding/base32"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"html"
 	"html/template"
 	"image"
+	"image/color"
 	"image/jpeg"
 	"image/png"
 	"io"
 	"log"
+	"math"
+	"math/big"
+	"mime/multipart"
 	"net"
 	"net/http"
+	"net/mail"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"unicode"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/nfnt/resize"
+	"github.com/skip2/go-qrcode"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -43,27 +64,80 @@ type Entry struct {
 	MediaType     string
 	ThumbnailPath string
 	Slug          string
+	ContentFormat string
+	Tags          []string
+	Media         []EntryMedia
+	SlugPinned    bool       // custom slug that edits don't change
+	Status        string     // "published", "draft" or "unlisted"
+	PublishAt     *time.Time // set while a published entry is scheduled
+	Author        string     // credited author, on blogs with several users
 	CreatedAt     time.Time
 	TimeAgo       string
 }
 
-type EntryDisplay struct {
+// EntryRevision is an earlier version of an entry, saved before each edit
+type EntryRevision struct {
 	ID            int
+	EntryID       int
 	Title         string
-	Content       template.HTML
-	FullContent   template.HTML
-	IsTruncated   bool
-	Photo         template.URL
-	HasPhoto      bool
+	Content       string
+	PhotoPath     string
 	MediaType     string
-	HasAudio      bool
-	HasVideo      bool
-	Thumbnail     template.URL
-	HasThumbnail  bool
-	Slug          string
-	CreatedAt     time.Time
-	TimeAgo       string
-	InitialLetter string
+	ThumbnailPath string
+	ContentFormat string
+	Tags          []string
+	Media         []EntryMedia
+	CreatedAt     time.Time // when this version was replaced
+}
+
+// EntryMedia is one photo, audio or video item of an entry. The first item
+// is mirrored into the entry's photo_path, media_type and thumbnail_path.
+type EntryMedia struct {
+	ID            int
+	Path          string
+	MediaType     string
+	ThumbnailPath string
+	AltText       string
+	URL           template.URL `json:",omitempty"`
+	Thumbnail     template.URL `json:",omitempty"`
+	Width         int          `json:",omitempty"` // photo size, when resized copies exist
+	Height        int          `json:",omitempty"`
+	SrcSet        string       `json:",omitempty"`
+	Placeholder   template.URL `json:",omitempty"` // tiny blurred preview as a data URI
+	Duration      int          `json:",omitempty"` // audio playing time in seconds
+}
+
+// DiffLine is one line of a line-based text diff
+type DiffLine struct {
+	Op   string // "same", "added" or "removed"
+	Text string
+}
+
+type EntryDisplay struct {
+	ID               int
+	Title            string
+	Content          template.HTML
+	FullContent      template.HTML
+	IsTruncated      bool
+	Photo            template.URL
+	HasPhoto         bool
+	MediaType        string
+	HasAudio         bool
+	HasVideo         bool
+	Thumbnail        template.URL
+	HasThumbnail     bool
+	PhotoAlt         string
+	PhotoSrcSet      string
+	PhotoPlaceholder template.URL
+	ImageSizes       string       // sizes attribute for srcset
+	Media            []EntryMedia // all media items; a gallery is shown for more than one
+	Slug             string
+	Tags             []string
+	Snippet          template.HTML // highlighted excerpt, set for search results
+	Author           string        // credited author, on blogs with several users
+	CreatedAt        time.Time
+	TimeAgo          string
+	InitialLetter    string
 }
 
 type ViewerPageData struct {
@@ -80,6 +154,7 @@ type ViewerPageData struct {
 	InitialCount     int
 	HasMore          bool
 	ThemeCSS         template.CSS
+	Tag              string // set on /tags/<name>/ archive pages
 }
 
 type EditorPageData struct {
@@ -97,6 +172,18 @@ type EditorPageData struct {
 	StartEntry   int
 	EndEntry     int
 	PageNumbers  []int
+	SearchQuery  string
+	// Revision history view
+	RevisionEntry    *Entry
+	Revisions        []EntryRevision
+	SelectedRevision *EntryRevision
+	RevisionDiff     []DiffLine
+	RevisionMedia    bool // selected revision's media differs from the current media
+	// Webmentions view
+	Webmentions      []Webmention
+	WebmentionFilter string
+	User             *User  // the signed-in user
+	CSRFToken        string // sent back with every form
 }
 
 type SinglePostPageData struct {
@@ -108,20 +195,24 @@ type SinglePostPageData struct {
 	AvatarPath       string
 	AvatarPreference string
 	ThemeCSS         template.CSS
+	Responses        PostResponses
 }
 
 type SiteSettings struct {
-	SiteTitle          string
-	SiteSubtitle       string
-	UserInitial        string
-	AvatarPath         string
-	AvatarPreference   string // "avatar" or "initials"
-	SiteTheme          string
-	CustomBgColor      string
-	CustomTextColor    string
-	CustomAccentColor  string
-	HasViewerPassword  bool
-	HasAdminPassword   bool
+	SiteTitle             string
+	SiteSubtitle          string
+	UserInitial           string
+	AvatarPath            string
+	AvatarPreference      string // "avatar" or "initials"
+	SiteTheme             string
+	CustomBgColor         string
+	CustomTextColor       string
+	CustomAccentColor     string
+	HasViewerPassword     bool
+	HasAdminPassword      bool
+	KeepPhotoDate         bool // keep capture date in uploaded photos
+	KeepPhotoCamera       bool // keep camera make and model in uploaded photos
+	KeepPhotoColorProfile bool // keep the ICC profile of uploaded photos
 }
 
 type SettingsPageData struct {
@@ -135,6 +226,47 @@ type SettingsPageData struct {
 	CanEnableCustomDomain bool
 	View                  string
 	PageTitle             string
+	Podcast               PodcastSettings
+	EnableAudioUploads    bool
+	AccessTokens          []AccessToken
+	TokenScopes           []string
+	MicropubEndpoint      string
+	APIEndpoint           string
+	NewToken              string // shown once after creating a token
+	Webhooks              []Webhook
+	WebhookDeliveries     []WebhookDelivery
+	WebhookEvents         []string
+	Sessions              []Session
+	User                  *User // the signed-in user
+	Users                 []User
+	UserInvites           []UserInvite
+	UserRoles             []string
+	NewInvite             string // invite link shown once after creating it
+	TwoFactor             TwoFactorSettings
+	Passkeys              []Passkey
+	FailedLogins          []FailedLogin
+	LoginLockouts         []LoginLockout
+	CSRFToken             string // sent back with every form
+}
+
+// TwoFactorSettings is the two-factor state shown on the security page
+type TwoFactorSettings struct {
+	SetupSecret       string       // set while enrolling, for manual entry
+	SetupQRCode       template.URL // the secret as a QR code
+	RecoveryCodesLeft int
+	NewRecoveryCodes  []string // shown once after they are created
+}
+
+type SearchPageData struct {
+	Query          string
+	Results        []EntryDisplay
+	TotalResults   int
+	Page           int
+	HasMore        bool
+	SiteTitle      string
+	SiteSubtitle   string
+	EnableSubtitle bool
+	ThemeCSS       template.CSS
 }
 
 type NotFoundPageData struct {
@@ -165,9 +297,17 @@ type EntryJSON struct {
 	CreatedAt string `json:"createdAt"`
 }
 
+// Session is a browser signed in to the admin area
 type Session struct {
-	Token     string
-	ExpiresAt time.Time
+	ID         int
+	UserID     int
+	Remember   bool // kept for rememberedSessionLifetime instead of sessionIdleTimeout
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	Current    bool // the session of the request being served
 }
 
 // CustomDomain represents a custom domain configuration
@@ -187,13 +327,22 @@ var urlRegex *regexp.Regexp
 var uploadsDir string
 var enableAudioUploads bool
 var enableSubtitle bool
-var sessions = make(map[string]*Session)
-var sessionMutex sync.RWMutex
+
+// Full-text search is available when SQLite was built with FTS5
+var searchIndexEnabled bool
 
 // Rate limiting for domain verification
 var domainVerifyAttempts = make(map[string][]time.Time)
 var domainVerifyMutex sync.Mutex
 
+// Proxies whose X-Forwarded-For header is believed, from TRUSTED_PROXIES
+var trustedProxies []*net.IPNet
+
+// Untrusted peers that sent X-Forwarded-For, most likely a proxy missing from
+// TRUSTED_PROXIES, with when they last did
+var forwardingPeers = make(map[string]time.Time)
+var forwardingPeersMutex sync.Mutex
+
 // Hostname auto-detection
 var hostnameDetected sync.Once
 
@@ -276,6 +425,21 @@ func initDB() error {
 	// Subtitle disabled by default, enable via environment variable
 	enableSubtitle = os.Getenv("ENABLE_SUBTITLE") == "true"
 
+	// Fetches of addresses supplied by other sites stay on the public
+	// internet unless enabled for testing against local servers
+	allowPrivateFetches = os.Getenv("ALLOW_PRIVATE_FETCHES") == "true"
+
+	// Client addresses come from X-Forwarded-For only when the request
+	// arrived through one of these proxies
+	proxies := os.Getenv("TRUSTED_PROXIES")
+	if proxies == "" {
+		proxies = defaultTrustedProxies
+	}
+	trustedProxies, err = parseTrustedProxies(proxies)
+	if err != nil {
+		return fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// Create uploads directory if it doesn't exist
 	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
 		return fmt.Errorf("failed to create uploads directory: %v", err)
@@ -462,13 +626,27 @@ func initDB() error {
 	// First, drop the unique index temporarily to allow updates
 	_, _ = db.Exec(`DROP INDEX IF EXISTS idx_entries_slug`)
 
-	rows, err := db.Query("SELECT id, title, content, created_at FROM entries")
+	// Custom slugs are left alone (the column doesn't exist before the first
+	// migration run, in which case nothing is pinned yet)
+	pinnedSlugs := make(map[int]bool)
+	if pinnedRows, err := db.Query("SELECT id FROM entries WHERE slug_pinned = 1"); err == nil {
+		for pinnedRows.Next() {
+			var id int
+			if pinnedRows.Scan(&id) == nil {
+				pinnedSlugs[id] = true
+			}
+		}
+		pinnedRows.Close()
+	}
+
+	rows, err := db.Query("SELECT id, title, content, slug, created_at FROM entries")
 	if err == nil {
 		// Read all entries first
 		type entryData struct {
 			id        int
 			title     string
 			content   string
+			slug      string
 			createdAt time.Time
 		}
 		var entriesToUpdate []entryData
@@ -477,12 +655,14 @@ func initDB() error {
 			var id int
 			var title sql.NullString
 			var content string
+			var slug sql.NullString
 			var createdAt time.Time
-			if err := rows.Scan(&id, &title, &content, &createdAt); err == nil {
+			if err := rows.Scan(&id, &title, &content, &slug, &createdAt); err == nil && !pinnedSlugs[id] {
 				entriesToUpdate = append(entriesToUpdate, entryData{
 					id:        id,
 					title:     title.String,
 					content:   content,
+					slug:      slug.String,
 					createdAt: createdAt,
 				})
 			}
@@ -517,6 +697,9 @@ func initDB() error {
 
 			slug := generateSlug(finalTitle, entry.createdAt)
 			_, _ = db.Exec("UPDATE entries SET title = ?, slug = ? WHERE id = ?", finalTitle, slug, entry.id)
+			if slug != entry.slug {
+				recordSlugChange(entry.id, entry.slug, slug)
+			}
 			updateCount++
 		}
 		if updateCount > 0 {
@@ -628,7 +811,7 @@ func initDB() error {
 		if err != nil {
 			return fmt.Errorf("failed to store initial admin password: %v", err)
 		}
-		log.Println("Initial admin password configured - password change required on first login")
+		log.Println("Initial admin password configured - sign in as 'admin'; password change required on first login")
 	}
 
 	// Add instance_hostname column to site_settings if it doesn't exist
@@ -683,6 +866,10 @@ func initDB() error {
 		return fmt.Errorf("failed to create custom_domain table: %v", err)
 	}
 
+	if err := runDatabaseMigrations(); err != nil {
+		return err
+	}
+
 	log.Println("Database connection established and table created")
 	log.Printf("Uploads directory: %s", uploadsDir)
 	return nil
@@ -756,6 +943,595 @@ func runDatabaseMigrations() error {
 		}
 	}
 
+	// Add podcast feed columns if they don't exist
+	podcastColumns := []struct {
+		name       string
+		definition string
+	}{
+		{"podcast_author", "TEXT DEFAULT ''"},
+		{"podcast_email", "TEXT DEFAULT ''"},
+		{"podcast_category", "TEXT DEFAULT ''"},
+		{"podcast_explicit", "INTEGER DEFAULT 0"},
+		{"podcast_image_path", "TEXT DEFAULT ''"},
+	}
+	for _, col := range podcastColumns {
+		var colExists bool
+		err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('site_settings') WHERE name=?", col.name).Scan(&colExists)
+		if err == nil && !colExists {
+			log.Printf("Migration: Adding %s column...", col.name)
+			_, err = db.Exec(fmt.Sprintf(`ALTER TABLE site_settings ADD COLUMN %s %s`, col.name, col.definition))
+			if err != nil {
+				return fmt.Errorf("failed to add %s column: %v", col.name, err)
+			}
+		}
+	}
+
+	// Add content_updated_at column if it doesn't exist
+	// Last time posts or settings were changed, used for ETags
+	var contentUpdatedExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('site_settings') WHERE name='content_updated_at'").Scan(&contentUpdatedExists)
+	if err == nil && !contentUpdatedExists {
+		log.Println("Migration: Adding content_updated_at column...")
+		_, err = db.Exec(`ALTER TABLE site_settings ADD COLUMN content_updated_at DATETIME`)
+		if err != nil {
+			return fmt.Errorf("failed to add content_updated_at column: %v", err)
+		}
+	}
+
+	// Add photo_metadata_keep column if it doesn't exist
+	// Comma-separated metadata kept on uploaded photos ("date", "camera",
+	// "color"). Color profiles are kept unless the admin turns them off.
+	var photoMetadataKeepExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('site_settings') WHERE name='photo_metadata_keep'").Scan(&photoMetadataKeepExists)
+	if err == nil && !photoMetadataKeepExists {
+		log.Println("Migration: Adding photo_metadata_keep column...")
+		_, err = db.Exec(`ALTER TABLE site_settings ADD COLUMN photo_metadata_keep TEXT DEFAULT 'color'`)
+		if err != nil {
+			return fmt.Errorf("failed to add photo_metadata_keep column: %v", err)
+		}
+	}
+
+	// Add content_format column to entries if it doesn't exist
+	// Existing posts keep the original plain-text rendering; new posts are Markdown
+	var contentFormatExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('entries') WHERE name='content_format'").Scan(&contentFormatExists)
+	if err == nil && !contentFormatExists {
+		log.Println("Migration: Adding content_format column...")
+		_, err = db.Exec(`ALTER TABLE entries ADD COLUMN content_format TEXT DEFAULT 'plain'`)
+		if err != nil {
+			return fmt.Errorf("failed to add content_format column: %v", err)
+		}
+	}
+
+	// Add status and publish_at columns for drafts, unlisted and scheduled posts
+	var statusColumnExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('entries') WHERE name='status'").Scan(&statusColumnExists)
+	if err == nil && !statusColumnExists {
+		log.Println("Migration: Adding status column...")
+		_, err = db.Exec(`ALTER TABLE entries ADD COLUMN status TEXT DEFAULT 'published'`)
+		if err != nil {
+			return fmt.Errorf("failed to add status column: %v", err)
+		}
+	}
+	var publishAtColumnExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('entries') WHERE name='publish_at'").Scan(&publishAtColumnExists)
+	if err == nil && !publishAtColumnExists {
+		log.Println("Migration: Adding publish_at column...")
+		_, err = db.Exec(`ALTER TABLE entries ADD COLUMN publish_at DATETIME`)
+		if err != nil {
+			return fmt.Errorf("failed to add publish_at column: %v", err)
+		}
+	}
+	var scheduledBaseURLColumnExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('entries') WHERE name='scheduled_base_url'").Scan(&scheduledBaseURLColumnExists)
+	if err == nil && !scheduledBaseURLColumnExists {
+		log.Println("Migration: Adding scheduled_base_url column...")
+		_, err = db.Exec(`ALTER TABLE entries ADD COLUMN scheduled_base_url TEXT`)
+		if err != nil {
+			return fmt.Errorf("failed to add scheduled_base_url column: %v", err)
+		}
+	}
+
+	// Create tags and entry_tags tables for grouping posts by topic
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create tags table: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS entry_tags (
+			entry_id INTEGER NOT NULL,
+			tag_id INTEGER NOT NULL,
+			PRIMARY KEY (entry_id, tag_id)
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create entry_tags table: %v", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_entry_tags_tag ON entry_tags(tag_id)`)
+	if err != nil {
+		log.Printf("Warning: failed to create index on entry_tags: %v", err)
+	}
+
+	// Add slug_pinned column for custom slugs that survive edits
+	var slugPinnedColumnExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('entries') WHERE name='slug_pinned'").Scan(&slugPinnedColumnExists)
+	if err == nil && !slugPinnedColumnExists {
+		log.Println("Migration: Adding slug_pinned column...")
+		_, err = db.Exec(`ALTER TABLE entries ADD COLUMN slug_pinned INTEGER DEFAULT 0`)
+		if err != nil {
+			return fmt.Errorf("failed to add slug_pinned column: %v", err)
+		}
+	}
+
+	// Create slug_history table so old post URLs keep working after a slug change
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS slug_history (
+			slug TEXT PRIMARY KEY,
+			entry_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create slug_history table: %v", err)
+	}
+
+	// Create entry_revisions table holding earlier versions of edited entries
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS entry_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			entry_id INTEGER NOT NULL,
+			title TEXT,
+			content TEXT NOT NULL,
+			photo_path TEXT,
+			media_type TEXT,
+			thumbnail_path TEXT,
+			content_format TEXT,
+			tags TEXT,
+			media TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create entry_revisions table: %v", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_entry_revisions_entry ON entry_revisions(entry_id)`)
+	if err != nil {
+		log.Printf("Warning: failed to create index on entry_revisions: %v", err)
+	}
+
+	// Create entry_media table so an entry can hold several media items.
+	// Entries from before galleries get their single media item copied over.
+	var entryMediaExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='entry_media'").Scan(&entryMediaExists)
+	if err == nil && !entryMediaExists {
+		log.Println("Migration: Creating entry_media table...")
+		_, err = db.Exec(`
+			CREATE TABLE entry_media (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				entry_id INTEGER NOT NULL,
+				position INTEGER NOT NULL DEFAULT 0,
+				path TEXT NOT NULL,
+				media_type TEXT NOT NULL DEFAULT 'photo',
+				thumbnail_path TEXT,
+				alt_text TEXT,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`)
+		if err != nil {
+			return fmt.Errorf("failed to create entry_media table: %v", err)
+		}
+		_, err = db.Exec(`
+			INSERT INTO entry_media (entry_id, position, path, media_type, thumbnail_path)
+			SELECT id, 0, photo_path, COALESCE(NULLIF(media_type, ''), 'photo'), NULLIF(thumbnail_path, '')
+			FROM entries
+			WHERE photo_path IS NOT NULL AND photo_path != ''`)
+		if err != nil {
+			return fmt.Errorf("failed to migrate entry media: %v", err)
+		}
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_entry_media_entry ON entry_media(entry_id, position)`)
+	if err != nil {
+		log.Printf("Warning: failed to create index on entry_media: %v", err)
+	}
+
+	// Add duration column so the podcast feed doesn't read every audio file
+	var mediaDurationColumnExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('entry_media') WHERE name='duration'").Scan(&mediaDurationColumnExists)
+	if err == nil && !mediaDurationColumnExists {
+		log.Println("Migration: Adding duration column to entry_media...")
+		_, err = db.Exec(`ALTER TABLE entry_media ADD COLUMN duration INTEGER`)
+		if err != nil {
+			return fmt.Errorf("failed to add entry_media duration column: %v", err)
+		}
+	}
+
+	// Create image_variants table describing the resized copies of uploaded photos
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS image_variants (
+			path TEXT PRIMARY KEY,
+			width INTEGER NOT NULL,
+			height INTEGER NOT NULL,
+			widths TEXT,
+			placeholder TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create image_variants table: %v", err)
+	}
+	var variantFilesColumnExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('image_variants') WHERE name='files'").Scan(&variantFilesColumnExists)
+	if err == nil && !variantFilesColumnExists {
+		log.Println("Migration: Adding files column to image_variants...")
+		_, err = db.Exec(`ALTER TABLE image_variants ADD COLUMN files TEXT`)
+		if err != nil {
+			return fmt.Errorf("failed to add image_variants files column: %v", err)
+		}
+	}
+
+	// Add media column to entry_revisions holding the revision's media items as JSON
+	var revisionMediaExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('entry_revisions') WHERE name='media'").Scan(&revisionMediaExists)
+	if err == nil && !revisionMediaExists {
+		log.Println("Migration: Adding media column to entry_revisions...")
+		_, err = db.Exec(`ALTER TABLE entry_revisions ADD COLUMN media TEXT`)
+		if err != nil {
+			return fmt.Errorf("failed to add entry_revisions media column: %v", err)
+		}
+	}
+
+	// Create webmentions table holding responses received from other sites
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webmentions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			entry_id INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			target TEXT NOT NULL,
+			type TEXT NOT NULL DEFAULT 'mention',
+			author_name TEXT NOT NULL DEFAULT '',
+			author_url TEXT NOT NULL DEFAULT '',
+			content TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'pending',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(source, target)
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create webmentions table: %v", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_webmentions_entry ON webmentions(entry_id, status)`)
+	if err != nil {
+		log.Printf("Warning: failed to create index on webmentions: %v", err)
+	}
+
+	// Create webmention_queue table holding Webmentions to send for our posts
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webmention_queue (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			entry_id INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			target TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(entry_id, target)
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create webmention_queue table: %v", err)
+	}
+
+	// Create access_tokens table holding hashed bearer tokens for Micropub apps
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS access_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token_hash TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			scope TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create access_tokens table: %v", err)
+	}
+
+	// Add client_id column to access_tokens for tokens issued through IndieAuth
+	var tokenClientIDExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('access_tokens') WHERE name='client_id'").Scan(&tokenClientIDExists)
+	if err == nil && !tokenClientIDExists {
+		log.Println("Migration: Adding client_id column to access_tokens...")
+		_, err = db.Exec(`ALTER TABLE access_tokens ADD COLUMN client_id TEXT NOT NULL DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add access_tokens client_id column: %v", err)
+		}
+	}
+
+	// Add activitypub_private_key column to site_settings for signing activities
+	var activityPubKeyExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('site_settings') WHERE name='activitypub_private_key'").Scan(&activityPubKeyExists)
+	if err == nil && !activityPubKeyExists {
+		log.Println("Migration: Adding activitypub_private_key column to site_settings...")
+		_, err = db.Exec(`ALTER TABLE site_settings ADD COLUMN activitypub_private_key TEXT`)
+		if err != nil {
+			return fmt.Errorf("failed to add activitypub_private_key column: %v", err)
+		}
+	}
+
+	// Add cookie_signing_key column to site_settings for signing cookies
+	var cookieKeyExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('site_settings') WHERE name='cookie_signing_key'").Scan(&cookieKeyExists)
+	if err == nil && !cookieKeyExists {
+		log.Println("Migration: Adding cookie_signing_key column to site_settings...")
+		_, err = db.Exec(`ALTER TABLE site_settings ADD COLUMN cookie_signing_key TEXT`)
+		if err != nil {
+			return fmt.Errorf("failed to add cookie_signing_key column: %v", err)
+		}
+	}
+
+	// Create activitypub_followers table holding fediverse accounts that
+	// follow the blog
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS activitypub_followers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			actor_id TEXT NOT NULL UNIQUE,
+			inbox TEXT NOT NULL,
+			shared_inbox TEXT NOT NULL DEFAULT '',
+			follow_id TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create activitypub_followers table: %v", err)
+	}
+
+	// Create activitypub_posts table recording which entries were announced
+	// to followers and under which address
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS activitypub_posts (
+			entry_id INTEGER PRIMARY KEY,
+			base_url TEXT NOT NULL,
+			state TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create activitypub_posts table: %v", err)
+	}
+
+	// Create activitypub_deliveries table holding signed activities to send
+	// to follower inboxes
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS activitypub_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			inbox TEXT NOT NULL,
+			activity TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME NOT NULL,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create activitypub_deliveries table: %v", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_activitypub_deliveries_due ON activitypub_deliveries(status, next_attempt_at)`)
+	if err != nil {
+		return fmt.Errorf("failed to create activitypub_deliveries index: %v", err)
+	}
+
+	// Create webhooks table holding endpoints notified of content changes
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create webhooks table: %v", err)
+	}
+
+	// Create webhook_deliveries table, the delivery queue and log of webhooks
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME NOT NULL,
+			response_status INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook_deliveries table: %v", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at)`)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook_deliveries index: %v", err)
+	}
+
+	// Create sessions table so admin logins survive restarts. Only a hash of
+	// each session token is stored.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token_hash TEXT NOT NULL UNIQUE,
+			remember INTEGER NOT NULL DEFAULT 0,
+			ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_seen_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create sessions table: %v", err)
+	}
+
+	// Create users table. Roles are "owner", "editor" and "author".
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			display_name TEXT NOT NULL DEFAULT '',
+			email TEXT NOT NULL DEFAULT '',
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'author',
+			password_change_required INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create users table: %v", err)
+	}
+
+	// Create user_invites table holding pending invite links
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_invites (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token_hash TEXT NOT NULL UNIQUE,
+			role TEXT NOT NULL,
+			email TEXT NOT NULL DEFAULT '',
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_invites table: %v", err)
+	}
+
+	// Add user_id columns tying sessions and access tokens to a user, and
+	// author_id to entries
+	for _, column := range []struct{ table, name string }{
+		{"sessions", "user_id"},
+		{"access_tokens", "user_id"},
+		{"entries", "author_id"},
+	} {
+		var exists bool
+		err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('"+column.table+"') WHERE name = ?", column.name).Scan(&exists)
+		if err == nil && !exists {
+			log.Printf("Migration: Adding %s column to %s...", column.name, column.table)
+			_, err = db.Exec(`ALTER TABLE ` + column.table + ` ADD COLUMN ` + column.name + ` INTEGER`)
+			if err != nil {
+				return fmt.Errorf("failed to add %s column to %s: %v", column.name, column.table, err)
+			}
+		}
+	}
+
+	// The single admin of earlier versions becomes the owner, with the admin
+	// password, and everything made so far is theirs
+	var userCount int
+	db.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount)
+	if userCount == 0 {
+		var adminHash sql.NullString
+		var changeRequired sql.NullBool
+		db.QueryRow("SELECT admin_password_hash, password_change_required FROM site_settings WHERE id = 1").Scan(&adminHash, &changeRequired)
+		if adminHash.String != "" {
+			log.Println("Migration: Creating owner account 'admin' from the admin password...")
+			_, err = db.Exec("INSERT INTO users (username, password_hash, role, password_change_required) VALUES ('admin', ?, 'owner', ?)",
+				adminHash.String, changeRequired.Bool)
+			if err != nil {
+				return fmt.Errorf("failed to create owner account: %v", err)
+			}
+		}
+	}
+	for _, table := range []string{"sessions", "access_tokens"} {
+		db.Exec(`UPDATE ` + table + ` SET user_id = (SELECT MIN(id) FROM users WHERE role = 'owner') WHERE user_id IS NULL`)
+	}
+	db.Exec(`UPDATE entries SET author_id = (SELECT MIN(id) FROM users WHERE role = 'owner') WHERE author_id IS NULL`)
+
+	// Add two-factor authentication columns to users. totp_last_step is the
+	// last time step a code was accepted for, so codes can't be replayed.
+	for _, column := range []struct{ name, definition string }{
+		{"totp_secret", "TEXT NOT NULL DEFAULT ''"},
+		{"totp_enabled", "INTEGER NOT NULL DEFAULT 0"},
+		{"totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+	} {
+		var exists bool
+		err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = ?", column.name).Scan(&exists)
+		if err == nil && !exists {
+			log.Printf("Migration: Adding %s column to users...", column.name)
+			_, err = db.Exec(`ALTER TABLE users ADD COLUMN ` + column.name + ` ` + column.definition)
+			if err != nil {
+				return fmt.Errorf("failed to add %s column to users: %v", column.name, err)
+			}
+		}
+	}
+
+	// Create recovery_codes table holding single-use two-factor backup codes
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create recovery_codes table: %v", err)
+	}
+
+	// Create passkeys table holding users' WebAuthn credentials
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS passkeys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			credential_id TEXT NOT NULL UNIQUE,
+			public_key BLOB NOT NULL,
+			sign_count INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create passkeys table: %v", err)
+	}
+
+	// Create failed_logins table logging wrong passwords and codes
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS failed_logins (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			form TEXT NOT NULL,
+			username TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create failed_logins table: %v", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_failed_logins_created ON failed_logins(created_at)`)
+	if err != nil {
+		return fmt.Errorf("failed to create failed_logins index: %v", err)
+	}
+
+	// Create the full-text search index. This needs SQLite built with FTS5
+	// (the sqlite_fts5 build tag); without it search falls back to LIKE. A
+	// new index is filled from the entries, and then kept up to date by
+	// syncSearchIndex.
+	var searchIndexExists bool
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='entries_fts'").Scan(&searchIndexExists)
+	if err == nil && !searchIndexExists {
+		log.Println("Migration: Creating search index...")
+		_, err = db.Exec(`CREATE VIRTUAL TABLE entries_fts USING fts5(title, content, tokenize = 'unicode61 remove_diacritics 2')`)
+	}
+	if err == nil {
+		// The table may exist from a build with FTS5 while this one lacks it
+		err = db.QueryRow("SELECT rowid FROM entries_fts LIMIT 1").Scan(new(int64))
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: full-text search index unavailable, using basic search: %v", err)
+		searchIndexEnabled = false
+	} else {
+		searchIndexEnabled = true
+		if !searchIndexExists {
+			rebuildSearchIndex()
+		}
+	}
+
 	return nil
 }
 
@@ -1494,6 +2270,15 @@ func validateMediaAndSave(file io.Reader, filename string, title string, created
 		return "", fmt.Errorf("file content does not match expected %s format", mediaType)
 	}
 
+	// Remove location and other camera metadata from photos before they are
+	// published
+	if mediaType == "photo" {
+		data, err = stripImageMetadata(data, getPhotoMetadataOptions())
+		if err != nil {
+			return "", fmt.Errorf("failed to process photo: %v", err)
+		}
+	}
+
 	// Generate filename from title (same pattern as slug)
 	slug := strings.TrimSpace(title)
 	slug = strings.ToLower(slug)
@@ -1522,7 +2307,7 @@ func validateMediaAndSave(file io.Reader, filename string, title string, created
 	slug = fmt.Sprintf("%s-%s", slug, dateStr)
 
 	// Save file and return path
-	newFilename := slug + filepath.Ext(filename)
+	newFilename := hashedUploadFilename(slug, filepath.Ext(filename), data)
 	filePath := filepath.Join(uploadsDir, newFilename)
 
 	if err := os.WriteFile(filePath, data, 0644); err != nil {
@@ -1532,6 +2317,30 @@ func validateMediaAndSave(file io.Reader, filename string, title string, created
 	return newFilename, nil
 }
 
+// uploadHashLength is how many hex digits of an upload's SHA-256 its name
+// carries
+const uploadHashLength = 12
+
+// hashedUploadPattern matches the name (without extension) of an upload that
+// carries its content hash: hashedUploadFilename's names and the all-hash
+// names of saveUploadedFile
+var hashedUploadPattern = regexp.MustCompile(`(^|-)[0-9a-f]{12,}$`)
+
+// hashedUploadFilename returns base plus a hash of data, so a name always
+// stands for the same file and gallery items sharing a title and date get
+// names of their own
+func hashedUploadFilename(base, ext string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s-%s%s", base, hex.EncodeToString(sum[:])[:uploadHashLength], ext)
+}
+
+// isHashedUpload reports whether an upload's name carries its content hash.
+// Those files never change. Uploads from before names were hashed may be
+// written over by a backup restore.
+func isHashedUpload(name string) bool {
+	return hashedUploadPattern.MatchString(strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
 // Backward compatibility wrapper
 func validateImageAndSave(file io.Reader, filename string, title string, createdAt time.Time) (string, error) {
 	return validateMediaAndSave(file, filename, title, createdAt, "photo")
@@ -1584,6 +2393,11 @@ func validateAndSaveThumbnail(file io.Reader, filename string, title string, cre
 		return "", fmt.Errorf("thumbnail content does not match expected image format")
 	}
 
+	data, err = stripImageMetadata(data, getPhotoMetadataOptions())
+	if err != nil {
+		return "", fmt.Errorf("failed to process thumbnail: %v", err)
+	}
+
 	// Generate filename from title with "thumb-" prefix
 	slug := strings.TrimSpace(title)
 	slug = strings.ToLower(slug)
@@ -1612,7 +2426,7 @@ func validateAndSaveThumbnail(file io.Reader, filename string, title string, cre
 	slug = fmt.Sprintf("thumb-%s-%s", slug, dateStr)
 
 	// Save file and return path
-	newFilename := slug + "." + ext
+	newFilename := hashedUploadFilename(slug, "."+ext, data)
 	filePath := filepath.Join(uploadsDir, newFilename)
 
 	if err := os.WriteFile(filePath, data, 0644); err != nil {
@@ -1641,6 +2455,13 @@ func validateAndResizeAvatar(file io.Reader, filename string) (string, error) {
 		return "", fmt.Errorf("only JPG and PNG files are supported for avatars")
 	}
 
+	// Apply the EXIF orientation so phone photos aren't sideways. Re-encoding
+	// below drops the remaining metadata.
+	data, err = stripImageMetadata(data, photoMetadataOptions{})
+	if err != nil {
+		return "", fmt.Errorf("invalid image file: %v", err)
+	}
+
 	// Decode image
 	var img image.Image
 	if ext == ".png" {
@@ -1676,65 +2497,636 @@ func validateAndResizeAvatar(file io.Reader, filename string) (string, error) {
 	return newFilename, nil
 }
 
-// Session management functions
-func generateSessionToken() (string, error) {
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+// ============================================================================
+// Photo metadata
+// ============================================================================
+
+// photoMetadataOptions lists the metadata the admin chose to keep on
+// uploaded photos. Location and everything else is always removed.
+type photoMetadataOptions struct {
+	KeepDate         bool // DateTimeOriginal
+	KeepCamera       bool // Make and Model
+	KeepColorProfile bool // embedded ICC profile
+}
+
+// exifFields holds the EXIF values read from a JPEG that stripping cares about
+type exifFields struct {
+	Orientation      int
+	Make             string
+	Model            string
+	DateTimeOriginal string
+}
+
+// getPhotoMetadataOptions reads the metadata settings
+func getPhotoMetadataOptions() photoMetadataOptions {
+	var keep sql.NullString
+	if err := db.QueryRow("SELECT photo_metadata_keep FROM site_settings WHERE id = 1").Scan(&keep); err != nil {
+		log.Printf("Error reading photo metadata settings: %v", err)
 	}
-	return base64.URLEncoding.EncodeToString(b), nil
+	return parsePhotoMetadataOptions(keep.String)
 }
 
-func createSession() (string, error) {
-	token, err := generateSessionToken()
-	if err != nil {
+// parsePhotoMetadataOptions parses the comma-separated photo_metadata_keep
+// setting ("date", "camera", "color")
+func parsePhotoMetadataOptions(value string) photoMetadataOptions {
+	var options photoMetadataOptions
+	for _, field := range strings.Split(value, ",") {
+		switch strings.TrimSpace(field) {
+		case "date":
+			options.KeepDate = true
+		case "camera":
+			options.KeepCamera = true
+		case "color":
+			options.KeepColorProfile = true
+		}
+	}
+	return options
+}
+
+// stripImageMetadata removes EXIF, XMP, IPTC and comment data from a JPEG,
+// PNG or WebP image. JPEGs with an EXIF orientation are rotated so they still
+// display upright without it. Other formats are returned unchanged.
+func stripImageMetadata(data []byte, options photoMetadataOptions) ([]byte, error) {
+	switch {
+	case len(data) >= 3 && data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF:
+		return stripJPEGMetadata(data, options)
+	case len(data) >= 8 && bytes.Equal(data[:8], []byte("\x89PNG\r\n\x1a\n")):
+		return stripPNGMetadata(data, options)
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return stripWebPMetadata(data, options)
+	}
+	return data, nil
+}
+
+// stripJPEGMetadata drops the metadata segments before the image data. The
+// Adobe color transform marker (APP14) is kept because it affects how the
+// image decodes, and ICC color profiles (APP2) if the admin keeps them.
+func stripJPEGMetadata(data []byte, options photoMetadataOptions) ([]byte, error) {
+	var exif exifFields
+	var colorProfile [][]byte
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, fmt.Errorf("malformed JPEG")
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++ // fill byte
+			continue
+		}
+		if marker == 0xDA {
+			// Start of scan: the rest is image data
+			out.Write(data[pos:])
+			break
+		}
+		length := int(data[pos+2])<<8 | int(data[pos+3])
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, fmt.Errorf("malformed JPEG segment")
+		}
+		segment := data[pos+4 : end]
+
+		keep := true
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			exif = parseEXIF(segment[6:])
+			keep = false
+		case marker == 0xE2 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")):
+			keep = options.KeepColorProfile
+			if keep {
+				colorProfile = append(colorProfile, data[pos:end])
+			}
+		case marker == 0xEE:
+			keep = true // Adobe
+		case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+			keep = false // XMP, IPTC, other application data, comments
+		}
+		if keep {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+
+	stripped := out.Bytes()
+	if exif.Orientation > 1 && exif.Orientation <= 8 {
+		img, err := jpeg.Decode(bytes.NewReader(stripped))
+		if err != nil {
+			return nil, fmt.Errorf("invalid image file: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, applyEXIFOrientation(img, exif.Orientation), &jpeg.Options{Quality: 92}); err != nil {
+			return nil, fmt.Errorf("failed to encode image: %v", err)
+		}
+		// The encoder writes no profile, so carry the original one over
+		encoded := buf.Bytes()
+		stripped = append([]byte{}, encoded[:2]...)
+		for _, segment := range colorProfile {
+			stripped = append(stripped, segment...)
+		}
+		stripped = append(stripped, encoded[2:]...)
+	}
+
+	// Put back the fields the admin chose to keep
+	var kept exifFields
+	if options.KeepDate {
+		kept.DateTimeOriginal = exif.DateTimeOriginal
+	}
+	if options.KeepCamera {
+		kept.Make, kept.Model = exif.Make, exif.Model
+	}
+	if segment := buildEXIFSegment(kept); segment != nil {
+		stripped = append(append(append([]byte{}, stripped[:2]...), segment...), stripped[2:]...)
+	}
+	return stripped, nil
+}
+
+// parseEXIF reads orientation, camera and capture date from a TIFF-structured
+// EXIF block. Unreadable values are left empty.
+func parseEXIF(tiff []byte) exifFields {
+	var fields exifFields
+	if len(tiff) < 8 {
+		return fields
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return fields
+	}
+
+	readASCII := func(entry []byte) string {
+		count := int(order.Uint32(entry[4:8]))
+		value := entry[8:12]
+		if count > 4 {
+			offset := int(order.Uint32(entry[8:12]))
+			if offset < 0 || offset+count > len(tiff) {
+				return ""
+			}
+			value = tiff[offset : offset+count]
+		} else {
+			value = value[:count]
+		}
+		return strings.TrimRight(string(value), "\x00 ")
+	}
+
+	var readIFD func(offset int, depth int)
+	readIFD = func(offset int, depth int) {
+		if depth > 1 || offset < 8 || offset+2 > len(tiff) {
+			return
+		}
+		count := int(order.Uint16(tiff[offset:]))
+		for i := 0; i < count; i++ {
+			start := offset + 2 + i*12
+			if start+12 > len(tiff) {
+				return
+			}
+			entry := tiff[start : start+12]
+			switch order.Uint16(entry[0:2]) {
+			case 0x0112:
+				fields.Orientation = int(order.Uint16(entry[8:10]))
+			case 0x010F:
+				fields.Make = readASCII(entry)
+			case 0x0110:
+				fields.Model = readASCII(entry)
+			case 0x9003:
+				fields.DateTimeOriginal = readASCII(entry)
+			case 0x8769:
+				readIFD(int(order.Uint32(entry[8:12])), depth+1)
+			}
+		}
+	}
+	readIFD(int(order.Uint32(tiff[4:8])), 0)
+	return fields
+}
+
+// buildEXIFSegment writes a minimal little-endian APP1 EXIF segment holding
+// only the given fields, or returns nil if there is nothing to keep
+func buildEXIFSegment(fields exifFields) []byte {
+	type asciiTag struct {
+		tag   uint16
+		value string
+	}
+	var ifd0 []asciiTag
+	if fields.Make != "" {
+		ifd0 = append(ifd0, asciiTag{0x010F, fields.Make})
+	}
+	if fields.Model != "" {
+		ifd0 = append(ifd0, asciiTag{0x0110, fields.Model})
+	}
+	hasDate := fields.DateTimeOriginal != ""
+	if len(ifd0) == 0 && !hasDate {
+		return nil
+	}
+
+	le := binary.LittleEndian
+	ifd0Count := len(ifd0)
+	if hasDate {
+		ifd0Count++
+	}
+	ifd0Size := 2 + ifd0Count*12 + 4
+	exifIFDOffset := 8 + ifd0Size
+	exifIFDSize := 2 + 12 + 4
+	dataOffset := exifIFDOffset
+	if hasDate {
+		dataOffset += exifIFDSize
+	}
+
+	var tiff, extra []byte
+	tiff = append(tiff, 'I', 'I', 0x2A, 0x00, 8, 0, 0, 0)
+	writeEntry := func(buf []byte, tag, typ uint16, count, value uint32) []byte {
+		entry := make([]byte, 12)
+		le.PutUint16(entry[0:], tag)
+		le.PutUint16(entry[2:], typ)
+		le.PutUint32(entry[4:], count)
+		le.PutUint32(entry[8:], value)
+		return append(buf, entry...)
+	}
+	writeASCII := func(buf []byte, tag uint16, value string) []byte {
+		text := append([]byte(value), 0)
+		if len(text) <= 4 {
+			var inline [4]byte
+			copy(inline[:], text)
+			return writeEntry(buf, tag, 2, uint32(len(text)), le.Uint32(inline[:]))
+		}
+		offset := dataOffset + len(extra)
+		extra = append(extra, text...)
+		if len(extra)%2 == 1 {
+			extra = append(extra, 0)
+		}
+		return writeEntry(buf, tag, 2, uint32(len(text)), uint32(offset))
+	}
+
+	tiff = append(tiff, byte(ifd0Count), byte(ifd0Count>>8))
+	for _, t := range ifd0 {
+		tiff = writeASCII(tiff, t.tag, t.value)
+	}
+	if hasDate {
+		tiff = writeEntry(tiff, 0x8769, 4, 1, uint32(exifIFDOffset))
+	}
+	tiff = append(tiff, 0, 0, 0, 0)
+	if hasDate {
+		tiff = append(tiff, 1, 0)
+		tiff = writeASCII(tiff, 0x9003, fields.DateTimeOriginal)
+		tiff = append(tiff, 0, 0, 0, 0)
+	}
+	tiff = append(tiff, extra...)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	if len(payload)+2 > 0xFFFF {
+		return nil
+	}
+	segment := []byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	return append(segment, payload...)
+}
+
+// applyEXIFOrientation returns img transformed so that it displays upright
+// without the EXIF orientation tag (values 2-8)
+func applyEXIFOrientation(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated 90° counter-clockwise
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			dst.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// stripPNGMetadata drops the eXIf, text and timestamp chunks of a PNG
+func stripPNGMetadata(data []byte, options photoMetadataOptions) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:8])
+	pos := 8
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return nil, fmt.Errorf("malformed PNG")
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, fmt.Errorf("malformed PNG chunk")
+		}
+		switch chunkType := string(data[pos+4 : pos+8]); {
+		case chunkType == "eXIf", chunkType == "tEXt", chunkType == "zTXt", chunkType == "iTXt", chunkType == "tIME":
+		case chunkType == "iCCP" && !options.KeepColorProfile:
+		default:
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+	return out.Bytes(), nil
+}
+
+// stripWebPMetadata drops the EXIF and XMP chunks of a WebP image, and the
+// ICC profile unless the admin keeps it, and clears their flags in the VP8X
+// header
+func stripWebPMetadata(data []byte, options photoMetadataOptions) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	pos := 12
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, fmt.Errorf("malformed WebP")
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size%2
+		if size < 0 || end > len(data) {
+			return nil, fmt.Errorf("malformed WebP chunk")
+		}
+		switch chunkType := string(data[pos : pos+4]); {
+		case chunkType == "EXIF", chunkType == "XMP ":
+		case chunkType == "ICCP" && !options.KeepColorProfile:
+		case chunkType == "VP8X":
+			chunk := append([]byte{}, data[pos:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04
+				if !options.KeepColorProfile {
+					chunk[8] &^= 0x20
+				}
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+	return stripped, nil
+}
+
+// Session management functions
+func generateSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
 
-	session := &Session{
-		Token:     token,
-		ExpiresAt: time.Now().Add(60 * time.Minute),
+const (
+	// sessionIdleTimeout signs out sessions that haven't been used for a while
+	sessionIdleTimeout = 60 * time.Minute
+	// rememberedSessionLifetime is the idle timeout with "remember me"
+	rememberedSessionLifetime = 30 * 24 * time.Hour
+)
+
+func sessionLifetime(remember bool) time.Duration {
+	if remember {
+		return rememberedSessionLifetime
 	}
+	return sessionIdleTimeout
+}
 
-	sessionMutex.Lock()
-	sessions[token] = session
-	sessionMutex.Unlock()
+// createSession stores a new session for the user signing in with the
+// request and returns its token
+func createSession(r *http.Request, userID int, remember bool) (string, error) {
+	token, err := generateSessionToken()
+	if err != nil {
+		return "", err
+	}
 
+	now := time.Now().UTC()
+	userAgent := r.UserAgent()
+	if len(userAgent) > 300 {
+		userAgent = userAgent[:300]
+	}
+	_, err = db.Exec(`
+		INSERT INTO sessions (token_hash, user_id, remember, ip, user_agent, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, hashAccessToken(token), userID, remember, requestIP(r), userAgent, now.Format(sqliteTimeLayout),
+		now.Format(sqliteTimeLayout), now.Add(sessionLifetime(remember)).Format(sqliteTimeLayout))
+	if err != nil {
+		return "", err
+	}
 	return token, nil
 }
 
+// getSession returns the unexpired session with the given token
 func getSession(token string) (*Session, bool) {
-	sessionMutex.RLock()
-	defer sessionMutex.RUnlock()
-
-	session, exists := sessions[token]
-	if !exists {
+	var session Session
+	err := db.QueryRow(`
+		SELECT id, user_id, remember, ip, user_agent, created_at, last_seen_at, expires_at
+		FROM sessions WHERE token_hash = ? AND expires_at > ?
+	`, hashAccessToken(token), time.Now().UTC().Format(sqliteTimeLayout)).
+		Scan(&session.ID, &session.UserID, &session.Remember, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
 		return nil, false
 	}
+	return &session, true
+}
 
-	if time.Now().After(session.ExpiresAt) {
-		return nil, false
+// touchSession slides a session's expiry forward. Writes are skipped while
+// the session was seen less than a minute ago; it reports whether the
+// session was extended.
+func touchSession(session *Session) bool {
+	now := time.Now().UTC()
+	if now.Sub(session.LastSeenAt) < time.Minute {
+		return false
 	}
-
-	return session, true
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(sessionLifetime(session.Remember))
+	_, err := db.Exec("UPDATE sessions SET last_seen_at = ?, expires_at = ? WHERE id = ?",
+		now.Format(sqliteTimeLayout), session.ExpiresAt.Format(sqliteTimeLayout), session.ID)
+	if err != nil {
+		log.Printf("Error extending session: %v", err)
+		return false
+	}
+	return true
 }
 
 func deleteSession(token string) {
-	sessionMutex.Lock()
-	delete(sessions, token)
-	sessionMutex.Unlock()
+	if _, err := db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashAccessToken(token)); err != nil {
+		log.Printf("Error deleting session: %v", err)
+	}
 }
 
 func cleanupExpiredSessions() {
-	sessionMutex.Lock()
-	defer sessionMutex.Unlock()
+	if _, err := db.Exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now().UTC().Format(sqliteTimeLayout)); err != nil {
+		log.Printf("Error removing expired sessions: %v", err)
+	}
+}
 
-	now := time.Now()
-	for token, session := range sessions {
-		if now.After(session.ExpiresAt) {
-			delete(sessions, token)
+// getSessions returns the active sessions of a user, most recently used first
+func getSessions(r *http.Request, userID int) ([]Session, error) {
+	var currentHash string
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		currentHash = hashAccessToken(cookie.Value)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, token_hash, user_id, remember, ip, user_agent, created_at, last_seen_at, expires_at
+		FROM sessions WHERE user_id = ? AND expires_at > ?
+		ORDER BY last_seen_at DESC
+	`, userID, time.Now().UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		var tokenHash string
+		if err := rows.Scan(&s.ID, &tokenHash, &s.UserID, &s.Remember, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		s.Current = tokenHash == currentHash
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// setSessionCookie sends the session cookie. Remembered sessions outlive the
+// browser; others end with it or after sessionIdleTimeout, whichever is first.
+func setSessionCookie(w http.ResponseWriter, token string, remember bool) {
+	cookie := &http.Cookie{
+		Name:     "session_token",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if remember {
+		cookie.MaxAge = int(rememberedSessionLifetime.Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+// defaultTrustedProxies covers Caddy on the same host. A proxy elsewhere,
+// such as Caddy in another container, has to be listed in TRUSTED_PROXIES:
+// trusting whole private ranges would let anyone on them pick their address.
+// An unlisted proxy is noticed by noteForwardingPeer.
+const defaultTrustedProxies = "127.0.0.0/8,::1/128"
+
+// parseTrustedProxies parses a comma-separated list of addresses and CIDR
+// ranges. "none" trusts no proxy.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	if strings.TrimSpace(list) == "none" {
+		return nets, nil
+	}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// noteForwardingPeer remembers an untrusted peer that forwards for others.
+// The first time, it warns loudly: every client behind it shares its address
+// in the sign-in throttle, the session list and the failed sign-in log.
+func noteForwardingPeer(addr string) {
+	forwardingPeersMutex.Lock()
+	_, seen := forwardingPeers[addr]
+	forwardingPeers[addr] = time.Now()
+	forwardingPeersMutex.Unlock()
+	if !seen {
+		log.Printf("WARNING: %s sent X-Forwarded-For but is not in TRUSTED_PROXIES. "+
+			"If it is your reverse proxy, add it to TRUSTED_PROXIES, or all visitors will share its address "+
+			"and sign-in throttling will slow down everyone behind it.", addr)
+	}
+}
+
+// isForwardingPeer reports whether an untrusted address recently forwarded
+// requests for other clients
+func isForwardingPeer(addr string) bool {
+	forwardingPeersMutex.Lock()
+	defer forwardingPeersMutex.Unlock()
+	_, ok := forwardingPeers[addr]
+	return ok
+}
+
+// requestIP returns the address of the client making the request, without
+// the port. Requests from a trusted proxy take it from X-Forwarded-For, read
+// from the right so that addresses the client made up are never reached.
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		if r.Header.Get("X-Forwarded-For") != "" {
+			noteForwardingPeer(host)
+		}
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		host = addr
+		if !isTrustedProxy(addr) {
+			break
 		}
 	}
+	return host
 }
 
 func isAuthenticated(r *http.Request) bool {
@@ -1749,14 +3141,23 @@ func isAuthenticated(r *http.Request) bool {
 
 func requireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !isAuthenticated(r) {
+		var session *Session
+		cookie, err := r.Cookie("session_token")
+		if err == nil {
+			session, _ = getSession(cookie.Value)
+		}
+		if session == nil {
 			http.Redirect(w, r, "/login?redirect="+r.URL.Path, http.StatusSeeOther)
 			return
 		}
+		// Each visit extends the session; remembered ones renew their cookie
+		if touchSession(session) && session.Remember {
+			setSessionCookie(w, cookie.Value, true)
+		}
 
 		// Check if password change is required (first login with auto-generated password)
 		// Skip this check if already on the change-password page
-		if r.URL.Path != "/change-password" && isPasswordChangeRequired() {
+		if r.URL.Path != "/change-password" && isPasswordChangeRequired(session.UserID) {
 			http.Redirect(w, r, "/change-password", http.StatusSeeOther)
 			return
 		}
@@ -1818,2862 +3219,12697 @@ func linkifyContent(content string) template.HTML {
 	return template.HTML(linked)
 }
 
-func generateSlug(title string, createdAt time.Time) string {
-	// Trim leading/trailing whitespace
-	slug := strings.TrimSpace(title)
-
-	// Convert to lowercase
-	slug = strings.ToLower(slug)
-
-	// Remove punctuation (keep only alphanumeric and spaces)
-	reg := regexp.MustCompile(`[^a-z0-9\s]+`)
-	slug = reg.ReplaceAllString(slug, "")
-
-	// Replace spaces with hyphens
-	slug = strings.ReplaceAll(slug, " ", "-")
+// renderContent renders stored post content according to its content_format.
+// Posts created before Markdown support are "plain" and keep the original
+// escape-and-linkify rendering.
+func renderContent(content, format string) template.HTML {
+	if format == "markdown" {
+		return renderMarkdown(content)
+	}
+	return linkifyContent(content)
+}
 
-	// Collapse multiple hyphens into one
-	multiHyphen := regexp.MustCompile(`-+`)
-	slug = multiHyphen.ReplaceAllString(slug, "-")
+// renderContentPreview renders the first maxLength characters of a post for
+// lists, reporting whether anything was cut. Markdown is rendered before it is
+// cut, so the preview is the post's visible text rather than half a link or an
+// unclosed code span.
+func renderContentPreview(content, format string, maxLength int) (template.HTML, bool) {
+	if format != "markdown" {
+		return renderContent(truncateContent(content, maxLength), format), len([]rune(content)) > maxLength
+	}
+	text := htmlText(string(renderMarkdown(content)))
+	return template.HTML(html.EscapeString(truncateContent(text, maxLength))), len([]rune(text)) > maxLength
+}
 
-	// Remove leading/trailing hyphens
-	slug = strings.Trim(slug, "-")
+// Markdown rendering
+//
+// renderMarkdown only ever emits a fixed set of tags and escapes every piece of
+// source text, so raw HTML typed into a post is shown literally instead of being
+// interpreted by the browser. Link and image URLs are restricted to http, https,
+// mailto and relative references.
+var (
+	mdHeadingRegex = regexp.MustCompile(`^ {0,3}(#{1,6})[ \t]+(.*?)[ \t]*#*[ \t]*$`)
+	mdRuleRegex    = regexp.MustCompile(`^ {0,3}((\*[ \t]*){3,}|(-[ \t]*){3,}|(_[ \t]*){3,})$`)
+	mdFenceRegex   = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ \t]*([A-Za-z0-9_+-]*)")
+	mdBulletRegex  = regexp.MustCompile(`^( *)([-*+])([ \t]+)(.*)$`)
+	mdOrderedRegex = regexp.MustCompile(`^( *)(\d{1,9})[.)]([ \t]+)(.*)$`)
+	mdLinkRegex    = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+)
 
-	// Truncate to 80 characters if needed
-	if len(slug) > 80 {
-		slug = slug[:80]
-		// Remove trailing hyphen if truncation created one
-		slug = strings.TrimRight(slug, "-")
-	}
+const mdEscapable = "\\`*_{}[]()#+-.!~>|<\""
 
-	// If slug is empty after processing, use fallback
-	if slug == "" {
-		slug = "post"
+func renderMarkdown(content string) template.HTML {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = expandLeadingTabs(line)
 	}
 
-	// Append date in format: yyyy-mm-dd
-	dateStr := createdAt.Format("2006-01-02")
-	slug = fmt.Sprintf("%s-%s", slug, dateStr)
+	var b strings.Builder
+	renderMarkdownBlocks(&b, lines, false)
+	return template.HTML(strings.TrimSuffix(b.String(), "\n"))
+}
 
-	return slug
+// expandLeadingTabs replaces tabs in a line's indentation with four spaces so
+// list nesting can be measured in columns
+func expandLeadingTabs(line string) string {
+	i := 0
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	return strings.ReplaceAll(line[:i], "\t", "    ") + line[i:]
 }
 
-func getEntries(offset, limit int) ([]EntryDisplay, bool, error) {
-	query := `
-		SELECT id, title, content, photo_path, media_type, thumbnail_path, slug, created_at
-		FROM entries
-		ORDER BY created_at DESC
-		LIMIT ? OFFSET ?
-	`
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
 
-	rows, err := db.Query(query, limit+1, offset)
-	if err != nil {
-		return nil, false, err
+// renderMarkdownBlocks renders block-level Markdown. In tight mode (list items
+// without blank lines between them) paragraphs are written without <p> tags.
+func renderMarkdownBlocks(b *strings.Builder, lines []string, tight bool) {
+	var para []string
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		if !tight {
+			b.WriteString("<p>")
+		}
+		b.WriteString(renderMarkdownParagraph(para))
+		if !tight {
+			b.WriteString("</p>")
+		}
+		b.WriteString("\n")
+		para = nil
 	}
-	defer rows.Close()
 
-	var entries []EntryDisplay
-	count := 0
-	for rows.Next() {
-		count++
-		if count > limit {
-			break
-		}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
 
-		var entry Entry
-		var title sql.NullString
-		var photoPath sql.NullString
-		var mediaType sql.NullString
-		var thumbnailPath sql.NullString
-		var slug sql.NullString
+		if trimmed == "" {
+			flush()
+			i++
+			continue
+		}
 
-		err := rows.Scan(&entry.ID, &title, &entry.Content, &photoPath, &mediaType, &thumbnailPath, &slug, &entry.CreatedAt)
-		if err != nil {
-			log.Printf("Row scan error: %v", err)
+		if m := mdFenceRegex.FindStringSubmatch(line); m != nil {
+			flush()
+			fence := m[1]
+			lang := m[2]
+			i++
+			var code []string
+			for i < len(lines) {
+				t := strings.TrimSpace(lines[i])
+				if strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]) == "" {
+					i++
+					break
+				}
+				code = append(code, lines[i])
+				i++
+			}
+			b.WriteString("<pre><code")
+			if lang != "" {
+				b.WriteString(` class="language-` + lang + `"`)
+			}
+			b.WriteString(">")
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
 			continue
 		}
 
-		if title.Valid {
-			entry.Title = title.String
+		if m := mdHeadingRegex.FindStringSubmatch(line); m != nil {
+			flush()
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">" + renderMarkdownInline(m[2]) + "</h" + level + ">\n")
+			i++
+			continue
 		}
 
-		if slug.Valid {
-			entry.Slug = slug.String
+		if mdRuleRegex.MatchString(line) {
+			flush()
+			b.WriteString("<hr>\n")
+			i++
+			continue
 		}
 
-		if mediaType.Valid {
-			entry.MediaType = mediaType.String
-		} else {
-			entry.MediaType = "photo" // default for old entries
+		if strings.HasPrefix(trimmed, ">") {
+			flush()
+			var quoted []string
+			for i < len(lines) {
+				t := strings.TrimSpace(lines[i])
+				if !strings.HasPrefix(t, ">") {
+					break
+				}
+				t = strings.TrimPrefix(t, ">")
+				t = strings.TrimPrefix(t, " ")
+				quoted = append(quoted, t)
+				i++
+			}
+			b.WriteString("<blockquote>\n")
+			renderMarkdownBlocks(b, quoted, false)
+			b.WriteString("</blockquote>\n")
+			continue
 		}
 
-		var photoURL template.URL
-		hasPhoto := false
-		hasAudio := false
-		hasVideo := false
+		if _, _, _, _, ok := parseMarkdownListMarker(line); ok {
+			flush()
+			i = renderMarkdownList(b, lines, i)
+			continue
+		}
 
-		if photoPath.Valid && photoPath.String != "" {
-			photoURL = template.URL("/uploads/" + photoPath.String)
-			if entry.MediaType == "photo" {
-				hasPhoto = true
-			} else if entry.MediaType == "audio" {
-				hasAudio = true
-			} else if entry.MediaType == "video" {
-				hasVideo = true
+		para = append(para, line)
+		i++
+	}
+	flush()
+}
+
+// parseMarkdownListMarker reports whether line starts a list item, returning
+// the marker indentation, whether the list is ordered, the item number, and the
+// column at which the item text starts.
+func parseMarkdownListMarker(line string) (indent int, ordered bool, number int, contentCol int, ok bool) {
+	if mdRuleRegex.MatchString(line) {
+		return 0, false, 0, 0, false
+	}
+	if m := mdBulletRegex.FindStringSubmatch(line); m != nil {
+		return len(m[1]), false, 0, len(m[1]) + 1 + len(m[3]), true
+	}
+	if m := mdOrderedRegex.FindStringSubmatch(line); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		return len(m[1]), true, n, len(m[1]) + len(m[2]) + 1 + len(m[3]), true
+	}
+	return 0, false, 0, 0, false
+}
+
+// renderMarkdownList renders the list starting at lines[start] and returns the
+// index of the first line after it
+func renderMarkdownList(b *strings.Builder, lines []string, start int) int {
+	indent, ordered, number, _, _ := parseMarkdownListMarker(lines[start])
+
+	var items [][]string
+	itemContentCol := 0
+	tight := true
+	i := start
+	for i < len(lines) {
+		line := lines[i]
+		ind, ord, _, contentCol, isMarker := parseMarkdownListMarker(line)
+
+		if isMarker && ind <= indent {
+			if ord != ordered {
+				break
 			}
+			items = append(items, []string{line[contentCol:]})
+			itemContentCol = contentCol
+			i++
+			continue
 		}
 
-		var thumbnailURL template.URL
-		hasThumbnail := false
-		if thumbnailPath.Valid && thumbnailPath.String != "" {
-			thumbnailURL = template.URL("/uploads/" + thumbnailPath.String)
-			hasThumbnail = true
+		current := len(items) - 1
+		if strings.TrimSpace(line) == "" {
+			// A blank line continues the list only if more indented content
+			// or another item of the same list follows it
+			next := i + 1
+			for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+				next++
+			}
+			if next >= len(lines) {
+				break
+			}
+			nInd, nOrd, _, _, nMarker := parseMarkdownListMarker(lines[next])
+			if leadingSpaces(lines[next]) <= indent && !(nMarker && nInd <= indent && nOrd == ordered) {
+				break
+			}
+			tight = false
+			items[current] = append(items[current], "")
+			i++
+			continue
 		}
 
-		truncatedContent := truncateContent(entry.Content, 150)
+		if leadingSpaces(line) > indent {
+			// Nested content: strip the item's indentation
+			items[current] = append(items[current], line[min(leadingSpaces(line), itemContentCol):])
+			i++
+			continue
+		}
 
-		entries = append(entries, EntryDisplay{
-			ID:            entry.ID,
-			Title:         entry.Title,
-			Content:       linkifyContent(truncatedContent),
-			Slug:          entry.Slug,
-			Photo:         photoURL,
-			HasPhoto:      hasPhoto,
-			MediaType:     entry.MediaType,
-			HasAudio:      hasAudio,
-			HasVideo:      hasVideo,
-			Thumbnail:     thumbnailURL,
-			HasThumbnail:  hasThumbnail,
-			CreatedAt:     entry.CreatedAt,
-			TimeAgo:       timeAgo(entry.CreatedAt),
-			InitialLetter: getInitialLetter(entry.Content),
-		})
+		// Lazy continuation of the previous item's paragraph
+		prev := items[current][len(items[current])-1]
+		if strings.TrimSpace(prev) != "" && !isMarker && !strings.HasPrefix(strings.TrimSpace(line), ">") &&
+			!mdHeadingRegex.MatchString(line) && !mdFenceRegex.MatchString(line) && !mdRuleRegex.MatchString(line) {
+			items[current] = append(items[current], strings.TrimSpace(line))
+			i++
+			continue
+		}
+		break
 	}
 
-	hasMore := count > limit
-	return entries, hasMore, nil
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag)
+	if ordered && number != 1 {
+		b.WriteString(` start="` + strconv.Itoa(number) + `"`)
+	}
+	b.WriteString(">\n")
+	for _, item := range items {
+		b.WriteString("<li>")
+		renderMarkdownBlocks(b, item, tight)
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
 }
 
-// Blog viewer handlers
-func handleBlogFeed(w http.ResponseWriter, r *http.Request) {
-	// Auto-detect hostname from first request
-	detectHostnameFromRequest(r)
-
-	tmpl, err := template.New("feed").Parse(viewerTemplate)
-	if err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		log.Printf("Template error: %v", err)
-		return
+// renderMarkdownParagraph joins paragraph lines and renders their inline
+// content. Lines ending in two spaces or a backslash become hard line breaks.
+func renderMarkdownParagraph(lines []string) string {
+	parts := make([]string, len(lines))
+	for i, line := range lines {
+		hard := strings.HasSuffix(line, "  ")
+		line = strings.TrimSpace(line)
+		if hard && i < len(lines)-1 && !strings.HasSuffix(line, "\\") {
+			line += "\\"
+		}
+		if i == len(lines)-1 {
+			line = strings.TrimSuffix(line, "\\")
+		}
+		parts[i] = line
 	}
+	return renderMarkdownInline(strings.Join(parts, "\n"))
+}
 
-	entries, hasMore, err := getEntries(0, 10)
-	if err != nil {
-		http.Error(w, "Database query error", http.StatusInternalServerError)
-		log.Printf("Database query error: %v", err)
-		return
+// renderMarkdownInline renders inline Markdown: code spans, emphasis, links,
+// images, autolinks and bare URLs. Everything else is HTML-escaped.
+func renderMarkdownInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == '\\' && i+1 < len(s):
+			next := s[i+1]
+			if next == '\n' {
+				b.WriteString("<br>\n")
+				i += 2
+				continue
+			}
+			if strings.IndexByte(mdEscapable, next) >= 0 {
+				b.WriteString(html.EscapeString(string(next)))
+				i += 2
+				continue
+			}
+
+		case c == '`':
+			n := runLength(s[i:], '`')
+			fence := strings.Repeat("`", n)
+			if end := strings.Index(s[i+n:], fence); end >= 0 {
+				code := strings.ReplaceAll(s[i+n:i+n+end], "\n", " ")
+				if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+					code = code[1 : len(code)-1]
+				}
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += n + end + n
+				continue
+			}
+			b.WriteString(fence)
+			i += n
+			continue
+
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			if text, dest, n, ok := parseMarkdownLink(s[i+1:]); ok {
+				if src, ok := sanitizeMarkdownURL(dest); ok {
+					fmt.Fprintf(&b, `<img src="%s" alt="%s" loading="lazy">`, html.EscapeString(src), html.EscapeString(markdownPlainText(text)))
+					i += 1 + n
+					continue
+				}
+			}
+
+		case c == '[':
+			if text, dest, n, ok := parseMarkdownLink(s[i:]); ok {
+				if href, ok := sanitizeMarkdownURL(dest); ok {
+					b.WriteString(markdownAnchor(href, renderMarkdownInline(text)))
+					i += n
+					continue
+				}
+			}
+
+		case c == '<':
+			if end := strings.IndexByte(s[i:], '>'); end > 0 {
+				inner := s[i+1 : i+end]
+				if (strings.HasPrefix(inner, "http://") || strings.HasPrefix(inner, "https://")) && !strings.ContainsAny(inner, " \t\n<") {
+					if href, ok := sanitizeMarkdownURL(inner); ok {
+						b.WriteString(markdownAnchor(href, html.EscapeString(inner)))
+						i += end + 1
+						continue
+					}
+				}
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			if out, n, ok := renderMarkdownEmphasis(s, i); ok {
+				b.WriteString(out)
+				i += n
+				continue
+			}
+			// Write the whole delimiter run literally so a failed opener is
+			// not retried one character later
+			n := runLength(s[i:], c)
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case c == 'h' && (strings.HasPrefix(s[i:], "http://") || strings.HasPrefix(s[i:], "https://")):
+			if i == 0 || !isWordByte(s[i-1]) {
+				if loc := urlRegex.FindStringIndex(s[i:]); loc != nil && loc[0] == 0 {
+					raw := strings.TrimRight(s[i:i+loc[1]], ".,;:!?'*_~)")
+					if href, ok := sanitizeMarkdownURL(raw); ok {
+						b.WriteString(markdownAnchor(href, html.EscapeString(raw)))
+						i += len(raw)
+						continue
+					}
+				}
+			}
+		}
+
+		switch c {
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		case '&':
+			b.WriteString("&amp;")
+		case '"':
+			b.WriteString("&#34;")
+		case '\'':
+			b.WriteString("&#39;")
+		default:
+			b.WriteByte(c)
+		}
+		i++
 	}
+	return b.String()
+}
 
-	var totalEntries, todayEntries int
-	db.QueryRow("SELECT COUNT(*) FROM entries").Scan(&totalEntries)
-	db.QueryRow("SELECT COUNT(*) FROM entries WHERE DATE(created_at) = DATE('now')").Scan(&todayEntries)
+// renderMarkdownEmphasis renders *em*, **strong**, ***both*** and ~~strike~~
+// starting at s[i], returning the HTML and the number of bytes consumed
+func renderMarkdownEmphasis(s string, i int) (string, int, bool) {
+	c := s[i]
+	n := runLength(s[i:], c)
+	if c == '~' {
+		if n != 2 {
+			return "", 0, false
+		}
+	} else if n > 3 {
+		return "", 0, false
+	}
 
-	// Get settings from database
-	settings, err := getSiteSettings()
-	if err != nil {
-		log.Printf("Error getting site settings: %v", err)
-		// Use defaults if error
-		settings = SiteSettings{
-			SiteTitle:    "My Blog",
-			SiteSubtitle: "A Personal Blog",
-			UserInitial:  "AB",
-			SiteTheme:    "default",
+	if out, used, ok := renderMarkdownEmphasisRun(s, i, n); ok {
+		return out, used, true
+	}
+	// A run of three can also open two emphases that close separately, as
+	// in ***strong** em* or ***em* strong**. The first to close is inside.
+	if n == 3 {
+		if inner := firstMarkdownCloser(s, i+n, c); inner == 1 || inner == 2 {
+			return renderMarkdownEmphasisRun(s, i, n-inner)
 		}
 	}
+	return "", 0, false
+}
 
-	data := ViewerPageData{
-		Entries:          entries,
-		TotalEntries:     totalEntries,
-		TodayEntries:     todayEntries,
-		SiteTitle:        settings.SiteTitle,
-		SiteSubtitle:     settings.SiteSubtitle,
-		EnableSubtitle:   enableSubtitle,
-		UserInitial:      settings.UserInitial,
-		AvatarPath:       settings.AvatarPath,
-		AvatarPreference: settings.AvatarPreference,
-		InitialCount:     len(entries),
-		HasMore:          hasMore,
-		ThemeCSS:         template.CSS(getThemeCSS()),
+// firstMarkdownCloser returns the length of the first run of c from s[j] on
+// that can close emphasis, or 0 if there is none
+func firstMarkdownCloser(s string, j int, c byte) int {
+	for j < len(s) {
+		if s[j] == '`' {
+			m := runLength(s[j:], '`')
+			if end := strings.Index(s[j+m:], strings.Repeat("`", m)); end >= 0 {
+				j += m + end + m
+				continue
+			}
+		}
+		if s[j] != c {
+			j++
+			continue
+		}
+		r := runLength(s[j:], c)
+		if prev := s[j-1]; prev != ' ' && prev != '\n' && prev != '\t' {
+			return r
+		}
+		j += r
 	}
+	return 0
+}
 
-	err = tmpl.Execute(w, data)
-	if err != nil {
-		log.Printf("Template execution error: %v", err)
+// renderMarkdownEmphasisRun renders emphasis opened by the first n delimiters
+// at s[i]
+func renderMarkdownEmphasisRun(s string, i, n int) (string, int, bool) {
+	c := s[i]
+	open := i + n
+	if open >= len(s) || s[open] == ' ' || s[open] == '\n' || s[open] == '\t' {
+		return "", 0, false
+	}
+	// Intraword underscores (snake_case) never start emphasis
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		return "", 0, false
+	}
+
+	// Find a closing run of the same length that is not preceded by
+	// whitespace. A longer run may also close emphasis opened inside, as in
+	// **strong *em***, when the inner text ends with that opener.
+	for j := i + runLength(s[i:], c) + 1; j < len(s); {
+		if s[j] == '`' {
+			// Skip code spans so delimiters inside them are ignored
+			m := runLength(s[j:], '`')
+			if end := strings.Index(s[j+m:], strings.Repeat("`", m)); end >= 0 {
+				j += m + end + m
+				continue
+			}
+		}
+		if s[j] != c {
+			j++
+			continue
+		}
+		r := runLength(s[j:], c)
+		prev := s[j-1]
+		closes := r >= n && prev != ' ' && prev != '\n' && prev != '\t'
+		if closes && c == '_' && j+r < len(s) && isWordByte(s[j+r]) {
+			closes = false
+		}
+		if closes && r > n && (c == '~' || !hasMarkdownOpener(s[open:j], c, r-n)) {
+			closes = false
+		}
+		if closes {
+			inner := renderMarkdownInline(s[open : j+r-n])
+			var out string
+			switch {
+			case c == '~':
+				out = "<del>" + inner + "</del>"
+			case n == 1:
+				out = "<em>" + inner + "</em>"
+			case n == 2:
+				out = "<strong>" + inner + "</strong>"
+			default:
+				out = "<strong><em>" + inner + "</em></strong>"
+			}
+			return out, j + r - i, true
+		}
+		j += r
 	}
+	return "", 0, false
 }
 
-func handleAPIEntries(w http.ResponseWriter, r *http.Request) {
-	offset := 0
-	limit := 10
+// hasMarkdownOpener reports whether the last run of c in s is an opening run
+// of n delimiters, left open until the end of s
+func hasMarkdownOpener(s string, c byte, n int) bool {
+	end := strings.LastIndexByte(s, c)
+	if end < 0 {
+		return false
+	}
+	start := end
+	for start > 0 && s[start-1] == c {
+		start--
+	}
+	if end-start+1 != n || end+1 >= len(s) {
+		return false
+	}
+	if next := s[end+1]; next == ' ' || next == '\n' || next == '\t' {
+		return false
+	}
+	return !(c == '_' && start > 0 && isWordByte(s[start-1]))
+}
 
-	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
-		if val, err := strconv.Atoi(offsetStr); err == nil {
-			offset = val
+// parseMarkdownLink parses "[text](destination)" at the start of s and returns
+// the link text, destination and the number of bytes consumed
+func parseMarkdownLink(s string) (text, dest string, n int, ok bool) {
+	if len(s) == 0 || s[0] != '[' {
+		return "", "", 0, false
+	}
+
+	depth := 0
+	end := -1
+	for j := 0; j < len(s) && end < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				end = j
+			}
 		}
 	}
+	if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+		return "", "", 0, false
+	}
 
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if val, err := strconv.Atoi(limitStr); err == nil && val > 0 && val <= 50 {
-			limit = val
+	depth = 0
+	closeIdx := -1
+	for j := end + 1; j < len(s) && closeIdx < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				closeIdx = j
+			}
+		case '\n':
+			return "", "", 0, false
 		}
 	}
+	if closeIdx < 0 {
+		return "", "", 0, false
+	}
 
-	entries, hasMore, err := getEntries(offset, limit)
-	if err != nil {
-		http.Error(w, "Database query error", http.StatusInternalServerError)
-		log.Printf("Database query error: %v", err)
-		return
+	dest = strings.TrimSpace(s[end+2 : closeIdx])
+	// Drop an optional "title" after the destination
+	if sp := strings.IndexAny(dest, " \t"); sp >= 0 {
+		dest = dest[:sp]
 	}
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	return s[1:end], dest, closeIdx + 1, true
+}
 
-	response := APIResponse{
-		Entries: entries,
-		HasMore: hasMore,
+// sanitizeMarkdownURL allows only http, https, mailto and relative URLs
+func sanitizeMarkdownURL(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
 	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return raw, true
+	}
+	return "", false
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// markdownAnchor builds a link; external links open in a new tab like the
+// links produced by linkifyContent
+func markdownAnchor(href, inner string) string {
+	if strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") {
+		return fmt.Sprintf(`<a href="%s" target="_blank" rel="noopener noreferrer">%s</a>`, html.EscapeString(href), inner)
+	}
+	return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(href), inner)
 }
 
-func handleSinglePost(w http.ResponseWriter, r *http.Request) {
-	// Extract slug from URL path
-	// Expected format: /posts/slug-here/
-	path := r.URL.Path
-	slug := strings.TrimPrefix(path, "/posts/")
-	slug = strings.TrimSuffix(slug, "/")
+// markdownPlainText reduces a line of Markdown to its visible text. It is used
+// to derive post titles and image alt text.
+func markdownPlainText(s string) string {
+	s = strings.TrimSpace(s)
+	if m := mdHeadingRegex.FindStringSubmatch(s); m != nil {
+		s = m[2]
+	}
+	if _, _, _, contentCol, ok := parseMarkdownListMarker(s); ok {
+		s = s[contentCol:]
+	}
+	s = strings.TrimLeft(s, "> ")
+	s = mdLinkRegex.ReplaceAllString(s, "$1")
+	s = strings.NewReplacer("***", "", "**", "", "__", "", "~~", "", "`", "").Replace(s)
+	return strings.TrimSpace(s)
+}
 
-	if slug == "" {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+func runLength(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
 	}
+	return n
+}
 
-	// Query database for entry by slug
-	query := `
-		SELECT id, title, content, photo_path, media_type, thumbnail_path, slug, created_at
-		FROM entries
-		WHERE slug = ?
-		LIMIT 1
-	`
+func isWordByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
 
-	var entry Entry
-	var title sql.NullString
-	var photoPath sql.NullString
-	var mediaType sql.NullString
-	var thumbnailPath sql.NullString
-	var entrySlug sql.NullString
+// ============================================================================
+// Tags
+// ============================================================================
 
-	err := db.QueryRow(query, slug).Scan(&entry.ID, &title, &entry.Content, &photoPath, &mediaType, &thumbnailPath, &entrySlug, &entry.CreatedAt)
-	if err == sql.ErrNoRows {
-		handle404(w, r)
-		return
+var tagInvalidChars = regexp.MustCompile(`[^a-z0-9-]+`)
+var tagMultiHyphen = regexp.MustCompile(`-+`)
+
+// normalizeTag turns user input like " #Go Lang " into the URL-safe tag name
+// "go-lang". Returns "" if nothing usable is left.
+func normalizeTag(raw string) string {
+	tag := strings.ToLower(strings.TrimSpace(raw))
+	tag = strings.TrimLeft(tag, "#")
+	tag = strings.Join(strings.Fields(tag), "-")
+	tag = tagInvalidChars.ReplaceAllString(tag, "")
+	tag = tagMultiHyphen.ReplaceAllString(tag, "-")
+	tag = strings.Trim(tag, "-")
+	if len(tag) > 40 {
+		tag = strings.TrimRight(tag[:40], "-")
+	}
+	return tag
+}
+
+// parseTagInput parses the comma-separated tags field of the post form
+func parseTagInput(raw string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		tag := normalizeTag(part)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+		if len(tags) == 10 {
+			break
+		}
 	}
+	return tags
+}
+
+// setEntryTags replaces the tags of an entry and removes tags no longer used
+// by any entry
+func setEntryTags(entryID int64, tags []string) error {
+	tx, err := db.Begin()
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		log.Printf("Database error: %v", err)
-		return
+		return err
 	}
+	defer tx.Rollback()
 
-	if title.Valid {
-		entry.Title = title.String
+	if _, err := tx.Exec("DELETE FROM entry_tags WHERE entry_id = ?", entryID); err != nil {
+		return err
 	}
-	if photoPath.Valid {
-		entry.PhotoPath = photoPath.String
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO tags (name) VALUES (?)", tag); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO entry_tags (entry_id, tag_id) SELECT ?, id FROM tags WHERE name = ?", entryID, tag); err != nil {
+			return err
+		}
 	}
-	if mediaType.Valid {
-		entry.MediaType = mediaType.String
-	} else {
-		entry.MediaType = "photo"
+	if _, err := tx.Exec("DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM entry_tags)"); err != nil {
+		return err
 	}
-	if thumbnailPath.Valid {
-		entry.ThumbnailPath = thumbnailPath.String
+	return tx.Commit()
+}
+
+// getTagsForEntries returns the tag names of each given entry, keyed by entry ID
+func getTagsForEntries(ids []int) map[int][]string {
+	tagsByEntry := make(map[int][]string)
+	if len(ids) == 0 {
+		return tagsByEntry
 	}
-	if entrySlug.Valid {
-		entry.Slug = entrySlug.String
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
 	}
 
-	var photoURL template.URL
-	hasPhoto := false
-	hasAudio := false
-	hasVideo := false
-	if entry.PhotoPath != "" {
-		photoURL = template.URL("/uploads/" + entry.PhotoPath)
-		if entry.MediaType == "photo" {
-			hasPhoto = true
-		} else if entry.MediaType == "audio" {
-			hasAudio = true
-		} else if entry.MediaType == "video" {
-			hasVideo = true
+	rows, err := db.Query(`
+		SELECT et.entry_id, t.name
+		FROM entry_tags et
+		JOIN tags t ON t.id = et.tag_id
+		WHERE et.entry_id IN (`+placeholders+`)
+		ORDER BY t.name
+	`, args...)
+	if err != nil {
+		log.Printf("Error fetching tags: %v", err)
+		return tagsByEntry
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryID int
+		var name string
+		if err := rows.Scan(&entryID, &name); err == nil {
+			tagsByEntry[entryID] = append(tagsByEntry[entryID], name)
 		}
 	}
+	return tagsByEntry
+}
 
-	var thumbnailURL template.URL
-	hasThumbnail := false
-	if entry.ThumbnailPath != "" {
-		thumbnailURL = template.URL("/uploads/" + entry.ThumbnailPath)
-		hasThumbnail = true
-	}
+// entryHasTagCondition restricts an entries query to one tag (one argument)
+const entryHasTagCondition = "id IN (SELECT et.entry_id FROM entry_tags et JOIN tags t ON t.id = et.tag_id WHERE t.name = ?)"
 
-	// Check if content needs truncation
-	truncatedContent := truncateContent(entry.Content, 150)
-	isTruncated := len([]rune(entry.Content)) > 150
+// tagExists reports whether at least one public entry carries the tag
+func tagExists(tag string) bool {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM entries WHERE "+entryHasTagCondition+" AND "+publicEntryCondition, tag).Scan(&count)
+	return err == nil && count > 0
+}
 
-	entryDisplay := EntryDisplay{
-		ID:            entry.ID,
-		Title:         entry.Title,
-		Content:       linkifyContent(truncatedContent),
-		FullContent:   linkifyContent(entry.Content),
-		IsTruncated:   isTruncated,
-		Photo:         photoURL,
-		HasPhoto:      hasPhoto,
-		MediaType:     entry.MediaType,
-		HasAudio:      hasAudio,
-		HasVideo:      hasVideo,
-		Thumbnail:     thumbnailURL,
-		HasThumbnail:  hasThumbnail,
-		Slug:          entry.Slug,
-		CreatedAt:     entry.CreatedAt,
-		TimeAgo:       timeAgo(entry.CreatedAt),
-		InitialLetter: getInitialLetter(entry.Content),
+func generateSlug(title string, createdAt time.Time) string {
+	// Trim leading/trailing whitespace
+	slug := strings.TrimSpace(title)
+
+	// Convert to lowercase
+	slug = strings.ToLower(slug)
+
+	// Remove punctuation (keep only alphanumeric and spaces)
+	reg := regexp.MustCompile(`[^a-z0-9\s]+`)
+	slug = reg.ReplaceAllString(slug, "")
+
+	// Replace spaces with hyphens
+	slug = strings.ReplaceAll(slug, " ", "-")
+
+	// Collapse multiple hyphens into one
+	multiHyphen := regexp.MustCompile(`-+`)
+	slug = multiHyphen.ReplaceAllString(slug, "-")
+
+	// Remove leading/trailing hyphens
+	slug = strings.Trim(slug, "-")
+
+	// Truncate to 80 characters if needed
+	if len(slug) > 80 {
+		slug = slug[:80]
+		// Remove trailing hyphen if truncation created one
+		slug = strings.TrimRight(slug, "-")
 	}
 
-	// Get settings from database
-	settings, err := getSiteSettings()
-	if err != nil {
-		log.Printf("Error getting site settings: %v", err)
-		// Use defaults if error
-		settings = SiteSettings{
-			SiteTitle:    "My Blog",
-			SiteSubtitle: "A Personal Blog",
-			UserInitial:  "AB",
-			SiteTheme:    "default",
-		}
+	// If slug is empty after processing, use fallback
+	if slug == "" {
+		slug = "post"
 	}
 
-	data := SinglePostPageData{
-		Entry:            entryDisplay,
-		SiteTitle:        settings.SiteTitle,
-		SiteSubtitle:     settings.SiteSubtitle,
-		EnableSubtitle:   enableSubtitle,
-		UserInitial:      settings.UserInitial,
-		AvatarPath:       settings.AvatarPath,
-		AvatarPreference: settings.AvatarPreference,
-		ThemeCSS:         template.CSS(getThemeCSS()),
+	// Append date in format: yyyy-mm-dd
+	dateStr := createdAt.Format("2006-01-02")
+	slug = fmt.Sprintf("%s-%s", slug, dateStr)
+
+	return slug
+}
+
+var slugInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// normalizeSlug turns a custom slug typed by the author into a URL-safe one.
+// Returns "" if nothing usable is left.
+func normalizeSlug(raw string) string {
+	slug := strings.ToLower(strings.TrimSpace(raw))
+	slug = strings.TrimPrefix(slug, "/posts/")
+	slug = slugInvalidChars.ReplaceAllString(slug, "-")
+	slug = strings.Trim(slug, "-")
+	if len(slug) > 80 {
+		slug = strings.TrimRight(slug[:80], "-")
 	}
+	return slug
+}
 
-	tmpl, err := template.New("post").Parse(postTemplate)
-	if err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		log.Printf("Template error: %v", err)
+// slugTaken reports whether another entry already uses the slug
+func slugTaken(slug string, entryID int) bool {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM entries WHERE slug = ? AND id != ?", slug, entryID).Scan(&count)
+	return err != nil || count > 0
+}
+
+// recordSlugChange remembers an entry's previous slug so requests for it can be
+// redirected. The new slug is removed from the history in case it was used
+// before, by this or another entry.
+func recordSlugChange(entryID int, oldSlug, newSlug string) {
+	if _, err := db.Exec("DELETE FROM slug_history WHERE slug = ?", newSlug); err != nil {
+		log.Printf("Error updating slug history: %v", err)
 		return
 	}
+	if oldSlug == "" || oldSlug == newSlug {
+		return
+	}
+	if _, err := db.Exec("INSERT OR REPLACE INTO slug_history (slug, entry_id) VALUES (?, ?)", oldSlug, entryID); err != nil {
+		log.Printf("Error updating slug history: %v", err)
+	}
+}
 
-	err = tmpl.Execute(w, data)
+// ============================================================================
+// Publication status
+// ============================================================================
+
+// publicEntryCondition matches entries that are published and due. Drafts,
+// unlisted entries and entries scheduled for later are left out of the feed,
+// tag pages and RSS.
+const publicEntryCondition = "status = 'published' AND (publish_at IS NULL OR publish_at <= datetime('now'))"
+
+// sqliteTimeLayout matches SQLite's CURRENT_TIMESTAMP so stored times compare
+// correctly against datetime('now')
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// parseEntryStatus validates the status field of the post form
+func parseEntryStatus(raw string) string {
+	switch raw {
+	case "draft", "unlisted":
+		return raw
+	default:
+		return "published"
+	}
+}
+
+// parsePublishAt reads the optional publish_at field of the post form. The
+// browser sends local time from a datetime-local input together with its
+// timezone offset in minutes. Returns nil unless the time is in the future.
+func parsePublishAt(r *http.Request) *time.Time {
+	raw := strings.TrimSpace(r.FormValue("publish_at"))
+	if raw == "" {
+		return nil
+	}
+	t, err := time.Parse("2006-01-02T15:04", raw)
 	if err != nil {
-		log.Printf("Template execution error: %v", err)
+		return nil
+	}
+	if offset, err := strconv.Atoi(r.FormValue("timezone_offset")); err == nil {
+		t = t.Add(time.Duration(offset) * time.Minute)
+	}
+	if !t.After(time.Now()) {
+		return nil
 	}
+	return &t
 }
 
-func handle404(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotFound)
+// isEntryPublic reports whether an entry with the given status and publish
+// time is visible to readers right now
+func isEntryPublic(status string, publishAt sql.NullTime) bool {
+	if status != "" && status != "published" {
+		return false
+	}
+	return !publishAt.Valid || !publishAt.Time.After(time.Now())
+}
 
-	// Get recent entries
-	recentEntries, _, err := getEntries(0, 3)
-	if err != nil {
-		log.Printf("Error fetching recent entries for 404 page: %v", err)
-		recentEntries = []EntryDisplay{}
+// setEntryPublication stores the status, schedule and publication time of an
+// entry. created_at doubles as the publication time, so scheduled entries sort
+// into the feed at the moment they go live.
+func setEntryPublication(entryID int64, status string, publishAt *time.Time, createdAt time.Time) error {
+	_, err := db.Exec("UPDATE entries SET status = ?, publish_at = ?, created_at = ? WHERE id = ?",
+		status, publishAtValue(publishAt), createdAt.UTC().Format(sqliteTimeLayout), entryID)
+	return err
+}
+
+// publishAtValue is the publish_at column value for an optional schedule.
+// New entries store it with their status in the INSERT, so a draft or
+// scheduled entry is never public, not even briefly.
+func publishAtValue(publishAt *time.Time) interface{} {
+	if publishAt == nil {
+		return nil
 	}
+	return publishAt.UTC().Format(sqliteTimeLayout)
+}
 
-	// Get settings from database
-	settings, err := getSiteSettings()
+// startScheduledPublishingCron checks every minute for scheduled entries that
+// are due and publishes them
+func startScheduledPublishingCron() {
+	publishScheduledEntries()
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		for range ticker.C {
+			publishScheduledEntries()
+		}
+	}()
+}
+
+// publishScheduledEntries clears the schedule of entries whose publish time has
+// passed, turning them into regular published entries
+func publishScheduledEntries() {
+	rows, err := db.Query("SELECT id, title, scheduled_base_url FROM entries WHERE status = 'published' AND publish_at IS NOT NULL AND publish_at <= datetime('now')")
 	if err != nil {
-		log.Printf("Error getting site settings: %v", err)
-		// Use defaults if error
-		settings = SiteSettings{
-			SiteTitle:    "My Blog",
-			SiteSubtitle: "A Personal Blog",
-			UserInitial:  "AB",
-			SiteTheme:    "default",
+		log.Printf("Error checking scheduled entries: %v", err)
+		return
+	}
+	type dueEntry struct {
+		id      int
+		title   string
+		baseURL string
+	}
+	var due []dueEntry
+	for rows.Next() {
+		var e dueEntry
+		var title, baseURL sql.NullString
+		if err := rows.Scan(&e.id, &title, &baseURL); err == nil {
+			e.title = title.String
+			e.baseURL = baseURL.String
+			due = append(due, e)
 		}
 	}
+	rows.Close()
 
-	data := NotFoundPageData{
-		SiteTitle:      settings.SiteTitle,
-		SiteSubtitle:   settings.SiteSubtitle,
-		EnableSubtitle: enableSubtitle,
-		UserInitial:    settings.UserInitial,
-		RecentEntries:  recentEntries,
-		ThemeCSS:       template.CSS(getThemeCSS()),
+	for _, e := range due {
+		if _, err := db.Exec("UPDATE entries SET publish_at = NULL, scheduled_base_url = NULL WHERE id = ?", e.id); err != nil {
+			log.Printf("Error publishing scheduled entry %d: %v", e.id, err)
+			continue
+		}
+		log.Printf("Published scheduled entry %d: %s", e.id, e.title)
+		queueScheduledEntryActivities(e.id)
+		if e.baseURL != "" {
+			triggerEntryWebhooks("entry.published", e.id, e.baseURL)
+		}
+	}
+	if len(due) > 0 {
+		markContentChanged()
 	}
+}
 
-	tmpl, err := template.New("404").Parse(notFoundTemplate)
-	if err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		log.Printf("404 Template error: %v", err)
+// ============================================================================
+// Search
+// ============================================================================
+
+// rebuildSearchIndex refills the full-text index from the entries table
+func rebuildSearchIndex() {
+	if !searchIndexEnabled {
 		return
 	}
-
-	err = tmpl.Execute(w, data)
-	if err != nil {
-		log.Printf("404 Template execution error: %v", err)
+	if _, err := db.Exec("DELETE FROM entries_fts"); err != nil {
+		log.Printf("Error clearing search index: %v", err)
+		return
+	}
+	if _, err := db.Exec("INSERT INTO entries_fts (rowid, title, content) SELECT id, COALESCE(title, ''), content FROM entries"); err != nil {
+		log.Printf("Error rebuilding search index: %v", err)
 	}
 }
 
-// Admin service handlers
-func handleAdminIndex(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// syncSearchIndex updates the full-text index for one entry after it was
+// created, edited or deleted
+func syncSearchIndex(entryID int64) {
+	if !searchIndexEnabled {
 		return
 	}
+	if _, err := db.Exec("DELETE FROM entries_fts WHERE rowid = ?", entryID); err != nil {
+		log.Printf("Error updating search index: %v", err)
+		return
+	}
+	if _, err := db.Exec("INSERT INTO entries_fts (rowid, title, content) SELECT id, COALESCE(title, ''), content FROM entries WHERE id = ?", entryID); err != nil {
+		log.Printf("Error updating search index: %v", err)
+	}
+}
 
-	// Determine which view to show (default to new post form)
-	view := r.URL.Query().Get("view")
-	if view == "" {
-		view = "new"
+// searchTerms splits a search query into lowercase words. Only letters and
+// digits are kept, so the terms are safe to quote in an FTS5 query.
+func searchTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+		if len(terms) == 8 {
+			break
+		}
 	}
+	return terms
+}
 
-	// Template functions
-	funcMap := template.FuncMap{
-		"jsEscape": jsEscape,
-		"add": func(a, b int) int {
-			return a + b
-		},
-		"subtract": func(a, b int) int {
-			return a - b
-		},
+// searchCondition builds a WHERE condition matching entries that contain all
+// words of the query (as prefixes). ok is false if the query has no words.
+func searchCondition(query string) (condition string, args []interface{}, ok bool) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return "", nil, false
 	}
-	tmpl := template.Must(template.New("admin").Funcs(funcMap).Parse(adminTemplate))
 
-	// Check if viewer password is set in site_settings
-	hasPassword := false
-	var passwordHash sql.NullString
-	err := db.QueryRow("SELECT viewer_password_hash FROM site_settings WHERE id = 1").Scan(&passwordHash)
-	if err == nil && passwordHash.Valid && passwordHash.String != "" {
-		hasPassword = true
+	if searchIndexEnabled {
+		quoted := make([]string, len(terms))
+		for i, term := range terms {
+			quoted[i] = `"` + term + `"*`
+		}
+		return "id IN (SELECT rowid FROM entries_fts WHERE entries_fts MATCH ?)", []interface{}{strings.Join(quoted, " ")}, true
 	}
 
-	// Read flash messages from cookies
-	var message, messageType string
-	if cookie, err := r.Cookie("flash_message"); err == nil {
-		message = cookie.Value
-		http.SetCookie(w, &http.Cookie{
-			Name:   "flash_message",
-			Value:  "",
-			Path:   "/",
-			MaxAge: -1,
-		})
+	conditions := make([]string, len(terms))
+	for i, term := range terms {
+		conditions[i] = "(title LIKE ? OR content LIKE ?)"
+		args = append(args, "%"+term+"%", "%"+term+"%")
 	}
-	if cookie, err := r.Cookie("flash_type"); err == nil {
-		messageType = cookie.Value
-		http.SetCookie(w, &http.Cookie{
-			Name:   "flash_type",
-			Value:  "",
-			Path:   "/",
-			MaxAge: -1,
-		})
+	return strings.Join(conditions, " AND "), args, true
+}
+
+// searchEntries returns the public entries matching a query, newest first,
+// with highlighted snippets
+func searchEntries(query string, offset, limit int) ([]EntryDisplay, bool, error) {
+	condition, args, ok := searchCondition(query)
+	if !ok {
+		return []EntryDisplay{}, false, nil
 	}
 
-	data := EditorPageData{
-		HasPrivacyPassword: hasPassword,
-		EnableAudioUploads: enableAudioUploads,
-		Message:            message,
-		MessageType:        messageType,
-		View:               view,
-		PageTitle:          "Posts",
+	entries, hasMore, err := queryEntries(condition, args, offset, limit)
+	if err != nil {
+		return nil, false, err
 	}
 
-	// For posts view, fetch paginated entries
-	if view == "posts" {
-		// Pagination settings
-		perPage := 20
-		pageStr := r.URL.Query().Get("page")
-		currentPage, err := strconv.Atoi(pageStr)
-		if err != nil || currentPage < 1 {
-			currentPage = 1
-		}
+	ids := make([]int, len(entries))
+	for i := range entries {
+		ids[i] = entries[i].ID
+	}
+	snippets := getSearchSnippets(ids, searchTerms(query))
+	for i := range entries {
+		entries[i].Snippet = snippets[entries[i].ID]
+	}
+	return entries, hasMore, nil
+}
 
-		// Get total count
-		var totalEntries int
-		err = db.QueryRow("SELECT COUNT(*) FROM entries").Scan(&totalEntries)
-		if err != nil {
-			log.Printf("Error counting entries: %v", err)
-			totalEntries = 0
-		}
+// countSearchResults returns the number of public entries matching a query
+func countSearchResults(query string) int {
+	condition, args, ok := searchCondition(query)
+	if !ok {
+		return 0
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM entries WHERE "+publicEntryCondition+" AND ("+condition+")", args...).Scan(&count)
+	return count
+}
 
-		// Calculate pagination
-		totalPages := (totalEntries + perPage - 1) / perPage
-		if totalPages < 1 {
-			totalPages = 1
-		}
-		if currentPage > totalPages {
-			currentPage = totalPages
-		}
+// getSearchSnippets builds highlighted snippets for the given entries, keyed
+// by entry ID
+func getSearchSnippets(ids []int, terms []string) map[int]template.HTML {
+	snippets := make(map[int]template.HTML)
+	if len(ids) == 0 {
+		return snippets
+	}
 
-		offset := (currentPage - 1) * perPage
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
 
-		// Fetch entries for current page
-		entries, err := getPaginatedEntries(offset, perPage)
-		if err != nil {
-			log.Printf("Error fetching entries: %v", err)
-			entries = []Entry{}
-		}
+	rows, err := db.Query("SELECT id, content, content_format FROM entries WHERE id IN ("+placeholders+")", args...)
+	if err != nil {
+		log.Printf("Error fetching search snippets: %v", err)
+		return snippets
+	}
+	defer rows.Close()
 
-		// Calculate page numbers to show (max 5 pages around current)
-		var pageNumbers []int
-		startPage := currentPage - 2
-		if startPage < 1 {
-			startPage = 1
+	for rows.Next() {
+		var id int
+		var content string
+		var contentFormat sql.NullString
+		if err := rows.Scan(&id, &content, &contentFormat); err != nil {
+			continue
 		}
-		endPage := startPage + 4
-		if endPage > totalPages {
-			endPage = totalPages
-			startPage = endPage - 4
-			if startPage < 1 {
-				startPage = 1
+
+		// Search the text readers see rather than the Markdown source
+		lines := strings.Split(content, "\n")
+		if contentFormat.String == "markdown" {
+			for i, line := range lines {
+				lines[i] = markdownPlainText(line)
 			}
 		}
-		for i := startPage; i <= endPage; i++ {
-			pageNumbers = append(pageNumbers, i)
+		text := strings.Join(strings.Fields(strings.Join(lines, " ")), " ")
+		snippets[id] = highlightSnippet(text, terms, 200)
+	}
+	return snippets
+}
+
+// highlightSnippet cuts an excerpt of about maxLen characters around the first
+// matching term and wraps every match in <mark>
+func highlightSnippet(text string, terms []string, maxLen int) template.HTML {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// matchAt returns the length of the term matching at position i, or 0
+	matchAt := func(i int) int {
+		for _, term := range terms {
+			t := []rune(term)
+			if i+len(t) <= len(lower) && string(lower[i:i+len(t)]) == term {
+				return len(t)
+			}
 		}
+		return 0
+	}
 
-		// Calculate start/end entry numbers for display
-		startEntry := offset + 1
-		endEntry := offset + len(entries)
-		if totalEntries == 0 {
-			startEntry = 0
-			endEntry = 0
+	// Start a little before the first match, at a word boundary
+	start := 0
+	for i := range lower {
+		if matchAt(i) > 0 {
+			start = i - maxLen/4
+			break
 		}
+	}
+	if start > len(runes)-maxLen {
+		start = len(runes) - maxLen
+	}
+	if start < 0 {
+		start = 0
+	}
+	for start > 0 && start < len(runes) && runes[start-1] != ' ' {
+		start++
+	}
+	end := start + maxLen
+	if end > len(runes) {
+		end = len(runes)
+	}
 
-		data.Entries = entries
-		data.CurrentPage = currentPage
-		data.TotalPages = totalPages
-		data.TotalEntries = totalEntries
-		data.StartEntry = startEntry
-		data.EndEntry = endEntry
-		data.PageNumbers = pageNumbers
-		data.PageTitle = "Posts"
-	} else if view == "new" {
-		data.PageTitle = "New Post"
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	for i := start; i < end; {
+		if n := matchAt(i); n > 0 {
+			if i+n > end {
+				n = end - i
+			}
+			b.WriteString("<mark>" + html.EscapeString(string(runes[i:i+n])) + "</mark>")
+			i += n
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[i])))
+		i++
+	}
+	if end < len(runes) {
+		b.WriteString(" …")
 	}
+	return template.HTML(b.String())
+}
 
-	tmpl.Execute(w, data)
+func getEntries(offset, limit int) ([]EntryDisplay, bool, error) {
+	return queryEntries("", nil, offset, limit)
 }
 
-func handleGetEntriesForAdmin(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// getEntriesWithTag returns the entries carrying a tag, newest first
+func getEntriesWithTag(tag string, offset, limit int) ([]EntryDisplay, bool, error) {
+	return queryEntries(entryHasTagCondition, []interface{}{tag}, offset, limit)
+}
 
-	offsetStr := r.URL.Query().Get("offset")
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil || offset < 0 {
-		offset = 0
+// queryEntries loads public entries for display, newest first, optionally
+// restricted by an extra WHERE condition
+func queryEntries(condition string, args []interface{}, offset, limit int) ([]EntryDisplay, bool, error) {
+	where := "WHERE " + publicEntryCondition
+	if condition != "" {
+		where += " AND (" + condition + ")"
 	}
+	query := `
+		SELECT id, title, content, photo_path, media_type, thumbnail_path, slug, content_format, created_at
+		FROM entries
+		` + where + `
+		ORDER BY created_at DESC
+		LIMIT ? OFFSET ?
+	`
 
-	limit := 5
-	entries, err := getPaginatedEntries(offset, limit)
+	rows, err := db.Query(query, append(args, limit+1, offset)...)
 	if err != nil {
-		log.Printf("Error fetching entries: %v", err)
-		http.Error(w, "Error fetching entries", http.StatusInternalServerError)
-		return
+		return nil, false, err
 	}
+	defer rows.Close()
 
-	hasMore := len(entries) == limit
+	var entries []EntryDisplay
+	count := 0
+	for rows.Next() {
+		count++
+		if count > limit {
+			break
+		}
 
-	var entriesJSON []EntryJSON
-	for _, entry := range entries {
-		photoURL := ""
-		if entry.PhotoPath != "" {
-			photoURL = "/uploads/" + entry.PhotoPath
+		var entry Entry
+		var title sql.NullString
+		var photoPath sql.NullString
+		var mediaType sql.NullString
+		var thumbnailPath sql.NullString
+		var slug sql.NullString
+		var contentFormat sql.NullString
+
+		err := rows.Scan(&entry.ID, &title, &entry.Content, &photoPath, &mediaType, &thumbnailPath, &slug, &contentFormat, &entry.CreatedAt)
+		if err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
 		}
-		entriesJSON = append(entriesJSON, EntryJSON{
-			ID:        entry.ID,
-			Title:     entry.Title,
-			Content:   entry.Content,
-			Photo:     photoURL,
-			Slug:      entry.Slug,
-			CreatedAt: entry.CreatedAt.Format("Jan 2, 2006 3:04 PM"),
+
+		entry.ContentFormat = contentFormat.String
+
+		if title.Valid {
+			entry.Title = title.String
+		}
+
+		if slug.Valid {
+			entry.Slug = slug.String
+		}
+
+		if mediaType.Valid {
+			entry.MediaType = mediaType.String
+		} else {
+			entry.MediaType = "photo" // default for old entries
+		}
+
+		var photoURL template.URL
+		hasPhoto := false
+		hasAudio := false
+		hasVideo := false
+
+		if photoPath.Valid && photoPath.String != "" {
+			photoURL = template.URL("/uploads/" + photoPath.String)
+			if entry.MediaType == "photo" {
+				hasPhoto = true
+			} else if entry.MediaType == "audio" {
+				hasAudio = true
+			} else if entry.MediaType == "video" {
+				hasVideo = true
+			}
+		}
+
+		var thumbnailURL template.URL
+		hasThumbnail := false
+		if thumbnailPath.Valid && thumbnailPath.String != "" {
+			thumbnailURL = template.URL("/uploads/" + thumbnailPath.String)
+			hasThumbnail = true
+		}
+
+		preview, isTruncated := renderContentPreview(entry.Content, entry.ContentFormat, 150)
+
+		entries = append(entries, EntryDisplay{
+			ID:            entry.ID,
+			Title:         entry.Title,
+			Content:       preview,
+			FullContent:   renderContent(entry.Content, entry.ContentFormat),
+			IsTruncated:   isTruncated,
+			Slug:          entry.Slug,
+			Photo:         photoURL,
+			HasPhoto:      hasPhoto,
+			MediaType:     entry.MediaType,
+			HasAudio:      hasAudio,
+			HasVideo:      hasVideo,
+			Thumbnail:     thumbnailURL,
+			HasThumbnail:  hasThumbnail,
+			CreatedAt:     entry.CreatedAt,
+			TimeAgo:       timeAgo(entry.CreatedAt),
+			InitialLetter: getInitialLetter(entry.Content),
 		})
 	}
 
-	response := EntriesResponse{
-		Entries: entriesJSON,
-		HasMore: hasMore,
+	ids := make([]int, len(entries))
+	for i := range entries {
+		ids[i] = entries[i].ID
+	}
+	tagsByEntry := getTagsForEntries(ids)
+	mediaByEntry := getMediaForEntries(ids)
+	authorByEntry := getEntryAuthors(ids)
+	for i := range entries {
+		entries[i].Tags = tagsByEntry[entries[i].ID]
+		entries[i].Media = mediaByEntry[entries[i].ID]
+		entries[i].Author = authorByEntry[entries[i].ID]
+		entries[i].ImageSizes = gridImageSizes
+		if offset == 0 && i == 0 {
+			entries[i].ImageSizes = featuredImageSizes
+		}
+		applyPrimaryMedia(&entries[i])
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	hasMore := count > limit
+	return entries, hasMore, nil
 }
 
-func handleCreate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// Blog viewer handlers
+// ============================================================================
+// Conditional requests
+// ============================================================================
+
+// markContentChanged records that something shown on public pages or feeds
+// changed, which invalidates the ETags and Last-Modified times handed out so
+// far
+func markContentChanged() {
+	if _, err := db.Exec("UPDATE site_settings SET content_updated_at = ? WHERE id = 1", time.Now().UTC()); err != nil {
+		log.Printf("Error recording content change: %v", err)
 	}
+}
 
-	err := r.ParseMultipartForm(10 << 20)
-	if err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
-		return
+// checkNotModified sets the ETag, Last-Modified and Cache-Control headers of
+// a public page or feed and answers 304 Not Modified if the client's copy is
+// current, in which case it returns true and the handler should stop. The
+// validators change when the newest post goes live or anything is saved in
+// the admin area. Pages showing relative times ("5 minutes ago") pass
+// relativeTimes so their validators also change as those labels go stale.
+func checkNotModified(w http.ResponseWriter, r *http.Request, relativeTimes bool) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
 	}
 
-	content := r.FormValue("content")
-	if len(content) > 2000 {
-		content = content[:2000]
+	var newest time.Time
+	err := db.QueryRow("SELECT created_at FROM entries WHERE " + publicEntryCondition + " ORDER BY created_at DESC LIMIT 1").Scan(&newest)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error reading newest entry time: %v", err)
+		return false
 	}
 
-	// Auto-generate title from content (first line, trim to 60 chars at last full word)
-	var finalTitle string
-	if content != "" {
-		firstLine := strings.Split(content, "\n")[0]
-		if len(firstLine) > 60 {
-			// Find last space before 60 chars
-			truncated := firstLine[:60]
-			lastSpace := strings.LastIndex(truncated, " ")
-			if lastSpace > 0 {
-				finalTitle = truncated[:lastSpace]
-			} else {
-				finalTitle = truncated
-			}
-		} else {
-			finalTitle = firstLine
+	var contentUpdated sql.NullTime
+	var protected bool
+	err = db.QueryRow("SELECT content_updated_at, COALESCE(viewer_password_hash, '') != '' FROM site_settings WHERE id = 1").Scan(&contentUpdated, &protected)
+	if err != nil {
+		log.Printf("Error reading content change time: %v", err)
+		return false
+	}
+
+	lastModified := newest
+	if contentUpdated.Valid && contentUpdated.Time.After(lastModified) {
+		lastModified = contentUpdated.Time
+	}
+	if relativeTimes && !newest.IsZero() {
+		// Relative times of the newest post change fastest
+		bucket := 24 * time.Hour
+		if age := time.Since(newest); age < time.Hour {
+			bucket = time.Minute
+		} else if age < 24*time.Hour {
+			bucket = time.Hour
+		}
+		if start := time.Now().Truncate(bucket); start.After(lastModified) {
+			lastModified = start
 		}
-	} else {
-		// Fallback title
-		finalTitle = fmt.Sprintf("Untitled Post %s", time.Now().Format("2006-01-02"))
 	}
+	lastModified = lastModified.UTC().Truncate(time.Second)
 
-	// Generate timestamp for both slug and media
-	now := time.Now()
-	slug := generateSlug(finalTitle, now)
+	admin := isAuthenticated(r)
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%d|%s|%s|%t",
+		lastModified.Unix(), newest.UnixNano(), contentUpdated.Time.UnixNano(), r.Host, r.URL.RequestURI(), admin)))
+	etag := `W/"` + hex.EncodeToString(hash[:12]) + `"`
 
-	// Get media type from form (defaults to photo for backward compatibility)
-	mediaType := r.FormValue("media_type")
-	if mediaType == "" {
-		mediaType = "photo"
+	// Pages of password-protected blogs and admin previews must not end up
+	// in shared caches. Everyone else revalidates on every request.
+	if protected || admin {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, no-cache")
 	}
-
-	var photoPath string
-	file, header, err := r.FormFile("media")
-	if err == nil {
-		defer file.Close()
-		photoPath, err = validateMediaAndSave(file, header.Filename, finalTitle, now, mediaType)
-		if err != nil {
-			log.Printf("Error saving %s: %v", mediaType, err)
-		}
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
 	}
 
-	// Handle thumbnail upload for video/audio
-	var thumbnailPath string
-	if mediaType == "video" || mediaType == "audio" {
-		thumbFile, thumbHeader, thumbErr := r.FormFile("thumbnail")
-		if thumbErr == nil {
-			defer thumbFile.Close()
-			thumbnailPath, thumbErr = validateAndSaveThumbnail(thumbFile, thumbHeader.Filename, finalTitle, now)
-			if thumbErr != nil {
-				log.Printf("Error saving thumbnail: %v", thumbErr)
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+				w.WriteHeader(http.StatusNotModified)
+				return true
 			}
 		}
+		return false
 	}
-
-	_, err = db.Exec("INSERT INTO entries (title, content, photo_path, media_type, thumbnail_path, slug) VALUES (?, ?, ?, ?, ?, ?)", finalTitle, content, photoPath, mediaType, thumbnailPath, slug)
-	if err != nil {
-		log.Printf("Error inserting entry: %v", err)
-		showMessage(w, r, "Failed to create entry", "error")
-		return
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.IsZero() && !lastModified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
 	}
+	return false
+}
 
-	// Redirect to the new post page
-	http.Redirect(w, r, "/posts/"+slug+"/", http.StatusSeeOther)
+// uploadsHandler serves uploaded media. Files named after their content are
+// cached for good. Older uploads may be written over by a backup restore, so
+// they are only cached briefly and then revalidated against their ETag.
+func uploadsHandler() http.Handler {
+	fileServer := http.StripPrefix("/uploads/", http.FileServer(http.Dir(uploadsDir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Clean("/" + strings.TrimPrefix(r.URL.Path, "/uploads/"))
+		if info, err := os.Stat(filepath.Join(uploadsDir, name)); err == nil && !info.IsDir() {
+			w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+			if isHashedUpload(name) {
+				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			} else {
+				w.Header().Set("Cache-Control", "public, max-age=300")
+			}
+		}
+		fileServer.ServeHTTP(w, r)
+	})
 }
 
-func handleUpdate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func handleBlogFeed(w http.ResponseWriter, r *http.Request) {
+	// Auto-detect hostname from first request
+	detectHostnameFromRequest(r)
+
+	if checkNotModified(w, r, true) {
 		return
 	}
 
-	err := r.ParseMultipartForm(10 << 20)
+	tmpl, err := template.New("feed").Parse(viewerTemplate + entryGalleryTemplate)
 	if err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Template error: %v", err)
 		return
 	}
 
-	idStr := r.FormValue("id")
-	id, err := strconv.Atoi(idStr)
+	entries, hasMore, err := getEntries(0, 10)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		http.Error(w, "Database query error", http.StatusInternalServerError)
+		log.Printf("Database query error: %v", err)
 		return
 	}
 
-	content := r.FormValue("content")
-	if len(content) > 2000 {
-		content = content[:2000]
-	}
+	var totalEntries, todayEntries int
+	db.QueryRow("SELECT COUNT(*) FROM entries WHERE " + publicEntryCondition).Scan(&totalEntries)
+	db.QueryRow("SELECT COUNT(*) FROM entries WHERE DATE(created_at) = DATE('now') AND " + publicEntryCondition).Scan(&todayEntries)
 
-	// Get the created_at timestamp for slug generation
-	var createdAt time.Time
-	err = db.QueryRow("SELECT created_at FROM entries WHERE id = ?", id).Scan(&createdAt)
+	// Get settings from database
+	settings, err := getSiteSettings()
 	if err != nil {
-		log.Printf("Error fetching entry: %v", err)
-		showMessage(w, r, "Failed to find entry", "error")
-		return
+		log.Printf("Error getting site settings: %v", err)
+		// Use defaults if error
+		settings = SiteSettings{
+			SiteTitle:    "My Blog",
+			SiteSubtitle: "A Personal Blog",
+			UserInitial:  "AB",
+			SiteTheme:    "default",
+		}
 	}
 
-	// Auto-generate title from content (first line, trim to 60 chars at last full word)
-	var finalTitle string
-	if content != "" {
-		firstLine := strings.Split(content, "\n")[0]
-		if len(firstLine) > 60 {
-			// Find last space before 60 chars
-			truncated := firstLine[:60]
-			lastSpace := strings.LastIndex(truncated, " ")
-			if lastSpace > 0 {
-				finalTitle = truncated[:lastSpace]
-			} else {
-				finalTitle = truncated
-			}
-		} else {
-			finalTitle = firstLine
-		}
-	} else {
-		// Fallback title
-		finalTitle = fmt.Sprintf("Untitled Post %s", createdAt.Format("2006-01-02"))
+	data := ViewerPageData{
+		Entries:          entries,
+		TotalEntries:     totalEntries,
+		TodayEntries:     todayEntries,
+		SiteTitle:        settings.SiteTitle,
+		SiteSubtitle:     settings.SiteSubtitle,
+		EnableSubtitle:   enableSubtitle,
+		UserInitial:      settings.UserInitial,
+		AvatarPath:       settings.AvatarPath,
+		AvatarPreference: settings.AvatarPreference,
+		InitialCount:     len(entries),
+		HasMore:          hasMore,
+		ThemeCSS:         template.CSS(getThemeCSS()),
 	}
 
-	// Regenerate slug with final title
-	slug := generateSlug(finalTitle, createdAt)
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
 
-	// Get media type from form (defaults to photo for backward compatibility)
-	mediaType := r.FormValue("media_type")
-	if mediaType == "" {
-		mediaType = "photo"
+func handleAPIEntries(w http.ResponseWriter, r *http.Request) {
+	if checkNotModified(w, r, true) {
+		return
 	}
 
-	// Handle thumbnail upload/removal for video/audio
-	var thumbnailPath sql.NullString
-	thumbnailUpdated := false
-	removeThumbnail := r.FormValue("remove_thumbnail") == "1"
+	offset := 0
+	limit := 10
 
-	if removeThumbnail {
-		thumbnailPath = sql.NullString{String: "", Valid: false}
-		thumbnailUpdated = true
-	} else if mediaType == "video" || mediaType == "audio" {
-		thumbFile, thumbHeader, thumbErr := r.FormFile("thumbnail")
-		if thumbErr == nil {
-			defer thumbFile.Close()
-			newThumbPath, thumbErr := validateAndSaveThumbnail(thumbFile, thumbHeader.Filename, finalTitle, createdAt)
-			if thumbErr != nil {
-				log.Printf("Error saving thumbnail: %v", thumbErr)
-			} else {
-				thumbnailPath = sql.NullString{String: newThumbPath, Valid: true}
-				thumbnailUpdated = true
-			}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if val, err := strconv.Atoi(offsetStr); err == nil {
+			offset = val
 		}
 	}
 
-	file, header, err := r.FormFile("media")
-	if err == nil {
-		defer file.Close()
-		photoPath, err := validateMediaAndSave(file, header.Filename, finalTitle, createdAt, mediaType)
-		if err != nil {
-			log.Printf("Error saving %s: %v", mediaType, err)
-		} else {
-			if thumbnailUpdated {
-				_, err = db.Exec("UPDATE entries SET title = ?, content = ?, photo_path = ?, media_type = ?, thumbnail_path = ?, slug = ? WHERE id = ?", finalTitle, content, photoPath, mediaType, thumbnailPath, slug, id)
-			} else {
-				_, err = db.Exec("UPDATE entries SET title = ?, content = ?, photo_path = ?, media_type = ?, slug = ? WHERE id = ?", finalTitle, content, photoPath, mediaType, slug, id)
-			}
-			if err != nil {
-				log.Printf("Error updating entry: %v", err)
-				showMessage(w, r, "Failed to update entry", "error")
-				return
-			}
-			http.Redirect(w, r, "/posts/"+slug+"/", http.StatusSeeOther)
-			return
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if val, err := strconv.Atoi(limitStr); err == nil && val > 0 && val <= 50 {
+			limit = val
 		}
 	}
 
-	if thumbnailUpdated {
-		_, err = db.Exec("UPDATE entries SET title = ?, content = ?, thumbnail_path = ?, slug = ? WHERE id = ?", finalTitle, content, thumbnailPath, slug, id)
+	var entries []EntryDisplay
+	var hasMore bool
+	var err error
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		entries, hasMore, err = searchEntries(q, offset, limit)
+	} else if tag := normalizeTag(r.URL.Query().Get("tag")); tag != "" {
+		entries, hasMore, err = getEntriesWithTag(tag, offset, limit)
 	} else {
-		_, err = db.Exec("UPDATE entries SET title = ?, content = ?, slug = ? WHERE id = ?", finalTitle, content, slug, id)
+		entries, hasMore, err = getEntries(offset, limit)
 	}
 	if err != nil {
-		log.Printf("Error updating entry: %v", err)
-		showMessage(w, r, "Failed to update entry", "error")
+		http.Error(w, "Database query error", http.StatusInternalServerError)
+		log.Printf("Database query error: %v", err)
 		return
 	}
 
-	http.Redirect(w, r, "/posts/"+slug+"/", http.StatusSeeOther)
+	response := APIResponse{
+		Entries: entries,
+		HasMore: hasMore,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
-func handleDelete(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func handleSinglePost(w http.ResponseWriter, r *http.Request) {
+	// Extract slug from URL path
+	// Expected format: /posts/slug-here/
+	path := r.URL.Path
+	slug := strings.TrimPrefix(path, "/posts/")
+	slug = strings.TrimSuffix(slug, "/")
+
+	if slug == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	err := r.ParseForm()
-	if err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
+	// Query database for entry by slug
+	query := `
+		SELECT id, title, content, photo_path, media_type, thumbnail_path, slug, content_format, status, publish_at, created_at
+		FROM entries
+		WHERE slug = ?
+		LIMIT 1
+	`
+
+	var entry Entry
+	var title sql.NullString
+	var photoPath sql.NullString
+	var mediaType sql.NullString
+	var thumbnailPath sql.NullString
+	var entrySlug sql.NullString
+	var contentFormat sql.NullString
+	var status sql.NullString
+	var publishAt sql.NullTime
+
+	err := db.QueryRow(query, slug).Scan(&entry.ID, &title, &entry.Content, &photoPath, &mediaType, &thumbnailPath, &entrySlug, &contentFormat, &status, &publishAt, &entry.CreatedAt)
+	if err == sql.ErrNoRows {
+		// Redirect old slugs of renamed posts to the current one
+		var currentSlug string
+		err = db.QueryRow("SELECT e.slug FROM slug_history h JOIN entries e ON e.id = h.entry_id WHERE h.slug = ?", slug).Scan(&currentSlug)
+		if err == nil && currentSlug != "" {
+			http.Redirect(w, r, "/posts/"+currentSlug+"/", http.StatusMovedPermanently)
+			return
+		}
+		handle404(w, r)
 		return
 	}
-
-	idStr := r.FormValue("id")
-	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Printf("Database error: %v", err)
 		return
 	}
 
-	_, err = db.Exec("DELETE FROM entries WHERE id = ?", id)
-	if err != nil {
-		log.Printf("Error deleting entry: %v", err)
-		showMessage(w, r, "Failed to delete entry", "error")
+	// Drafts and scheduled entries are only visible to the admin (as a
+	// preview); unlisted entries are visible to anyone with the link
+	if status.String != "unlisted" && !isEntryPublic(status.String, publishAt) && !isAuthenticated(r) {
+		handle404(w, r)
 		return
 	}
 
-	showMessage(w, r, "Entry deleted successfully!", "success")
-}
+	if checkNotModified(w, r, true) {
+		return
+	}
 
-func getAllEntries() ([]Entry, error) {
-	rows, err := db.Query("SELECT id, title, content, photo_path, media_type, thumbnail_path, slug, created_at FROM entries ORDER BY created_at DESC")
-	if err != nil {
-		return nil, err
+	if title.Valid {
+		entry.Title = title.String
 	}
-	defer rows.Close()
+	if photoPath.Valid {
+		entry.PhotoPath = photoPath.String
+	}
+	if mediaType.Valid {
+		entry.MediaType = mediaType.String
+	} else {
+		entry.MediaType = "photo"
+	}
+	if thumbnailPath.Valid {
+		entry.ThumbnailPath = thumbnailPath.String
+	}
+	if entrySlug.Valid {
+		entry.Slug = entrySlug.String
+	}
+	entry.ContentFormat = contentFormat.String
 
-	var entries []Entry
-	for rows.Next() {
-		var entry Entry
-		var title sql.NullString
-		var photoPath sql.NullString
-		var mediaType sql.NullString
-		var thumbnailPath sql.NullString
-		var slug sql.NullString
-		err := rows.Scan(&entry.ID, &title, &entry.Content, &photoPath, &mediaType, &thumbnailPath, &slug, &entry.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		if title.Valid {
-			entry.Title = title.String
-		}
-		if photoPath.Valid {
-			entry.PhotoPath = photoPath.String
-		}
-		if mediaType.Valid {
-			entry.MediaType = mediaType.String
-		} else {
-			entry.MediaType = "photo"
-		}
-		if thumbnailPath.Valid {
-			entry.ThumbnailPath = thumbnailPath.String
-		}
-		if slug.Valid {
-			entry.Slug = slug.String
+	var photoURL template.URL
+	hasPhoto := false
+	hasAudio := false
+	hasVideo := false
+	if entry.PhotoPath != "" {
+		photoURL = template.URL("/uploads/" + entry.PhotoPath)
+		if entry.MediaType == "photo" {
+			hasPhoto = true
+		} else if entry.MediaType == "audio" {
+			hasAudio = true
+		} else if entry.MediaType == "video" {
+			hasVideo = true
 		}
-		entries = append(entries, entry)
 	}
 
-	return entries, nil
-}
-
-func getPaginatedEntries(offset, limit int) ([]Entry, error) {
-	rows, err := db.Query("SELECT id, title, content, photo_path, media_type, thumbnail_path, slug, created_at FROM entries ORDER BY created_at DESC LIMIT ? OFFSET ?", limit, offset)
-	if err != nil {
-		return nil, err
+	var thumbnailURL template.URL
+	hasThumbnail := false
+	if entry.ThumbnailPath != "" {
+		thumbnailURL = template.URL("/uploads/" + entry.ThumbnailPath)
+		hasThumbnail = true
 	}
-	defer rows.Close()
 
-	var entries []Entry
-	for rows.Next() {
-		var entry Entry
-		var title sql.NullString
-		var photoPath sql.NullString
-		var mediaType sql.NullString
-		var thumbnailPath sql.NullString
-		var slug sql.NullString
-		err := rows.Scan(&entry.ID, &title, &entry.Content, &photoPath, &mediaType, &thumbnailPath, &slug, &entry.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		if title.Valid {
-			entry.Title = title.String
-		}
-		if photoPath.Valid {
-			entry.PhotoPath = photoPath.String
-		}
-		if mediaType.Valid {
-			entry.MediaType = mediaType.String
-		} else {
-			entry.MediaType = "photo"
-		}
-		if thumbnailPath.Valid {
-			entry.ThumbnailPath = thumbnailPath.String
-		}
-		if slug.Valid {
-			entry.Slug = slug.String
-		}
-		entries = append(entries, entry)
-	}
+	// Check if content needs truncation
+	preview, isTruncated := renderContentPreview(entry.Content, entry.ContentFormat, 150)
 
-	return entries, nil
-}
+	media := getMediaForEntries([]int{entry.ID})[entry.ID]
 
-func showMessage(w http.ResponseWriter, r *http.Request, message, messageType string) {
-	// Set flash message cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "flash_message",
-		Value:    message,
-		Path:     "/",
-		MaxAge:   5, // 5 seconds
-		HttpOnly: true,
-	})
-	http.SetCookie(w, &http.Cookie{
-		Name:     "flash_type",
-		Value:    messageType,
-		Path:     "/",
-		MaxAge:   5, // 5 seconds
-		HttpOnly: true,
-	})
+	entryDisplay := EntryDisplay{
+		ID:            entry.ID,
+		Title:         entry.Title,
+		Content:       preview,
+		FullContent:   renderContent(entry.Content, entry.ContentFormat),
+		IsTruncated:   isTruncated,
+		Photo:         photoURL,
+		HasPhoto:      hasPhoto,
+		MediaType:     entry.MediaType,
+		HasAudio:      hasAudio,
+		HasVideo:      hasVideo,
+		Thumbnail:     thumbnailURL,
+		HasThumbnail:  hasThumbnail,
+		ImageSizes:    featuredImageSizes,
+		Media:         media,
+		Slug:          entry.Slug,
+		Tags:          getTagsForEntries([]int{entry.ID})[entry.ID],
+		Author:        getEntryAuthors([]int{entry.ID})[entry.ID],
+		CreatedAt:     entry.CreatedAt,
+		TimeAgo:       timeAgo(entry.CreatedAt),
+		InitialLetter: getInitialLetter(entry.Content),
+	}
+	applyPrimaryMedia(&entryDisplay)
 
-	// Redirect to admin page
-	http.Redirect(w, r, "/admin", http.StatusSeeOther)
-}
+	// Get settings from database
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error getting site settings: %v", err)
+		// Use defaults if error
+		settings = SiteSettings{
+			SiteTitle:    "My Blog",
+			SiteSubtitle: "A Personal Blog",
+			UserInitial:  "AB",
+			SiteTheme:    "default",
+		}
+	}
 
-func showSettingsMessage(w http.ResponseWriter, r *http.Request, message, messageType, section string) {
-	// Set flash message cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "flash_message",
-		Value:    message,
-		Path:     "/",
-		MaxAge:   5, // 5 seconds
-		HttpOnly: true,
-	})
-	http.SetCookie(w, &http.Cookie{
-		Name:     "flash_type",
-		Value:    messageType,
-		Path:     "/",
-		MaxAge:   5, // 5 seconds
-		HttpOnly: true,
-	})
+	data := SinglePostPageData{
+		Entry:            entryDisplay,
+		SiteTitle:        settings.SiteTitle,
+		SiteSubtitle:     settings.SiteSubtitle,
+		EnableSubtitle:   enableSubtitle,
+		UserInitial:      settings.UserInitial,
+		AvatarPath:       settings.AvatarPath,
+		AvatarPreference: settings.AvatarPreference,
+		ThemeCSS:         template.CSS(getThemeCSS()),
+		Responses:        getPostResponses(entry.ID),
+	}
 
-	// Redirect to appropriate settings section
-	redirectURL := "/admin/settings"
-	switch section {
-	case "appearance":
-		redirectURL = "/admin/settings/appearance"
-	case "security":
-		redirectURL = "/admin/settings/security"
-	case "backup":
-		redirectURL = "/admin/settings/backup"
+	tmpl, err := template.New("post").Parse(postTemplate + entryGalleryTemplate)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Template error: %v", err)
+		return
 	}
-	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
-}
 
-func jsEscape(s string) string {
-	return template.JSEscapeString(s)
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
 }
 
-func getThemeCSS() string {
-	// Get theme from database settings
+// handleSearch serves the public search page at /search?q=
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	const perPage = 20
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len(query) > 200 {
+		query = query[:200]
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	var results []EntryDisplay
+	var hasMore bool
+	var totalResults int
+	if query != "" {
+		results, hasMore, err = searchEntries(query, (page-1)*perPage, perPage)
+		if err != nil {
+			http.Error(w, "Database query error", http.StatusInternalServerError)
+			log.Printf("Search error: %v", err)
+			return
+		}
+		totalResults = countSearchResults(query)
+	}
+
+	// Get settings from database
 	settings, err := getSiteSettings()
 	if err != nil {
-		log.Printf("Error getting site settings for theme: %v", err)
-		return defaultThemeCSS
+		log.Printf("Error getting site settings: %v", err)
+		// Use defaults if error
+		settings = SiteSettings{
+			SiteTitle:    "My Blog",
+			SiteSubtitle: "A Personal Blog",
+			UserInitial:  "AB",
+			SiteTheme:    "default",
+		}
 	}
 
-	switch settings.SiteTheme {
-	case "dark":
-		return darkThemeCSS
-	case "custom":
-		return generateCustomThemeCSS(settings.CustomBgColor, settings.CustomTextColor, settings.CustomAccentColor)
-	default:
-		return defaultThemeCSS
+	data := SearchPageData{
+		Query:          query,
+		Results:        results,
+		TotalResults:   totalResults,
+		Page:           page,
+		HasMore:        hasMore,
+		SiteTitle:      settings.SiteTitle,
+		SiteSubtitle:   settings.SiteSubtitle,
+		EnableSubtitle: enableSubtitle,
+		ThemeCSS:       template.CSS(getThemeCSS()),
 	}
-}
 
-// generateCustomThemeCSS creates a custom theme CSS based on 3 base colors
-func generateCustomThemeCSS(bgColor, textColor, accentColor string) string {
-	// Parse colors and calculate derived colors
-	isLight := isLightHexColor(bgColor)
+	funcMap := template.FuncMap{
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"subtract": func(a, b int) int {
+			return a - b
+		},
+	}
+	tmpl, err := template.New("search").Funcs(funcMap).Parse(searchTemplate)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Search template error: %v", err)
+		return
+	}
 
-	// Derive secondary colors from the base colors
-	headerBg := adjustHexBrightness(bgColor, ifThen(isLight, -5, 10))
-	cardBg := adjustHexBrightness(bgColor, ifThen(isLight, 3, 5))
-	borderColor := adjustHexBrightness(bgColor, ifThen(isLight, -15, 20))
-	secondaryText := blendColors(textColor, bgColor, 0.5)
-	hoverShadow := "rgba(0, 0, 0, 0.15)"
-	if !isLight {
-		hoverShadow = "rgba(255, 255, 255, 0.1)"
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Printf("Search template execution error: %v", err)
 	}
-	subtleBorder := adjustHexBrightness(bgColor, ifThen(isLight, -8, 12))
+}
 
-	return fmt.Sprintf(`
-        * {
-            margin: 0;
-            padding: 0;
-            box-sizing: border-box;
-        }
+func handle404(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotFound)
 
-        body {
-            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif;
-            background-color: %[1]s;
-            color: %[2]s;
-            line-height: 1.6;
-        }
+	// Get recent entries
+	recentEntries, _, err := getEntries(0, 3)
+	if err != nil {
+		log.Printf("Error fetching recent entries for 404 page: %v", err)
+		recentEntries = []EntryDisplay{}
+	}
 
-        .container {
-            max-width: 614px;
-            margin: 0 auto;
-            background-color: %[1]s;
-            min-height: 100vh;
-        }
+	// Get settings from database
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error getting site settings: %v", err)
+		// Use defaults if error
+		settings = SiteSettings{
+			SiteTitle:    "My Blog",
+			SiteSubtitle: "A Personal Blog",
+			UserInitial:  "AB",
+			SiteTheme:    "default",
+		}
+	}
 
-        .header {
-            background: %[3]s;
-            padding: 20px 32px;
-            color: %[2]s;
-            border-bottom: 1px solid %[4]s;
-            position: sticky;
-            top: 0;
-            z-index: 100;
-        }
+	data := NotFoundPageData{
+		SiteTitle:      settings.SiteTitle,
+		SiteSubtitle:   settings.SiteSubtitle,
+		EnableSubtitle: enableSubtitle,
+		UserInitial:    settings.UserInitial,
+		RecentEntries:  recentEntries,
+		ThemeCSS:       template.CSS(getThemeCSS()),
+	}
 
-        .header-content {
-            display: flex;
-            align-items: center;
-            justify-content: space-between;
-        }
+	tmpl, err := template.New("404").Parse(notFoundTemplate)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("404 Template error: %v", err)
+		return
+	}
 
-        .header h1 {
-            font-size: 28px;
-            font-weight: 600;
-            display: flex;
-            align-items: center;
-            gap: 12px;
-            font-family: 'Segoe UI', Roboto, sans-serif;
-        }
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Printf("404 Template execution error: %v", err)
+	}
+}
 
-        .subtitle {
-            font-size: 14px;
-            color: %[5]s;
-            margin-top: 4px;
-            font-weight: 400;
-        }
+// Admin service handlers
+func handleAdminIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-        .stats {
-            padding: 16px 0;
-            background-color: %[1]s;
-            display: none;
-        }
+	// Determine which view to show (default to new post form)
+	view := r.URL.Query().Get("view")
+	if view == "" {
+		view = "new"
+	}
 
-        .stat {
-            text-align: center;
-            padding: 16px;
-            background-color: %[6]s;
+	// Template functions
+	funcMap := template.FuncMap{
+		"jsEscape": jsEscape,
+		"joinTags": func(tags []string) string {
+			return strings.Join(tags, ", ")
+		},
+		"isoTime": func(t *time.Time) string {
+			if t == nil {
+				return ""
+			}
+			return t.UTC().Format(time.RFC3339)
+		},
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"subtract": func(a, b int) int {
+			return a - b
+		},
+	}
+	tmpl := template.Must(template.New("admin").Funcs(funcMap).Parse(adminTemplate))
+
+	// Check if viewer password is set in site_settings
+	hasPassword := false
+	var passwordHash sql.NullString
+	err := db.QueryRow("SELECT viewer_password_hash FROM site_settings WHERE id = 1").Scan(&passwordHash)
+	if err == nil && passwordHash.Valid && passwordHash.String != "" {
+		hasPassword = true
+	}
+
+	// Read flash messages from cookies
+	var message, messageType string
+	if cookie, err := r.Cookie("flash_message"); err == nil {
+		message = cookie.Value
+		http.SetCookie(w, &http.Cookie{
+			Name:   "flash_message",
+			Value:  "",
+			Path:   "/",
+			MaxAge: -1,
+		})
+	}
+	if cookie, err := r.Cookie("flash_type"); err == nil {
+		messageType = cookie.Value
+		http.SetCookie(w, &http.Cookie{
+			Name:   "flash_type",
+			Value:  "",
+			Path:   "/",
+			MaxAge: -1,
+		})
+	}
+
+	user := currentUser(r)
+	data := EditorPageData{
+		HasPrivacyPassword: hasPassword,
+		EnableAudioUploads: enableAudioUploads,
+		Message:            message,
+		MessageType:        messageType,
+		View:               view,
+		PageTitle:          "Posts",
+		User:               user,
+		CSRFToken:          csrfToken(r),
+	}
+
+	// Authors only see their own posts
+	authorCondition, authorArgs := "", []interface{}(nil)
+	if !user.CanPublish() {
+		authorCondition, authorArgs = "author_id = ?", []interface{}{user.ID}
+	}
+
+	// For posts view, fetch paginated entries
+	if view == "posts" {
+		// Pagination settings
+		perPage := 20
+		pageStr := r.URL.Query().Get("page")
+		currentPage, err := strconv.Atoi(pageStr)
+		if err != nil || currentPage < 1 {
+			currentPage = 1
+		}
+
+		// Optional search across all posts, including drafts
+		searchQuery := strings.TrimSpace(r.URL.Query().Get("q"))
+		condition, conditionArgs := "", []interface{}(nil)
+		if searchQuery != "" {
+			var ok bool
+			condition, conditionArgs, ok = searchCondition(searchQuery)
+			if !ok {
+				condition = "0"
+			}
+		}
+		if authorCondition != "" {
+			if condition != "" {
+				condition = "(" + condition + ") AND "
+			}
+			condition += authorCondition
+			conditionArgs = append(conditionArgs, authorArgs...)
+		}
+		where := ""
+		if condition != "" {
+			where = " WHERE " + condition
+		}
+
+		// Get total count
+		var totalEntries int
+		err = db.QueryRow("SELECT COUNT(*) FROM entries"+where, conditionArgs...).Scan(&totalEntries)
+		if err != nil {
+			log.Printf("Error counting entries: %v", err)
+			totalEntries = 0
+		}
+
+		// Calculate pagination
+		totalPages := (totalEntries + perPage - 1) / perPage
+		if totalPages < 1 {
+			totalPages = 1
+		}
+		if currentPage > totalPages {
+			currentPage = totalPages
+		}
+
+		offset := (currentPage - 1) * perPage
+
+		// Fetch entries for current page
+		entries, err := queryAdminEntries(condition, conditionArgs, offset, perPage)
+		if err != nil {
+			log.Printf("Error fetching entries: %v", err)
+			entries = []Entry{}
+		}
+
+		// Calculate page numbers to show (max 5 pages around current)
+		var pageNumbers []int
+		startPage := currentPage - 2
+		if startPage < 1 {
+			startPage = 1
+		}
+		endPage := startPage + 4
+		if endPage > totalPages {
+			endPage = totalPages
+			startPage = endPage - 4
+			if startPage < 1 {
+				startPage = 1
+			}
+		}
+		for i := startPage; i <= endPage; i++ {
+			pageNumbers = append(pageNumbers, i)
+		}
+
+		// Calculate start/end entry numbers for display
+		startEntry := offset + 1
+		endEntry := offset + len(entries)
+		if totalEntries == 0 {
+			startEntry = 0
+			endEntry = 0
+		}
+
+		data.Entries = entries
+		data.CurrentPage = currentPage
+		data.TotalPages = totalPages
+		data.TotalEntries = totalEntries
+		data.StartEntry = startEntry
+		data.EndEntry = endEntry
+		data.PageNumbers = pageNumbers
+		data.SearchQuery = searchQuery
+		data.PageTitle = "Posts"
+	} else if view == "revisions" {
+		entryID, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		entries, err := queryAdminEntries("id = ?", []interface{}{entryID}, 0, 1)
+		if err != nil || len(entries) == 0 || !canEditEntry(user, entryID) {
+			showMessage(w, r, "Post not found", "error")
+			return
+		}
+		current := entries[0]
+
+		revisions, err := getEntryRevisions(entryID)
+		if err != nil {
+			log.Printf("Error fetching revisions: %v", err)
+		}
+
+		// Compare the selected revision (newest by default) with the current version
+		var selected *EntryRevision
+		revisionID, _ := strconv.Atoi(r.URL.Query().Get("rev"))
+		for i := range revisions {
+			if revisions[i].ID == revisionID {
+				selected = &revisions[i]
+				break
+			}
+		}
+		if selected == nil && len(revisions) > 0 {
+			selected = &revisions[0]
+		}
+		if selected != nil {
+			data.RevisionDiff = diffLines(selected.Content, current.Content)
+			data.RevisionMedia = !sameMedia(selected.Media, current.Media)
+		}
+
+		data.RevisionEntry = &current
+		data.Revisions = revisions
+		data.SelectedRevision = selected
+		data.PageTitle = "Revision History"
+	} else if view == "webmentions" {
+		filter := r.URL.Query().Get("status")
+		if filter != "approved" && filter != "rejected" {
+			filter = "pending"
+		}
+		mentions, err := getWebmentions(filter)
+		if err != nil {
+			log.Printf("Error fetching webmentions: %v", err)
+		}
+		data.Webmentions = mentions
+		data.WebmentionFilter = filter
+		data.PageTitle = "Webmentions"
+	} else if view == "drafts" {
+		entries, err := getDraftEntries(authorCondition, authorArgs)
+		if err != nil {
+			log.Printf("Error fetching drafts: %v", err)
+			entries = []Entry{}
+		}
+		data.Entries = entries
+		data.TotalEntries = len(entries)
+		data.PageTitle = "Drafts & Scheduled"
+	} else if view == "new" {
+		data.PageTitle = "New Post"
+	}
+
+	tmpl.Execute(w, data)
+}
+
+func handleGetEntriesForAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	offsetStr := r.URL.Query().Get("offset")
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	limit := 5
+	var entries []Entry
+	if user := currentUser(r); user.CanPublish() {
+		entries, err = getPaginatedEntries(offset, limit)
+	} else {
+		entries, err = queryAdminEntries("author_id = ?", []interface{}{user.ID}, offset, limit)
+	}
+	if err != nil {
+		log.Printf("Error fetching entries: %v", err)
+		http.Error(w, "Error fetching entries", http.StatusInternalServerError)
+		return
+	}
+
+	hasMore := len(entries) == limit
+
+	var entriesJSON []EntryJSON
+	for _, entry := range entries {
+		photoURL := ""
+		if entry.PhotoPath != "" {
+			photoURL = "/uploads/" + entry.PhotoPath
+		}
+		entriesJSON = append(entriesJSON, EntryJSON{
+			ID:        entry.ID,
+			Title:     entry.Title,
+			Content:   entry.Content,
+			Photo:     photoURL,
+			Slug:      entry.Slug,
+			CreatedAt: entry.CreatedAt.Format("Jan 2, 2006 3:04 PM"),
+		})
+	}
+
+	response := EntriesResponse{
+		Entries: entriesJSON,
+		HasMore: hasMore,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// entryTitle derives an entry's title from the first line of its content,
+// trimmed to 60 characters at the last full word. Entries without content
+// are titled after the given date.
+func entryTitle(content, contentFormat string, date time.Time) string {
+	if content == "" {
+		return fmt.Sprintf("Untitled Post %s", date.Format("2006-01-02"))
+	}
+	firstLine := strings.Split(content, "\n")[0]
+	if contentFormat == "markdown" {
+		firstLine = markdownPlainText(firstLine)
+	}
+	if len(firstLine) <= 60 {
+		return firstLine
+	}
+	// Find last space before 60 chars
+	truncated := firstLine[:60]
+	if lastSpace := strings.LastIndex(truncated, " "); lastSpace > 0 {
+		return truncated[:lastSpace]
+	}
+	return truncated
+}
+
+func handleCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	content := r.FormValue("content")
+	if len(content) > 2000 {
+		content = content[:2000]
+	}
+
+	finalTitle := entryTitle(content, "markdown", time.Now())
+
+	// A custom slug is pinned: later edits of the first line won't change it
+	customSlug := normalizeSlug(r.FormValue("slug"))
+	if customSlug != "" && slugTaken(customSlug, 0) {
+		showMessage(w, r, "That URL is already used by another post", "error")
+		return
+	}
+
+	// Generate timestamp for both slug and media. Scheduled entries take their
+	// publish time so they sort into the feed when they go live.
+	user := currentUser(r)
+	status := parseEntryStatus(r.FormValue("status"))
+	var publishAt *time.Time
+	if status == "published" {
+		publishAt = parsePublishAt(r)
+	}
+	status, publishAt = limitAuthorStatus(user, status, publishAt)
+	now := time.Now()
+	if publishAt != nil {
+		now = *publishAt
+	}
+	slug := generateSlug(finalTitle, now)
+	if customSlug != "" {
+		slug = customSlug
+	}
+
+	// Get media type from form (defaults to photo for backward compatibility).
+	// It applies to uploaded files whose extension doesn't tell their type.
+	mediaType := r.FormValue("media_type")
+	if mediaType == "" {
+		mediaType = "photo"
+	}
+
+	// Handle thumbnail upload; it covers the first video/audio item
+	var thumbnailPath string
+	thumbFile, thumbHeader, thumbErr := r.FormFile("thumbnail")
+	if thumbErr == nil {
+		defer thumbFile.Close()
+		thumbnailPath, thumbErr = validateAndSaveThumbnail(thumbFile, thumbHeader.Filename, finalTitle, now)
+		if thumbErr != nil {
+			log.Printf("Error saving thumbnail: %v", thumbErr)
+		}
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO entries (title, content, photo_path, media_type, slug, slug_pinned, content_format, author_id, status, publish_at, created_at)
+		VALUES (?, ?, '', ?, ?, ?, 'markdown', ?, ?, ?, ?)
+	`, finalTitle, content, mediaType, slug, customSlug != "", user.ID, status, publishAtValue(publishAt), now.UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		log.Printf("Error inserting entry: %v", err)
+		showMessage(w, r, "Failed to create entry", "error")
+		return
+	}
+
+	entryID, _ := result.LastInsertId()
+	saveMediaUploads(r, entryID, finalTitle, now, mediaType)
+	if thumbnailPath != "" {
+		if err := setMediaThumbnail(entryID, thumbnailPath); err != nil {
+			log.Printf("Error saving thumbnail: %v", err)
+		}
+	}
+	if err := syncPrimaryMedia(entryID); err != nil {
+		log.Printf("Error updating primary media: %v", err)
+	}
+	syncSearchIndex(entryID)
+	if err := setEntryTags(entryID, parseTagInput(r.FormValue("tags"))); err != nil {
+		log.Printf("Error saving tags: %v", err)
+	}
+	announceEntryChange(int(entryID), getBaseURL(r))
+	triggerEntryWebhooks("entry.created", int(entryID), getBaseURL(r))
+
+	// Drafts and scheduled posts go back to the drafts list
+	if status == "draft" || publishAt != nil {
+		http.Redirect(w, r, "/admin?view=drafts", http.StatusSeeOther)
+		return
+	}
+
+	// Redirect to the new post page
+	http.Redirect(w, r, "/posts/"+slug+"/", http.StatusSeeOther)
+}
+
+func handleUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	idStr := r.FormValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	user := currentUser(r)
+	if !canEditEntry(user, id) {
+		showMessage(w, r, "You can only edit your own posts", "error")
+		return
+	}
+
+	content := r.FormValue("content")
+	if len(content) > 2000 {
+		content = content[:2000]
+	}
+
+	// Get the created_at timestamp for slug generation and the format the
+	// post was written in (edits keep the original format)
+	var createdAt time.Time
+	var contentFormat sql.NullString
+	var currentStatus sql.NullString
+	var currentPublishAt sql.NullTime
+	var currentSlug sql.NullString
+	err = db.QueryRow("SELECT created_at, content_format, status, publish_at, slug FROM entries WHERE id = ?", id).Scan(&createdAt, &contentFormat, &currentStatus, &currentPublishAt, &currentSlug)
+	if err != nil {
+		log.Printf("Error fetching entry: %v", err)
+		showMessage(w, r, "Failed to find entry", "error")
+		return
+	}
+
+	// A custom slug is pinned; clearing it goes back to generated slugs
+	customSlug := normalizeSlug(r.FormValue("slug"))
+	if customSlug != "" && slugTaken(customSlug, id) {
+		showMessage(w, r, "That URL is already used by another post", "error")
+		return
+	}
+
+	// Scheduling moves the publication time; publishing a draft or a
+	// scheduled entry right away dates it now
+	status := parseEntryStatus(r.FormValue("status"))
+	var publishAt *time.Time
+	if status == "published" {
+		publishAt = parsePublishAt(r)
+	}
+	status, publishAt = limitAuthorStatus(user, status, publishAt)
+	if publishAt != nil {
+		createdAt = *publishAt
+	} else if status == "published" && currentStatus.String != "unlisted" && !isEntryPublic(currentStatus.String, currentPublishAt) {
+		createdAt = time.Now()
+	}
+
+	finalTitle := entryTitle(content, contentFormat.String, createdAt)
+
+	// Regenerate slug with final title
+	slug := generateSlug(finalTitle, createdAt)
+	if customSlug != "" {
+		slug = customSlug
+	}
+
+	tags := parseTagInput(r.FormValue("tags"))
+
+	// Get media type from form (defaults to photo for backward compatibility)
+	mediaType := r.FormValue("media_type")
+	if mediaType == "" {
+		mediaType = "photo"
+	}
+
+	// Handle thumbnail upload/removal; it covers the first video/audio item
+	var thumbnailPath string
+	thumbnailUpdated := false
+	removeThumbnail := r.FormValue("remove_thumbnail") == "1"
+
+	if removeThumbnail {
+		thumbnailUpdated = true
+	} else {
+		thumbFile, thumbHeader, thumbErr := r.FormFile("thumbnail")
+		if thumbErr == nil {
+			defer thumbFile.Close()
+			newThumbPath, thumbErr := validateAndSaveThumbnail(thumbFile, thumbHeader.Filename, finalTitle, createdAt)
+			if thumbErr != nil {
+				log.Printf("Error saving thumbnail: %v", thumbErr)
+			} else {
+				thumbnailPath = newThumbPath
+				thumbnailUpdated = true
+			}
+		}
+	}
+
+	// Keep the current version so the edit can be rolled back
+	revisionID, err := saveEntryRevision(id)
+	if err != nil {
+		log.Printf("Error saving revision: %v", err)
+	}
+
+	_, err = db.Exec("UPDATE entries SET title = ?, content = ?, slug = ? WHERE id = ?", finalTitle, content, slug, id)
+	if err != nil {
+		log.Printf("Error updating entry: %v", err)
+		showMessage(w, r, "Failed to update entry", "error")
+		return
+	}
+
+	// Reorder, relabel and remove existing media, then append new uploads
+	if err := updateEntryMedia(r, int64(id)); err != nil {
+		log.Printf("Error updating media: %v", err)
+	}
+	saveMediaUploads(r, int64(id), finalTitle, createdAt, mediaType)
+	if thumbnailUpdated {
+		if err := setMediaThumbnail(int64(id), thumbnailPath); err != nil {
+			log.Printf("Error saving thumbnail: %v", err)
+		}
+	}
+	if err := syncPrimaryMedia(int64(id)); err != nil {
+		log.Printf("Error updating primary media: %v", err)
+	}
+
+	syncSearchIndex(int64(id))
+	if _, err := db.Exec("UPDATE entries SET slug_pinned = ? WHERE id = ?", customSlug != "", id); err != nil {
+		log.Printf("Error saving custom slug: %v", err)
+	}
+	recordSlugChange(id, currentSlug.String, slug)
+	if err := setEntryPublication(int64(id), status, publishAt, createdAt); err != nil {
+		log.Printf("Error saving publication status: %v", err)
+	}
+	if err := setEntryTags(int64(id), tags); err != nil {
+		log.Printf("Error saving tags: %v", err)
+	}
+	if revisionID > 0 {
+		discardUnchangedRevision(revisionID)
+	}
+	announceEntryChange(id, getBaseURL(r))
+	triggerEntryWebhooks("entry.updated", id, getBaseURL(r))
+
+	http.Redirect(w, r, "/posts/"+slug+"/", http.StatusSeeOther)
+}
+
+// ============================================================================
+// Responsive images
+// ============================================================================
+
+// imageVariantWidths are the widths of the resized copies made of each photo,
+// smallest first. Only widths below the original's are generated.
+var imageVariantWidths = []uint{320, 640, 1080}
+
+// Sizes attributes matching the viewer grid: the first entry of the feed (and
+// a single post) spans two columns, the others one
+const (
+	featuredImageSizes = "(max-width: 900px) 100vw, 740px"
+	gridImageSizes     = "(max-width: 600px) 100vw, (max-width: 900px) 50vw, 370px"
+)
+
+// imageVariantFilename returns the filename of a photo's copy at a width.
+// Copies made since their names were hashed are listed in image_variants.
+func imageVariantFilename(filename string, width int) string {
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(filename, ext), width, ext)
+}
+
+// imageSrcSet builds a srcset from the comma-separated variant widths and
+// filenames of a photo plus the original at its full width
+func imageSrcSet(filename, widths, files string, width int) string {
+	if widths == "" || width == 0 {
+		return ""
+	}
+	names := strings.Split(files, ",")
+	var candidates []string
+	for i, w := range strings.Split(widths, ",") {
+		if n, err := strconv.Atoi(w); err == nil {
+			name := imageVariantFilename(filename, n)
+			if files != "" && i < len(names) {
+				name = names[i]
+			}
+			candidates = append(candidates, fmt.Sprintf("/uploads/%s %dw", name, n))
+		}
+	}
+	candidates = append(candidates, fmt.Sprintf("/uploads/%s %dw", filename, width))
+	return strings.Join(candidates, ", ")
+}
+
+// encodeImage writes img as PNG for .png files and as JPEG otherwise
+func encodeImage(w io.Writer, img image.Image, ext string) error {
+	if ext == ".png" {
+		return png.Encode(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 82})
+}
+
+// createImageVariants writes resized copies of an uploaded JPEG or PNG photo
+// and records them in image_variants together with a tiny blurred placeholder.
+// GIFs (which may be animated) and WebP images are served as uploaded.
+func createImageVariants(filename string) error {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(uploadsDir, filename))
+	if err != nil {
+		return fmt.Errorf("failed to read image: %v", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid image file: %v", err)
+	}
+	bounds := img.Bounds()
+
+	var widths, files []string
+	for _, width := range imageVariantWidths {
+		if int(width) >= bounds.Dx() {
+			break
+		}
+		resized := resize.Resize(width, 0, img, resize.Lanczos3)
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, resized, ext); err != nil {
+			return fmt.Errorf("failed to encode image: %v", err)
+		}
+		variantExt := filepath.Ext(filename)
+		name := hashedUploadFilename(fmt.Sprintf("%s-%dw", strings.TrimSuffix(filename, variantExt), width), variantExt, buf.Bytes())
+		if err := os.WriteFile(filepath.Join(uploadsDir, name), buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write file: %v", err)
+		}
+		widths = append(widths, strconv.Itoa(int(width)))
+		files = append(files, name)
+	}
+
+	placeholder, err := imagePlaceholder(img, ext)
+	if err != nil {
+		return fmt.Errorf("failed to create placeholder: %v", err)
+	}
+
+	_, err = db.Exec("INSERT OR REPLACE INTO image_variants (path, width, height, widths, files, placeholder) VALUES (?, ?, ?, ?, ?, ?)",
+		filename, bounds.Dx(), bounds.Dy(), strings.Join(widths, ","), strings.Join(files, ","), placeholder)
+	return err
+}
+
+// imagePlaceholder returns a 16px wide, blurred copy of img as a data URI,
+// small enough to inline in the page while the photo loads
+func imagePlaceholder(img image.Image, ext string) (string, error) {
+	small := blurImage(resize.Resize(16, 0, img, resize.Bilinear))
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, small, ext); err != nil {
+		return "", err
+	}
+	mimeType := "image/jpeg"
+	if ext == ".png" {
+		mimeType = "image/png"
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// blurImage applies a 3x3 box blur, so a scaled-up placeholder looks soft
+// rather than blocky
+func blurImage(img image.Image) image.Image {
+	bounds := img.Bounds()
+	blurred := image.NewRGBA64(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var r, g, b, a, n uint32
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if !image.Pt(x+dx, y+dy).In(bounds) {
+						continue
+					}
+					cr, cg, cb, ca := img.At(x+dx, y+dy).RGBA()
+					r, g, b, a, n = r+cr, g+cg, b+cb, a+ca, n+1
+				}
+			}
+			blurred.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return blurred
+}
+
+// createMissingImageVariants resizes photos uploaded before responsive images
+// existed (or restored from a backup). It runs in the background at startup.
+func createMissingImageVariants() {
+	rows, err := db.Query(`
+		SELECT DISTINCT path FROM entry_media
+		WHERE media_type = 'photo' AND path NOT IN (SELECT path FROM image_variants)
+	`)
+	if err != nil {
+		log.Printf("Error finding photos without resized copies: %v", err)
+		return
+	}
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	rows.Close()
+
+	for _, path := range paths {
+		if err := createImageVariants(path); err != nil {
+			log.Printf("Error resizing %s: %v", path, err)
+		}
+	}
+}
+
+// ============================================================================
+// Entry media
+// ============================================================================
+
+// mediaExtensions maps upload file extensions to media types. WebM can hold
+// audio or video, so it is left out and keeps the type chosen in the form.
+var mediaExtensions = map[string]string{
+	"jpg": "photo", "jpeg": "photo", "png": "photo", "gif": "photo", "webp": "photo",
+	"mp3": "audio", "m4a": "audio", "wav": "audio", "ogg": "audio", "aac": "audio",
+	"mp4": "video", "mov": "video", "avi": "video",
+}
+
+// mediaTypeForFile returns the media type of an uploaded file from its
+// extension, or fallback if the extension doesn't decide it
+func mediaTypeForFile(filename, fallback string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if mediaType, ok := mediaExtensions[ext]; ok {
+		return mediaType
+	}
+	return fallback
+}
+
+// mediaMIMEType returns the MIME type of a stored media file for feeds
+func mediaMIMEType(path, mediaType string) string {
+	switch strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")) {
+	case "png":
+		return "image/png"
+	case "gif":
+		return "image/gif"
+	case "webp":
+		return "image/webp"
+	case "mp3":
+		return "audio/mpeg"
+	case "m4a":
+		return "audio/mp4"
+	case "wav":
+		return "audio/wav"
+	case "ogg":
+		return "audio/ogg"
+	case "aac":
+		return "audio/aac"
+	case "webm":
+		if mediaType == "audio" {
+			return "audio/webm"
+		}
+		return "video/webm"
+	case "mp4":
+		return "video/mp4"
+	case "mov":
+		return "video/quicktime"
+	case "avi":
+		return "video/x-msvideo"
+	}
+	return "image/jpeg"
+}
+
+// withMediaURLs fills in the upload URLs of media items
+func withMediaURLs(media []EntryMedia) []EntryMedia {
+	for i := range media {
+		media[i].URL = template.URL("/uploads/" + media[i].Path)
+		media[i].Thumbnail = ""
+		if media[i].ThumbnailPath != "" {
+			media[i].Thumbnail = template.URL("/uploads/" + media[i].ThumbnailPath)
+		}
+	}
+	return media
+}
+
+// applyPrimaryMedia copies the alt text and responsive image details of an
+// entry's first media item to the fields used for single-photo entries
+func applyPrimaryMedia(entry *EntryDisplay) {
+	entry.PhotoAlt = "Entry photo"
+	if len(entry.Media) == 0 {
+		return
+	}
+	if entry.Media[0].AltText != "" {
+		entry.PhotoAlt = entry.Media[0].AltText
+	}
+	entry.PhotoSrcSet = entry.Media[0].SrcSet
+	entry.PhotoPlaceholder = entry.Media[0].Placeholder
+}
+
+// getMediaForEntries returns the media items of each given entry in display
+// order, keyed by entry ID
+func getMediaForEntries(ids []int) map[int][]EntryMedia {
+	mediaByEntry := make(map[int][]EntryMedia)
+	if len(ids) == 0 {
+		return mediaByEntry
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	rows, err := db.Query(`
+		SELECT m.entry_id, m.id, m.path, m.media_type, m.thumbnail_path, m.alt_text, m.duration, v.width, v.height, v.widths, v.files, v.placeholder
+		FROM entry_media m
+		LEFT JOIN image_variants v ON v.path = m.path
+		WHERE m.entry_id IN (`+placeholders+`)
+		ORDER BY m.position, m.id
+	`, args...)
+	if err != nil {
+		log.Printf("Error fetching media: %v", err)
+		return mediaByEntry
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryID int
+		var media EntryMedia
+		var thumbnailPath, altText, widths, files, placeholder sql.NullString
+		var duration, width, height sql.NullInt64
+		if err := rows.Scan(&entryID, &media.ID, &media.Path, &media.MediaType, &thumbnailPath, &altText, &duration, &width, &height, &widths, &files, &placeholder); err == nil {
+			media.ThumbnailPath = thumbnailPath.String
+			media.AltText = altText.String
+			media.Duration = int(duration.Int64)
+			media.Width = int(width.Int64)
+			media.Height = int(height.Int64)
+			media.SrcSet = imageSrcSet(media.Path, widths.String, files.String, media.Width)
+			media.Placeholder = template.URL(placeholder.String)
+			mediaByEntry[entryID] = append(mediaByEntry[entryID], media)
+		}
+	}
+	for id := range mediaByEntry {
+		withMediaURLs(mediaByEntry[id])
+	}
+	return mediaByEntry
+}
+
+// addEntryMedia appends a media item to the end of an entry's media
+func addEntryMedia(entryID int64, path, mediaType, thumbnailPath, altText string) error {
+	_, err := db.Exec(`
+		INSERT INTO entry_media (entry_id, position, path, media_type, thumbnail_path, alt_text, duration)
+		SELECT ?, COALESCE(MAX(position) + 1, 0), ?, ?, NULLIF(?, ''), ?, ? FROM entry_media WHERE entry_id = ?
+	`, entryID, path, mediaType, thumbnailPath, altText, mediaDuration(path, mediaType), entryID)
+	return err
+}
+
+// mediaDuration returns the playing time in seconds of an uploaded audio
+// file, or 0 for other media and files whose length can't be read
+func mediaDuration(path, mediaType string) int {
+	if mediaType != "audio" {
+		return 0
+	}
+	duration := audioDuration(filepath.Join(uploadsDir, filepath.Base(path)))
+	return int(duration.Round(time.Second).Seconds())
+}
+
+// fillMissingMediaDurations stores the playing time of audio added before
+// durations were saved with the upload
+func fillMissingMediaDurations() {
+	rows, err := db.Query("SELECT id, path FROM entry_media WHERE media_type = 'audio' AND duration IS NULL")
+	if err != nil {
+		log.Printf("Error finding audio without a duration: %v", err)
+		return
+	}
+	durations := map[int]int{}
+	for rows.Next() {
+		var id int
+		var path string
+		if err := rows.Scan(&id, &path); err == nil {
+			durations[id] = mediaDuration(path, "audio")
+		}
+	}
+	rows.Close()
+
+	for id, duration := range durations {
+		if _, err := db.Exec("UPDATE entry_media SET duration = ? WHERE id = ?", duration, id); err != nil {
+			log.Printf("Error saving duration of media %d: %v", id, err)
+		}
+	}
+}
+
+// setEntryMedia replaces all media items of an entry
+func setEntryMedia(entryID int64, media []EntryMedia) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM entry_media WHERE entry_id = ?", entryID); err != nil {
+		return err
+	}
+	for i, item := range media {
+		duration := item.Duration
+		if duration == 0 {
+			duration = mediaDuration(item.Path, item.MediaType)
+		}
+		_, err := tx.Exec("INSERT INTO entry_media (entry_id, position, path, media_type, thumbnail_path, alt_text, duration) VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, ?)",
+			entryID, i, item.Path, item.MediaType, item.ThumbnailPath, item.AltText, duration)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// saveMediaUploads validates and stores every file of the form's "media"
+// field and appends them to the entry. The media_alt values line up with the
+// files; fallbackType applies to files whose extension doesn't tell the type.
+func saveMediaUploads(r *http.Request, entryID int64, title string, createdAt time.Time, fallbackType string) {
+	if r.MultipartForm == nil {
+		return
+	}
+	altTexts := r.MultipartForm.Value["media_alt"]
+	for i, header := range r.MultipartForm.File["media"] {
+		mediaType := mediaTypeForFile(header.Filename, fallbackType)
+		file, err := header.Open()
+		if err != nil {
+			log.Printf("Error opening %s: %v", mediaType, err)
+			continue
+		}
+		photoPath, err := validateMediaAndSave(file, header.Filename, title, createdAt, mediaType)
+		file.Close()
+		if err != nil {
+			log.Printf("Error saving %s: %v", mediaType, err)
+			continue
+		}
+
+		var altText string
+		if i < len(altTexts) {
+			altText = strings.TrimSpace(altTexts[i])
+		}
+		if err := addEntryMedia(entryID, photoPath, mediaType, "", altText); err != nil {
+			log.Printf("Error saving media item: %v", err)
+		}
+		if mediaType == "photo" {
+			if err := createImageVariants(photoPath); err != nil {
+				log.Printf("Error resizing %s: %v", photoPath, err)
+			}
+		}
+	}
+}
+
+// updateEntryMedia applies the edit form's changes to an entry's existing
+// media: media_remove lists the items to drop, media_order the remaining item
+// IDs in their new order, and media_alt_<id> holds each item's alt text
+func updateEntryMedia(r *http.Request, entryID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, idStr := range r.Form["media_remove"] {
+		if _, err := tx.Exec("DELETE FROM entry_media WHERE id = ? AND entry_id = ?", idStr, entryID); err != nil {
+			return err
+		}
+	}
+	for position, idStr := range r.Form["media_order"] {
+		if _, err := tx.Exec("UPDATE entry_media SET position = ? WHERE id = ? AND entry_id = ?", position, idStr, entryID); err != nil {
+			return err
+		}
+		if altText, ok := r.Form["media_alt_"+idStr]; ok {
+			if _, err := tx.Exec("UPDATE entry_media SET alt_text = ? WHERE id = ? AND entry_id = ?", strings.TrimSpace(altText[0]), idStr, entryID); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// sameMedia reports whether two media lists hold the same items in the same
+// order with the same alt text and thumbnails
+func sameMedia(a, b []EntryMedia) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Path != b[i].Path || a[i].MediaType != b[i].MediaType ||
+			a[i].ThumbnailPath != b[i].ThumbnailPath || a[i].AltText != b[i].AltText {
+			return false
+		}
+	}
+	return true
+}
+
+// setMediaThumbnail sets the cover image of the entry's first audio or video
+// item; an empty path removes it
+func setMediaThumbnail(entryID int64, thumbnailPath string) error {
+	_, err := db.Exec(`
+		UPDATE entry_media SET thumbnail_path = NULLIF(?, '')
+		WHERE id = (SELECT id FROM entry_media WHERE entry_id = ? AND media_type IN ('audio', 'video') ORDER BY position, id LIMIT 1)
+	`, thumbnailPath, entryID)
+	return err
+}
+
+// syncPrimaryMedia mirrors an entry's first media item into its photo_path,
+// media_type and thumbnail_path columns
+func syncPrimaryMedia(entryID int64) error {
+	_, err := db.Exec(`
+		UPDATE entries SET
+			photo_path = COALESCE((SELECT path FROM entry_media WHERE entry_id = entries.id ORDER BY position, id LIMIT 1), ''),
+			media_type = COALESCE((SELECT media_type FROM entry_media WHERE entry_id = entries.id ORDER BY position, id LIMIT 1), 'photo'),
+			thumbnail_path = (SELECT thumbnail_path FROM entry_media WHERE entry_id = entries.id ORDER BY position, id LIMIT 1)
+		WHERE id = ?
+	`, entryID)
+	return err
+}
+
+// ============================================================================
+// Revision history
+// ============================================================================
+
+// entryTagListSQL selects the comma-separated, sorted tag names of the entry
+// whose ID is in column e.id
+const entryTagListSQL = `(SELECT group_concat(name, ',') FROM (
+	SELECT t.name FROM entry_tags et JOIN tags t ON t.id = et.tag_id WHERE et.entry_id = e.id ORDER BY t.name))`
+
+// entryMediaListSQL selects the media items of the entry whose ID is in column
+// e.id as a JSON array, in display order
+const entryMediaListSQL = `(SELECT json_group_array(json_object('Path', path, 'MediaType', media_type,
+	'ThumbnailPath', COALESCE(thumbnail_path, ''), 'AltText', COALESCE(alt_text, ''))) FROM (
+	SELECT * FROM entry_media m WHERE m.entry_id = e.id ORDER BY m.position, m.id))`
+
+// saveEntryRevision stores the current version of an entry as a revision and
+// returns the revision ID
+func saveEntryRevision(entryID int) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO entry_revisions (entry_id, title, content, photo_path, media_type, thumbnail_path, content_format, tags, media)
+		SELECT e.id, e.title, e.content, e.photo_path, e.media_type, e.thumbnail_path, e.content_format, `+entryTagListSQL+`, `+entryMediaListSQL+`
+		FROM entries e WHERE e.id = ?
+	`, entryID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// discardUnchangedRevision deletes a revision saved before an edit if the edit
+// did not change anything, so saving without changes doesn't clutter the history
+func discardUnchangedRevision(revisionID int64) {
+	_, err := db.Exec(`
+		DELETE FROM entry_revisions WHERE id = ? AND EXISTS (
+			SELECT 1 FROM entries e
+			WHERE e.id = entry_revisions.entry_id
+			AND e.title IS entry_revisions.title
+			AND e.content IS entry_revisions.content
+			AND e.content_format IS entry_revisions.content_format
+			AND e.photo_path IS entry_revisions.photo_path
+			AND e.media_type IS entry_revisions.media_type
+			AND e.thumbnail_path IS entry_revisions.thumbnail_path
+			AND `+entryTagListSQL+` IS entry_revisions.tags
+			AND `+entryMediaListSQL+` IS entry_revisions.media
+		)
+	`, revisionID)
+	if err != nil {
+		log.Printf("Error discarding unchanged revision: %v", err)
+	}
+}
+
+// getEntryRevisions returns the revisions of an entry, newest first
+func getEntryRevisions(entryID int) ([]EntryRevision, error) {
+	rows, err := db.Query(`
+		SELECT id, entry_id, title, content, photo_path, media_type, thumbnail_path, content_format, tags, media, created_at
+		FROM entry_revisions
+		WHERE entry_id = ?
+		ORDER BY id DESC
+	`, entryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []EntryRevision
+	for rows.Next() {
+		revision, err := scanEntryRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// scanEntryRevision reads one entry_revisions row
+func scanEntryRevision(row interface{ Scan(...interface{}) error }) (EntryRevision, error) {
+	var revision EntryRevision
+	var title, photoPath, mediaType, thumbnailPath, contentFormat, tags, media sql.NullString
+	err := row.Scan(&revision.ID, &revision.EntryID, &title, &revision.Content, &photoPath, &mediaType,
+		&thumbnailPath, &contentFormat, &tags, &media, &revision.CreatedAt)
+	if err != nil {
+		return revision, err
+	}
+	revision.Title = title.String
+	revision.PhotoPath = photoPath.String
+	revision.MediaType = mediaType.String
+	revision.ThumbnailPath = thumbnailPath.String
+	revision.ContentFormat = contentFormat.String
+	if tags.String != "" {
+		revision.Tags = strings.Split(tags.String, ",")
+	}
+	// Revisions from before galleries only have the single media item
+	if media.Valid {
+		if err := json.Unmarshal([]byte(media.String), &revision.Media); err != nil {
+			return revision, err
+		}
+	} else if revision.PhotoPath != "" {
+		revision.Media = []EntryMedia{{Path: revision.PhotoPath, MediaType: revision.MediaType, ThumbnailPath: revision.ThumbnailPath}}
+	}
+	withMediaURLs(revision.Media)
+	return revision, nil
+}
+
+// diffLines computes a line-based diff from oldText to newText using the
+// longest common subsequence of lines
+func diffLines(oldText, newText string) []DiffLine {
+	a := strings.Split(strings.ReplaceAll(oldText, "\r\n", "\n"), "\n")
+	b := strings.Split(strings.ReplaceAll(newText, "\r\n", "\n"), "\n")
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []DiffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{Op: "same", Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Op: "removed", Text: a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: "added", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{Op: "removed", Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{Op: "added", Text: b[j]})
+	}
+	return diff
+}
+
+// handleRestoreRevision replaces an entry with one of its revisions. The
+// current version is saved as a revision first, so a restore can be undone.
+func handleRestoreRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	revisionID, err := strconv.Atoi(r.FormValue("revision_id"))
+	if err != nil {
+		http.Error(w, "Invalid revision ID", http.StatusBadRequest)
+		return
+	}
+
+	revision, err := scanEntryRevision(db.QueryRow(`
+		SELECT id, entry_id, title, content, photo_path, media_type, thumbnail_path, content_format, tags, media, created_at
+		FROM entry_revisions WHERE id = ?
+	`, revisionID))
+	if err != nil {
+		log.Printf("Error fetching revision: %v", err)
+		showMessage(w, r, "Revision not found", "error")
+		return
+	}
+	historyURL := fmt.Sprintf("/admin?view=revisions&id=%d", revision.EntryID)
+	user := currentUser(r)
+	if !canEditEntry(user, revision.EntryID) {
+		showMessage(w, r, "You can only edit your own posts", "error")
+		return
+	}
+
+	// The slug follows the restored title like on a regular edit, unless the
+	// author pinned a custom slug
+	var createdAt time.Time
+	var currentSlug, currentStatus sql.NullString
+	var currentPublishAt sql.NullTime
+	var slugPinned bool
+	if err := db.QueryRow("SELECT created_at, slug, slug_pinned, status, publish_at FROM entries WHERE id = ?", revision.EntryID).Scan(&createdAt, &currentSlug, &slugPinned, &currentStatus, &currentPublishAt); err != nil {
+		log.Printf("Error fetching entry: %v", err)
+		showMessage(w, r, "Failed to find entry", "error")
+		return
+	}
+	slug := generateSlug(revision.Title, createdAt)
+	if slugPinned {
+		slug = currentSlug.String
+	}
+
+	if _, err := saveEntryRevision(revision.EntryID); err != nil {
+		log.Printf("Error saving revision: %v", err)
+		showMessageAt(w, r, "Failed to restore revision", "error", historyURL)
+		return
+	}
+
+	// Restoring is an edit like any other, so authors' restores are drafts too
+	var publishAt *time.Time
+	if currentPublishAt.Valid {
+		publishAt = &currentPublishAt.Time
+	}
+	status, publishAt := limitAuthorStatus(user, parseEntryStatus(currentStatus.String), publishAt)
+
+	_, err = db.Exec(`
+		UPDATE entries SET title = ?, content = ?, content_format = ?, slug = ?, status = ?, publish_at = ?
+		WHERE id = ?
+	`, revision.Title, revision.Content, revision.ContentFormat, slug, status, publishAtValue(publishAt), revision.EntryID)
+	if err != nil {
+		log.Printf("Error restoring revision: %v", err)
+		showMessageAt(w, r, "Failed to restore revision", "error", historyURL)
+		return
+	}
+
+	if err := setEntryMedia(int64(revision.EntryID), revision.Media); err != nil {
+		log.Printf("Error restoring media: %v", err)
+	}
+	if err := syncPrimaryMedia(int64(revision.EntryID)); err != nil {
+		log.Printf("Error updating primary media: %v", err)
+	}
+
+	recordSlugChange(revision.EntryID, currentSlug.String, slug)
+	if err := setEntryTags(int64(revision.EntryID), revision.Tags); err != nil {
+		log.Printf("Error restoring tags: %v", err)
+	}
+	syncSearchIndex(int64(revision.EntryID))
+	announceEntryChange(revision.EntryID, getBaseURL(r))
+	triggerEntryWebhooks("entry.updated", revision.EntryID, getBaseURL(r))
+
+	showMessageAt(w, r, "Revision restored", "success", historyURL)
+}
+
+func handleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	idStr := r.FormValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if !canEditEntry(currentUser(r), id) {
+		showMessage(w, r, "You can only delete your own posts", "error")
+		return
+	}
+
+	if err := deleteEntry(id, getBaseURL(r)); err != nil {
+		log.Printf("Error deleting entry: %v", err)
+		showMessage(w, r, "Failed to delete entry", "error")
+		return
+	}
+
+	showMessage(w, r, "Entry deleted successfully!", "success")
+}
+
+// deleteEntry removes an entry with its tags, revisions, media and received
+// webmentions, and notifies the sites it linked to
+func deleteEntry(id int, baseURL string) error {
+	entry, exists := getWebhookEntry(id, baseURL)
+	if _, err := db.Exec("DELETE FROM entries WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	if err := setEntryTags(int64(id), nil); err != nil {
+		log.Printf("Error removing tags: %v", err)
+	}
+	syncSearchIndex(int64(id))
+	if _, err := db.Exec("DELETE FROM entry_revisions WHERE entry_id = ?", id); err != nil {
+		log.Printf("Error removing revisions: %v", err)
+	}
+	if _, err := db.Exec("DELETE FROM slug_history WHERE entry_id = ?", id); err != nil {
+		log.Printf("Error removing slug history: %v", err)
+	}
+	if _, err := db.Exec("DELETE FROM entry_media WHERE entry_id = ?", id); err != nil {
+		log.Printf("Error removing media: %v", err)
+	}
+	if _, err := db.Exec("DELETE FROM webmentions WHERE entry_id = ?", id); err != nil {
+		log.Printf("Error removing webmentions: %v", err)
+	}
+	// Let the sites we linked to know the post is gone
+	announceEntryChange(id, baseURL)
+	if exists {
+		triggerWebhooks("entry.deleted", entry)
+	}
+	return nil
+}
+
+func getAllEntries() ([]Entry, error) {
+	rows, err := db.Query("SELECT id, title, content, photo_path, media_type, thumbnail_path, slug, created_at FROM entries ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		var title sql.NullString
+		var photoPath sql.NullString
+		var mediaType sql.NullString
+		var thumbnailPath sql.NullString
+		var slug sql.NullString
+		err := rows.Scan(&entry.ID, &title, &entry.Content, &photoPath, &mediaType, &thumbnailPath, &slug, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if title.Valid {
+			entry.Title = title.String
+		}
+		if photoPath.Valid {
+			entry.PhotoPath = photoPath.String
+		}
+		if mediaType.Valid {
+			entry.MediaType = mediaType.String
+		} else {
+			entry.MediaType = "photo"
+		}
+		if thumbnailPath.Valid {
+			entry.ThumbnailPath = thumbnailPath.String
+		}
+		if slug.Valid {
+			entry.Slug = slug.String
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func getPaginatedEntries(offset, limit int) ([]Entry, error) {
+	return queryAdminEntries("", nil, offset, limit)
+}
+
+// draftEntriesCondition matches drafts and entries scheduled for later
+const draftEntriesCondition = "status = 'draft' OR (publish_at IS NOT NULL AND publish_at > datetime('now'))"
+
+// getDraftEntries returns drafts and scheduled entries for the admin drafts
+// view, optionally narrowed by an extra condition
+func getDraftEntries(condition string, args []interface{}) ([]Entry, error) {
+	if condition == "" {
+		return queryAdminEntries(draftEntriesCondition, nil, 0, -1)
+	}
+	return queryAdminEntries("("+draftEntriesCondition+") AND "+condition, args, 0, -1)
+}
+
+// queryAdminEntries loads entries of any status for the admin, newest first,
+// optionally restricted by an extra WHERE condition. A negative limit returns
+// all matching entries.
+func queryAdminEntries(condition string, args []interface{}, offset, limit int) ([]Entry, error) {
+	where := ""
+	if condition != "" {
+		where = "WHERE " + condition
+	}
+	query := "SELECT id, title, content, photo_path, media_type, thumbnail_path, slug, slug_pinned, status, publish_at, created_at FROM entries " + where + " ORDER BY created_at DESC LIMIT ? OFFSET ?"
+	rows, err := db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		var title sql.NullString
+		var photoPath sql.NullString
+		var mediaType sql.NullString
+		var thumbnailPath sql.NullString
+		var slug sql.NullString
+		var slugPinned sql.NullBool
+		var status sql.NullString
+		var publishAt sql.NullTime
+		err := rows.Scan(&entry.ID, &title, &entry.Content, &photoPath, &mediaType, &thumbnailPath, &slug, &slugPinned, &status, &publishAt, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.SlugPinned = slugPinned.Bool
+		entry.Status = parseEntryStatus(status.String)
+		if publishAt.Valid && publishAt.Time.After(time.Now()) {
+			entry.PublishAt = &publishAt.Time
+		}
+		if title.Valid {
+			entry.Title = title.String
+		}
+		if photoPath.Valid {
+			entry.PhotoPath = photoPath.String
+		}
+		if mediaType.Valid {
+			entry.MediaType = mediaType.String
+		} else {
+			entry.MediaType = "photo"
+		}
+		if thumbnailPath.Valid {
+			entry.ThumbnailPath = thumbnailPath.String
+		}
+		if slug.Valid {
+			entry.Slug = slug.String
+		}
+		entries = append(entries, entry)
+	}
+
+	ids := make([]int, len(entries))
+	for i := range entries {
+		ids[i] = entries[i].ID
+	}
+	tagsByEntry := getTagsForEntries(ids)
+	mediaByEntry := getMediaForEntries(ids)
+	authorByEntry := getEntryAuthors(ids)
+	for i := range entries {
+		entries[i].Tags = tagsByEntry[entries[i].ID]
+		entries[i].Media = mediaByEntry[entries[i].ID]
+		entries[i].Author = authorByEntry[entries[i].ID]
+	}
+
+	return entries, nil
+}
+
+func showMessage(w http.ResponseWriter, r *http.Request, message, messageType string) {
+	showMessageAt(w, r, message, messageType, "/admin")
+}
+
+// showMessageAt sets the flash message and redirects to the given admin page
+func showMessageAt(w http.ResponseWriter, r *http.Request, message, messageType, redirectURL string) {
+	// Set flash message cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     "flash_message",
+		Value:    message,
+		Path:     "/",
+		MaxAge:   5, // 5 seconds
+		HttpOnly: true,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "flash_type",
+		Value:    messageType,
+		Path:     "/",
+		MaxAge:   5, // 5 seconds
+		HttpOnly: true,
+	})
+
+	// Redirect to admin page
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+func showSettingsMessage(w http.ResponseWriter, r *http.Request, message, messageType, section string) {
+	// Set flash message cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     "flash_message",
+		Value:    message,
+		Path:     "/",
+		MaxAge:   5, // 5 seconds
+		HttpOnly: true,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "flash_type",
+		Value:    messageType,
+		Path:     "/",
+		MaxAge:   5, // 5 seconds
+		HttpOnly: true,
+	})
+
+	// Redirect to appropriate settings section
+	redirectURL := "/admin/settings"
+	switch section {
+	case "appearance":
+		redirectURL = "/admin/settings/appearance"
+	case "security":
+		redirectURL = "/admin/settings/security"
+	case "podcast":
+		redirectURL = "/admin/settings/podcast"
+	case "tokens":
+		redirectURL = "/admin/settings/tokens"
+	case "webhooks":
+		redirectURL = "/admin/settings/webhooks"
+	case "users":
+		redirectURL = "/admin/settings/users"
+	case "backup":
+		redirectURL = "/admin/settings/backup"
+	}
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+func jsEscape(s string) string {
+	return template.JSEscapeString(s)
+}
+
+func getThemeCSS() string {
+	// Get theme from database settings
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error getting site settings for theme: %v", err)
+		return defaultThemeCSS + markdownContentCSS + entryTagsCSS + searchCSS + photoPlaceholderCSS + galleryCSS + responsesCSS
+	}
+
+	var css string
+	switch settings.SiteTheme {
+	case "dark":
+		css = darkThemeCSS
+	case "custom":
+		css = generateCustomThemeCSS(settings.CustomBgColor, settings.CustomTextColor, settings.CustomAccentColor)
+	default:
+		css = defaultThemeCSS
+	}
+	return css + markdownContentCSS + entryTagsCSS + searchCSS + photoPlaceholderCSS + galleryCSS + responsesCSS
+}
+
+// generateCustomThemeCSS creates a custom theme CSS based on 3 base colors
+func generateCustomThemeCSS(bgColor, textColor, accentColor string) string {
+	// Parse colors and calculate derived colors
+	isLight := isLightHexColor(bgColor)
+
+	// Derive secondary colors from the base colors
+	headerBg := adjustHexBrightness(bgColor, ifThen(isLight, -5, 10))
+	cardBg := adjustHexBrightness(bgColor, ifThen(isLight, 3, 5))
+	borderColor := adjustHexBrightness(bgColor, ifThen(isLight, -15, 20))
+	secondaryText := blendColors(textColor, bgColor, 0.5)
+	hoverShadow := "rgba(0, 0, 0, 0.15)"
+	if !isLight {
+		hoverShadow = "rgba(255, 255, 255, 0.1)"
+	}
+	subtleBorder := adjustHexBrightness(bgColor, ifThen(isLight, -8, 12))
+
+	return fmt.Sprintf(`
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif;
+            background-color: %[1]s;
+            color: %[2]s;
+            line-height: 1.6;
+        }
+
+        .container {
+            max-width: 614px;
+            margin: 0 auto;
+            background-color: %[1]s;
+            min-height: 100vh;
+        }
+
+        .header {
+            background: %[3]s;
+            padding: 20px 32px;
+            color: %[2]s;
+            border-bottom: 1px solid %[4]s;
+            position: sticky;
+            top: 0;
+            z-index: 100;
+        }
+
+        .header-content {
+            display: flex;
+            align-items: center;
+            justify-content: space-between;
+        }
+
+        .header h1 {
+            font-size: 28px;
+            font-weight: 600;
+            display: flex;
+            align-items: center;
+            gap: 12px;
+            font-family: 'Segoe UI', Roboto, sans-serif;
+        }
+
+        .subtitle {
+            font-size: 14px;
+            color: %[5]s;
+            margin-top: 4px;
+            font-weight: 400;
+        }
+
+        .stats {
+            padding: 16px 0;
+            background-color: %[1]s;
+            display: none;
+        }
+
+        .stat {
+            text-align: center;
+            padding: 16px;
+            background-color: %[6]s;
+            border: 1px solid %[4]s;
+            border-radius: 8px;
+            transition: transform 0.2s, box-shadow 0.2s;
+        }
+
+        .stat:hover {
+            transform: translateY(-2px);
+            box-shadow: 0 2px 8px %[7]s;
+        }
+
+        .stat-value {
+            font-size: 32px;
+            font-weight: 700;
+            color: %[2]s;
+            margin-bottom: 4px;
+        }
+
+        .stat-label {
+            font-size: 13px;
+            color: %[5]s;
+            text-transform: uppercase;
+            font-weight: 500;
+            letter-spacing: 0.5px;
+        }
+
+        .feed {
+            padding: 24px 0 80px;
+        }
+
+        .entry {
+            padding: 0;
+            margin-bottom: 24px;
+            background-color: %[6]s;
             border: 1px solid %[4]s;
             border-radius: 8px;
+            cursor: pointer;
             transition: transform 0.2s, box-shadow 0.2s;
+            display: flex;
+            flex-direction: column;
+        }
+
+        .entry:hover {
+            transform: translateY(-2px);
+            box-shadow: 0 4px 12px %[7]s;
+        }
+
+        .entry-header {
+            display: flex;
+            align-items: center;
+            padding: 14px 16px;
+            border-bottom: 1px solid %[8]s;
+        }
+
+        .avatar {
+            width: 56px;
+            height: 56px;
+            border-radius: 50%%;
+            background: %[9]s;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            font-weight: 600;
+            font-size: 20px;
+            color: #ffffff;
+            margin-right: 16px;
+            flex-shrink: 0;
+            padding: 3px;
+        }
+
+        .avatar-inner {
+            width: 100%%;
+            height: 100%%;
+            background: %[6]s;
+            border-radius: 50%%;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            color: %[2]s;
+        }
+
+        .entry-info {
+            flex: 1;
+        }
+
+        .username {
+            display: none;
+        }
+
+        .timestamp {
+            color: %[5]s;
+            font-size: 12px;
+            font-weight: 400;
+            margin-top: 4px;
+            display: block;
+        }
+
+        .entry-photo-container {
+            width: 100%%;
+            max-width: 1024px;
+            height: 0;
+            padding-bottom: 100%%;
+            position: relative;
+            overflow: hidden;
+            background-color: #000000;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+        }
+
+        .entry-photo {
+            position: absolute;
+            top: 50%%;
+            left: 50%%;
+            transform: translate(-50%%, -50%%);
+            max-width: 100%%;
+            max-height: 100%%;
+            width: auto;
+            height: auto;
+            object-fit: contain;
+            display: block;
+        }
+
+        .entry-actions {
+            display: none;
+        }
+
+        .action-btn {
+            background: none;
+            border: none;
+            cursor: pointer;
+            font-size: 24px;
+            padding: 8px;
+            line-height: 1;
+            color: %[2]s;
+            transition: opacity 0.2s;
+        }
+
+        .action-btn:hover {
+            opacity: 0.6;
+        }
+
+        .entry-content {
+            font-size: 15px;
+            color: %[2]s;
+            line-height: 20px;
+            padding: 16px 16px 4px;
+            word-wrap: break-word;
+            overflow-wrap: break-word;
+        }
+
+        .entry-content h2 {
+            font-size: 18px;
+            font-weight: 600;
+            margin-bottom: 8px;
+            color: %[2]s;
+        }
+
+        .entry-content p {
+            font-size: 15px;
+            line-height: 1.6;
+            color: %[2]s;
+        }
+
+        .entry-content a {
+            color: %[9]s;
+            text-decoration: none;
+            font-weight: 500;
+        }
+
+        .entry-content a:hover {
+            text-decoration: underline;
+        }
+
+        .entry-content-username {
+            display: none;
+        }
+
+        .entry-timestamp {
+            padding: 4px 16px 16px;
+            color: %[5]s;
+            font-size: 10px;
+            text-transform: uppercase;
+            letter-spacing: 0.2px;
+        }
+
+        .entry-footer {
+            display: none;
+        }
+
+        .entry-id {
+            color: %[5]s;
+            font-size: 12px;
+            font-weight: 500;
+        }
+
+        .entry-badge {
+            background-color: %[9]s;
+            color: #ffffff;
+            padding: 4px 12px;
+            border-radius: 4px;
+            font-size: 11px;
+            font-weight: 600;
+            text-transform: uppercase;
+            letter-spacing: 0.5px;
+        }
+
+        .empty-state {
+            padding: 80px 32px;
+            text-align: center;
+        }
+
+        .empty-state-icon {
+            width: 120px;
+            height: 120px;
+            margin: 0 auto 24px;
+            background: %[6]s;
+            border-radius: 50%%;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            font-size: 64px;
+            border: 2px solid %[4]s;
+        }
+
+        .empty-state-title {
+            font-size: 28px;
+            font-weight: 600;
+            margin-bottom: 12px;
+            color: %[2]s;
+        }
+
+        .empty-state-text {
+            font-size: 16px;
+            color: %[5]s;
+            max-width: 400px;
+            margin: 0 auto;
+        }
+
+        .loading-indicator {
+            text-align: center;
+            padding: 24px;
+            color: %[5]s;
+            font-size: 14px;
+            display: none;
+        }
+
+        .loading-indicator.show {
+            display: block;
+        }
+
+        .loading-spinner {
+            display: inline-block;
+            width: 20px;
+            height: 20px;
+            border: 2px solid %[4]s;
+            border-top-color: %[2]s;
+            border-radius: 50%%;
+            animation: spin 0.8s linear infinite;
+            margin-right: 8px;
+            vertical-align: middle;
+        }
+
+        @keyframes spin {
+            to { transform: rotate(360deg); }
+        }
+
+        .end-message {
+            text-align: center;
+            padding: 24px;
+            color: %[5]s;
+            font-size: 14px;
+            display: none;
+        }
+
+        .end-message.show {
+            display: block;
+        }
+
+        .loading {
+            text-align: center;
+            padding: 40px;
+            color: %[5]s;
+        }
+
+        .rss-link {
+            text-align: center;
+            padding: 20px;
+        }
+
+        .rss-link a {
+            color: %[9]s;
+            text-decoration: none;
+            font-size: 14px;
+        }
+
+        @media (max-width: 768px) {
+            .container {
+                background-color: %[6]s;
+            }
+
+            .header {
+                padding: 16px 20px;
+            }
+
+            .header h1 {
+                font-size: 24px;
+            }
+
+            .stats {
+                padding: 12px 16px;
+                gap: 12px;
+            }
+
+            .stat {
+                padding: 12px;
+            }
+
+            .stat-value {
+                font-size: 24px;
+            }
+
+            .feed {
+                padding: 0 0 80px;
+            }
+
+            .entry {
+                margin-bottom: 12px;
+                border-radius: 0;
+                border-left: none;
+                border-right: none;
+            }
         }
+`, bgColor, textColor, headerBg, borderColor, secondaryText, cardBg, hoverShadow, subtleBorder, accentColor)
+}
+
+// Helper functions for color manipulation
+func isLightHexColor(hex string) bool {
+	if len(hex) != 7 || hex[0] != '#' {
+		return true // default to light
+	}
+	r, _ := strconv.ParseInt(hex[1:3], 16, 64)
+	g, _ := strconv.ParseInt(hex[3:5], 16, 64)
+	b, _ := strconv.ParseInt(hex[5:7], 16, 64)
+	luminance := (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 255
+	return luminance > 0.5
+}
+
+func adjustHexBrightness(hex string, percent int) string {
+	if len(hex) != 7 || hex[0] != '#' {
+		return hex
+	}
+	r, _ := strconv.ParseInt(hex[1:3], 16, 64)
+	g, _ := strconv.ParseInt(hex[3:5], 16, 64)
+	b, _ := strconv.ParseInt(hex[5:7], 16, 64)
+
+	r = clamp(r + int64(float64(r)*float64(percent)/100), 0, 255)
+	g = clamp(g + int64(float64(g)*float64(percent)/100), 0, 255)
+	b = clamp(b + int64(float64(b)*float64(percent)/100), 0, 255)
+
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+func blendColors(color1, color2 string, ratio float64) string {
+	if len(color1) != 7 || color1[0] != '#' || len(color2) != 7 || color2[0] != '#' {
+		return color1
+	}
+	r1, _ := strconv.ParseInt(color1[1:3], 16, 64)
+	g1, _ := strconv.ParseInt(color1[3:5], 16, 64)
+	b1, _ := strconv.ParseInt(color1[5:7], 16, 64)
+	r2, _ := strconv.ParseInt(color2[1:3], 16, 64)
+	g2, _ := strconv.ParseInt(color2[3:5], 16, 64)
+	b2, _ := strconv.ParseInt(color2[5:7], 16, 64)
+
+	r := int64(float64(r1)*(1-ratio) + float64(r2)*ratio)
+	g := int64(float64(g1)*(1-ratio) + float64(g2)*ratio)
+	b := int64(float64(b1)*(1-ratio) + float64(b2)*ratio)
+
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+func clamp(value, min, max int64) int64 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+func ifThen(condition bool, trueVal, falseVal int) int {
+	if condition {
+		return trueVal
+	}
+	return falseVal
+}
+
+// Authentication handlers
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	type LoginData struct {
+		Error      string
+		Redirect   string
+		Username   string
+		LoginToken string // set while asking for the two-factor code
+	}
+
+	redirect := r.URL.Query().Get("redirect")
+	if redirect == "" {
+		redirect = "/admin"
+	}
+
+	if r.Method == http.MethodGet {
+		tmpl := template.Must(template.New("login").Parse(loginTemplate))
+		tmpl.Execute(w, LoginData{Redirect: redirect})
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		tmpl := template.Must(template.New("login").Parse(loginTemplate))
+		loginToken := r.FormValue("login_token")
+
+		if wait := loginWait(r, loginFormAdmin); wait > 0 {
+			message := rejectLoginAttempt(w, wait)
+			w.WriteHeader(http.StatusTooManyRequests)
+			tmpl.Execute(w, LoginData{
+				Error:      message,
+				Redirect:   r.FormValue("redirect"),
+				Username:   r.FormValue("username"),
+				LoginToken: loginToken,
+			})
+			return
+		}
+		defer releaseLoginAttempt(r, loginFormAdmin)
+
+		// Second step: the two-factor code of a sign-in whose password matched
+		if loginToken != "" {
+			login, expired := finishPendingLogin(loginToken, r.FormValue("code"))
+			if expired {
+				tmpl.Execute(w, LoginData{Error: "Your sign-in expired. Please sign in again.", Redirect: r.FormValue("redirect")})
+				return
+			}
+			if login == nil {
+				recordLoginFailure(r, loginFormAdmin, "")
+				tmpl.Execute(w, LoginData{Error: "Invalid authentication code", Redirect: r.FormValue("redirect"), LoginToken: loginToken})
+				return
+			}
+			token, err := createSession(r, login.UserID, login.Remember)
+			if err != nil {
+				log.Printf("Error creating session: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			recordLoginSuccess(r, loginFormAdmin)
+			setSessionCookie(w, token, login.Remember)
+			http.Redirect(w, r, login.Redirect, http.StatusSeeOther)
+			return
+		}
+
+		password := r.FormValue("password")
+		redirectPath := r.FormValue("redirect")
+		if redirectPath == "" {
+			redirectPath = "/admin"
+		}
+
+		user := authenticateUser(r.FormValue("username"), password)
+		if user == nil {
+			recordLoginFailure(r, loginFormAdmin, r.FormValue("username"))
+			tmpl.Execute(w, LoginData{
+				Error:    "Invalid username or password",
+				Redirect: redirectPath,
+				Username: r.FormValue("username"),
+			})
+			return
+		}
+
+		remember := r.FormValue("remember") == "true"
+		if user.TwoFactorEnabled {
+			loginToken, err := startPendingLogin(user.ID, remember, redirectPath)
+			if err != nil {
+				log.Printf("Error starting sign-in: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			tmpl.Execute(w, LoginData{Redirect: redirectPath, LoginToken: loginToken})
+			return
+		}
+
+		// Create session
+		token, err := createSession(r, user.ID, remember)
+		if err != nil {
+			log.Printf("Error creating session: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		recordLoginSuccess(r, loginFormAdmin)
+		setSessionCookie(w, token, remember)
+
+		http.Redirect(w, r, redirectPath, http.StatusSeeOther)
+		return
+	}
+
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+func handleSetPrivacyPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	password := r.FormValue("password")
+	if password == "" {
+		showMessage(w, r, "Password cannot be empty", "error")
+		return
+	}
+
+	// Hash the password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		showMessage(w, r, "Failed to set password", "error")
+		return
+	}
+
+	// Update viewer password in site_settings
+	_, err = db.Exec(`UPDATE site_settings SET viewer_password_hash = ? WHERE id = 1`, string(hashedPassword))
+
+	if err != nil {
+		log.Printf("Error saving viewer password: %v", err)
+		showMessage(w, r, "Failed to save viewer password", "error")
+		return
+	}
+
+	showMessage(w, r, "Viewer password set successfully!", "success")
+}
+
+func handleRemovePrivacyPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Remove the viewer password from site_settings
+	_, err := db.Exec("UPDATE site_settings SET viewer_password_hash = NULL WHERE id = 1")
+	if err != nil {
+		log.Printf("Error removing viewer password: %v", err)
+		showMessage(w, r, "Failed to remove viewer password", "error")
+		return
+	}
+
+	showMessage(w, r, "Your blog is now public! Password protection removed successfully.", "success")
+}
+
+func getSiteSettings() (SiteSettings, error) {
+	var settings SiteSettings
+	var viewerPasswordHash, adminPasswordHash, avatarPath, avatarPreference sql.NullString
+	var customBgColor, customTextColor, customAccentColor sql.NullString
+	var photoMetadataKeep sql.NullString
+
+	err := db.QueryRow(`
+		SELECT site_title, site_subtitle, user_initial, avatar_path, avatar_preference, site_theme,
+		       viewer_password_hash, admin_password_hash, custom_bg_color, custom_text_color, custom_accent_color,
+		       photo_metadata_keep
+		FROM site_settings WHERE id = 1
+	`).Scan(&settings.SiteTitle, &settings.SiteSubtitle, &settings.UserInitial, &avatarPath, &avatarPreference,
+		&settings.SiteTheme, &viewerPasswordHash, &adminPasswordHash, &customBgColor, &customTextColor, &customAccentColor,
+		&photoMetadataKeep)
+
+	if err != nil {
+		// If query fails, it might be due to missing columns - try running migrations
+		if migErr := runDatabaseMigrations(); migErr == nil {
+			// Retry the query after migrations
+			err = db.QueryRow(`
+				SELECT site_title, site_subtitle, user_initial, avatar_path, avatar_preference, site_theme,
+				       viewer_password_hash, admin_password_hash, custom_bg_color, custom_text_color, custom_accent_color,
+				       photo_metadata_keep
+				FROM site_settings WHERE id = 1
+			`).Scan(&settings.SiteTitle, &settings.SiteSubtitle, &settings.UserInitial, &avatarPath, &avatarPreference,
+				&settings.SiteTheme, &viewerPasswordHash, &adminPasswordHash, &customBgColor, &customTextColor, &customAccentColor,
+				&photoMetadataKeep)
+		}
+		if err != nil {
+			return settings, err
+		}
+	}
+
+	if avatarPath.Valid {
+		settings.AvatarPath = avatarPath.String
+	}
+
+	if avatarPreference.Valid {
+		settings.AvatarPreference = avatarPreference.String
+	} else {
+		settings.AvatarPreference = "initials" // default
+	}
+
+	// Set custom colors with defaults
+	if customBgColor.Valid && customBgColor.String != "" {
+		settings.CustomBgColor = customBgColor.String
+	} else {
+		settings.CustomBgColor = "#fafafa"
+	}
+	if customTextColor.Valid && customTextColor.String != "" {
+		settings.CustomTextColor = customTextColor.String
+	} else {
+		settings.CustomTextColor = "#262626"
+	}
+	if customAccentColor.Valid && customAccentColor.String != "" {
+		settings.CustomAccentColor = customAccentColor.String
+	} else {
+		settings.CustomAccentColor = "#0095f6"
+	}
+
+	settings.HasViewerPassword = viewerPasswordHash.Valid && viewerPasswordHash.String != ""
+	settings.HasAdminPassword = adminPasswordHash.Valid && adminPasswordHash.String != ""
+
+	photoMetadata := parsePhotoMetadataOptions(photoMetadataKeep.String)
+	settings.KeepPhotoDate = photoMetadata.KeepDate
+	settings.KeepPhotoCamera = photoMetadata.KeepCamera
+	settings.KeepPhotoColorProfile = photoMetadata.KeepColorProfile
+
+	return settings, nil
+}
+
+func handleSettings(w http.ResponseWriter, r *http.Request) {
+	handleSettingsWithView(w, r, "site-info")
+}
+
+func handleSettingsAppearance(w http.ResponseWriter, r *http.Request) {
+	handleSettingsWithView(w, r, "appearance")
+}
+
+func handleSettingsSecurity(w http.ResponseWriter, r *http.Request) {
+	handleSettingsWithView(w, r, "security")
+}
+
+func handleSettingsPodcast(w http.ResponseWriter, r *http.Request) {
+	handleSettingsWithView(w, r, "podcast")
+}
+
+func handleSettingsTokens(w http.ResponseWriter, r *http.Request) {
+	handleSettingsWithView(w, r, "tokens")
+}
+
+func handleSettingsWebhooks(w http.ResponseWriter, r *http.Request) {
+	handleSettingsWithView(w, r, "webhooks")
+}
+
+func handleSettingsUsers(w http.ResponseWriter, r *http.Request) {
+	handleSettingsWithView(w, r, "users")
+}
+
+func handleSettingsBackup(w http.ResponseWriter, r *http.Request) {
+	handleSettingsWithView(w, r, "backup")
+}
+
+func handleSettingsWithView(w http.ResponseWriter, r *http.Request, view string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error fetching settings: %v", err)
+		http.Error(w, "Error fetching settings", http.StatusInternalServerError)
+		return
+	}
+
+	// Read flash messages from cookies
+	var message, messageType string
+	if cookie, err := r.Cookie("flash_message"); err == nil {
+		message = cookie.Value
+		http.SetCookie(w, &http.Cookie{
+			Name:   "flash_message",
+			Value:  "",
+			Path:   "/",
+			MaxAge: -1,
+		})
+	}
+	if cookie, err := r.Cookie("flash_type"); err == nil {
+		messageType = cookie.Value
+		http.SetCookie(w, &http.Cookie{
+			Name:   "flash_type",
+			Value:  "",
+			Path:   "/",
+			MaxAge: -1,
+		})
+	}
+
+	// Also check URL query params for messages (used by domain handlers)
+	if msg := r.URL.Query().Get("success"); msg != "" {
+		message = msg
+		messageType = "success"
+	} else if msg := r.URL.Query().Get("error"); msg != "" {
+		message = msg
+		messageType = "error"
+	}
+
+	// Get custom domain info
+	customDomain, _ := getCustomDomain()
+	instanceHostname := getInstanceHostname()
+
+	// Custom domains only available for *.postastiq.com subdomains
+	customDomainEnabled := isPostastiqSubdomain(instanceHostname)
+
+	// Check if current request is from a postastiq.com subdomain (for showing enable button)
+	canEnableCustomDomain := false
+	if !customDomainEnabled {
+		currentHost := getHostFromRequest(r)
+		canEnableCustomDomain = isPostastiqSubdomain(currentHost)
+	}
+
+	// Set page title based on view
+	pageTitles := map[string]string{
+		"site-info":  "Site Info",
+		"appearance": "Appearance",
+		"security":   "Security",
+		"podcast":    "Podcast",
+		"tokens":     "Access Tokens",
+		"webhooks":   "Webhooks",
+		"users":      "Users",
+		"backup":     "Backup",
+	}
+	pageTitle := pageTitles[view]
+	if pageTitle == "" {
+		pageTitle = "Settings"
+	}
+
+	podcast, err := getPodcastSettings()
+	if err != nil {
+		log.Printf("Error fetching podcast settings: %v", err)
+	}
+
+	var accessTokens []AccessToken
+	var newToken string
+	if view == "tokens" {
+		accessTokens, err = getAccessTokens(currentUser(r))
+		if err != nil {
+			log.Printf("Error fetching access tokens: %v", err)
+		}
+		if cookie, err := r.Cookie("flash_token"); err == nil {
+			newToken = cookie.Value
+			http.SetCookie(w, &http.Cookie{
+				Name:   "flash_token",
+				Value:  "",
+				Path:   "/admin/settings",
+				MaxAge: -1,
+			})
+		}
+	}
+
+	var sessions []Session
+	var twoFactor TwoFactorSettings
+	var passkeys []Passkey
+	var failedLogins []FailedLogin
+	var loginLockouts []LoginLockout
+	if view == "security" {
+		sessions, err = getSessions(r, user.ID)
+		if err != nil {
+			log.Printf("Error fetching sessions: %v", err)
+		}
+		passkeys, err = getPasskeys(user.ID)
+		if err != nil {
+			log.Printf("Error fetching passkeys: %v", err)
+		}
+		if user.IsOwner() {
+			failedLogins, err = getFailedLogins(50)
+			if err != nil {
+				log.Printf("Error fetching failed sign-ins: %v", err)
+			}
+			loginLockouts = getLoginLockouts()
+		}
+
+		if user.TwoFactorEnabled {
+			twoFactor.RecoveryCodesLeft = countRecoveryCodes(user.ID)
+		} else {
+			// A secret without two-factor on is a setup waiting for its first code
+			db.QueryRow("SELECT totp_secret FROM users WHERE id = ?", user.ID).Scan(&twoFactor.SetupSecret)
+			if twoFactor.SetupSecret != "" {
+				twoFactor.SetupQRCode, err = totpQRCode(totpURI(settings.SiteTitle, user.Username, twoFactor.SetupSecret))
+				if err != nil {
+					log.Printf("Error rendering QR code: %v", err)
+				}
+			}
+		}
+		if cookie, err := r.Cookie("flash_recovery_codes"); err == nil {
+			twoFactor.NewRecoveryCodes = strings.Split(cookie.Value, ".")
+			http.SetCookie(w, &http.Cookie{
+				Name:   "flash_recovery_codes",
+				Value:  "",
+				Path:   "/admin/settings",
+				MaxAge: -1,
+			})
+		}
+	}
+
+	var users []User
+	var invites []UserInvite
+	var newInvite string
+	if view == "users" {
+		users, err = getUsers()
+		if err != nil {
+			log.Printf("Error fetching users: %v", err)
+		}
+		invites, err = getUserInvites()
+		if err != nil {
+			log.Printf("Error fetching invites: %v", err)
+		}
+		if cookie, err := r.Cookie("flash_invite"); err == nil {
+			newInvite = cookie.Value
+			http.SetCookie(w, &http.Cookie{
+				Name:   "flash_invite",
+				Value:  "",
+				Path:   "/admin/settings",
+				MaxAge: -1,
+			})
+		}
+	}
+
+	var webhooks []Webhook
+	var webhookDeliveries []WebhookDelivery
+	if view == "webhooks" {
+		webhooks, err = getWebhooks()
+		if err != nil {
+			log.Printf("Error fetching webhooks: %v", err)
+		}
+		webhookDeliveries, err = getWebhookDeliveries(50)
+		if err != nil {
+			log.Printf("Error fetching webhook deliveries: %v", err)
+		}
+	}
+
+	data := SettingsPageData{
+		Settings:              settings,
+		EnableSubtitle:        enableSubtitle,
+		Message:               message,
+		MessageType:           messageType,
+		CustomDomain:          customDomain,
+		InstanceHostname:      instanceHostname,
+		CustomDomainEnabled:   customDomainEnabled,
+		CanEnableCustomDomain: canEnableCustomDomain,
+		View:                  view,
+		PageTitle:             pageTitle,
+		Podcast:               podcast,
+		EnableAudioUploads:    enableAudioUploads,
+		AccessTokens:          accessTokens,
+		TokenScopes:           accessTokenScopes,
+		MicropubEndpoint:      getBaseURL(r) + "/micropub",
+		APIEndpoint:           getBaseURL(r) + "/api/v1",
+		NewToken:              newToken,
+		Webhooks:              webhooks,
+		WebhookDeliveries:     webhookDeliveries,
+		WebhookEvents:         webhookEvents,
+		Sessions:              sessions,
+		User:                  user,
+		Users:                 users,
+		UserInvites:           invites,
+		UserRoles:             userRoles,
+		NewInvite:             newInvite,
+		TwoFactor:             twoFactor,
+		Passkeys:              passkeys,
+		FailedLogins:          failedLogins,
+		LoginLockouts:         loginLockouts,
+		CSRFToken:             csrfToken(r),
+	}
+
+	tmpl, err := template.New("settings").Parse(settingsTemplate)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	tmpl.Execute(w, data)
+}
+
+func handleSettingsUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get the section being updated
+	section := r.FormValue("section")
+	if section == "" {
+		section = "site-info"
+	}
+
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	switch section {
+	case "site-info":
+		handleSiteInfoUpdate(w, r)
+	case "appearance":
+		handleAppearanceUpdate(w, r)
+	case "security":
+		handleSecurityUpdate(w, r, user)
+	case "podcast":
+		handlePodcastUpdate(w, r)
+	case "photo-metadata":
+		handlePhotoMetadataUpdate(w, r)
+	case "tokens":
+		handleAccessTokenUpdate(w, r)
+	case "webhooks":
+		handleWebhookUpdate(w, r)
+	case "sessions":
+		handleSessionUpdate(w, r, user)
+	case "users":
+		handleUserUpdate(w, r, user)
+	case "two-factor":
+		handleTwoFactorUpdate(w, r, user)
+	case "passkeys":
+		handlePasskeyUpdate(w, r, user)
+	case "login-attempts":
+		handleLoginAttemptsUpdate(w, r, user)
+	default:
+		showSettingsMessage(w, r, "Invalid section", "error", section)
+	}
+}
+
+func handleSiteInfoUpdate(w http.ResponseWriter, r *http.Request) {
+	siteTitle := r.FormValue("site_title")
+	siteSubtitle := r.FormValue("site_subtitle")
+	userInitial := r.FormValue("user_initial")
+
+	// Validate inputs
+	if siteTitle == "" || userInitial == "" {
+		showSettingsMessage(w, r, "Site title and user initial are required", "error", "site-info")
+		return
+	}
+	if enableSubtitle && siteSubtitle == "" {
+		showSettingsMessage(w, r, "Site subtitle is required", "error", "site-info")
+		return
+	}
+
+	if len(userInitial) > 3 {
+		showSettingsMessage(w, r, "User initial must be 1-3 characters", "error", "site-info")
+		return
+	}
+
+	// Handle avatar upload
+	file, header, err := r.FormFile("avatar")
+	if err == nil {
+		defer file.Close()
+		avatarPath, err := validateAndResizeAvatar(file, header.Filename)
+		if err != nil {
+			log.Printf("Error saving avatar: %v", err)
+			showSettingsMessage(w, r, "Failed to save avatar: "+err.Error(), "error", "site-info")
+			return
+		}
+
+		// Update avatar path in database
+		_, err = db.Exec("UPDATE site_settings SET avatar_path = ? WHERE id = 1", avatarPath)
+		if err != nil {
+			log.Printf("Error updating avatar path: %v", err)
+			showSettingsMessage(w, r, "Failed to update avatar", "error", "site-info")
+			return
+		}
+	}
+
+	// Update site info settings
+	_, err = db.Exec(`
+		UPDATE site_settings
+		SET site_title = ?, site_subtitle = ?, user_initial = ?
+		WHERE id = 1
+	`, siteTitle, siteSubtitle, userInitial)
+
+	if err != nil {
+		log.Printf("Error updating settings: %v", err)
+		showSettingsMessage(w, r, "Failed to update settings", "error", "site-info")
+		return
+	}
+
+	showSettingsMessage(w, r, "Site info updated successfully!", "success", "site-info")
+}
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+func handleAppearanceUpdate(w http.ResponseWriter, r *http.Request) {
+	siteTheme := r.FormValue("site_theme")
+	avatarPreference := r.FormValue("avatar_preference")
+	customBgColor := r.FormValue("custom_bg_color")
+	customTextColor := r.FormValue("custom_text_color")
+	customAccentColor := r.FormValue("custom_accent_color")
+
+	// Set default avatar preference if not provided
+	if avatarPreference == "" {
+		avatarPreference = "initials"
+	}
+
+	// Set default custom colors if not provided
+	if customBgColor == "" {
+		customBgColor = "#fafafa"
+	}
+	if customTextColor == "" {
+		customTextColor = "#262626"
+	}
+	if customAccentColor == "" {
+		customAccentColor = "#0095f6"
+	}
+
+	// Validate color format (basic hex color validation)
+	if !hexColorPattern.MatchString(customBgColor) || !hexColorPattern.MatchString(customTextColor) || !hexColorPattern.MatchString(customAccentColor) {
+		showSettingsMessage(w, r, "Invalid color format. Please use hex colors (e.g., #ffffff)", "error", "appearance")
+		return
+	}
+
+	// Update appearance settings including custom colors
+	_, err := db.Exec(`
+		UPDATE site_settings
+		SET site_theme = ?, avatar_preference = ?, custom_bg_color = ?, custom_text_color = ?, custom_accent_color = ?
+		WHERE id = 1
+	`, siteTheme, avatarPreference, customBgColor, customTextColor, customAccentColor)
+
+	if err != nil {
+		log.Printf("Error updating appearance settings: %v", err)
+		showSettingsMessage(w, r, "Failed to update appearance settings", "error", "appearance")
+		return
+	}
+
+	showSettingsMessage(w, r, "Appearance updated successfully!", "success", "appearance")
+}
+
+func handleSecurityUpdate(w http.ResponseWriter, r *http.Request, user *User) {
+	newPassword := r.FormValue("new_password")
+	newPasswordConfirm := r.FormValue("new_password_confirm")
+	viewerPassword := r.FormValue("viewer_password")
+	removeViewerPassword := r.FormValue("remove_viewer_password")
+
+	// Handle the user's own account
+	displayName := strings.TrimSpace(r.FormValue("display_name"))
+	if len(displayName) > 100 {
+		displayName = displayName[:100]
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	if len(email) > 254 {
+		email = email[:254]
+	}
+	if _, err := db.Exec("UPDATE users SET display_name = ?, email = ? WHERE id = ?", displayName, email, user.ID); err != nil {
+		log.Printf("Error updating account: %v", err)
+		showSettingsMessage(w, r, "Failed to update your account", "error", "security")
+		return
+	}
+
+	// Handle password update
+	if newPassword != "" {
+		if newPassword != newPasswordConfirm {
+			showSettingsMessage(w, r, "Passwords do not match", "error", "security")
+			return
+		}
+		if len(newPassword) < 8 {
+			showSettingsMessage(w, r, "Password must be at least 8 characters long", "error", "security")
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			showSettingsMessage(w, r, "Failed to update password", "error", "security")
+			return
+		}
+
+		// Update password and clear password_change_required flag
+		_, err = db.Exec("UPDATE users SET password_hash = ?, password_change_required = 0 WHERE id = ?", string(hashedPassword), user.ID)
+		if err != nil {
+			log.Printf("Error updating password: %v", err)
+			showSettingsMessage(w, r, "Failed to update password", "error", "security")
+			return
+		}
+	}
+
+	// The rest of the page is site settings
+	if !user.CanChangeSettings() {
+		showSettingsMessage(w, r, "Account updated successfully!", "success", "security")
+		return
+	}
+
+	// Handle viewer password. Viewer cookies are bound to the password hash,
+	// so changing it signs out every visitor.
+	if removeViewerPassword == "true" {
+		_, err := db.Exec("UPDATE site_settings SET viewer_password_hash = NULL WHERE id = 1")
+		if err != nil {
+			log.Printf("Error removing viewer password: %v", err)
+			showSettingsMessage(w, r, "Failed to remove viewer password", "error", "security")
+			return
+		}
+	} else if viewerPassword != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(viewerPassword), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Error hashing viewer password: %v", err)
+			showSettingsMessage(w, r, "Failed to update viewer password", "error", "security")
+			return
+		}
+
+		_, err = db.Exec("UPDATE site_settings SET viewer_password_hash = ? WHERE id = 1", string(hashedPassword))
+		if err != nil {
+			log.Printf("Error updating viewer password: %v", err)
+			showSettingsMessage(w, r, "Failed to update viewer password", "error", "security")
+			return
+		}
+	}
+
+	showSettingsMessage(w, r, "Security settings updated successfully!", "success", "security")
+}
+
+// handlePhotoMetadataUpdate saves which metadata is kept on uploaded photos
+func handlePhotoMetadataUpdate(w http.ResponseWriter, r *http.Request) {
+	var keepFields []string
+	if r.FormValue("keep_photo_date") == "true" {
+		keepFields = append(keepFields, "date")
+	}
+	if r.FormValue("keep_photo_camera") == "true" {
+		keepFields = append(keepFields, "camera")
+	}
+	if r.FormValue("keep_photo_color_profile") == "true" {
+		keepFields = append(keepFields, "color")
+	}
+	_, err := db.Exec("UPDATE site_settings SET photo_metadata_keep = ? WHERE id = 1", strings.Join(keepFields, ","))
+	if err != nil {
+		log.Printf("Error updating photo metadata settings: %v", err)
+		showSettingsMessage(w, r, "Failed to update photo metadata settings", "error", "security")
+		return
+	}
+
+	showSettingsMessage(w, r, "Photo metadata settings updated successfully!", "success", "security")
+}
+
+// handleSessionUpdate signs out one of the user's sessions, or all of them
+// with "sign out everywhere". Signing out the current session goes back to
+// the login page.
+func handleSessionUpdate(w http.ResponseWriter, r *http.Request, user *User) {
+	var current *Session
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		current, _ = getSession(cookie.Value)
+	}
+
+	switch r.FormValue("action") {
+	case "revoke":
+		id, _ := strconv.Atoi(r.FormValue("session_id"))
+		if _, err := db.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", id, user.ID); err != nil {
+			log.Printf("Error revoking session: %v", err)
+			showSettingsMessage(w, r, "Failed to sign out session", "error", "security")
+			return
+		}
+		if current == nil || current.ID == id {
+			break
+		}
+		showSettingsMessage(w, r, "Session signed out", "success", "security")
+		return
+	case "revoke_all":
+		if _, err := db.Exec("DELETE FROM sessions WHERE user_id = ?", user.ID); err != nil {
+			log.Printf("Error revoking sessions: %v", err)
+			showSettingsMessage(w, r, "Failed to sign out sessions", "error", "security")
+			return
+		}
+		log.Printf("All sessions of %s signed out", user.Username)
+	default:
+		showSettingsMessage(w, r, "Invalid action", "error", "security")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func handlePodcastUpdate(w http.ResponseWriter, r *http.Request) {
+	author := strings.TrimSpace(r.FormValue("podcast_author"))
+	email := strings.TrimSpace(r.FormValue("podcast_email"))
+	category := strings.TrimSpace(r.FormValue("podcast_category"))
+	explicit := r.FormValue("podcast_explicit") == "true"
+
+	if email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			showSettingsMessage(w, r, "Invalid owner email address", "error", "podcast")
+			return
+		}
+	}
+
+	// Handle artwork upload
+	file, header, err := r.FormFile("podcast_image")
+	if err == nil {
+		defer file.Close()
+		imagePath, err := validateAndSaveThumbnail(file, header.Filename, "podcast artwork", time.Now())
+		if err != nil {
+			log.Printf("Error saving podcast artwork: %v", err)
+			showSettingsMessage(w, r, "Failed to save artwork: "+err.Error(), "error", "podcast")
+			return
+		}
+
+		_, err = db.Exec("UPDATE site_settings SET podcast_image_path = ? WHERE id = 1", imagePath)
+		if err != nil {
+			log.Printf("Error updating podcast artwork: %v", err)
+			showSettingsMessage(w, r, "Failed to update artwork", "error", "podcast")
+			return
+		}
+	}
+
+	_, err = db.Exec(`
+		UPDATE site_settings
+		SET podcast_author = ?, podcast_email = ?, podcast_category = ?, podcast_explicit = ?
+		WHERE id = 1
+	`, author, email, category, explicit)
+	if err != nil {
+		log.Printf("Error updating podcast settings: %v", err)
+		showSettingsMessage(w, r, "Failed to update podcast settings", "error", "podcast")
+		return
+	}
+
+	showSettingsMessage(w, r, "Podcast settings updated successfully!", "success", "podcast")
+}
+
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get database path
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "/app/data/blog.db"
+	}
+
+	// Set headers for ZIP download
+	timestamp := time.Now().Format("2006-01-02")
+	filename := fmt.Sprintf("postastiq-backup-%s.zip", timestamp)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+
+	// Create ZIP writer
+	zipWriter := zip.NewWriter(w)
+	defer zipWriter.Close()
+
+	// Add database file to ZIP
+	dbFile, err := os.Open(dbPath)
+	if err != nil {
+		log.Printf("Error opening database for backup: %v", err)
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+	defer dbFile.Close()
+
+	dbInfo, err := dbFile.Stat()
+	if err != nil {
+		log.Printf("Error getting database info: %v", err)
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+
+	dbHeader, err := zip.FileInfoHeader(dbInfo)
+	if err != nil {
+		log.Printf("Error creating ZIP header for database: %v", err)
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+	dbHeader.Name = "blog.db"
+	dbHeader.Method = zip.Deflate
+
+	dbWriter, err := zipWriter.CreateHeader(dbHeader)
+	if err != nil {
+		log.Printf("Error creating ZIP entry for database: %v", err)
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = io.Copy(dbWriter, dbFile)
+	if err != nil {
+		log.Printf("Error writing database to ZIP: %v", err)
+		return
+	}
+
+	// Add uploads directory to ZIP
+	err = filepath.Walk(uploadsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip the uploads directory itself
+		if path == uploadsDir {
+			return nil
+		}
+
+		// Get relative path
+		relPath, err := filepath.Rel(uploadsDir, path)
+		if err != nil {
+			return err
+		}
+
+		// Create ZIP header
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.Join("uploads", relPath)
+
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(writer, file)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Printf("Error adding uploads to ZIP: %v", err)
+	}
+
+	log.Printf("Backup created successfully: %s", filename)
+	triggerWebhooks("backup.created", map[string]string{"filename": filename})
+}
+
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Use small memory buffer (4MB) - excess streams to temp files automatically
+	// This keeps memory usage low for large backup files
+	err := r.ParseMultipartForm(4 << 20)
+	if err != nil {
+		log.Printf("Error parsing restore form: %v", err)
+		showSettingsMessage(w, r, "Failed to parse upload", "error", "backup")
+		return
+	}
+
+	// Get uploaded file
+	file, header, err := r.FormFile("backup_file")
+	if err != nil {
+		log.Printf("Error getting backup file: %v", err)
+		showSettingsMessage(w, r, "No backup file provided", "error", "backup")
+		return
+	}
+
+	// Validate file extension
+	if !strings.HasSuffix(strings.ToLower(header.Filename), ".zip") {
+		file.Close()
+		showSettingsMessage(w, r, "Invalid file type. Please upload a ZIP file.", "error", "backup")
+		return
+	}
+
+	// Stream upload to temp file using small buffer to minimize memory
+	tempFile, err := os.CreateTemp("", "postastiq-restore-*.zip")
+	if err != nil {
+		file.Close()
+		log.Printf("Error creating temp file: %v", err)
+		showSettingsMessage(w, r, "Failed to process upload", "error", "backup")
+		return
+	}
+	tempPath := tempFile.Name()
+	defer os.Remove(tempPath)
+
+	// Use small copy buffer (32KB) to minimize memory usage
+	copyBuf := make([]byte, 32*1024)
+	written, err := io.CopyBuffer(tempFile, file, copyBuf)
+	tempFile.Close()
+	file.Close()
+
+	// Release multipart form data to free memory
+	r.MultipartForm.RemoveAll()
+	runtime.GC()
+
+	if err != nil {
+		log.Printf("Error writing to temp file: %v", err)
+		showSettingsMessage(w, r, "Failed to save upload", "error", "backup")
+		return
+	}
+	log.Printf("Received backup file: %s (%d bytes)", header.Filename, written)
+
+	// Get paths
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "/app/data/blog.db"
+	}
+	tempDBPath := dbPath + ".restore-temp"
+
+	// Process ZIP file with minimal memory: extract database first
+	// We open/close the ZIP reader for each phase to allow GC between phases
+	err = extractDatabaseFromZip(tempPath, tempDBPath, copyBuf)
+	if err != nil {
+		if err.Error() == "missing blog.db" {
+			showSettingsMessage(w, r, "Invalid backup: missing blog.db", "error", "backup")
+		} else {
+			log.Printf("Error extracting database: %v", err)
+			showSettingsMessage(w, r, "Failed to extract database", "error", "backup")
+		}
+		return
+	}
+
+	// The restored database has its own users and sessions, so remember who
+	// is doing the restore and how they signed in
+	rememberSession := false
+	restoringUsername := ""
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		if session, ok := getSession(cookie.Value); ok {
+			rememberSession = session.Remember
+			if user, err := getUser(session.UserID); err == nil {
+				restoringUsername = user.Username
+			}
+		}
+	}
+
+	// Close current database connection before replacing file
+	if db != nil {
+		db.Close()
+		db = nil
+	}
+
+	// Move temp database to final location (atomic on same filesystem)
+	if err := os.Rename(tempDBPath, dbPath); err != nil {
+		log.Printf("Error replacing database file: %v", err)
+		os.Remove(tempDBPath)
+		reconnectDB(dbPath)
+		showSettingsMessage(w, r, "Failed to replace database", "error", "backup")
+		return
+	}
+
+	// Reconnect to the restored database immediately
+	if err := reconnectDB(dbPath); err != nil {
+		log.Printf("Error reconnecting to restored database: %v", err)
+		showSettingsMessage(w, r, "Database restored but reconnection failed. Please restart the server.", "error", "backup")
+		return
+	}
+
+	// Run database migrations to add any missing columns from newer versions
+	if err := runDatabaseMigrations(); err != nil {
+		log.Printf("Warning: some database migrations failed after restore: %v", err)
+		// Continue anyway - the core restore worked
+	}
+
+	// The restored database may come without (or with a stale) search index
+	rebuildSearchIndex()
+
+	// Sessions saved in the backup may have been revoked since, so sign
+	// everyone out and start a fresh session for this user if the backup
+	// has an account with their username
+	if _, err := db.Exec("DELETE FROM sessions"); err != nil {
+		log.Printf("Error clearing restored sessions: %v", err)
+	}
+	var restoredUserID int
+	if db.QueryRow("SELECT id FROM users WHERE username = ?", restoringUsername).Scan(&restoredUserID) == nil {
+		if token, err := createSession(r, restoredUserID, rememberSession); err == nil {
+			setSessionCookie(w, token, rememberSession)
+		} else {
+			log.Printf("Error creating session after restore: %v", err)
+		}
+	}
+
+	// Force GC before extracting uploads
+	runtime.GC()
+
+	// Extract upload files (database is already reconnected)
+	err = extractUploadsFromZip(tempPath, uploadsDir, copyBuf)
+	if err != nil {
+		log.Printf("Warning: some upload files may not have been restored: %v", err)
+	}
+	go createMissingImageVariants()
+	go fillMissingMediaDurations()
+
+	log.Printf("Backup restored successfully from: %s", header.Filename)
+	triggerWebhooks("backup.restored", map[string]string{"filename": header.Filename})
+	showSettingsMessage(w, r, "Backup restored successfully!", "success", "backup")
+}
+
+// extractDatabaseFromZip extracts only the blog.db file from the ZIP
+func extractDatabaseFromZip(zipPath, destPath string, buf []byte) error {
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("invalid ZIP file: %w", err)
+	}
+	defer zipReader.Close()
+
+	for _, f := range zipReader.File {
+		if f.Name == "blog.db" {
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("failed to open blog.db in ZIP: %w", err)
+			}
+
+			outFile, err := os.Create(destPath)
+			if err != nil {
+				rc.Close()
+				return fmt.Errorf("failed to create database file: %w", err)
+			}
+
+			_, err = io.CopyBuffer(outFile, rc, buf)
+			outFile.Close()
+			rc.Close()
+
+			if err != nil {
+				os.Remove(destPath)
+				return fmt.Errorf("failed to write database file: %w", err)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("missing blog.db")
+}
+
+// extractUploadsFromZip extracts upload files from the ZIP one at a time.
+// Files named after their content that are already there are left alone.
+func extractUploadsFromZip(zipPath, uploadsDir string, buf []byte) error {
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("failed to open ZIP: %w", err)
+	}
+	defer zipReader.Close()
+
+	var lastErr error
+	for _, f := range zipReader.File {
+		if !strings.HasPrefix(f.Name, "uploads/") {
+			continue
+		}
+
+		relPath := strings.TrimPrefix(f.Name, "uploads/")
+		if relPath == "" {
+			continue
+		}
+
+		targetPath := filepath.Join(uploadsDir, relPath)
+
+		if f.FileInfo().IsDir() {
+			os.MkdirAll(targetPath, 0755)
+			continue
+		}
+
+		if isHashedUpload(relPath) {
+			if _, err := os.Stat(targetPath); err == nil {
+				continue
+			}
+		}
+
+		// Ensure parent directory exists
+		os.MkdirAll(filepath.Dir(targetPath), 0755)
+
+		// Extract file with minimal memory
+		if err := extractSingleFile(f, targetPath, buf); err != nil {
+			log.Printf("Error extracting %s: %v", f.Name, err)
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+// extractSingleFile extracts a single file from ZIP using provided buffer
+func extractSingleFile(f *zip.File, destPath string, buf []byte) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	outFile, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	_, err = io.CopyBuffer(outFile, rc, buf)
+	return err
+}
+
+// reconnectDB closes the existing connection and opens a new one
+func reconnectDB(dbPath string) error {
+	// Close existing connection if any
+	if db != nil {
+		db.Close()
+		db = nil
+	}
+
+	var err error
+	db, err = sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return err
+	}
+
+	// Configure connection pool for better reliability
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+
+	return db.Ping()
+}
+
+// viewerAccessLifetime is how long visitors of a private blog stay signed in
+const viewerAccessLifetime = 30 * 24 * time.Hour
+
+// cookieKeyMutex keeps two requests from generating different keys
+var cookieKeyMutex sync.Mutex
+
+// getCookieSigningKey returns the key for signing cookies, generating it on
+// first use
+func getCookieSigningKey() ([]byte, error) {
+	cookieKeyMutex.Lock()
+	defer cookieKeyMutex.Unlock()
+
+	var keyHex sql.NullString
+	if err := db.QueryRow("SELECT cookie_signing_key FROM site_settings WHERE id = 1").Scan(&keyHex); err != nil {
+		return nil, err
+	}
+	if keyHex.String != "" {
+		return hex.DecodeString(keyHex.String)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("UPDATE site_settings SET cookie_signing_key = ? WHERE id = 1", hex.EncodeToString(key)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// viewerTokenSignature signs a viewer token's expiry together with the
+// viewer password hash, so changing the password invalidates every token
+func viewerTokenSignature(key []byte, expires, passwordHash string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("viewer|" + expires + "|" + passwordHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newViewerToken returns the cookie value that lets a visitor who entered
+// the viewer password in, in the form "<expiry unix time>.<signature>"
+func newViewerToken(passwordHash string) (string, error) {
+	key, err := getCookieSigningKey()
+	if err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(viewerAccessLifetime).Unix(), 10)
+	return expires + "." + viewerTokenSignature(key, expires, passwordHash), nil
+}
+
+// validViewerToken reports whether a viewer token is unexpired and was issued
+// for the current viewer password
+func validViewerToken(token, passwordHash string) bool {
+	expires, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() >= expiresUnix {
+		return false
+	}
+	key, err := getCookieSigningKey()
+	if err != nil {
+		log.Printf("Error loading cookie signing key: %v", err)
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(viewerTokenSignature(key, expires, passwordHash)))
+}
+
+func handleViewerAuth(w http.ResponseWriter, r *http.Request) {
+	// Get settings from database
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error getting site settings: %v", err)
+		settings = SiteSettings{
+			SiteTitle: "My Blog",
+		}
+	}
+
+	if r.Method == http.MethodGet {
+		data := struct {
+			SiteTitle string
+			Error     string
+			Redirect  string
+		}{
+			SiteTitle: settings.SiteTitle,
+			Redirect:  r.URL.Query().Get("redirect"),
+		}
+
+		tmpl, err := template.New("viewer-password").Parse(viewerPasswordTemplate)
+		if err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, data)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		password := r.FormValue("password")
+		redirect := r.FormValue("redirect")
+
+		// Get stored password hash from site_settings (not privacy_settings)
+		var passwordHash sql.NullString
+		err := db.QueryRow("SELECT viewer_password_hash FROM site_settings WHERE id = 1").Scan(&passwordHash)
+		if err != nil || !passwordHash.Valid {
+			// No password set, redirect to home or specified page
+			if redirect != "" {
+				http.Redirect(w, r, redirect, http.StatusSeeOther)
+			} else {
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+			}
+			return
+		}
+
+		data := struct {
+			SiteTitle string
+			Error     string
+			Redirect  string
+		}{
+			SiteTitle: settings.SiteTitle,
+			Redirect:  redirect,
+		}
+		tmpl, _ := template.New("viewer-password").Parse(viewerPasswordTemplate)
+
+		if wait := loginWait(r, loginFormViewer); wait > 0 {
+			data.Error = rejectLoginAttempt(w, wait)
+			w.WriteHeader(http.StatusTooManyRequests)
+			tmpl.Execute(w, data)
+			return
+		}
+		defer releaseLoginAttempt(r, loginFormViewer)
+
+		// Verify password
+		err = bcrypt.CompareHashAndPassword([]byte(passwordHash.String), []byte(password))
+		if err != nil {
+			recordLoginFailure(r, loginFormViewer, "")
+			data.Error = "Incorrect password"
+			tmpl.Execute(w, data)
+			return
+		}
+		recordLoginSuccess(r, loginFormViewer)
+
+		// Password correct, set viewer session cookie
+		token, err := newViewerToken(passwordHash.String)
+		if err != nil {
+			log.Printf("Error creating viewer token: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     "viewer_authenticated",
+			Value:    token,
+			Path:     "/",
+			MaxAge:   int(viewerAccessLifetime.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		// Redirect to original page or home
+		if redirect != "" && strings.HasPrefix(redirect, "/") {
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+		} else {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		}
+		return
+	}
+
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+func requireViewerAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Check if viewer password is set in site_settings
+		var passwordHash sql.NullString
+		err := db.QueryRow("SELECT viewer_password_hash FROM site_settings WHERE id = 1").Scan(&passwordHash)
+		if err != nil || !passwordHash.Valid || passwordHash.String == "" {
+			// No password set, allow access
+			next(w, r)
+			return
+		}
+
+		// Check if user is authenticated
+		cookie, err := r.Cookie("viewer_authenticated")
+		if err != nil || !validViewerToken(cookie.Value, passwordHash.String) {
+			// Not authenticated, redirect to password page with return URL
+			redirectURL := "/viewer-auth?redirect=" + template.URLQueryEscaper(r.URL.Path)
+			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("session_token")
+	if err == nil {
+		deleteSession(cookie.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func isPasswordChangeRequired(userID int) bool {
+	var required bool
+	err := db.QueryRow("SELECT password_change_required FROM users WHERE id = ?", userID).Scan(&required)
+	return err == nil && required
+}
+
+func handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	// Verify user is authenticated
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Only allow access if password change is required (first login)
+	// Otherwise redirect to settings page for password changes
+	if !user.PasswordChangeRequired {
+		http.Redirect(w, r, "/admin/settings", http.StatusSeeOther)
+		return
+	}
+
+	type ChangePasswordData struct {
+		Error     string
+		Success   string
+		CSRFToken string
+	}
+	csrf := csrfToken(r)
+
+	if r.Method == http.MethodGet {
+		tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
+		tmpl.Execute(w, ChangePasswordData{CSRFToken: csrf})
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if !checkCSRF(w, r) {
+			return
+		}
+
+		newPassword := r.FormValue("new_password")
+		confirmPassword := r.FormValue("confirm_password")
+
+		// Validate passwords
+		if newPassword == "" {
+			tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
+			tmpl.Execute(w, ChangePasswordData{Error: "Password cannot be empty", CSRFToken: csrf})
+			return
+		}
+
+		if len(newPassword) < 8 {
+			tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
+			tmpl.Execute(w, ChangePasswordData{Error: "Password must be at least 8 characters long", CSRFToken: csrf})
+			return
+		}
+
+		if newPassword != confirmPassword {
+			tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
+			tmpl.Execute(w, ChangePasswordData{Error: "Passwords do not match", CSRFToken: csrf})
+			return
+		}
+
+		// Hash the new password
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
+			tmpl.Execute(w, ChangePasswordData{Error: "Failed to update password", CSRFToken: csrf})
+			return
+		}
+
+		// Update password and clear the password_change_required flag
+		_, err = db.Exec("UPDATE users SET password_hash = ?, password_change_required = 0 WHERE id = ?", string(hashedPassword), user.ID)
+		if err != nil {
+			log.Printf("Error updating password: %v", err)
+			tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
+			tmpl.Execute(w, ChangePasswordData{Error: "Failed to update password", CSRFToken: csrf})
+			return
+		}
+
+		log.Printf("Password of %s changed, password_change_required cleared", user.Username)
+
+		// Redirect to admin panel
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// HealthResponse represents the JSON response for the health endpoint
+type HealthResponse struct {
+	Status    string `json:"status"`
+	Bootstrap bool   `json:"bootstrap"`
+}
+
+// userMayAccess reports whether the user's role allows an admin request.
+// Owners manage users, domains and backups; editors and owners change
+// settings and moderate webmentions. Which posts a user can change is
+// checked by the post handlers.
+func userMayAccess(user *User, r *http.Request) bool {
+	path := r.URL.Path
+	section := ""
+	if path == "/admin/settings/update" {
+		section = r.FormValue("section")
+		if section == "" {
+			section = "site-info"
+		}
+	}
+
+	switch {
+	case path == "/admin/backup" || path == "/admin/restore" || strings.HasPrefix(path, "/admin/domain/"),
+		path == "/admin/settings/backup" || path == "/admin/settings/users" || section == "users" || section == "login-attempts":
+		return user.IsOwner()
+	case path == "/admin/settings" || path == "/admin/settings/appearance" || path == "/admin/settings/podcast",
+		path == "/admin/settings/tokens" || path == "/admin/settings/webhooks" || strings.HasPrefix(path, "/admin/privacy/"),
+		section == "site-info" || section == "appearance" || section == "podcast" || section == "photo-metadata",
+		section == "tokens" || section == "webhooks":
+		return user.CanChangeSettings()
+	case path == "/admin/webmentions/moderate" || (path == "/admin" && r.URL.Query().Get("view") == "webmentions"):
+		return user.CanPublish()
+	}
+	return true
+}
+
+// adminRouter handles all /admin routes with a single prefix handler
+// This ensures all admin paths bypass viewer auth and only require admin auth
+func adminRouter(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login?redirect="+path, http.StatusSeeOther)
+		return
+	}
+	if !checkCSRF(w, r) {
+		return
+	}
+	if !userMayAccess(user, r) {
+		http.Error(w, "You don't have permission to do that", http.StatusForbidden)
+		return
+	}
+
+	// Anything saved in the admin area may change public pages and feeds
+	if r.Method == http.MethodPost {
+		defer markContentChanged()
+	}
+
+	switch {
+	case path == "/admin" || path == "/admin/":
+		handleAdminIndex(w, r)
+	case path == "/admin/create":
+		handleCreate(w, r)
+	case path == "/admin/update":
+		handleUpdate(w, r)
+	case path == "/admin/delete":
+		handleDelete(w, r)
+	case path == "/admin/revisions/restore":
+		handleRestoreRevision(w, r)
+	case path == "/admin/webmentions/moderate":
+		handleModerateWebmention(w, r)
+	case path == "/admin/entries":
+		handleGetEntriesForAdmin(w, r)
+	case path == "/admin/privacy/set":
+		handleSetPrivacyPassword(w, r)
+	case path == "/admin/privacy/remove":
+		handleRemovePrivacyPassword(w, r)
+	case path == "/admin/settings":
+		handleSettings(w, r)
+	case path == "/admin/settings/appearance":
+		handleSettingsAppearance(w, r)
+	case path == "/admin/settings/security":
+		handleSettingsSecurity(w, r)
+	case path == "/admin/settings/podcast":
+		handleSettingsPodcast(w, r)
+	case path == "/admin/settings/tokens":
+		handleSettingsTokens(w, r)
+	case path == "/admin/settings/webhooks":
+		handleSettingsWebhooks(w, r)
+	case path == "/admin/settings/users":
+		handleSettingsUsers(w, r)
+	case path == "/admin/settings/backup":
+		handleSettingsBackup(w, r)
+	case path == "/admin/settings/update":
+		handleSettingsUpdate(w, r)
+	case path == "/admin/passkeys/begin":
+		handlePasskeyRegisterBegin(w, r)
+	case path == "/admin/passkeys/finish":
+		handlePasskeyRegisterFinish(w, r)
+	case path == "/admin/backup":
+		handleBackup(w, r)
+	case path == "/admin/restore":
+		handleRestore(w, r)
+	case path == "/admin/domain/add":
+		handleDomainAdd(w, r)
+	case path == "/admin/domain/verify":
+		handleDomainVerify(w, r)
+	case path == "/admin/domain/activate":
+		handleDomainActivate(w, r)
+	case path == "/admin/domain/remove":
+		handleDomainRemove(w, r)
+	case path == "/admin/domain/status":
+		handleDomainStatus(w, r)
+	case path == "/admin/domain/set-hostname":
+		handleSetInstanceHostname(w, r)
+	case path == "/admin/domain/enable":
+		handleEnableCustomDomain(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// ============================================================================
+// Custom Domain HTTP Handlers
+// ============================================================================
+
+func handleDomainAdd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	domain := strings.TrimSpace(r.FormValue("domain"))
+	if domain == "" {
+		http.Redirect(w, r, "/admin/settings?error=Domain+cannot+be+empty", http.StatusSeeOther)
+		return
+	}
+
+	cd, err := addCustomDomain(domain)
+	if err != nil {
+		http.Redirect(w, r, "/admin/settings?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Custom domain added: %s (token: %s)", cd.Domain, cd.VerificationToken[:20]+"...")
+	http.Redirect(w, r, "/admin/settings?success=Domain+added.+Please+configure+DNS+records.", http.StatusSeeOther)
+}
+
+func handleDomainVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := verifyCustomDomain()
+	if err != nil {
+		http.Redirect(w, r, "/admin/settings?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	// Automatically activate after verification
+	err = activateCustomDomain()
+	if err != nil {
+		log.Printf("Domain verified but activation failed: %v", err)
+		http.Redirect(w, r, "/admin/settings?success=Domain+verified.+Activation+pending.", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/settings?success=Domain+verified+and+activated!", http.StatusSeeOther)
+}
+
+func handleDomainActivate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := activateCustomDomain()
+	if err != nil {
+		http.Redirect(w, r, "/admin/settings?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/settings?success=Domain+activated!", http.StatusSeeOther)
+}
+
+func handleDomainRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := removeCustomDomain()
+	if err != nil {
+		http.Redirect(w, r, "/admin/settings?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/settings?success=Domain+removed.", http.StatusSeeOther)
+}
+
+func handleDomainStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cd, err := getCustomDomain()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if cd == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"configured": false,
+		})
+		return
+	}
+
+	response := map[string]interface{}{
+		"configured":    true,
+		"domain":        cd.Domain,
+		"verified":      cd.VerifiedAt.Valid,
+		"activated":     cd.ActivatedAt.Valid,
+		"attempts":      cd.VerificationAttempts,
+		"attemptsLeft":  5 - cd.VerificationAttempts,
+	}
+
+	if cd.VerifiedAt.Valid {
+		response["verifiedAt"] = cd.VerifiedAt.Time.Format(time.RFC3339)
+	}
+	if cd.ActivatedAt.Valid {
+		response["activatedAt"] = cd.ActivatedAt.Time.Format(time.RFC3339)
+	}
+	if cd.LastVerifiedAt.Valid {
+		response["lastVerifiedAt"] = cd.LastVerifiedAt.Time.Format(time.RFC3339)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func handleSetInstanceHostname(w http.ResponseWriter, r *http.Request) {
+	// Deprecated - redirect to enable endpoint
+	http.Redirect(w, r, "/admin/domain/enable", http.StatusSeeOther)
+}
+
+// handleEnableCustomDomain enables custom domain feature by capturing hostname from current request
+func handleEnableCustomDomain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if already enabled
+	existingHostname := getInstanceHostname()
+	if existingHostname != "" {
+		http.Redirect(w, r, "/admin/settings?error=Custom+domains+already+enabled", http.StatusSeeOther)
+		return
+	}
+
+	// Get hostname from current request
+	currentHost := getHostFromRequest(r)
+	if currentHost == "" {
+		http.Redirect(w, r, "/admin/settings?error=Could+not+detect+hostname", http.StatusSeeOther)
+		return
+	}
+
+	// Only allow postastiq.com subdomains
+	if !isPostastiqSubdomain(currentHost) {
+		http.Redirect(w, r, "/admin/settings?error=Custom+domains+only+available+for+postastiq.com+subdomains", http.StatusSeeOther)
+		return
+	}
+
+	// Store the hostname
+	err := setInstanceHostname(currentHost)
+	if err != nil {
+		log.Printf("Failed to enable custom domains: %v", err)
+		http.Redirect(w, r, "/admin/settings?error=Failed+to+enable+custom+domains", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Custom domains enabled for: %s", currentHost)
+	http.Redirect(w, r, "/admin/settings?success=Custom+domains+enabled+for+"+url.QueryEscape(currentHost), http.StatusSeeOther)
+}
+
+// ============================================================================
+// End Custom Domain HTTP Handlers
+// ============================================================================
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Check if the owner account exists (bootstrap complete)
+	var userCount int
+	err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount)
+
+	bootstrapComplete := err == nil && userCount > 0
+
+	if !bootstrapComplete {
+		// Still initializing - owner account not yet created
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(HealthResponse{
+			Status:    "initializing",
+			Bootstrap: false,
+		})
+		return
+	}
+
+	// App is ready
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(HealthResponse{
+		Status:    "ready",
+		Bootstrap: true,
+	})
+}
+
+// handleTagRoutes serves tag archive pages at /tags/<name>/ and per-tag RSS
+// feeds at /tags/<name>/rss
+func handleTagRoutes(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tags/"), "/")
+	parts := strings.Split(path, "/")
+
+	tag := normalizeTag(parts[0])
+	if tag == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if tag != parts[0] {
+		// Redirect to the canonical tag URL (e.g. /tags/Go/ -> /tags/go/)
+		canonical := "/tags/" + tag + "/"
+		if len(parts) > 1 {
+			canonical += strings.Join(parts[1:], "/")
+		}
+		http.Redirect(w, r, canonical, http.StatusMovedPermanently)
+		return
+	}
+
+	if !tagExists(tag) {
+		handle404(w, r)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		handleTagPage(w, r, tag)
+	case len(parts) == 2 && parts[1] == "rss":
+		handleTagRSSFeed(w, r, tag)
+	default:
+		handle404(w, r)
+	}
+}
+
+// handleTagPage renders the viewer template with only the entries of one tag
+func handleTagPage(w http.ResponseWriter, r *http.Request, tag string) {
+	if checkNotModified(w, r, true) {
+		return
+	}
+
+	tmpl, err := template.New("feed").Parse(viewerTemplate + entryGalleryTemplate)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Template error: %v", err)
+		return
+	}
+
+	entries, hasMore, err := getEntriesWithTag(tag, 0, 10)
+	if err != nil {
+		http.Error(w, "Database query error", http.StatusInternalServerError)
+		log.Printf("Database query error: %v", err)
+		return
+	}
+
+	var totalEntries int
+	db.QueryRow("SELECT COUNT(*) FROM entries WHERE "+entryHasTagCondition+" AND "+publicEntryCondition, tag).Scan(&totalEntries)
+
+	// Get settings from database
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error getting site settings: %v", err)
+		// Use defaults if error
+		settings = SiteSettings{
+			SiteTitle:    "My Blog",
+			SiteSubtitle: "A Personal Blog",
+			UserInitial:  "AB",
+			SiteTheme:    "default",
+		}
+	}
+
+	data := ViewerPageData{
+		Entries:          entries,
+		TotalEntries:     totalEntries,
+		SiteTitle:        settings.SiteTitle,
+		SiteSubtitle:     settings.SiteSubtitle,
+		EnableSubtitle:   enableSubtitle,
+		UserInitial:      settings.UserInitial,
+		AvatarPath:       settings.AvatarPath,
+		AvatarPreference: settings.AvatarPreference,
+		InitialCount:     len(entries),
+		HasMore:          hasMore,
+		ThemeCSS:         template.CSS(getThemeCSS()),
+		Tag:              tag,
+	}
+
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
+
+// handleTagRSSFeed serves the RSS feed of a single tag
+func handleTagRSSFeed(w http.ResponseWriter, r *http.Request, tag string) {
+	if checkNotModified(w, r, false) {
+		return
+	}
+
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error getting site settings for RSS: %v", err)
+		settings = SiteSettings{
+			SiteTitle:    "My Blog",
+			SiteSubtitle: "A Personal Blog",
+		}
+	}
+
+	entries, _, err := getEntriesWithTag(tag, 0, 20)
+	if err != nil {
+		http.Error(w, "Error generating RSS feed", http.StatusInternalServerError)
+		log.Printf("Error getting entries for tag RSS: %v", err)
+		return
+	}
+
+	title := fmt.Sprintf("%s - #%s", settings.SiteTitle, tag)
+	description := fmt.Sprintf("Posts tagged #%s", tag)
+	writeRSSFeed(w, r, title, description, "/tags/"+tag+"/", "/tags/"+tag+"/rss", entries)
+}
+
+// getBaseURL returns the scheme and host the request was made to
+func getBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+func handleRSSFeed(w http.ResponseWriter, r *http.Request) {
+	if checkNotModified(w, r, false) {
+		return
+	}
+
+	// Get site settings
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error getting site settings for RSS: %v", err)
+		settings = SiteSettings{
+			SiteTitle:    "My Blog",
+			SiteSubtitle: "A Personal Blog",
+		}
+	}
+
+	// Get latest 20 entries for RSS feed
+	entries, _, err := getEntries(0, 20)
+	if err != nil {
+		http.Error(w, "Error generating RSS feed", http.StatusInternalServerError)
+		log.Printf("Error getting entries for RSS: %v", err)
+		return
+	}
+
+	writeRSSFeed(w, r, settings.SiteTitle, settings.SiteSubtitle, "", "/rss", entries)
+}
+
+// writeRSSFeed writes an RSS 2.0 document for the given entries. linkPath is
+// the HTML page the feed belongs to and selfPath the feed's own path.
+func writeRSSFeed(w http.ResponseWriter, r *http.Request, title, description, linkPath, selfPath string, entries []EntryDisplay) {
+	// Build RSS feed XML
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+
+	// Get the base URL from the request
+	baseURL := getBaseURL(r)
+
+	// Start RSS feed
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintf(w, `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/" xmlns:dc="http://purl.org/dc/elements/1.1/">`)
+	fmt.Fprintf(w, `<channel>`)
+	fmt.Fprintf(w, `<title>%s</title>`, html.EscapeString(title))
+	fmt.Fprintf(w, `<link>%s</link>`, html.EscapeString(baseURL+linkPath))
+	fmt.Fprintf(w, `<description>%s</description>`, html.EscapeString(description))
+	fmt.Fprintf(w, `<language>en-us</language>`)
+	fmt.Fprintf(w, `<atom:link href="%s%s" rel="self" type="application/rss+xml" />`, html.EscapeString(baseURL), html.EscapeString(selfPath))
+
+	// lastBuildDate is the newest publish or edit time
+	_, lastBuild := feedTimes(entries)
+	fmt.Fprintf(w, `<lastBuildDate>%s</lastBuildDate>`, lastBuild.UTC().Format(time.RFC1123Z))
+
+	// Add items
+	for _, entry := range entries {
+		fmt.Fprintf(w, `<item>`)
+
+		// Title - use content preview if no title
+		fmt.Fprintf(w, `<title>%s</title>`, html.EscapeString(feedEntryTitle(entry)))
+
+		// Link to individual post
+		postURL := fmt.Sprintf("%s/posts/%s/", baseURL, entry.Slug)
+		fmt.Fprintf(w, `<link>%s</link>`, html.EscapeString(postURL))
+
+		if entry.Author != "" {
+			fmt.Fprintf(w, `<dc:creator>%s</dc:creator>`, html.EscapeString(entry.Author))
+		}
+
+		// GUID (unique identifier)
+		fmt.Fprintf(w, `<guid isPermaLink="true">%s</guid>`, html.EscapeString(postURL))
+
+		// Description (text content only - media handled via enclosure tag)
+		fmt.Fprintf(w, `<description><![CDATA[%s]]></description>`, string(entry.Content))
+
+		// Enclosure for the primary media item (standard RSS 2.0 media handling)
+		if len(entry.Media) > 0 {
+			primary := entry.Media[0]
+			fmt.Fprintf(w, `<enclosure url="%s" type="%s" length="%d" />`,
+				html.EscapeString(baseURL+string(primary.URL)), mediaMIMEType(primary.Path, primary.MediaType), uploadFileSize(primary.Path))
+		}
+
+		// Every media item as Media RSS content (RSS allows one enclosure)
+		for _, item := range entry.Media {
+			medium := "image"
+			if item.MediaType == "audio" || item.MediaType == "video" {
+				medium = item.MediaType
+			}
+			fmt.Fprintf(w, `<media:content url="%s" type="%s" medium="%s">`, html.EscapeString(baseURL+string(item.URL)), mediaMIMEType(item.Path, item.MediaType), medium)
+			if item.AltText != "" {
+				fmt.Fprintf(w, `<media:description type="plain">%s</media:description>`, html.EscapeString(item.AltText))
+			}
+			if item.Thumbnail != "" {
+				fmt.Fprintf(w, `<media:thumbnail url="%s" />`, html.EscapeString(baseURL+string(item.Thumbnail)))
+			}
+			fmt.Fprintf(w, `</media:content>`)
+		}
+
+		// Tags as categories
+		for _, tag := range entry.Tags {
+			fmt.Fprintf(w, `<category>%s</category>`, html.EscapeString(tag))
+		}
+
+		// Publication date
+		fmt.Fprintf(w, `<pubDate>%s</pubDate>`, entry.CreatedAt.UTC().Format(time.RFC1123Z))
+
+		fmt.Fprintf(w, `</item>`)
+	}
+
+	fmt.Fprintf(w, `</channel>`)
+	fmt.Fprintf(w, `</rss>`)
+}
+
+// feedEntryTitle returns the entry title, or the start of its text for
+// untitled posts
+func feedEntryTitle(entry EntryDisplay) string {
+	if entry.Title != "" {
+		return entry.Title
+	}
+	// Use first 60 characters of content as title
+	contentRunes := []rune(string(entry.Content))
+	if len(contentRunes) > 60 {
+		return string(contentRunes[:60]) + "..."
+	}
+	return string(entry.Content)
+}
+
+// getEntryUpdatedTimes returns when each entry was last edited, taken from its
+// newest revision. Entries that were never edited are missing from the map.
+func getEntryUpdatedTimes(ids []int) map[int]time.Time {
+	updated := make(map[int]time.Time)
+	if len(ids) == 0 {
+		return updated
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	rows, err := db.Query(`
+		SELECT entry_id, created_at FROM entry_revisions
+		WHERE id IN (
+			SELECT MAX(id) FROM entry_revisions
+			WHERE entry_id IN (`+placeholders+`)
+			GROUP BY entry_id
+		)
+	`, args...)
+	if err != nil {
+		log.Printf("Error fetching entry update times: %v", err)
+		return updated
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryID int
+		var updatedAt time.Time
+		if err := rows.Scan(&entryID, &updatedAt); err == nil {
+			updated[entryID] = updatedAt
+		}
+	}
+	return updated
+}
+
+// feedTimes returns the published and updated time of every entry plus the
+// newest update overall, which is the feed's own updated time
+func feedTimes(entries []EntryDisplay) ([]time.Time, time.Time) {
+	ids := make([]int, len(entries))
+	for i := range entries {
+		ids[i] = entries[i].ID
+	}
+	updatedByEntry := getEntryUpdatedTimes(ids)
+
+	updated := make([]time.Time, len(entries))
+	var feedUpdated time.Time
+	for i, entry := range entries {
+		updated[i] = entry.CreatedAt
+		if t, ok := updatedByEntry[entry.ID]; ok && t.After(entry.CreatedAt) {
+			updated[i] = t
+		}
+		if updated[i].After(feedUpdated) {
+			feedUpdated = updated[i]
+		}
+	}
+	if feedUpdated.IsZero() {
+		feedUpdated = time.Now()
+	}
+	return updated, feedUpdated
+}
+
+// uploadFileSize returns the size in bytes of an uploaded file, or 0 if it
+// can't be read
+func uploadFileSize(path string) int64 {
+	info, err := os.Stat(filepath.Join(uploadsDir, filepath.Base(path)))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func handleAtomFeed(w http.ResponseWriter, r *http.Request) {
+	if checkNotModified(w, r, false) {
+		return
+	}
+
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error getting site settings for Atom feed: %v", err)
+		settings = SiteSettings{
+			SiteTitle:    "My Blog",
+			SiteSubtitle: "A Personal Blog",
+		}
+	}
+
+	entries, _, err := getEntries(0, 20)
+	if err != nil {
+		http.Error(w, "Error generating Atom feed", http.StatusInternalServerError)
+		log.Printf("Error getting entries for Atom feed: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+
+	baseURL := getBaseURL(r)
+	updated, feedUpdated := feedTimes(entries)
+
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintf(w, `<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="en-us">`)
+	fmt.Fprintf(w, `<title>%s</title>`, html.EscapeString(settings.SiteTitle))
+	if settings.SiteSubtitle != "" {
+		fmt.Fprintf(w, `<subtitle>%s</subtitle>`, html.EscapeString(settings.SiteSubtitle))
+	}
+	fmt.Fprintf(w, `<id>%s/</id>`, html.EscapeString(baseURL))
+	fmt.Fprintf(w, `<link href="%s/" rel="alternate" type="text/html" />`, html.EscapeString(baseURL))
+	fmt.Fprintf(w, `<link href="%s/atom.xml" rel="self" type="application/atom+xml" />`, html.EscapeString(baseURL))
+	fmt.Fprintf(w, `<updated>%s</updated>`, feedUpdated.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, `<author><name>%s</name></author>`, html.EscapeString(settings.SiteTitle))
+
+	for i, entry := range entries {
+		postURL := fmt.Sprintf("%s/posts/%s/", baseURL, entry.Slug)
+
+		fmt.Fprintf(w, `<entry>`)
+		fmt.Fprintf(w, `<title>%s</title>`, html.EscapeString(feedEntryTitle(entry)))
+		fmt.Fprintf(w, `<link href="%s" rel="alternate" type="text/html" />`, html.EscapeString(postURL))
+		fmt.Fprintf(w, `<id>%s</id>`, html.EscapeString(postURL))
+		fmt.Fprintf(w, `<published>%s</published>`, entry.CreatedAt.UTC().Format(time.RFC3339))
+		fmt.Fprintf(w, `<updated>%s</updated>`, updated[i].UTC().Format(time.RFC3339))
+		if entry.Author != "" {
+			fmt.Fprintf(w, `<author><name>%s</name></author>`, html.EscapeString(entry.Author))
+		}
+		fmt.Fprintf(w, `<content type="html">%s</content>`, html.EscapeString(string(entry.FullContent)))
+
+		// Media items as enclosure links
+		for _, item := range entry.Media {
+			fmt.Fprintf(w, `<link href="%s" rel="enclosure" type="%s" length="%d"`,
+				html.EscapeString(baseURL+string(item.URL)), mediaMIMEType(item.Path, item.MediaType), uploadFileSize(item.Path))
+			if item.AltText != "" {
+				fmt.Fprintf(w, ` title="%s"`, html.EscapeString(item.AltText))
+			}
+			fmt.Fprintf(w, ` />`)
+		}
+
+		for _, tag := range entry.Tags {
+			fmt.Fprintf(w, `<category term="%s" />`, html.EscapeString(tag))
+		}
+
+		fmt.Fprintf(w, `</entry>`)
+	}
+
+	fmt.Fprintf(w, `</feed>`)
+}
+
+// JSONFeed is a JSON Feed 1.1 document (https://jsonfeed.org/version/1.1)
+type JSONFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Description string           `json:"description,omitempty"`
+	Icon        string           `json:"icon,omitempty"`
+	Authors     []JSONFeedAuthor `json:"authors,omitempty"`
+	Language    string           `json:"language"`
+	Items       []JSONFeedItem   `json:"items"`
+}
+
+type JSONFeedAuthor struct {
+	Name   string `json:"name"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type JSONFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url"`
+	Title         string               `json:"title,omitempty"`
+	ContentHTML   string               `json:"content_html"`
+	Image         string               `json:"image,omitempty"`
+	DatePublished string               `json:"date_published"`
+	DateModified  string               `json:"date_modified"`
+	Tags          []string             `json:"tags,omitempty"`
+	Authors       []JSONFeedAuthor     `json:"authors,omitempty"`
+	Attachments   []JSONFeedAttachment `json:"attachments,omitempty"`
+}
+
+type JSONFeedAttachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	Title       string `json:"title,omitempty"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+func handleJSONFeed(w http.ResponseWriter, r *http.Request) {
+	if checkNotModified(w, r, false) {
+		return
+	}
+
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error getting site settings for JSON feed: %v", err)
+		settings = SiteSettings{
+			SiteTitle:    "My Blog",
+			SiteSubtitle: "A Personal Blog",
+		}
+	}
+
+	entries, _, err := getEntries(0, 20)
+	if err != nil {
+		http.Error(w, "Error generating JSON feed", http.StatusInternalServerError)
+		log.Printf("Error getting entries for JSON feed: %v", err)
+		return
+	}
+
+	baseURL := getBaseURL(r)
+	updated, _ := feedTimes(entries)
+
+	author := JSONFeedAuthor{Name: settings.SiteTitle}
+	feed := JSONFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       settings.SiteTitle,
+		HomePageURL: baseURL + "/",
+		FeedURL:     baseURL + "/feed.json",
+		Description: settings.SiteSubtitle,
+		Language:    "en-US",
+		Items:       []JSONFeedItem{},
+	}
+	if settings.AvatarPreference == "avatar" && settings.AvatarPath != "" {
+		feed.Icon = baseURL + "/uploads/" + settings.AvatarPath
+		author.Avatar = feed.Icon
+	}
+	feed.Authors = []JSONFeedAuthor{author}
+
+	for i, entry := range entries {
+		postURL := fmt.Sprintf("%s/posts/%s/", baseURL, entry.Slug)
+		item := JSONFeedItem{
+			ID:            postURL,
+			URL:           postURL,
+			Title:         entry.Title,
+			ContentHTML:   string(entry.FullContent),
+			DatePublished: entry.CreatedAt.UTC().Format(time.RFC3339),
+			DateModified:  updated[i].UTC().Format(time.RFC3339),
+			Tags:          entry.Tags,
+		}
+		if entry.HasPhoto {
+			item.Image = baseURL + string(entry.Photo)
+		} else if entry.HasThumbnail {
+			item.Image = baseURL + string(entry.Thumbnail)
+		}
+		if entry.Author != "" {
+			item.Authors = []JSONFeedAuthor{{Name: entry.Author}}
+		}
+		for _, media := range entry.Media {
+			item.Attachments = append(item.Attachments, JSONFeedAttachment{
+				URL:         baseURL + string(media.URL),
+				MimeType:    mediaMIMEType(media.Path, media.MediaType),
+				Title:       media.AltText,
+				SizeInBytes: uploadFileSize(media.Path),
+			})
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+	json.NewEncoder(w).Encode(feed)
+}
+
+// ============================================================================
+// Webmention
+// ============================================================================
+
+// Webmention is a response to one of our posts published on another site
+type Webmention struct {
+	ID            int
+	EntryID       int
+	Source        string
+	Target        string
+	Type          string // "reply", "like", "repost", "bookmark" or "mention"
+	AuthorName    string
+	AuthorURL     string
+	AuthorInitial string
+	Content       string
+	Status        string // "pending", "approved" or "rejected"
+	CreatedAt     time.Time
+	EntryTitle    string // set in the admin list
+	EntrySlug     string // set in the admin list
+}
+
+// PostResponses groups the approved webmentions shown beneath a post
+type PostResponses struct {
+	Likes   []Webmention
+	Reposts []Webmention
+	Replies []Webmention // replies, bookmarks and plain mentions
+}
+
+const maxWebmentionAttempts = 5
+
+// webmentionVerifyWorkers is how many received sources are fetched at once
+const webmentionVerifyWorkers = 4
+
+// webmentionVerification is a received Webmention waiting to be verified
+type webmentionVerification struct {
+	Source  string
+	Target  string
+	EntryID int
+}
+
+var (
+	// webmentionQueueSignal wakes the sender when new Webmentions are queued
+	webmentionQueueSignal = make(chan struct{}, 1)
+
+	// webmentionVerifyQueue holds received Webmentions waiting for their
+	// source to be checked by one of webmentionVerifyWorkers workers
+	webmentionVerifyQueue = make(chan webmentionVerification, 100)
+
+	// allowPrivateFetches lets publicHTTPClient reach private addresses
+	allowPrivateFetches bool
+
+	// publicHTTPClient fetches URLs supplied by other sites. It refuses to
+	// connect to addresses that aren't public, see isPublicAddress.
+	publicHTTPClient = &http.Client{
+		Timeout: 15 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 10 * time.Second,
+				Control: refuseNonPublicAddress,
+			}).DialContext,
+		},
+	}
+)
+
+// nonPublicNetworks are the special-purpose ranges of the IANA IPv4 and IPv6
+// address registries that don't reach the public internet
+var nonPublicNetworks = parseCIDRs(
+	"0.0.0.0/8",       // this network
+	"10.0.0.0/8",      // private
+	"100.64.0.0/10",   // carrier-grade NAT
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local
+	"172.16.0.0/12",   // private
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"192.88.99.0/24",  // 6to4 relay anycast
+	"192.168.0.0/16",  // private
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved and broadcast
+	"::/96",           // unspecified, loopback and IPv4-compatible
+	"64:ff9b:1::/48",  // local-use NAT64
+	"100::/64",        // discard
+	"2001::/23",       // IETF protocol assignments, including Teredo
+	"2001:db8::/32",   // documentation
+	"fc00::/7",        // unique local
+	"fe80::/10",       // link-local
+	"fec0::/10",       // site-local
+	"ff00::/8",        // multicast
+)
+
+var (
+	// nat64Network holds IPv4 addresses in its last 32 bits
+	nat64Network = parseCIDRs("64:ff9b::/96")[0]
+	// sixToFourNetwork holds IPv4 addresses in bits 16 to 48
+	sixToFourNetwork = parseCIDRs("2002::/16")[0]
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// isPublicAddress reports whether ip is outside nonPublicNetworks. IPv6
+// addresses that embed an IPv4 address are judged by that address.
+func isPublicAddress(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	} else if nat64Network.Contains(ip) {
+		return isPublicAddress(ip[12:16])
+	} else if sixToFourNetwork.Contains(ip) {
+		return isPublicAddress(ip[2:6])
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func refuseNonPublicAddress(network, address string, c syscall.RawConn) error {
+	if allowPrivateFetches {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicAddress(ip) {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
+
+// htmlTag is a start tag found in an HTML document
+type htmlTag struct {
+	Name  string
+	Attrs map[string]string
+	Start int // offset of "<"
+	End   int // offset just past ">"
+}
+
+var (
+	htmlTagPattern   = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9]*)\b((?:[^>"']|"[^"]*"|'[^']*')*)>`)
+	htmlAttrPattern  = regexp.MustCompile(`([^\s"'>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+)))?`)
+	htmlBlockPattern = regexp.MustCompile(`(?i)</?(?:p|div|br|li|h[1-6]|blockquote|pre|tr|td|th)\b[^>]*>`)
+	htmlTextPattern  = regexp.MustCompile(`<[^>]*>`)
+)
+
+// findHTMLTags returns the start tags of the given names (all tags if none are
+// given), in document order
+func findHTMLTags(doc string, names ...string) []htmlTag {
+	var tags []htmlTag
+	for _, m := range htmlTagPattern.FindAllStringSubmatchIndex(doc, -1) {
+		name := strings.ToLower(doc[m[2]:m[3]])
+		if len(names) > 0 {
+			wanted := false
+			for _, n := range names {
+				wanted = wanted || n == name
+			}
+			if !wanted {
+				continue
+			}
+		}
+		tag := htmlTag{Name: name, Attrs: make(map[string]string), Start: m[0], End: m[1]}
+		for _, a := range htmlAttrPattern.FindAllStringSubmatch(doc[m[4]:m[5]], -1) {
+			key := strings.ToLower(a[1])
+			if _, seen := tag.Attrs[key]; !seen {
+				tag.Attrs[key] = html.UnescapeString(a[2] + a[3] + a[4])
+			}
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// hasToken reports whether a space-separated attribute such as class or rel
+// contains token
+func (t htmlTag) hasToken(attr, token string) bool {
+	for _, field := range strings.Fields(strings.ToLower(t.Attrs[attr])) {
+		if field == token {
+			return true
+		}
+	}
+	return false
+}
+
+// elementInner returns the HTML between a start tag and its matching end tag
+func elementInner(doc string, tag htmlTag) string {
+	switch tag.Name {
+	case "img", "link", "meta", "br", "hr", "input", "source":
+		return ""
+	}
+	open := regexp.MustCompile(`(?i)<` + tag.Name + `\b`)
+	closing := regexp.MustCompile(`(?i)</` + tag.Name + `\s*>`)
+	depth := 1
+	pos := tag.End
+	for depth > 0 {
+		c := closing.FindStringIndex(doc[pos:])
+		if c == nil {
+			return doc[tag.End:]
+		}
+		depth += len(open.FindAllStringIndex(doc[pos:pos+c[0]], -1)) - 1
+		if depth == 0 {
+			return doc[tag.End : pos+c[0]]
+		}
+		pos += c[1]
+	}
+	return ""
+}
+
+// htmlText returns the visible text of an HTML fragment
+func htmlText(fragment string) string {
+	text := htmlTextPattern.ReplaceAllString(htmlBlockPattern.ReplaceAllString(fragment, " "), "")
+	return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
+
+// resolveURL resolves ref against base, returning "" for anything that isn't
+// an http(s) URL
+func resolveURL(base *url.URL, ref string) string {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+// sameURL compares two URLs ignoring host case and a trailing slash
+func sameURL(a, b string) bool {
+	normalize := func(s string) string {
+		u, err := url.Parse(s)
+		if err != nil {
+			return s
+		}
+		u.Host = strings.ToLower(u.Host)
+		u.Fragment = ""
+		return strings.TrimSuffix(u.String(), "/")
+	}
+	return normalize(a) == normalize(b)
+}
+
+// extractLinks returns the external http(s) links of rendered post content
+func extractLinks(content string, baseURL string) []string {
+	base, err := url.Parse(baseURL + "/")
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var links []string
+	for _, tag := range findHTMLTags(content, "a") {
+		link := resolveURL(base, tag.Attrs["href"])
+		if link == "" || seen[link] {
+			continue
+		}
+		if u, _ := url.Parse(link); u == nil || strings.EqualFold(u.Host, base.Host) {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+	}
+	return links
+}
+
+// queueWebmentions schedules Webmentions to every link of an entry. Targets
+// linked by earlier versions are notified again so they notice edits, removed
+// links and deletion. Scheduled entries are sent once they go live; drafts
+// and unlisted entries are never announced.
+func queueWebmentions(entryID int, baseURL string) {
+	var slug, content, status sql.NullString
+	var contentFormat sql.NullString
+	var publishAt sql.NullTime
+	err := db.QueryRow("SELECT slug, content, content_format, status, publish_at FROM entries WHERE id = ?", entryID).
+		Scan(&slug, &content, &contentFormat, &status, &publishAt)
+
+	now := time.Now().UTC().Format(sqliteTimeLayout)
+	if err == sql.ErrNoRows {
+		// Deleted: tell previous targets the source is gone
+		if _, err := db.Exec(`
+			UPDATE webmention_queue SET status = 'pending', attempts = 0, next_attempt_at = ?, last_error = ''
+			WHERE entry_id = ?
+		`, now, entryID); err != nil {
+			log.Printf("Error queueing webmentions for deleted entry %d: %v", entryID, err)
+		}
+		signalWebmentionSender()
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading entry %d for webmentions: %v", entryID, err)
+		return
+	}
+	if status.String != "published" {
+		return
+	}
+
+	// Posts of a password-protected blog can't be read by the targets
+	var viewerPassword sql.NullString
+	db.QueryRow("SELECT viewer_password_hash FROM site_settings WHERE id = 1").Scan(&viewerPassword)
+	if viewerPassword.String != "" {
+		return
+	}
+
+	sendAt := now
+	if publishAt.Valid && publishAt.Time.After(time.Now()) {
+		sendAt = publishAt.Time.UTC().Format(sqliteTimeLayout)
+	}
+	source := baseURL + "/posts/" + slug.String + "/"
+	links := extractLinks(string(renderContent(content.String, contentFormat.String)), baseURL)
+
+	if _, err := db.Exec(`
+		UPDATE webmention_queue SET source = ?, status = 'pending', attempts = 0, next_attempt_at = ?, last_error = ''
+		WHERE entry_id = ?
+	`, source, sendAt, entryID); err != nil {
+		log.Printf("Error requeueing webmentions for entry %d: %v", entryID, err)
+	}
+	for _, target := range links {
+		_, err := db.Exec(`
+			INSERT INTO webmention_queue (entry_id, source, target, status, attempts, next_attempt_at, last_error, created_at)
+			VALUES (?, ?, ?, 'pending', 0, ?, '', ?)
+			ON CONFLICT(entry_id, target) DO NOTHING
+		`, entryID, source, target, sendAt, now)
+		if err != nil {
+			log.Printf("Error queueing webmention to %s: %v", target, err)
+		}
+	}
+	signalWebmentionSender()
+}
+
+func signalWebmentionSender() {
+	select {
+	case webmentionQueueSignal <- struct{}{}:
+	default:
+	}
+}
+
+// startWebmentionSender sends queued Webmentions in the background, checking
+// the queue every minute and whenever something is queued
+func startWebmentionSender() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for {
+			sendQueuedWebmentions()
+			select {
+			case <-ticker.C:
+			case <-webmentionQueueSignal:
+			}
+		}
+	}()
+}
+
+// sendQueuedWebmentions sends the Webmentions that are due. Failures are
+// retried with exponential backoff up to maxWebmentionAttempts times.
+func sendQueuedWebmentions() {
+	rows, err := db.Query(`
+		SELECT q.id, q.entry_id, q.source, q.target, q.attempts, e.status, e.publish_at, e.id IS NOT NULL
+		FROM webmention_queue q
+		LEFT JOIN entries e ON e.id = q.entry_id
+		WHERE q.status = 'pending' AND q.next_attempt_at <= ?
+		ORDER BY q.next_attempt_at
+		LIMIT 20
+	`, time.Now().UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		log.Printf("Error reading webmention queue: %v", err)
+		return
+	}
+	type queued struct {
+		id, entryID, attempts int
+		source, target        string
+		status                sql.NullString
+		publishAt             sql.NullTime
+		exists                bool
+	}
+	var due []queued
+	for rows.Next() {
+		var q queued
+		if err := rows.Scan(&q.id, &q.entryID, &q.source, &q.target, &q.attempts, &q.status, &q.publishAt, &q.exists); err == nil {
+			due = append(due, q)
+		}
+	}
+	rows.Close()
+
+	for _, q := range due {
+		// Entries may have been unpublished or rescheduled since queueing
+		if q.exists && !isEntryPublic(q.status.String, q.publishAt) {
+			if q.status.String == "published" && q.publishAt.Valid {
+				db.Exec("UPDATE webmention_queue SET next_attempt_at = ? WHERE id = ?", q.publishAt.Time.UTC().Format(sqliteTimeLayout), q.id)
+			} else {
+				db.Exec("UPDATE webmention_queue SET status = 'skipped' WHERE id = ?", q.id)
+			}
+			continue
+		}
+
+		status, retry, err := sendWebmention(q.source, q.target)
+		lastError := ""
+		if err != nil {
+			lastError = err.Error()
+		}
+		attempts := q.attempts + 1
+		nextAttempt := time.Now().UTC()
+		if retry {
+			if attempts >= maxWebmentionAttempts {
+				status = "failed"
+			} else {
+				status = "pending"
+				nextAttempt = nextAttempt.Add(time.Minute << (2 * uint(attempts)))
+			}
+		}
+		if _, err := db.Exec(`
+			UPDATE webmention_queue SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?
+			WHERE id = ?
+		`, status, attempts, nextAttempt.Format(sqliteTimeLayout), lastError, q.id); err != nil {
+			log.Printf("Error updating webmention queue: %v", err)
+		}
+		if status == "sent" {
+			log.Printf("Sent webmention from %s to %s", q.source, q.target)
+		} else if err != nil {
+			log.Printf("Webmention from %s to %s: %v", q.source, q.target, err)
+		}
+	}
+}
+
+// sendWebmention discovers the target's endpoint and notifies it. It returns
+// the resulting queue status ("sent", "no_endpoint" or "failed") and whether
+// the attempt should be retried later.
+func sendWebmention(source, target string) (string, bool, error) {
+	endpoint, err := discoverWebmentionEndpoint(target)
+	if err != nil {
+		return "", true, err
+	}
+	if endpoint == "" {
+		return "no_endpoint", false, nil
+	}
+
+	resp, err := publicHTTPClient.PostForm(endpoint, url.Values{"source": {source}, "target": {target}})
+	if err != nil {
+		return "", true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return "sent", false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return "", true, fmt.Errorf("endpoint returned %s", resp.Status)
+	default:
+		return "failed", false, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+}
+
+var linkHeaderPattern = regexp.MustCompile(`<([^>]*)>([^<]*)`)
+var linkRelPattern = regexp.MustCompile(`(?i)\brel\s*=\s*(?:"([^"]*)"|([^\s;,]+))`)
+
+// discoverWebmentionEndpoint finds the Webmention endpoint advertised by a
+// page in its Link header or a <link>/<a rel="webmention"> element. It
+// returns "" if there is none.
+func discoverWebmentionEndpoint(target string) (string, error) {
+	resp, err := publicHTTPClient.Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return "", fmt.Errorf("target returned %s", resp.Status)
+	}
+	if resp.StatusCode >= 400 {
+		return "", nil
+	}
+	base := resp.Request.URL
+
+	for _, header := range resp.Header.Values("Link") {
+		for _, m := range linkHeaderPattern.FindAllStringSubmatch(header, -1) {
+			if rel := linkRelPattern.FindStringSubmatch(m[2]); rel != nil {
+				for _, value := range strings.Fields(strings.ToLower(rel[1] + rel[2])) {
+					if value == "webmention" {
+						return resolveURL(base, m[1]), nil
+					}
+				}
+			}
+		}
+	}
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return "", nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	for _, tag := range findHTMLTags(string(body), "link", "a") {
+		if href, ok := tag.Attrs["href"]; ok && tag.hasToken("rel", "webmention") {
+			return resolveURL(base, href), nil
+		}
+	}
+	return "", nil
+}
+
+// isOwnURL reports whether an absolute URL points at this site, under the
+// requested host, the instance hostname or the active custom domain
+func isOwnURL(u *url.URL, r *http.Request) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if strings.EqualFold(u.Host, r.Host) || host == getInstanceHostname() {
+		return true
+	}
+	cd, err := getCustomDomain()
+	return err == nil && cd != nil && cd.ActivatedAt.Valid && host == strings.ToLower(cd.Domain)
+}
+
+// entryForPostURL looks up the entry a /posts/<slug>/ URL of this site points
+// to, following renamed slugs. It returns sql.ErrNoRows for other URLs.
+func entryForPostURL(rawURL string, r *http.Request) (id int, status string, publishAt sql.NullTime, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !isOwnURL(u, r) || !strings.HasPrefix(u.Path, "/posts/") {
+		return 0, "", publishAt, sql.ErrNoRows
+	}
+
+	slug := strings.Trim(strings.TrimPrefix(u.Path, "/posts/"), "/")
+	var entryStatus sql.NullString
+	err = db.QueryRow("SELECT id, status, publish_at FROM entries WHERE slug = ?", slug).Scan(&id, &entryStatus, &publishAt)
+	if err == sql.ErrNoRows {
+		err = db.QueryRow(`
+			SELECT e.id, e.status, e.publish_at FROM slug_history h JOIN entries e ON e.id = h.entry_id
+			WHERE h.slug = ?
+		`, slug).Scan(&id, &entryStatus, &publishAt)
+	}
+	return id, entryStatus.String, publishAt, err
+}
+
+// webmentionTargetEntry returns the ID of the post a Webmention target URL
+// points to, or 0 if it isn't one of our posts that readers can see
+func webmentionTargetEntry(target string, r *http.Request) int {
+	entryID, status, publishAt, err := entryForPostURL(target, r)
+	if err != nil {
+		return 0
+	}
+	if status != "unlisted" && !isEntryPublic(status, publishAt) {
+		return 0
+	}
+	return entryID
+}
+
+// handleWebmention receives Webmentions. The source is verified in the
+// background, as the spec recommends, and stored for moderation.
+func handleWebmention(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	source := strings.TrimSpace(r.FormValue("source"))
+	target := strings.TrimSpace(r.FormValue("target"))
+	sourceURL, err := url.Parse(source)
+	if err != nil || (sourceURL.Scheme != "http" && sourceURL.Scheme != "https") || sourceURL.Host == "" {
+		http.Error(w, "source must be an http(s) URL", http.StatusBadRequest)
+		return
+	}
+	if sameURL(source, target) {
+		http.Error(w, "source and target must differ", http.StatusBadRequest)
+		return
+	}
+	entryID := webmentionTargetEntry(target, r)
+	if entryID == 0 {
+		http.Error(w, "target is not a post on this site", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case webmentionVerifyQueue <- webmentionVerification{Source: source, Target: target, EntryID: entryID}:
+	default:
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many Webmentions waiting to be verified, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "Webmention accepted for processing")
+}
+
+// startWebmentionVerifiers starts the workers that verify received Webmentions
+func startWebmentionVerifiers() {
+	for i := 0; i < webmentionVerifyWorkers; i++ {
+		go func() {
+			for v := range webmentionVerifyQueue {
+				verifyWebmention(v.Source, v.Target, v.EntryID)
+			}
+		}()
+	}
+}
+
+// verifyWebmention fetches the source and stores the mention if it links to
+// the target. Mentions whose source is gone or no longer links are removed.
+func verifyWebmention(source, target string, entryID int) {
+	resp, err := publicHTTPClient.Get(source)
+	if err != nil {
+		log.Printf("Error fetching webmention source %s: %v", source, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		deleteWebmention(source, target)
+		return
+	}
+	if resp.StatusCode >= 300 {
+		log.Printf("Webmention source %s returned %s", source, resp.Status)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		log.Printf("Error reading webmention source %s: %v", source, err)
+		return
+	}
+	doc := string(body)
+	base := resp.Request.URL
+
+	linked := false
+	for _, tag := range findHTMLTags(doc, "a", "link", "img", "audio", "video", "source") {
+		for _, attr := range []string{"href", "src"} {
+			if ref, ok := tag.Attrs[attr]; ok && sameURL(resolveURL(base, ref), target) {
+				linked = true
+			}
+		}
+	}
+	if !linked {
+		deleteWebmention(source, target)
+		return
+	}
+
+	mention := parseWebmentionSource(doc, target, base)
+	now := time.Now().UTC().Format(sqliteTimeLayout)
+	_, err = db.Exec(`
+		INSERT INTO webmentions (entry_id, source, target, type, author_name, author_url, content, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 'pending', ?, ?)
+		ON CONFLICT(source, target) DO UPDATE SET
+			entry_id = excluded.entry_id, type = excluded.type, author_name = excluded.author_name,
+			author_url = excluded.author_url, content = excluded.content, updated_at = excluded.updated_at
+	`, entryID, source, target, mention.Type, mention.AuthorName, mention.AuthorURL, mention.Content, now, now)
+	if err != nil {
+		log.Printf("Error saving webmention: %v", err)
+		return
+	}
+	markContentChanged()
+	log.Printf("Received webmention (%s) from %s", mention.Type, source)
+}
+
+func deleteWebmention(source, target string) {
+	result, err := db.Exec("DELETE FROM webmentions WHERE source = ? AND target = ?", source, target)
+	if err != nil {
+		log.Printf("Error deleting webmention: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		markContentChanged()
+	}
+}
+
+// parseWebmentionSource reads the response type, author and content from the
+// microformats2 markup (h-entry, h-card) of a source page. Pages without
+// markup become plain mentions attributed to their host.
+func parseWebmentionSource(doc, target string, base *url.URL) Webmention {
+	mention := Webmention{Type: "mention", AuthorName: base.Hostname()}
+
+	// Scope everything to the first h-entry if there is one
+	tags := findHTMLTags(doc)
+	for _, tag := range tags {
+		if tag.hasToken("class", "h-entry") {
+			doc = elementInner(doc, tag)
+			tags = findHTMLTags(doc)
+			break
+		}
+	}
+
+	responseClasses := []struct{ class, kind string }{
+		{"u-like-of", "like"},
+		{"u-repost-of", "repost"},
+		{"u-bookmark-of", "bookmark"},
+		{"u-in-reply-to", "reply"},
+	}
+	for _, rc := range responseClasses {
+		for _, tag := range tags {
+			if tag.hasToken("class", rc.class) && sameURL(resolveURL(base, tag.Attrs["href"]), target) {
+				mention.Type = rc.kind
+				break
+			}
+		}
+		if mention.Type != "mention" {
+			break
+		}
+	}
+
+	for _, tag := range tags {
+		if !tag.hasToken("class", "p-author") {
+			continue
+		}
+		inner := elementInner(doc, tag)
+		if href, ok := tag.Attrs["href"]; ok {
+			mention.AuthorURL = resolveURL(base, href)
+		}
+		name := ""
+		for _, t := range findHTMLTags(inner) {
+			if t.hasToken("class", "p-name") && name == "" {
+				name = htmlText(elementInner(inner, t))
+			}
+			if href, ok := t.Attrs["href"]; ok && t.hasToken("class", "u-url") && mention.AuthorURL == "" {
+				mention.AuthorURL = resolveURL(base, href)
+			}
+		}
+		if name == "" {
+			name = htmlText(inner)
+		}
+		if name != "" {
+			mention.AuthorName = name
+		}
+		break
+	}
+
+	if mention.Type == "reply" || mention.Type == "mention" {
+		for _, tag := range tags {
+			if tag.hasToken("class", "e-content") || tag.hasToken("class", "p-content") {
+				mention.Content = htmlText(elementInner(doc, tag))
+				break
+			}
+		}
+		if runes := []rune(mention.Content); len(runes) > 500 {
+			mention.Content = string(runes[:500]) + "…"
+		}
+	}
+
+	if runes := []rune(mention.AuthorName); len(runes) > 100 {
+		mention.AuthorName = string(runes[:100])
+	}
+	return mention
+}
+
+// getPostResponses returns the approved webmentions of an entry, oldest first
+func getPostResponses(entryID int) PostResponses {
+	var responses PostResponses
+	rows, err := db.Query(`
+		SELECT id, source, type, author_name, author_url, content, created_at
+		FROM webmentions
+		WHERE entry_id = ? AND status = 'approved'
+		ORDER BY created_at
+	`, entryID)
+	if err != nil {
+		log.Printf("Error fetching webmentions: %v", err)
+		return responses
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m Webmention
+		if err := rows.Scan(&m.ID, &m.Source, &m.Type, &m.AuthorName, &m.AuthorURL, &m.Content, &m.CreatedAt); err != nil {
+			continue
+		}
+		m.AuthorInitial = getInitialLetter(m.AuthorName)
+		switch m.Type {
+		case "like":
+			responses.Likes = append(responses.Likes, m)
+		case "repost":
+			responses.Reposts = append(responses.Reposts, m)
+		default:
+			responses.Replies = append(responses.Replies, m)
+		}
+	}
+	return responses
+}
+
+// getWebmentions returns the received webmentions with the given status for
+// the admin list, newest first
+func getWebmentions(status string) ([]Webmention, error) {
+	rows, err := db.Query(`
+		SELECT w.id, w.entry_id, w.source, w.type, w.author_name, w.author_url, w.content, w.status, w.created_at,
+		       COALESCE(e.title, ''), COALESCE(e.slug, '')
+		FROM webmentions w
+		LEFT JOIN entries e ON e.id = w.entry_id
+		WHERE w.status = ?
+		ORDER BY w.created_at DESC
+		LIMIT 200
+	`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mentions []Webmention
+	for rows.Next() {
+		var m Webmention
+		if err := rows.Scan(&m.ID, &m.EntryID, &m.Source, &m.Type, &m.AuthorName, &m.AuthorURL, &m.Content, &m.Status, &m.CreatedAt, &m.EntryTitle, &m.EntrySlug); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		mentions = append(mentions, m)
+	}
+	return mentions, nil
+}
+
+// handleModerateWebmention approves, rejects or deletes a received webmention
+func handleModerateWebmention(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	redirectURL := "/admin?view=webmentions"
+	if filter := r.FormValue("filter"); filter != "" {
+		redirectURL += "&status=" + url.QueryEscape(filter)
+	}
+
+	switch action := r.FormValue("action"); action {
+	case "approve", "reject":
+		status := map[string]string{"approve": "approved", "reject": "rejected"}[action]
+		_, err = db.Exec("UPDATE webmentions SET status = ? WHERE id = ?", status, id)
+	case "delete":
+		_, err = db.Exec("DELETE FROM webmentions WHERE id = ?", id)
+	default:
+		http.Error(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error moderating webmention: %v", err)
+		showMessageAt(w, r, "Failed to update webmention", "error", redirectURL)
+		return
+	}
+	showMessageAt(w, r, "Webmention updated", "success", redirectURL)
+}
+
+// ============================================================================
+// Users
+// ============================================================================
+
+// User is someone who can sign in to the admin area. Owners can do
+// everything; editors publish and edit everyone's posts and change settings;
+// authors write and edit their own posts, which editors publish.
+type User struct {
+	ID                     int
+	Username               string
+	DisplayName            string
+	Email                  string
+	Role                   string // "owner", "editor" or "author"
+	PasswordChangeRequired bool
+	TwoFactorEnabled       bool
+	CreatedAt              time.Time
+}
+
+// UserInvite is an invite link that hasn't been used yet
+type UserInvite struct {
+	ID        int
+	Role      string
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// userRoles are the roles a user can have, most powerful first
+var userRoles = []string{"owner", "editor", "author"}
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_.-]{3,32}$`)
+
+// userInviteLifetime is how long an invite link can be used
+const userInviteLifetime = 7 * 24 * time.Hour
+
+// Name is what the user's posts are credited to
+func (u *User) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Username
+}
+
+// IsOwner reports whether the user manages users, domains and backups
+func (u *User) IsOwner() bool {
+	return u.Role == "owner"
+}
+
+// CanPublish reports whether the user publishes posts and edits everyone's
+func (u *User) CanPublish() bool {
+	return u.Role == "owner" || u.Role == "editor"
+}
+
+// CanChangeSettings reports whether the user changes the site's settings
+func (u *User) CanChangeSettings() bool {
+	return u.Role == "owner" || u.Role == "editor"
+}
+
+const userColumns = "id, username, display_name, email, role, password_change_required, totp_enabled, created_at"
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var u User
+	if err := row.Scan(&u.ID, &u.Username, &u.DisplayName, &u.Email, &u.Role, &u.PasswordChangeRequired, &u.TwoFactorEnabled, &u.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func getUser(id int) (*User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+// getUsers returns all users, owners first
+func getUsers() ([]User, error) {
+	rows, err := db.Query("SELECT " + userColumns + " FROM users ORDER BY CASE role WHEN 'owner' THEN 0 WHEN 'editor' THEN 1 ELSE 2 END, username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		users = append(users, *u)
+	}
+	return users, nil
+}
+
+// authenticateUser returns the user with the given username and password,
+// or nil if they don't match
+func authenticateUser(username, password string) *User {
+	var id int
+	var passwordHash string
+	err := db.QueryRow("SELECT id, password_hash FROM users WHERE username = ?", strings.ToLower(strings.TrimSpace(username))).
+		Scan(&id, &passwordHash)
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		return nil
+	}
+	user, err := getUser(id)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// currentUser returns the signed-in user of the request, or nil
+func currentUser(r *http.Request) *User {
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return nil
+	}
+	session, ok := getSession(cookie.Value)
+	if !ok {
+		return nil
+	}
+	user, err := getUser(session.UserID)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// canEditEntry reports whether the user may change or delete an entry.
+// Authors can only change their own.
+func canEditEntry(user *User, entryID int) bool {
+	if user == nil {
+		return false
+	}
+	if user.CanPublish() {
+		return true
+	}
+	var authorID sql.NullInt64
+	db.QueryRow("SELECT author_id FROM entries WHERE id = ?", entryID).Scan(&authorID)
+	return authorID.Valid && int(authorID.Int64) == user.ID
+}
+
+// limitAuthorStatus keeps users who can't publish from publishing: whatever
+// they save is a draft, so changes to a published entry go back to the
+// drafts until an editor publishes them again
+func limitAuthorStatus(user *User, status string, publishAt *time.Time) (string, *time.Time) {
+	if user.CanPublish() {
+		return status, publishAt
+	}
+	return "draft", nil
+}
+
+// getEntryAuthors returns the names entries are credited to. Blogs with a
+// single user don't credit posts, so the map is empty for them.
+func getEntryAuthors(ids []int) map[int]string {
+	authors := make(map[int]string)
+	var userCount int
+	db.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount)
+	if len(ids) == 0 || userCount < 2 {
+		return authors
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := db.Query(`
+		SELECT e.id, CASE WHEN u.display_name != '' THEN u.display_name ELSE u.username END
+		FROM entries e JOIN users u ON u.id = e.author_id
+		WHERE e.id IN (`+placeholders+`)
+	`, args...)
+	if err != nil {
+		log.Printf("Error fetching entry authors: %v", err)
+		return authors
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryID int
+		var name string
+		if err := rows.Scan(&entryID, &name); err == nil {
+			authors[entryID] = name
+		}
+	}
+	return authors
+}
+
+// validateNewUser checks the username and password of a new account and
+// returns the normalized username, or a message saying what is wrong
+func validateNewUser(username, password, confirmPassword string) (string, string) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if !usernamePattern.MatchString(username) {
+		return "", "Usernames are 3 to 32 letters, digits, dots, dashes, or underscores"
+	}
+	var taken bool
+	db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&taken)
+	if taken {
+		return "", "That username is taken"
+	}
+	if len(password) < 8 {
+		return "", "Password must be at least 8 characters long"
+	}
+	if password != confirmPassword {
+		return "", "Passwords do not match"
+	}
+	return username, ""
+}
+
+// getUserInvites returns the invites that can still be used, newest first
+func getUserInvites() ([]UserInvite, error) {
+	rows, err := db.Query("SELECT id, role, email, created_at, expires_at FROM user_invites WHERE expires_at > ? ORDER BY id DESC",
+		time.Now().UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []UserInvite
+	for rows.Next() {
+		var invite UserInvite
+		if err := rows.Scan(&invite.ID, &invite.Role, &invite.Email, &invite.CreatedAt, &invite.ExpiresAt); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		invites = append(invites, invite)
+	}
+	return invites, nil
+}
+
+// handleInvite lets someone with an invite link create their account
+func handleInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, "/invite/")
+	data := struct {
+		SiteTitle   string
+		Role        string
+		Invalid     bool
+		Error       string
+		Username    string
+		DisplayName string
+	}{SiteTitle: "Blog"}
+	if settings, err := getSiteSettings(); err == nil {
+		data.SiteTitle = settings.SiteTitle
+	}
+
+	var inviteID int
+	var email string
+	err := db.QueryRow("SELECT id, role, email FROM user_invites WHERE token_hash = ? AND expires_at > ?",
+		hashAccessToken(token), time.Now().UTC().Format(sqliteTimeLayout)).Scan(&inviteID, &data.Role, &email)
+	tmpl := template.Must(template.New("invite").Parse(inviteTemplate))
+	if err != nil {
+		data.Invalid = true
+		w.WriteHeader(http.StatusNotFound)
+		tmpl.Execute(w, data)
+		return
+	}
+	if r.Method == http.MethodGet {
+		tmpl.Execute(w, data)
+		return
+	}
+
+	data.Username = r.FormValue("username")
+	data.DisplayName = strings.TrimSpace(r.FormValue("display_name"))
+	if len(data.DisplayName) > 100 {
+		data.DisplayName = data.DisplayName[:100]
+	}
+	username, problem := validateNewUser(data.Username, r.FormValue("password"), r.FormValue("confirm_password"))
+	if problem != "" {
+		data.Error = problem
+		tmpl.Execute(w, data)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(r.FormValue("password")), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Deleting the invite first keeps a link from being used twice
+	result, err := db.Exec("DELETE FROM user_invites WHERE id = ?", inviteID)
+	if err != nil {
+		log.Printf("Error using invite: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		data.Invalid = true
+		tmpl.Execute(w, data)
+		return
+	}
+	userResult, err := db.Exec("INSERT INTO users (username, display_name, email, password_hash, role) VALUES (?, ?, ?, ?, ?)",
+		username, data.DisplayName, email, string(hashedPassword), data.Role)
+	if err != nil {
+		log.Printf("Error creating user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	userID, _ := userResult.LastInsertId()
+	log.Printf("User %s joined as %s", username, data.Role)
+
+	sessionToken, err := createSession(r, int(userID), false)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	setSessionCookie(w, sessionToken, false)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleUserUpdate invites, changes, and removes users from the settings
+func handleUserUpdate(w http.ResponseWriter, r *http.Request, user *User) {
+	switch r.FormValue("action") {
+	case "invite":
+		role := r.FormValue("role")
+		if !slices.Contains(userRoles, role) {
+			showSettingsMessage(w, r, "Choose a role", "error", "users")
+			return
+		}
+		email := strings.TrimSpace(r.FormValue("email"))
+		if len(email) > 254 {
+			email = email[:254]
+		}
+
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			log.Printf("Error generating invite: %v", err)
+			showSettingsMessage(w, r, "Failed to create invite", "error", "users")
+			return
+		}
+		token := base64.RawURLEncoding.EncodeToString(b)
+		_, err := db.Exec("INSERT INTO user_invites (token_hash, role, email, created_by, expires_at) VALUES (?, ?, ?, ?, ?)",
+			hashAccessToken(token), role, email, user.ID, time.Now().UTC().Add(userInviteLifetime).Format(sqliteTimeLayout))
+		if err != nil {
+			log.Printf("Error creating invite: %v", err)
+			showSettingsMessage(w, r, "Failed to create invite", "error", "users")
+			return
+		}
+
+		// The link is shown once on the next page
+		http.SetCookie(w, &http.Cookie{
+			Name:     "flash_invite",
+			Value:    getBaseURL(r) + "/invite/" + token,
+			Path:     "/admin/settings",
+			MaxAge:   60,
+			HttpOnly: true,
+		})
+		showSettingsMessage(w, r, "Invite created", "success", "users")
+	case "revoke_invite":
+		if _, err := db.Exec("DELETE FROM user_invites WHERE id = ?", r.FormValue("invite_id")); err != nil {
+			log.Printf("Error revoking invite: %v", err)
+			showSettingsMessage(w, r, "Failed to revoke invite", "error", "users")
+			return
+		}
+		showSettingsMessage(w, r, "Invite revoked", "success", "users")
+	case "role":
+		id, _ := strconv.Atoi(r.FormValue("user_id"))
+		role := r.FormValue("role")
+		if id == user.ID {
+			showSettingsMessage(w, r, "You can't change your own role", "error", "users")
+			return
+		}
+		if !slices.Contains(userRoles, role) {
+			showSettingsMessage(w, r, "Choose a role", "error", "users")
+			return
+		}
+		if _, err := db.Exec("UPDATE users SET role = ? WHERE id = ?", role, id); err != nil {
+			log.Printf("Error changing role: %v", err)
+			showSettingsMessage(w, r, "Failed to change role", "error", "users")
+			return
+		}
+		// Access tokens act with the full rights of editors, so authors can't
+		// keep theirs
+		if role == "author" {
+			db.Exec("DELETE FROM access_tokens WHERE user_id = ?", id)
+		}
+		showSettingsMessage(w, r, "Role changed", "success", "users")
+	case "delete":
+		id, _ := strconv.Atoi(r.FormValue("user_id"))
+		if id == user.ID {
+			showSettingsMessage(w, r, "You can't delete your own account", "error", "users")
+			return
+		}
+		if _, err := db.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+			log.Printf("Error deleting user: %v", err)
+			showSettingsMessage(w, r, "Failed to delete user", "error", "users")
+			return
+		}
+		// Their posts are kept and move to the owner who removed them
+		db.Exec("UPDATE entries SET author_id = ? WHERE author_id = ?", user.ID, id)
+		db.Exec("DELETE FROM sessions WHERE user_id = ?", id)
+		db.Exec("DELETE FROM access_tokens WHERE user_id = ?", id)
+		db.Exec("DELETE FROM recovery_codes WHERE user_id = ?", id)
+		db.Exec("DELETE FROM passkeys WHERE user_id = ?", id)
+		showSettingsMessage(w, r, "User deleted. Their posts are now yours.", "success", "users")
+	default:
+		showSettingsMessage(w, r, "Invalid action", "error", "users")
+	}
+}
+
+// ============================================================================
+// Two-factor authentication
+// ============================================================================
+
+// Users can require a time-based one-time password (RFC 6238) from an
+// authenticator app on top of their password. Codes are six digits, change
+// every 30 seconds, and are accepted one step either side of now to allow
+// for clock drift. Single-use recovery codes stand in when the app is lost.
+
+const (
+	totpPeriod         = 30 // seconds
+	totpDigits         = 6
+	recoveryCodeCount  = 10
+	pendingLoginExpiry = 5 * time.Minute
+	maxLoginCodeTries  = 5
+)
+
+// pendingLogin is a sign-in whose password was correct and which waits for
+// the second factor. Pending logins expire after five minutes or five wrong
+// codes.
+type pendingLogin struct {
+	UserID    int
+	Remember  bool
+	Redirect  string
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+var pendingLogins = make(map[string]*pendingLogin)
+var pendingLoginsMutex sync.Mutex
+
+// newTOTPSecret returns a random 160-bit secret in base32, the form
+// authenticator apps expect
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// totpCode returns the code for a secret at a time step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000), nil
+}
+
+// totpURI is the otpauth:// link encoded in the enrollment QR code
+func totpURI(issuer, username, secret string) string {
+	label := url.PathEscape(issuer + ":" + username)
+	params := url.Values{
+		"secret": {secret},
+		"issuer": {issuer},
+		"digits": {strconv.Itoa(totpDigits)},
+		"period": {strconv.Itoa(totpPeriod)},
+	}
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// totpQRCode renders an otpauth:// link as a PNG data URL
+func totpQRCode(uri string) (template.URL, error) {
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+}
+
+// checkTOTP reports whether code is valid for the secret right now. Each
+// accepted code uses up its time step for the user, so a code can't be
+// used twice.
+func checkTOTP(userID int, secret, code string) bool {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits || secret == "" {
+		return false
+	}
+	now := time.Now().Unix() / totpPeriod
+	for step := now - 1; step <= now+1; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return false
+		}
+		if !hmac.Equal([]byte(expected), []byte(code)) {
+			continue
+		}
+		result, err := db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+		if err != nil {
+			log.Printf("Error recording two-factor code: %v", err)
+			return false
+		}
+		n, _ := result.RowsAffected()
+		return n == 1
+	}
+	return false
+}
+
+// normalizeRecoveryCode strips the formatting people add when typing a code
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// newRecoveryCodes replaces the user's recovery codes with a fresh set and
+// returns them. Only hashes are stored, so they can be shown just once.
+func newRecoveryCodes(userID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b)) // 8 characters
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashAccessToken(normalizeRecoveryCode(code))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// useRecoveryCode consumes one of the user's recovery codes
+func useRecoveryCode(userID int, code string) bool {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false
+	}
+	result, err := db.Exec("DELETE FROM recovery_codes WHERE user_id = ? AND code_hash = ?", userID, hashAccessToken(code))
+	if err != nil {
+		log.Printf("Error using recovery code: %v", err)
+		return false
+	}
+	n, _ := result.RowsAffected()
+	return n == 1
+}
+
+func countRecoveryCodes(userID int) int {
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?", userID).Scan(&count)
+	return count
+}
+
+// checkSecondFactor accepts either a current authenticator code or an
+// unused recovery code for a user with two-factor authentication on
+func checkSecondFactor(user *User, code string) bool {
+	code = strings.TrimSpace(code)
+	var secret string
+	if err := db.QueryRow("SELECT totp_secret FROM users WHERE id = ?", user.ID).Scan(&secret); err != nil {
+		return false
+	}
+	if checkTOTP(user.ID, secret, code) {
+		return true
+	}
+	if useRecoveryCode(user.ID, code) {
+		log.Printf("Recovery code used by %s (%d left)", user.Username, countRecoveryCodes(user.ID))
+		return true
+	}
+	return false
+}
+
+// startPendingLogin remembers a sign-in waiting for its second factor and
+// returns the token identifying it
+func startPendingLogin(userID int, remember bool, redirect string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	pendingLoginsMutex.Lock()
+	defer pendingLoginsMutex.Unlock()
+	now := time.Now()
+	for key, p := range pendingLogins {
+		if now.After(p.ExpiresAt) {
+			delete(pendingLogins, key)
+		}
+	}
+	pendingLogins[token] = &pendingLogin{
+		UserID:    userID,
+		Remember:  remember,
+		Redirect:  redirect,
+		ExpiresAt: now.Add(pendingLoginExpiry),
+	}
+	return token, nil
+}
+
+// finishPendingLogin checks the second factor of a pending sign-in. It
+// returns the pending login once the code is right; a wrong code counts
+// against the login, and stale or exhausted logins report expired.
+func finishPendingLogin(token, code string) (login *pendingLogin, expired bool) {
+	pendingLoginsMutex.Lock()
+	p, ok := pendingLogins[token]
+	if !ok || time.Now().After(p.ExpiresAt) || p.Attempts >= maxLoginCodeTries {
+		delete(pendingLogins, token)
+		pendingLoginsMutex.Unlock()
+		return nil, true
+	}
+	p.Attempts++
+	pendingLoginsMutex.Unlock()
+
+	user, err := getUser(p.UserID)
+	if err != nil || !checkSecondFactor(user, code) {
+		return nil, false
+	}
+
+	pendingLoginsMutex.Lock()
+	delete(pendingLogins, token)
+	pendingLoginsMutex.Unlock()
+	return p, false
+}
+
+// handleTwoFactorUpdate handles enrolling in, confirming and turning off
+// two-factor authentication, and new recovery codes, for the signed-in user
+func handleTwoFactorUpdate(w http.ResponseWriter, r *http.Request, user *User) {
+	switch r.FormValue("action") {
+	case "setup":
+		if user.TwoFactorEnabled {
+			showSettingsMessage(w, r, "Two-factor authentication is already on", "error", "security")
+			return
+		}
+		secret, err := newTOTPSecret()
+		if err != nil {
+			log.Printf("Error generating two-factor secret: %v", err)
+			showSettingsMessage(w, r, "Failed to set up two-factor authentication", "error", "security")
+			return
+		}
+		if _, err := db.Exec("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?", secret, user.ID); err != nil {
+			log.Printf("Error saving two-factor secret: %v", err)
+			showSettingsMessage(w, r, "Failed to set up two-factor authentication", "error", "security")
+			return
+		}
+		showSettingsMessage(w, r, "Scan the QR code with your authenticator app, then enter the code it shows", "success", "security")
+	case "confirm":
+		var secret string
+		db.QueryRow("SELECT totp_secret FROM users WHERE id = ?", user.ID).Scan(&secret)
+		if user.TwoFactorEnabled || secret == "" {
+			showSettingsMessage(w, r, "Start the setup first", "error", "security")
+			return
+		}
+		if !checkTOTP(user.ID, secret, r.FormValue("code")) {
+			showSettingsMessage(w, r, "That code didn't match. Check your device's clock and try again.", "error", "security")
+			return
+		}
+		if _, err := db.Exec("UPDATE users SET totp_enabled = 1 WHERE id = ?", user.ID); err != nil {
+			log.Printf("Error enabling two-factor authentication: %v", err)
+			showSettingsMessage(w, r, "Failed to turn on two-factor authentication", "error", "security")
+			return
+		}
+		log.Printf("Two-factor authentication turned on for %s", user.Username)
+		showRecoveryCodes(w, r, user, "Two-factor authentication is on")
+	case "cancel":
+		db.Exec("UPDATE users SET totp_secret = '' WHERE id = ? AND totp_enabled = 0", user.ID)
+		showSettingsMessage(w, r, "Two-factor setup cancelled", "success", "security")
+	case "recovery_codes":
+		if !user.TwoFactorEnabled {
+			showSettingsMessage(w, r, "Two-factor authentication is off", "error", "security")
+			return
+		}
+		showRecoveryCodes(w, r, user, "New recovery codes created. The old ones no longer work.")
+	case "disable":
+		if !user.TwoFactorEnabled {
+			showSettingsMessage(w, r, "Two-factor authentication is off", "error", "security")
+			return
+		}
+		if authenticateUser(user.Username, r.FormValue("password")) == nil {
+			showSettingsMessage(w, r, "Incorrect password", "error", "security")
+			return
+		}
+		if err := disableTwoFactor(user.ID); err != nil {
+			log.Printf("Error disabling two-factor authentication: %v", err)
+			showSettingsMessage(w, r, "Failed to turn off two-factor authentication", "error", "security")
+			return
+		}
+		log.Printf("Two-factor authentication turned off for %s", user.Username)
+		showSettingsMessage(w, r, "Two-factor authentication is off", "success", "security")
+	default:
+		showSettingsMessage(w, r, "Invalid action", "error", "security")
+	}
+}
+
+// showRecoveryCodes creates a fresh set of recovery codes and shows them
+// once on the security page
+func showRecoveryCodes(w http.ResponseWriter, r *http.Request, user *User, message string) {
+	codes, err := newRecoveryCodes(user.ID)
+	if err != nil {
+		log.Printf("Error creating recovery codes: %v", err)
+		showSettingsMessage(w, r, "Failed to create recovery codes", "error", "security")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "flash_recovery_codes",
+		Value:    strings.Join(codes, "."),
+		Path:     "/admin/settings",
+		MaxAge:   60,
+		HttpOnly: true,
+	})
+	showSettingsMessage(w, r, message, "success", "security")
+}
+
+// disableTwoFactor turns off two-factor authentication for a user and
+// forgets their secret and recovery codes
+func disableTwoFactor(userID int) error {
+	if _, err := db.Exec("UPDATE users SET totp_enabled = 0, totp_secret = '', totp_last_step = 0 WHERE id = ?", userID); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	return err
+}
+
+// ============================================================================
+// Passkeys (WebAuthn)
+// ============================================================================
+
+// Users can register passkeys and sign in with them instead of a password.
+// The blog is the relying party: its hostname is the RP ID, so a passkey
+// only works on the hostname it was registered on. Attestation isn't
+// requested or checked, so any authenticator can be used. Signing in needs
+// user verification (a PIN or biometric), which is why a passkey sign-in
+// skips the two-factor code.
+
+// Passkey is a WebAuthn credential registered by a user
+type Passkey struct {
+	ID           int
+	UserID       int
+	Name         string
+	CredentialID string // base64url
+	PublicKey    []byte // COSE_Key
+	SignCount    uint32
+	CreatedAt    time.Time
+	LastUsedAt   sql.NullTime
+}
+
+const (
+	webauthnTimeout = 5 * time.Minute
+	// Anyone may start a passkey sign-in, so pending challenges are capped:
+	// each address keeps its newest few, and all of them together at most
+	// maxWebAuthnChallenges
+	webauthnChallengesPerIP = 5
+	maxWebAuthnChallenges   = 10000
+)
+
+// webauthnChallenge is a ceremony waiting for the browser's response.
+// Registration challenges belong to the user adding a passkey; sign-in
+// challenges have no user.
+type webauthnChallenge struct {
+	UserID    int
+	Register  bool
+	IP        string
+	ExpiresAt time.Time
+}
+
+var webauthnChallenges = make(map[string]*webauthnChallenge)
+var webauthnMutex sync.Mutex
+
+// errWebAuthnBusy is returned when too many ceremonies are pending
+var errWebAuthnBusy = fmt.Errorf("too many passkey ceremonies in progress")
+
+// webauthnRelyingParty identifies the blog to authenticators
+type webauthnRelyingParty struct {
+	ID     string // hostname
+	Origin string // scheme://host[:port] the browser reports
+}
+
+func relyingPartyFor(r *http.Request) webauthnRelyingParty {
+	return webauthnRelyingParty{ID: getHostFromRequest(r), Origin: getBaseURL(r)}
+}
+
+// webauthnCredential is what registration yields
+type webauthnCredential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key
+	SignCount uint32
+}
+
+// COSE algorithms offered to authenticators, preferred first
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// Authenticator data flags
+const (
+	authDataUserPresent  = 0x01
+	authDataUserVerified = 0x04
+	authDataAttested     = 0x40
+)
+
+// authenticatorData is the parsed binary authenticator data
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte // registration only
+	PublicKey    []byte // registration only, COSE_Key
+}
+
+// decodeCBOR decodes one CBOR item from the start of data, returning the
+// item and the number of bytes it used. It supports what WebAuthn needs:
+// integers, byte and text strings, arrays, maps and simple values. Maps
+// decode to map[interface{}]interface{} with int64 or string keys.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	if len(data) == 0 {
+		return nil, 0, fmt.Errorf("unexpected end of CBOR data")
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	n := 1
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(data) < n+size {
+			return nil, 0, fmt.Errorf("unexpected end of CBOR data")
+		}
+		for _, b := range data[n : n+size] {
+			arg = arg<<8 | uint64(b)
+		}
+		n += size
+	default:
+		return nil, 0, fmt.Errorf("unsupported CBOR length encoding")
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, 0, fmt.Errorf("CBOR integer out of range")
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, 0, fmt.Errorf("CBOR integer out of range")
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if uint64(len(data)-n) < arg {
+			return nil, 0, fmt.Errorf("unexpected end of CBOR data")
+		}
+		b := data[n : n+int(arg)]
+		if major == 3 {
+			return string(b), n + int(arg), nil
+		}
+		return b, n + int(arg), nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, 0, fmt.Errorf("CBOR array too long")
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, used, err := decodeCBOR(data[n:])
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			n += used
+		}
+		return items, n, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, 0, fmt.Errorf("CBOR map too long")
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, used, err := decodeCBOR(data[n:])
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, fmt.Errorf("unsupported CBOR map key")
+			}
+			value, used, err := decodeCBOR(data[n:])
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			m[key] = value
+		}
+		return m, n, nil
+	case 7:
+		switch info {
+		case 20:
+			return false, n, nil
+		case 21:
+			return true, n, nil
+		case 22, 23:
+			return nil, n, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("unsupported CBOR item")
+}
+
+// parseAuthenticatorData parses authenticator data, including the attested
+// credential when the AT flag is set
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("authenticator data too short")
+	}
+	ad := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if ad.Flags&authDataAttested == 0 {
+		return ad, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, fmt.Errorf("attested credential data too short")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18])) // after the 16-byte AAGUID
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, fmt.Errorf("credential ID too short")
+	}
+	ad.CredentialID = rest[:idLength]
+	_, used, err := decodeCBOR(rest[idLength:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential public key: %v", err)
+	}
+	ad.PublicKey = rest[idLength : idLength+used]
+	return ad, nil
+}
+
+// parseCOSEKey turns a COSE_Key into a Go public key and its algorithm
+func parseCOSEKey(data []byte) (crypto.PublicKey, int64, error) {
+	item, _, err := decodeCBOR(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	key, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("COSE key is not a map")
+	}
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+	param := func(label int64) []byte {
+		b, _ := key[label].([]byte)
+		return b
+	}
+
+	switch {
+	case kty == 2 && alg == coseAlgES256:
+		if crv, _ := key[int64(-1)].(int64); crv != 1 {
+			return nil, 0, fmt.Errorf("unsupported EC2 curve")
+		}
+		x, y := param(-2), param(-3)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("invalid EC2 key")
+		}
+		pub, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid EC2 key: %v", err)
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub.Bytes()[1:33]),
+			Y:     new(big.Int).SetBytes(pub.Bytes()[33:]),
+		}, alg, nil
+	case kty == 1 && alg == coseAlgEdDSA:
+		if crv, _ := key[int64(-1)].(int64); crv != 6 {
+			return nil, 0, fmt.Errorf("unsupported OKP curve")
+		}
+		x := param(-2)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case kty == 3 && alg == coseAlgRS256:
+		n, e := param(-1), param(-2)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("invalid RSA key")
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported key type %d with algorithm %d", kty, alg)
+}
+
+// verifyCOSESignature checks a signature made with a COSE_Key's private key
+func verifyCOSESignature(coseKey, signed, signature []byte) error {
+	pub, alg, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case coseAlgES256:
+		if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	case coseAlgEdDSA:
+		if !ed25519.Verify(pub.(ed25519.PublicKey), signed, signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	case coseAlgRS256:
+		if err := rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+	}
+	return nil
+}
+
+// verifyClientData checks the client data the browser signed over
+func verifyClientData(rp webauthnRelyingParty, clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var clientData struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return fmt.Errorf("invalid client data")
+	}
+	if clientData.Type != ceremony {
+		return fmt.Errorf("wrong ceremony type %q", clientData.Type)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(clientData.Challenge, "="))
+	if err != nil || !hmac.Equal(got, challenge) {
+		return fmt.Errorf("challenge mismatch")
+	}
+	if clientData.Origin != rp.Origin {
+		return fmt.Errorf("origin %q doesn't match %q", clientData.Origin, rp.Origin)
+	}
+	return nil
+}
+
+// verifyWebAuthnRegistration checks a navigator.credentials.create()
+// response against the challenge it answers and returns the new credential.
+// It doesn't use the database, so it can be checked against recorded
+// responses.
+func verifyWebAuthnRegistration(rp webauthnRelyingParty, challenge, clientDataJSON, attestationObject []byte) (*webauthnCredential, error) {
+	if err := verifyClientData(rp, clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	item, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object: %v", err)
+	}
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid attestation object")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("attestation object has no authenticator data")
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !hmac.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return nil, fmt.Errorf("credential is for a different site")
+	}
+	if authData.Flags&authDataUserPresent == 0 {
+		return nil, fmt.Errorf("user wasn't present")
+	}
+	if authData.Flags&authDataAttested == 0 || len(authData.CredentialID) == 0 {
+		return nil, fmt.Errorf("no credential in response")
+	}
+	if _, _, err := parseCOSEKey(authData.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return &webauthnCredential{
+		ID:        authData.CredentialID,
+		PublicKey: authData.PublicKey,
+		SignCount: authData.SignCount,
+	}, nil
+}
+
+// verifyWebAuthnAssertion checks a navigator.credentials.get() response
+// made with a stored credential and returns the authenticator's new
+// signature counter. Like registration, it doesn't touch the database.
+func verifyWebAuthnAssertion(rp webauthnRelyingParty, challenge, publicKey []byte, storedSignCount uint32, clientDataJSON, rawAuthData, signature []byte) (uint32, error) {
+	if err := verifyClientData(rp, clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !hmac.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return 0, fmt.Errorf("credential is for a different site")
+	}
+	if authData.Flags&authDataUserPresent == 0 || authData.Flags&authDataUserVerified == 0 {
+		return 0, fmt.Errorf("user wasn't verified")
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	if err := verifyCOSESignature(publicKey, signed, signature); err != nil {
+		return 0, err
+	}
+
+	// A counter that doesn't move forward points to a cloned authenticator.
+	// Authenticators that don't count always report zero.
+	if (authData.SignCount != 0 || storedSignCount != 0) && authData.SignCount <= storedSignCount {
+		return 0, fmt.Errorf("signature counter went backwards")
+	}
+	return authData.SignCount, nil
+}
+
+// newWebAuthnChallenge creates and remembers a random challenge
+func newWebAuthnChallenge(userID int, register bool, ip string) ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	webauthnMutex.Lock()
+	defer webauthnMutex.Unlock()
+	now := time.Now()
+	fromIP, oldest := 0, ""
+	for key, c := range webauthnChallenges {
+		if now.After(c.ExpiresAt) {
+			delete(webauthnChallenges, key)
+			continue
+		}
+		if c.IP == ip {
+			fromIP++
+			if oldest == "" || c.ExpiresAt.Before(webauthnChallenges[oldest].ExpiresAt) {
+				oldest = key
+			}
+		}
+	}
+	// A new ceremony from the same address replaces its oldest one
+	if fromIP >= webauthnChallengesPerIP {
+		delete(webauthnChallenges, oldest)
+	}
+	if len(webauthnChallenges) >= maxWebAuthnChallenges {
+		return nil, errWebAuthnBusy
+	}
+	webauthnChallenges[string(challenge)] = &webauthnChallenge{
+		UserID:    userID,
+		Register:  register,
+		IP:        ip,
+		ExpiresAt: now.Add(webauthnTimeout),
+	}
+	return challenge, nil
+}
+
+// takeWebAuthnChallenge finds the challenge the browser's client data
+// answers and uses it up. The signature over the client data is checked
+// afterwards, so a forged challenge only wastes a lookup.
+func takeWebAuthnChallenge(clientDataJSON []byte, register bool) ([]byte, *webauthnChallenge, bool) {
+	var clientData struct {
+		Challenge string `json:"challenge"`
+	}
+	if json.Unmarshal(clientDataJSON, &clientData) != nil {
+		return nil, nil, false
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(clientData.Challenge, "="))
+	if err != nil {
+		return nil, nil, false
+	}
+
+	webauthnMutex.Lock()
+	defer webauthnMutex.Unlock()
+	c, ok := webauthnChallenges[string(challenge)]
+	delete(webauthnChallenges, string(challenge))
+	if !ok || time.Now().After(c.ExpiresAt) || c.Register != register {
+		return nil, nil, false
+	}
+	return challenge, c, true
+}
+
+// webauthnUserHandle is the opaque user ID given to authenticators
+func webauthnUserHandle(userID int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(userID))
+	return b
+}
+
+func getPasskeys(userID int) ([]Passkey, error) {
+	rows, err := db.Query(`SELECT id, user_id, name, credential_id, public_key, sign_count, created_at, last_used_at
+		FROM passkeys WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []Passkey
+	for rows.Next() {
+		var p Passkey
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Name, &p.CredentialID, &p.PublicKey, &p.SignCount, &p.CreatedAt, &p.LastUsedAt); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		passkeys = append(passkeys, p)
+	}
+	return passkeys, nil
+}
+
+// webauthnResponse is a PublicKeyCredential as the admin pages' script
+// posts it, with binary fields in base64url
+type webauthnResponse struct {
+	ID       string `json:"id"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+	Name     string `json:"name"`     // registration
+	Remember bool   `json:"remember"` // sign-in
+	Redirect string `json:"redirect"` // sign-in
+}
+
+func decodeBase64URL(s string) []byte {
+	b, _ := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	return b
+}
+
+// handlePasskeyRegisterBegin returns the options for
+// navigator.credentials.create() to add a passkey for the signed-in user
+func handlePasskeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := currentUser(r)
+	challenge, err := newWebAuthnChallenge(user.ID, true, requestIP(r))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to start registration")
+		return
+	}
+
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error getting site settings: %v", err)
+	}
+	passkeys, err := getPasskeys(user.ID)
+	if err != nil {
+		log.Printf("Error fetching passkeys: %v", err)
+	}
+	exclude := []map[string]string{}
+	for _, p := range passkeys {
+		exclude = append(exclude, map[string]string{"type": "public-key", "id": p.CredentialID})
+	}
+
+	rp := relyingPartyFor(r)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"rp":        map[string]string{"id": rp.ID, "name": settings.SiteTitle},
+		"user": map[string]string{
+			"id":          base64.RawURLEncoding.EncodeToString(webauthnUserHandle(user.ID)),
+			"name":        user.Username,
+			"displayName": user.Name(),
+		},
+		"pubKeyCredParams": []map[string]interface{}{
+			{"type": "public-key", "alg": coseAlgES256},
+			{"type": "public-key", "alg": coseAlgEdDSA},
+			{"type": "public-key", "alg": coseAlgRS256},
+		},
+		"timeout":            webauthnTimeout.Milliseconds(),
+		"attestation":        "none",
+		"excludeCredentials": exclude,
+		"authenticatorSelection": map[string]string{
+			"residentKey":      "required",
+			"userVerification": "required",
+		},
+	})
+}
+
+// handlePasskeyRegisterFinish verifies and stores a new passkey
+func handlePasskeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := currentUser(r)
+
+	var response webauthnResponse
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&response); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON")
+		return
+	}
+	clientDataJSON := decodeBase64URL(response.Response.ClientDataJSON)
+	challenge, pending, ok := takeWebAuthnChallenge(clientDataJSON, true)
+	if !ok || pending.UserID != user.ID {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "The request expired. Please try again.")
+		return
+	}
+
+	credential, err := verifyWebAuthnRegistration(relyingPartyFor(r), challenge, clientDataJSON, decodeBase64URL(response.Response.AttestationObject))
+	if err != nil {
+		log.Printf("Passkey registration failed for %s: %v", user.Username, err)
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "The passkey couldn't be verified")
+		return
+	}
+
+	name := strings.TrimSpace(response.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	_, err = db.Exec("INSERT INTO passkeys (user_id, name, credential_id, public_key, sign_count) VALUES (?, ?, ?, ?, ?)",
+		user.ID, name, base64.RawURLEncoding.EncodeToString(credential.ID), credential.PublicKey, credential.SignCount)
+	if err != nil {
+		log.Printf("Error saving passkey: %v", err)
+		writeJSONError(w, http.StatusConflict, "invalid_request", "This passkey is already registered")
+		return
+	}
+	log.Printf("Passkey %q added for %s", name, user.Username)
+	writeJSON(w, http.StatusCreated, map[string]string{"name": name})
+}
+
+// handlePasskeyLoginBegin returns the options for navigator.credentials.get()
+// on the login page. No credentials are listed, so the browser offers the
+// passkeys it has for this site.
+func handlePasskeyLoginBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	challenge, err := newWebAuthnChallenge(0, false, requestIP(r))
+	if err == errWebAuthnBusy {
+		w.Header().Set("Retry-After", "60")
+		writeJSONError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "Too many sign-ins in progress, try again in a minute")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to start sign-in")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"challenge":        base64.RawURLEncoding.EncodeToString(challenge),
+		"rpId":             relyingPartyFor(r).ID,
+		"timeout":          webauthnTimeout.Milliseconds(),
+		"userVerification": "required",
+	})
+}
 
-        .stat:hover {
-            transform: translateY(-2px);
-            box-shadow: 0 2px 8px %[7]s;
-        }
+// handlePasskeyLoginFinish verifies a passkey sign-in and starts a session
+func handlePasskeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-        .stat-value {
-            font-size: 32px;
-            font-weight: 700;
-            color: %[2]s;
-            margin-bottom: 4px;
-        }
+	var response webauthnResponse
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&response); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON")
+		return
+	}
+	clientDataJSON := decodeBase64URL(response.Response.ClientDataJSON)
+	challenge, _, ok := takeWebAuthnChallenge(clientDataJSON, false)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "The sign-in expired. Please try again.")
+		return
+	}
 
-        .stat-label {
-            font-size: 13px;
-            color: %[5]s;
-            text-transform: uppercase;
-            font-weight: 500;
-            letter-spacing: 0.5px;
-        }
+	var passkey Passkey
+	err := db.QueryRow("SELECT id, user_id, name, public_key, sign_count FROM passkeys WHERE credential_id = ?", strings.TrimRight(response.ID, "=")).
+		Scan(&passkey.ID, &passkey.UserID, &passkey.Name, &passkey.PublicKey, &passkey.SignCount)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "access_denied", "This passkey isn't registered here")
+		return
+	}
+	if handle := decodeBase64URL(response.Response.UserHandle); len(handle) > 0 && !hmac.Equal(handle, webauthnUserHandle(passkey.UserID)) {
+		writeJSONError(w, http.StatusUnauthorized, "access_denied", "This passkey isn't registered here")
+		return
+	}
 
-        .feed {
-            padding: 24px 0 80px;
-        }
+	signCount, err := verifyWebAuthnAssertion(relyingPartyFor(r), challenge, passkey.PublicKey, passkey.SignCount,
+		clientDataJSON, decodeBase64URL(response.Response.AuthenticatorData), decodeBase64URL(response.Response.Signature))
+	if err != nil {
+		log.Printf("Passkey sign-in failed for passkey %d: %v", passkey.ID, err)
+		writeJSONError(w, http.StatusUnauthorized, "access_denied", "The passkey couldn't be verified")
+		return
+	}
+	db.Exec("UPDATE passkeys SET sign_count = ?, last_used_at = ? WHERE id = ?",
+		signCount, time.Now().UTC().Format(sqliteTimeLayout), passkey.ID)
 
-        .entry {
-            padding: 0;
-            margin-bottom: 24px;
-            background-color: %[6]s;
-            border: 1px solid %[4]s;
-            border-radius: 8px;
-            cursor: pointer;
-            transition: transform 0.2s, box-shadow 0.2s;
-            display: flex;
-            flex-direction: column;
-        }
+	token, err := createSession(r, passkey.UserID, response.Remember)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to sign in")
+		return
+	}
+	setSessionCookie(w, token, response.Remember)
 
-        .entry:hover {
-            transform: translateY(-2px);
-            box-shadow: 0 4px 12px %[7]s;
-        }
+	redirect := response.Redirect
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/admin"
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"redirect": redirect})
+}
 
-        .entry-header {
-            display: flex;
-            align-items: center;
-            padding: 14px 16px;
-            border-bottom: 1px solid %[8]s;
-        }
+// handlePasskeyUpdate renames and deletes the signed-in user's passkeys
+func handlePasskeyUpdate(w http.ResponseWriter, r *http.Request, user *User) {
+	id := r.FormValue("passkey_id")
+	switch r.FormValue("action") {
+	case "rename":
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			showSettingsMessage(w, r, "Name cannot be empty", "error", "security")
+			return
+		}
+		if len(name) > 100 {
+			name = name[:100]
+		}
+		if _, err := db.Exec("UPDATE passkeys SET name = ? WHERE id = ? AND user_id = ?", name, id, user.ID); err != nil {
+			log.Printf("Error renaming passkey: %v", err)
+			showSettingsMessage(w, r, "Failed to rename passkey", "error", "security")
+			return
+		}
+		showSettingsMessage(w, r, "Passkey renamed", "success", "security")
+	case "delete":
+		if _, err := db.Exec("DELETE FROM passkeys WHERE id = ? AND user_id = ?", id, user.ID); err != nil {
+			log.Printf("Error deleting passkey: %v", err)
+			showSettingsMessage(w, r, "Failed to delete passkey", "error", "security")
+			return
+		}
+		showSettingsMessage(w, r, "Passkey deleted", "success", "security")
+	default:
+		showSettingsMessage(w, r, "Invalid action", "error", "security")
+	}
+}
 
-        .avatar {
-            width: 56px;
-            height: 56px;
-            border-radius: 50%%;
-            background: %[9]s;
-            display: flex;
-            align-items: center;
-            justify-content: center;
-            font-weight: 600;
-            font-size: 20px;
-            color: #ffffff;
-            margin-right: 16px;
-            flex-shrink: 0;
-            padding: 3px;
-        }
+// ============================================================================
+// Sign-in throttling
+// ============================================================================
+
+// Password forms guarded by the throttle. Each is throttled separately, so a
+// flood of guesses at the viewer password doesn't lock admins out.
+const (
+	loginFormAdmin  = "admin"  // admin sign-in, its two-factor step and IndieAuth approval
+	loginFormViewer = "viewer" // the viewer password
+)
+
+const (
+	// loginFreeAttempts failures from an address are allowed before it has
+	// to wait between attempts. The wait starts at loginBaseDelay and
+	// doubles with each further failure.
+	loginFreeAttempts = 3
+	loginBaseDelay    = time.Second
+	// loginLockoutAttempts failures in a row lock the address out
+	loginLockoutAttempts = 10
+	loginLockoutDuration = 30 * time.Minute
+	// loginFailureMemory is how long an address's failures count against it
+	loginFailureMemory = 24 * time.Hour
+	// Once more than loginGlobalLimit failures from all addresses together
+	// happen within loginGlobalWindow, the form takes one attempt per
+	// loginGlobalDelay until things calm down
+	loginGlobalLimit  = 100
+	loginGlobalWindow = 15 * time.Minute
+	loginGlobalDelay  = 5 * time.Second
+	// failedLoginRetention is how long failed attempts stay in the log
+	failedLoginRetention = 30 * 24 * time.Hour
+)
+
+// loginFailures tracks consecutive failures from one address on one form.
+// InFlight counts attempts let through whose outcome isn't known yet, so a
+// burst of parallel requests can't all slip in before the first failure is
+// recorded.
+type loginFailures struct {
+	Count       int
+	InFlight    int
+	LastFailure time.Time
+}
+
+var (
+	loginFailuresByIP   = make(map[string]*loginFailures) // keyed by form and address
+	loginGlobalFailures = make(map[string][]time.Time)    // keyed by form
+	loginGlobalNextTry  = make(map[string]time.Time)      // keyed by form
+	loginThrottleMutex  sync.Mutex
+)
+
+// FailedLogin is an entry in the log of failed sign-ins
+type FailedLogin struct {
+	ID        int
+	Form      string
+	Username  string
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+// LoginLockout is an address that has to wait before trying a form again
+type LoginLockout struct {
+	Form     string
+	IP       string
+	Failures int
+	Until    time.Time
+}
+
+func loginThrottleKey(form, ip string) string {
+	return form + " " + ip
+}
+
+// loginDelay is how long to wait after the last of a run of failures. An
+// address forwarding for other clients is never locked out, since that would
+// lock out everyone behind it; its wait stops growing at the last step
+// before the lockout instead.
+func loginDelay(failures int, forwarder bool) time.Duration {
+	switch {
+	case failures < loginFreeAttempts:
+		return 0
+	case failures >= loginLockoutAttempts && forwarder:
+		return loginBaseDelay << (loginLockoutAttempts - 1 - loginFreeAttempts)
+	case failures >= loginLockoutAttempts:
+		return loginLockoutDuration
+	}
+	return loginBaseDelay << (failures - loginFreeAttempts)
+}
+
+// recentGlobalFailures drops failures older than loginGlobalWindow and
+// returns the rest. The caller holds loginThrottleMutex.
+func recentGlobalFailures(form string, now time.Time) []time.Time {
+	failures := loginGlobalFailures[form]
+	i := 0
+	for i < len(failures) && now.Sub(failures[i]) > loginGlobalWindow {
+		i++
+	}
+	failures = failures[i:]
+	loginGlobalFailures[form] = failures
+	return failures
+}
+
+// loginWait returns how long the client of the request has to wait before it
+// may try the form's password, or zero when it may try now. Passing while
+// the whole form is throttled uses up the next attempt.
+//
+// An attempt let through is reserved as if it had failed until the caller
+// records its outcome and calls releaseLoginAttempt.
+func loginWait(r *http.Request, form string) time.Duration {
+	loginThrottleMutex.Lock()
+	defer loginThrottleMutex.Unlock()
+
+	now := time.Now()
+	ip := requestIP(r)
+	key := loginThrottleKey(form, ip)
+	f := loginFailuresByIP[key]
+	if f == nil {
+		f = &loginFailures{}
+		loginFailuresByIP[key] = f
+	}
+	if f.InFlight == 0 && staleLoginFailures(f, now) {
+		f.Count = 0
+	}
+	lastFailure := f.LastFailure
+	if f.InFlight > 0 {
+		lastFailure = now
+	}
+	if wait := lastFailure.Add(loginDelay(f.Count+f.InFlight, isForwardingPeer(ip))).Sub(now); wait > 0 {
+		return wait
+	}
+
+	if len(recentGlobalFailures(form, now)) >= loginGlobalLimit {
+		if wait := loginGlobalNextTry[form].Sub(now); wait > 0 {
+			return wait
+		}
+		loginGlobalNextTry[form] = now.Add(loginGlobalDelay)
+	}
+	f.InFlight++
+	return 0
+}
+
+// releaseLoginAttempt ends an attempt loginWait let through, once its failure
+// or success has been recorded
+func releaseLoginAttempt(r *http.Request, form string) {
+	loginThrottleMutex.Lock()
+	defer loginThrottleMutex.Unlock()
+	if f := loginFailuresByIP[loginThrottleKey(form, requestIP(r))]; f != nil && f.InFlight > 0 {
+		f.InFlight--
+	}
+}
+
+// staleLoginFailures reports whether failures are old enough to forget
+func staleLoginFailures(f *loginFailures, now time.Time) bool {
+	return now.Sub(f.LastFailure) > loginFailureMemory && now.Sub(f.LastFailure) > loginDelay(f.Count, false)
+}
+
+// recordLoginFailure counts a wrong password or code against the client of
+// the request and adds it to the log of failed sign-ins
+func recordLoginFailure(r *http.Request, form, username string) {
+	ip := requestIP(r)
+	now := time.Now()
+
+	loginThrottleMutex.Lock()
+	key := loginThrottleKey(form, ip)
+	f := loginFailuresByIP[key]
+	if f == nil {
+		f = &loginFailures{}
+		loginFailuresByIP[key] = f
+	} else if now.Sub(f.LastFailure) > loginFailureMemory {
+		f.Count = 0
+	}
+	f.Count++
+	f.LastFailure = now
+	count := f.Count
+	global := append(recentGlobalFailures(form, now), now)
+	loginGlobalFailures[form] = global
+	loginThrottleMutex.Unlock()
+
+	if count == loginLockoutAttempts && !isForwardingPeer(ip) {
+		log.Printf("Sign-in: %s locked out of the %s form for %v after %d failed attempts", ip, form, loginLockoutDuration, count)
+	}
+	if len(global) == loginGlobalLimit {
+		log.Printf("Sign-in: %d failed attempts on the %s form in %v, slowing it down for everyone", len(global), form, loginGlobalWindow)
+	}
+
+	if len(username) > 100 {
+		username = username[:100]
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > 300 {
+		userAgent = userAgent[:300]
+	}
+	_, err := db.Exec(`
+		INSERT INTO failed_logins (form, username, ip, user_agent, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, form, username, ip, userAgent, now.UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		log.Printf("Error logging failed sign-in: %v", err)
+	}
+}
+
+// recordLoginSuccess clears the failures of the client of the request
+func recordLoginSuccess(r *http.Request, form string) {
+	loginThrottleMutex.Lock()
+	defer loginThrottleMutex.Unlock()
+	key := loginThrottleKey(form, requestIP(r))
+	if f := loginFailuresByIP[key]; f != nil && f.InFlight > 0 {
+		f.Count = 0
+	} else {
+		delete(loginFailuresByIP, key)
+	}
+}
+
+// rejectLoginAttempt sets Retry-After for a throttled attempt and returns the
+// message to show on the form. The caller responds with 429 Too Many Requests.
+func rejectLoginAttempt(w http.ResponseWriter, wait time.Duration) string {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	count, unit := seconds, "second"
+	if seconds >= 60 {
+		count, unit = (seconds+59)/60, "minute"
+	}
+	if count != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("Too many failed attempts. Try again in %d %s.", count, unit)
+}
+
+// getLoginLockouts returns the addresses that currently have to wait before
+// trying again, the longest wait first
+func getLoginLockouts() []LoginLockout {
+	loginThrottleMutex.Lock()
+	defer loginThrottleMutex.Unlock()
+
+	now := time.Now()
+	var lockouts []LoginLockout
+	for key, f := range loginFailuresByIP {
+		form, ip, _ := strings.Cut(key, " ")
+		until := f.LastFailure.Add(loginDelay(f.Count, isForwardingPeer(ip)))
+		if !until.After(now) {
+			continue
+		}
+		lockouts = append(lockouts, LoginLockout{Form: form, IP: ip, Failures: f.Count, Until: until})
+	}
+	slices.SortFunc(lockouts, func(a, b LoginLockout) int {
+		return b.Until.Compare(a.Until)
+	})
+	return lockouts
+}
+
+// getFailedLogins returns the most recent failed sign-ins
+func getFailedLogins(limit int) ([]FailedLogin, error) {
+	rows, err := db.Query(`
+		SELECT id, form, username, ip, user_agent, created_at
+		FROM failed_logins ORDER BY created_at DESC, id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failures []FailedLogin
+	for rows.Next() {
+		var f FailedLogin
+		if err := rows.Scan(&f.ID, &f.Form, &f.Username, &f.IP, &f.UserAgent, &f.CreatedAt); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		failures = append(failures, f)
+	}
+	return failures, nil
+}
+
+// cleanupLoginFailures forgets old failures, in memory and in the log
+func cleanupLoginFailures() {
+	now := time.Now()
+	loginThrottleMutex.Lock()
+	for key, f := range loginFailuresByIP {
+		if f.InFlight == 0 && staleLoginFailures(f, now) {
+			delete(loginFailuresByIP, key)
+		}
+	}
+	for form := range loginGlobalFailures {
+		recentGlobalFailures(form, now)
+	}
+	loginThrottleMutex.Unlock()
+
+	forwardingPeersMutex.Lock()
+	for addr, lastSeen := range forwardingPeers {
+		if now.Sub(lastSeen) > loginFailureMemory {
+			delete(forwardingPeers, addr)
+		}
+	}
+	forwardingPeersMutex.Unlock()
+
+	cutoff := now.Add(-failedLoginRetention).UTC().Format(sqliteTimeLayout)
+	if _, err := db.Exec("DELETE FROM failed_logins WHERE created_at < ?", cutoff); err != nil {
+		log.Printf("Error removing old failed sign-ins: %v", err)
+	}
+}
+
+// handleLoginAttemptsUpdate unblocks an address, or clears the log of failed sign-ins
+func handleLoginAttemptsUpdate(w http.ResponseWriter, r *http.Request, user *User) {
+	switch r.FormValue("action") {
+	case "unlock":
+		loginThrottleMutex.Lock()
+		delete(loginFailuresByIP, loginThrottleKey(r.FormValue("form"), r.FormValue("ip")))
+		loginThrottleMutex.Unlock()
+		log.Printf("Sign-in: %s unblocked %s", user.Username, r.FormValue("ip"))
+		showSettingsMessage(w, r, "Address unblocked", "success", "security")
+	case "clear":
+		if _, err := db.Exec("DELETE FROM failed_logins"); err != nil {
+			log.Printf("Error clearing failed sign-ins: %v", err)
+			showSettingsMessage(w, r, "Failed to clear the log", "error", "security")
+			return
+		}
+		showSettingsMessage(w, r, "Failed sign-in log cleared", "success", "security")
+	default:
+		showSettingsMessage(w, r, "Invalid action", "error", "security")
+	}
+}
+
+// ============================================================================
+// CSRF protection
+// ============================================================================
+
+// csrfToken returns the token that admin forms posted with the request's
+// session must carry. It is derived from the session token, so each session
+// has its own and it stops working at sign-out.
+func csrfToken(r *http.Request) string {
+	cookie, err := r.Cookie("session_token")
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	key, err := getCookieSigningKey()
+	if err != nil {
+		log.Printf("Error loading cookie signing key: %v", err)
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("csrf:" + cookie.Value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validCSRFToken reports whether the request carries its session's token,
+// in the X-CSRF-Token header or the csrf_token form field
+func validCSRFToken(r *http.Request) bool {
+	expected := csrfToken(r)
+	if expected == "" {
+		return false
+	}
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.PostFormValue("csrf_token")
+	}
+	return hmac.Equal([]byte(token), []byte(expected))
+}
+
+// sameOriginRequest reports whether the Origin header, or the Referer when
+// there is no Origin, names this site. Browsers and privacy tools that send
+// neither are let through to the token check.
+func sameOriginRequest(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+		if source == "" {
+			return true
+		}
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// checkCSRF rejects a POST that came from another site or lacks its
+// session's token, and reports whether the request may go ahead
+func checkCSRF(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return true
+	}
+
+	message := ""
+	switch {
+	case !sameOriginRequest(r):
+		log.Printf("CSRF: rejected %s %s from %s, origin %q, referer %q", r.Method, r.URL.Path, requestIP(r), r.Header.Get("Origin"), r.Header.Get("Referer"))
+		message = "This request was sent from another website, so it was blocked to protect your blog. If you meant to do this, go back to the admin area and try again from there."
+	case !validCSRFToken(r):
+		log.Printf("CSRF: rejected %s %s from %s, missing or wrong token", r.Method, r.URL.Path, requestIP(r))
+		message = "The page you submitted from has expired, or was opened before you last signed in. Go back, reload the page and try again."
+	default:
+		return true
+	}
+
+	tmpl := template.Must(template.New("forbidden").Parse(forbiddenTemplate))
+	w.WriteHeader(http.StatusForbidden)
+	tmpl.Execute(w, struct{ Message string }{message})
+	return false
+}
+
+// ============================================================================
+// Access tokens
+// ============================================================================
+
+// AccessToken is a bearer token that lets an app post on the admin's behalf.
+// Only a hash of the token is stored.
+type AccessToken struct {
+	ID         int
+	UserID     int    // posts created with the token are credited to this user
+	Username   string // of UserID, in the settings list
+	Name       string
+	Scope      string // space-separated
+	ClientID   string // IndieAuth client the token was issued to, if any
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+}
+
+// accessTokenScopes are the scopes a token can be granted in the settings.
+// Micropub uses create, update, delete and media. The admin API needs api on
+// top of the scope of each operation, and also uses read and settings.
+var accessTokenScopes = []string{"create", "update", "delete", "media", "read", "settings", "api"}
+
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAccessToken stores a new token of a user and returns its value, which
+// is only shown once. clientID is empty for tokens created in the settings.
+func createAccessToken(name, scope, clientID string, userID int) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	_, err := db.Exec("INSERT INTO access_tokens (token_hash, name, scope, client_id, user_id) VALUES (?, ?, ?, ?, ?)",
+		hashAccessToken(token), name, scope, clientID, userID)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// getAccessTokens returns the tokens a user manages, newest first: their own,
+// or everyone's for owners
+func getAccessTokens(user *User) ([]AccessToken, error) {
+	rows, err := db.Query(`
+		SELECT t.id, COALESCE(t.user_id, 0), COALESCE(u.username, ''), t.name, t.scope, t.client_id, t.created_at, t.last_used_at
+		FROM access_tokens t LEFT JOIN users u ON u.id = t.user_id
+		WHERE t.user_id = ? OR ?
+		ORDER BY t.created_at DESC, t.id DESC
+	`, user.ID, user.IsOwner())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []AccessToken
+	for rows.Next() {
+		var t AccessToken
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &t.Scope, &t.ClientID, &t.CreatedAt, &t.LastUsedAt); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
+
+// maxTokenRequestSize bounds the body of requests made with access tokens:
+// the largest upload, a video, plus a thumbnail and the other fields
+const maxTokenRequestSize = 64 << 20
+
+// limitTokenRequest caps the body of a request authenticated by access token
+// before anything reads it
+func limitTokenRequest(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxTokenRequestSize)
+}
+
+// authenticateAccessToken returns the token presented in the Authorization
+// header or the access_token form field, or nil if it is missing or unknown.
+// The body is only parsed when there is no header, and callers limit it with
+// limitTokenRequest first.
+func authenticateAccessToken(r *http.Request) *AccessToken {
+	if r.Header.Get("Authorization") == "" {
+		r.ParseMultipartForm(10 << 20)
+		return lookupAccessToken(r.FormValue("access_token"))
+	}
+	return authenticateBearerToken(r)
+}
 
-        .avatar-inner {
-            width: 100%%;
-            height: 100%%;
-            background: %[6]s;
-            border-radius: 50%%;
-            display: flex;
-            align-items: center;
-            justify-content: center;
-            color: %[2]s;
-        }
+// authenticateBearerToken returns the token presented in the Authorization
+// header, or nil if it is missing or unknown
+func authenticateBearerToken(r *http.Request) *AccessToken {
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return nil
+	}
+	return lookupAccessToken(strings.TrimSpace(auth[7:]))
+}
 
-        .entry-info {
-            flex: 1;
-        }
+// lookupAccessToken returns the stored token with the given value and marks
+// it used, or nil if there is none
+func lookupAccessToken(token string) *AccessToken {
+	if token == "" {
+		return nil
+	}
 
-        .username {
-            display: none;
-        }
+	var t AccessToken
+	err := db.QueryRow("SELECT id, COALESCE(user_id, 0), name, scope, client_id, created_at, last_used_at FROM access_tokens WHERE token_hash = ?", hashAccessToken(token)).
+		Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.ClientID, &t.CreatedAt, &t.LastUsedAt)
+	if err != nil {
+		return nil
+	}
+	db.Exec("UPDATE access_tokens SET last_used_at = ? WHERE id = ?", time.Now().UTC().Format(sqliteTimeLayout), t.ID)
+	return &t
+}
 
-        .timestamp {
-            color: %[5]s;
-            font-size: 12px;
-            font-weight: 400;
-            margin-top: 4px;
-            display: block;
-        }
+// revokeAccessToken deletes the token with the given value, if it exists
+func revokeAccessToken(token string) {
+	if token == "" {
+		return
+	}
+	if _, err := db.Exec("DELETE FROM access_tokens WHERE token_hash = ?", hashAccessToken(token)); err != nil {
+		log.Printf("Error revoking access token: %v", err)
+	}
+}
 
-        .entry-photo-container {
-            width: 100%%;
-            max-width: 1024px;
-            height: 0;
-            padding-bottom: 100%%;
-            position: relative;
-            overflow: hidden;
-            background-color: #000000;
-            display: flex;
-            align-items: center;
-            justify-content: center;
-        }
+// hasScope reports whether the token was granted scope. The older "post"
+// scope covers creating posts and uploading media.
+func (t *AccessToken) hasScope(scope string) bool {
+	for _, s := range strings.Fields(t.Scope) {
+		if s == scope || (s == "post" && (scope == "create" || scope == "media")) {
+			return true
+		}
+	}
+	return false
+}
 
-        .entry-photo {
-            position: absolute;
-            top: 50%%;
-            left: 50%%;
-            transform: translate(-50%%, -50%%);
-            max-width: 100%%;
-            max-height: 100%%;
-            width: auto;
-            height: auto;
-            object-fit: contain;
-            display: block;
-        }
+// handleAccessTokenUpdate creates or revokes an access token from the
+// settings page
+func handleAccessTokenUpdate(w http.ResponseWriter, r *http.Request) {
+	switch r.FormValue("action") {
+	case "create":
+		name := strings.TrimSpace(r.FormValue("token_name"))
+		if name == "" {
+			showSettingsMessage(w, r, "Give the token a name", "error", "tokens")
+			return
+		}
+		if len(name) > 100 {
+			name = name[:100]
+		}
+		var scopes []string
+		for _, scope := range accessTokenScopes {
+			for _, selected := range r.Form["token_scope"] {
+				if selected == scope {
+					scopes = append(scopes, scope)
+				}
+			}
+		}
+		if len(scopes) == 0 {
+			showSettingsMessage(w, r, "Select at least one permission", "error", "tokens")
+			return
+		}
 
-        .entry-actions {
-            display: none;
-        }
+		token, err := createAccessToken(name, strings.Join(scopes, " "), "", currentUser(r).ID)
+		if err != nil {
+			log.Printf("Error creating access token: %v", err)
+			showSettingsMessage(w, r, "Failed to create token", "error", "tokens")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     "flash_token",
+			Value:    token,
+			Path:     "/admin/settings",
+			MaxAge:   5, // 5 seconds
+			HttpOnly: true,
+		})
+		showSettingsMessage(w, r, "Token created", "success", "tokens")
+	case "revoke":
+		// Only owners revoke other users' tokens
+		user := currentUser(r)
+		result, err := db.Exec("DELETE FROM access_tokens WHERE id = ? AND (user_id = ? OR ?)", r.FormValue("token_id"), user.ID, user.IsOwner())
+		if err != nil {
+			log.Printf("Error revoking access token: %v", err)
+			showSettingsMessage(w, r, "Failed to revoke token", "error", "tokens")
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			showSettingsMessage(w, r, "Token not found", "error", "tokens")
+			return
+		}
+		showSettingsMessage(w, r, "Token revoked", "success", "tokens")
+	default:
+		showSettingsMessage(w, r, "Invalid action", "error", "tokens")
+	}
+}
 
-        .action-btn {
-            background: none;
-            border: none;
-            cursor: pointer;
-            font-size: 24px;
-            padding: 8px;
-            line-height: 1;
-            color: %[2]s;
-            transition: opacity 0.2s;
-        }
+// ============================================================================
+// Micropub
+// ============================================================================
 
-        .action-btn:hover {
-            opacity: 0.6;
-        }
+// micropubRequest is a Micropub request in its JSON form. Form-encoded and
+// multipart requests are converted to it.
+type micropubRequest struct {
+	Type       []string                 `json:"type"`
+	Properties map[string][]interface{} `json:"properties"`
+	Action     string                   `json:"action"`
+	URL        string                   `json:"url"`
+	Replace    map[string][]interface{} `json:"replace"`
+	Add        map[string][]interface{} `json:"add"`
+	Delete     interface{}              `json:"delete"` // property names, or values to remove by property
+	Files      map[string][]*multipart.FileHeader
+}
 
-        .entry-content {
-            font-size: 15px;
-            color: %[2]s;
-            line-height: 20px;
-            padding: 16px 16px 4px;
-            word-wrap: break-word;
-            overflow-wrap: break-word;
-        }
+// micropubMediaProperties map the Micropub media properties to media types
+var micropubMediaProperties = []string{"photo", "audio", "video"}
 
-        .entry-content h2 {
-            font-size: 18px;
-            font-weight: 600;
-            margin-bottom: 8px;
-            color: %[2]s;
-        }
+// writeJSONError writes an OAuth-style error response, as used by Micropub,
+// IndieAuth and the admin API
+func writeJSONError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
 
-        .entry-content p {
-            font-size: 15px;
-            line-height: 1.6;
-            color: %[2]s;
-        }
+// requireTokenScope writes an insufficient_scope error unless the token has
+// scope
+func requireTokenScope(w http.ResponseWriter, token *AccessToken, scope string) bool {
+	if token.hasScope(scope) {
+		return true
+	}
+	writeJSONError(w, http.StatusForbidden, "insufficient_scope", "This token lacks the "+scope+" scope")
+	return false
+}
 
-        .entry-content a {
-            color: %[9]s;
-            text-decoration: none;
-            font-weight: 500;
-        }
+// handleMicropub implements the Micropub endpoint: queries on GET, and
+// creating, updating and deleting posts on POST
+func handleMicropub(w http.ResponseWriter, r *http.Request) {
+	limitTokenRequest(w, r)
+	token := authenticateAccessToken(r)
+	if token == nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "A valid access token is required")
+		return
+	}
+	if r.Method == http.MethodPost && !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := r.ParseMultipartForm(10 << 20); err != nil && err != http.ErrNotMultipart {
+			writeJSONError(w, http.StatusBadRequest, "invalid_request", "Could not parse the request body")
+			return
+		}
+	}
 
-        .entry-content a:hover {
-            text-decoration: underline;
-        }
+	switch r.Method {
+	case http.MethodGet:
+		handleMicropubQuery(w, r)
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-        .entry-content-username {
-            display: none;
-        }
+	req, err := parseMicropubRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	defer markContentChanged()
 
-        .entry-timestamp {
-            padding: 4px 16px 16px;
-            color: %[5]s;
-            font-size: 10px;
-            text-transform: uppercase;
-            letter-spacing: 0.2px;
-        }
+	switch req.Action {
+	case "", "create":
+		if !requireTokenScope(w, token, "create") {
+			return
+		}
+		slug, err := createMicropubEntry(req, token.UserID, r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		w.Header().Set("Location", getBaseURL(r)+"/posts/"+slug+"/")
+		w.WriteHeader(http.StatusCreated)
+	case "update":
+		if !requireTokenScope(w, token, "update") {
+			return
+		}
+		entryID, _, _, err := entryForPostURL(req.URL, r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_request", "url is not a post on this site")
+			return
+		}
+		slug, changed, err := updateMicropubEntry(entryID, req, r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		if changed {
+			w.Header().Set("Location", getBaseURL(r)+"/posts/"+slug+"/")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "delete":
+		if !requireTokenScope(w, token, "delete") {
+			return
+		}
+		entryID, _, _, err := entryForPostURL(req.URL, r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_request", "url is not a post on this site")
+			return
+		}
+		if err := deleteEntry(entryID, getBaseURL(r)); err != nil {
+			log.Printf("Error deleting entry: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to delete the post")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "undelete":
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Deleted posts can't be restored")
+	default:
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Unknown action "+req.Action)
+	}
+}
 
-        .entry-footer {
-            display: none;
-        }
+// parseMicropubRequest reads a JSON, form-encoded or multipart request
+func parseMicropubRequest(r *http.Request) (*micropubRequest, error) {
+	req := &micropubRequest{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(req); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		if req.Action == "" && (len(req.Type) == 0 || req.Type[0] != "h-entry") {
+			return nil, fmt.Errorf("only h-entry posts are supported")
+		}
+		return req, nil
+	}
 
-        .entry-id {
-            color: %[5]s;
-            font-size: 12px;
-            font-weight: 500;
-        }
+	req.Action = r.PostFormValue("action")
+	req.URL = r.PostFormValue("url")
+	if req.Action != "" {
+		return req, nil
+	}
+	if h := r.PostFormValue("h"); h != "" && h != "entry" {
+		return nil, fmt.Errorf("only h=entry posts are supported")
+	}
+	req.Properties = make(map[string][]interface{})
+	for key, values := range r.PostForm {
+		name := strings.TrimSuffix(key, "[]")
+		switch name {
+		case "h", "access_token", "action", "url":
+			continue
+		}
+		for _, value := range values {
+			req.Properties[name] = append(req.Properties[name], value)
+		}
+	}
+	if r.MultipartForm != nil {
+		req.Files = make(map[string][]*multipart.FileHeader)
+		for key, files := range r.MultipartForm.File {
+			name := strings.TrimSuffix(key, "[]")
+			req.Files[name] = append(req.Files[name], files...)
+		}
+	}
+	return req, nil
+}
 
-        .entry-badge {
-            background-color: %[9]s;
-            color: #ffffff;
-            padding: 4px 12px;
-            border-radius: 4px;
-            font-size: 11px;
-            font-weight: 600;
-            text-transform: uppercase;
-            letter-spacing: 0.5px;
-        }
+// micropubString returns the first value of a property as text. Content may
+// also be given as {"text": ...} or {"html": ...}.
+func micropubString(values []interface{}) string {
+	if len(values) == 0 {
+		return ""
+	}
+	switch v := values[0].(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if text, ok := v["text"].(string); ok {
+			return text
+		}
+		if value, ok := v["value"].(string); ok {
+			return value
+		}
+		if markup, ok := v["html"].(string); ok {
+			return htmlText(markup)
+		}
+	}
+	return ""
+}
 
-        .empty-state {
-            padding: 80px 32px;
-            text-align: center;
-        }
+// micropubStrings returns all string values of a property
+func micropubStrings(values []interface{}) []string {
+	var out []string
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
 
-        .empty-state-icon {
-            width: 120px;
-            height: 120px;
-            margin: 0 auto 24px;
-            background: %[6]s;
-            border-radius: 50%%;
-            display: flex;
-            align-items: center;
-            justify-content: center;
-            font-size: 64px;
-            border: 2px solid %[4]s;
-        }
+// uploadedFileForURL returns the name of the uploaded file a /uploads/ URL of
+// this site points to
+func uploadedFileForURL(rawURL string, r *http.Request) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Host != "" && !isOwnURL(u, r)) || !strings.HasPrefix(u.Path, "/uploads/") {
+		return "", fmt.Errorf("must be uploaded to the media endpoint first")
+	}
+	name := strings.TrimPrefix(u.Path, "/uploads/")
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("is not a valid upload URL")
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, name)); err != nil {
+		return "", fmt.Errorf("was not found")
+	}
+	return name, nil
+}
+
+// micropubMedia resolves the values of a photo, audio or video property to
+// files previously uploaded to this site, e.g. through the media endpoint.
+// Values are URLs, or {"value": url, "alt": text} for photos.
+func micropubMedia(property string, values []interface{}, r *http.Request) ([]EntryMedia, error) {
+	var media []EntryMedia
+	for _, value := range values {
+		var rawURL, alt string
+		switch v := value.(type) {
+		case string:
+			rawURL = v
+		case map[string]interface{}:
+			rawURL, _ = v["value"].(string)
+			alt, _ = v["alt"].(string)
+		}
+
+		name, err := uploadedFileForURL(rawURL, r)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q %v", property, rawURL, err)
+		}
+		media = append(media, EntryMedia{Path: name, MediaType: mediaTypeForFile(name, property), AltText: strings.TrimSpace(alt)})
+	}
+	return media, nil
+}
+
+// saveMicropubFiles stores the files uploaded with a multipart request as
+// media items of the entry
+func saveMicropubFiles(entryID int64, files map[string][]*multipart.FileHeader, title string, createdAt time.Time) error {
+	for _, property := range micropubMediaProperties {
+		for _, header := range files[property] {
+			file, err := header.Open()
+			if err != nil {
+				return err
+			}
+			path, err := validateMediaAndSave(file, header.Filename, title, createdAt, property)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %v", header.Filename, err)
+			}
+			if err := addEntryMedia(entryID, path, property, "", ""); err != nil {
+				return err
+			}
+			if property == "photo" {
+				if err := createImageVariants(path); err != nil {
+					log.Printf("Error resizing %s: %v", path, err)
+				}
+			}
+		}
+	}
+	return nil
+}
 
-        .empty-state-title {
-            font-size: 28px;
-            font-weight: 600;
-            margin-bottom: 12px;
-            color: %[2]s;
-        }
+// micropubStatus maps post-status and visibility to an entry status
+func micropubStatus(properties map[string][]interface{}, current string) string {
+	status := current
+	switch micropubString(properties["post-status"]) {
+	case "draft":
+		status = "draft"
+	case "published":
+		status = "published"
+	}
+	if status != "draft" {
+		switch micropubString(properties["visibility"]) {
+		case "unlisted":
+			status = "unlisted"
+		case "public":
+			status = "published"
+		}
+	}
+	return status
+}
 
-        .empty-state-text {
-            font-size: 16px;
-            color: %[5]s;
-            max-width: 400px;
-            margin: 0 auto;
-        }
+// createMicropubEntry creates a post by authorID from a Micropub create
+// request and returns its slug. A name becomes the first line, which titles
+// the post.
+func createMicropubEntry(req *micropubRequest, authorID int, r *http.Request) (string, error) {
+	props := req.Properties
+	content := micropubString(props["content"])
+	if name := strings.TrimSpace(micropubString(props["name"])); name != "" {
+		content = strings.TrimSpace("# " + name + "\n\n" + content)
+	}
+	if len(content) > 2000 {
+		content = content[:2000]
+	}
 
-        .loading-indicator {
-            text-align: center;
-            padding: 24px;
-            color: %[5]s;
-            font-size: 14px;
-            display: none;
-        }
+	var media []EntryMedia
+	for _, property := range micropubMediaProperties {
+		items, err := micropubMedia(property, props[property], r)
+		if err != nil {
+			return "", err
+		}
+		media = append(media, items...)
+	}
+	if content == "" && len(media) == 0 && len(req.Files) == 0 {
+		return "", fmt.Errorf("a post needs content or media")
+	}
 
-        .loading-indicator.show {
-            display: block;
-        }
+	customSlug := normalizeSlug(micropubString(props["mp-slug"]))
+	if customSlug != "" && slugTaken(customSlug, 0) {
+		return "", fmt.Errorf("the slug %q is already used by another post", customSlug)
+	}
 
-        .loading-spinner {
-            display: inline-block;
-            width: 20px;
-            height: 20px;
-            border: 2px solid %[4]s;
-            border-top-color: %[2]s;
-            border-radius: 50%%;
-            animation: spin 0.8s linear infinite;
-            margin-right: 8px;
-            vertical-align: middle;
-        }
+	// A published date in the future schedules the post
+	status := micropubStatus(props, "published")
+	now := time.Now()
+	var publishAt *time.Time
+	if published := micropubString(props["published"]); published != "" {
+		t, err := time.Parse(time.RFC3339, published)
+		if err != nil {
+			return "", fmt.Errorf("published must be an RFC 3339 date")
+		}
+		now = t
+		if status == "published" && t.After(time.Now()) {
+			publishAt = &t
+		}
+	}
 
-        @keyframes spin {
-            to { transform: rotate(360deg); }
-        }
+	finalTitle := entryTitle(content, "markdown", now)
+	slug := generateSlug(finalTitle, now)
+	if customSlug != "" {
+		slug = customSlug
+	}
 
-        .end-message {
-            text-align: center;
-            padding: 24px;
-            color: %[5]s;
-            font-size: 14px;
-            display: none;
-        }
+	result, err := db.Exec(`
+		INSERT INTO entries (title, content, photo_path, media_type, slug, slug_pinned, content_format, author_id, status, publish_at, created_at)
+		VALUES (?, ?, '', 'photo', ?, ?, 'markdown', ?, ?, ?, ?)
+	`, finalTitle, content, slug, customSlug != "", authorID, status, publishAtValue(publishAt), now.UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		log.Printf("Error inserting entry: %v", err)
+		return "", fmt.Errorf("failed to create the post")
+	}
+	entryID, _ := result.LastInsertId()
 
-        .end-message.show {
-            display: block;
-        }
+	if err := setEntryMedia(entryID, media); err != nil {
+		log.Printf("Error saving media: %v", err)
+	}
+	if err := saveMicropubFiles(entryID, req.Files, finalTitle, now); err != nil {
+		log.Printf("Error saving Micropub upload: %v", err)
+	}
+	if err := syncPrimaryMedia(entryID); err != nil {
+		log.Printf("Error updating primary media: %v", err)
+	}
+	syncSearchIndex(entryID)
+	if err := setEntryTags(entryID, parseTagInput(strings.Join(micropubStrings(props["category"]), ","))); err != nil {
+		log.Printf("Error saving tags: %v", err)
+	}
+	announceEntryChange(int(entryID), getBaseURL(r))
+	triggerEntryWebhooks("entry.created", int(entryID), getBaseURL(r))
+	return slug, nil
+}
 
-        .loading {
-            text-align: center;
-            padding: 40px;
-            color: %[5]s;
-        }
+// updateMicropubEntry applies a Micropub update request to an entry. It
+// returns the entry's slug and whether its URL changed.
+func updateMicropubEntry(entryID int, req *micropubRequest, r *http.Request) (string, bool, error) {
+	var content string
+	var contentFormat, status, currentSlug sql.NullString
+	var publishAt sql.NullTime
+	var createdAt time.Time
+	var slugPinned bool
+	err := db.QueryRow("SELECT content, content_format, status, publish_at, created_at, slug, slug_pinned FROM entries WHERE id = ?", entryID).
+		Scan(&content, &contentFormat, &status, &publishAt, &createdAt, &currentSlug, &slugPinned)
+	if err != nil {
+		return "", false, fmt.Errorf("post not found")
+	}
+	tags := getTagsForEntries([]int{entryID})[entryID]
+	media := getMediaForEntries([]int{entryID})[entryID]
+	newStatus := status.String
+	customSlug := ""
+	if slugPinned {
+		customSlug = currentSlug.String
+	}
+
+	supported := map[string]bool{"content": true, "category": true, "photo": true, "audio": true, "video": true,
+		"post-status": true, "visibility": true, "mp-slug": true}
+	for _, changes := range []map[string][]interface{}{req.Replace, req.Add} {
+		for property := range changes {
+			if !supported[property] {
+				return "", false, fmt.Errorf("updating %s is not supported", property)
+			}
+		}
+	}
 
-        .rss-link {
-            text-align: center;
-            padding: 20px;
-        }
+	// Media items of one type are replaced, added to or removed as a group
+	withoutType := func(mediaType string) []EntryMedia {
+		var kept []EntryMedia
+		for _, item := range media {
+			if item.MediaType != mediaType {
+				kept = append(kept, item)
+			}
+		}
+		return kept
+	}
 
-        .rss-link a {
-            color: %[9]s;
-            text-decoration: none;
-            font-size: 14px;
-        }
+	for property, values := range req.Replace {
+		switch property {
+		case "content":
+			content = micropubString(values)
+		case "category":
+			tags = parseTagInput(strings.Join(micropubStrings(values), ","))
+		case "photo", "audio", "video":
+			items, err := micropubMedia(property, values, r)
+			if err != nil {
+				return "", false, err
+			}
+			media = append(withoutType(property), items...)
+		case "mp-slug":
+			customSlug = normalizeSlug(micropubString(values))
+			if customSlug != "" && slugTaken(customSlug, entryID) {
+				return "", false, fmt.Errorf("the slug %q is already used by another post", customSlug)
+			}
+		}
+	}
+	newStatus = micropubStatus(req.Replace, newStatus)
 
-        @media (max-width: 768px) {
-            .container {
-                background-color: %[6]s;
-            }
+	for property, values := range req.Add {
+		switch property {
+		case "content":
+			content = strings.TrimSpace(content + "\n\n" + micropubString(values))
+		case "category":
+			tags = parseTagInput(strings.Join(append(tags, micropubStrings(values)...), ","))
+		case "photo", "audio", "video":
+			items, err := micropubMedia(property, values, r)
+			if err != nil {
+				return "", false, err
+			}
+			media = append(media, items...)
+		}
+	}
+
+	switch del := req.Delete.(type) {
+	case []interface{}:
+		for _, property := range micropubStrings(del) {
+			switch property {
+			case "content":
+				content = ""
+			case "category":
+				tags = nil
+			case "photo", "audio", "video":
+				media = withoutType(property)
+			case "mp-slug":
+				customSlug = ""
+			default:
+				return "", false, fmt.Errorf("deleting %s is not supported", property)
+			}
+		}
+	case map[string]interface{}:
+		for property, values := range del {
+			list, _ := values.([]interface{})
+			remove := make(map[string]bool)
+			for _, value := range micropubStrings(list) {
+				remove[value] = true
+			}
+			switch property {
+			case "category":
+				var kept []string
+				for _, tag := range tags {
+					if !remove[tag] {
+						kept = append(kept, tag)
+					}
+				}
+				tags = kept
+			case "photo", "audio", "video":
+				var kept []EntryMedia
+				for _, item := range media {
+					u := string(item.URL)
+					if item.MediaType != property || !(remove[u] || remove[getBaseURL(r)+u]) {
+						kept = append(kept, item)
+					}
+				}
+				media = kept
+			default:
+				return "", false, fmt.Errorf("deleting %s values is not supported", property)
+			}
+		}
+	}
 
-            .header {
-                padding: 16px 20px;
-            }
+	if len(content) > 2000 {
+		content = content[:2000]
+	}
 
-            .header h1 {
-                font-size: 24px;
-            }
+	// Publishing a draft dates it now, like in the editor
+	var newPublishAt *time.Time
+	if newStatus == "published" && publishAt.Valid && publishAt.Time.After(time.Now()) {
+		newPublishAt = &publishAt.Time
+	} else if newStatus == "published" && status.String == "draft" {
+		createdAt = time.Now()
+	}
 
-            .stats {
-                padding: 12px 16px;
-                gap: 12px;
-            }
+	revisionID, err := saveEntryRevision(entryID)
+	if err != nil {
+		log.Printf("Error saving revision: %v", err)
+	}
 
-            .stat {
-                padding: 12px;
-            }
+	finalTitle := entryTitle(content, contentFormat.String, createdAt)
+	slug := generateSlug(finalTitle, createdAt)
+	if customSlug != "" {
+		slug = customSlug
+	}
+	_, err = db.Exec("UPDATE entries SET title = ?, content = ?, slug = ?, slug_pinned = ? WHERE id = ?", finalTitle, content, slug, customSlug != "", entryID)
+	if err != nil {
+		log.Printf("Error updating entry: %v", err)
+		return "", false, fmt.Errorf("failed to update the post")
+	}
 
-            .stat-value {
-                font-size: 24px;
-            }
+	if err := setEntryMedia(int64(entryID), media); err != nil {
+		log.Printf("Error updating media: %v", err)
+	}
+	if err := syncPrimaryMedia(int64(entryID)); err != nil {
+		log.Printf("Error updating primary media: %v", err)
+	}
+	syncSearchIndex(int64(entryID))
+	recordSlugChange(entryID, currentSlug.String, slug)
+	if err := setEntryPublication(int64(entryID), newStatus, newPublishAt, createdAt); err != nil {
+		log.Printf("Error saving publication status: %v", err)
+	}
+	if err := setEntryTags(int64(entryID), tags); err != nil {
+		log.Printf("Error saving tags: %v", err)
+	}
+	if revisionID > 0 {
+		discardUnchangedRevision(revisionID)
+	}
+	announceEntryChange(entryID, getBaseURL(r))
+	triggerEntryWebhooks("entry.updated", entryID, getBaseURL(r))
+	return slug, slug != currentSlug.String, nil
+}
 
-            .feed {
-                padding: 0 0 80px;
-            }
+// handleMicropubQuery answers q=config, q=source and q=syndicate-to
+func handleMicropubQuery(w http.ResponseWriter, r *http.Request) {
+	baseURL := getBaseURL(r)
+	var response interface{}
+
+	switch r.URL.Query().Get("q") {
+	case "config":
+		response = map[string]interface{}{
+			"media-endpoint": baseURL + "/micropub/media",
+			"syndicate-to":   []string{},
+			"q":              []string{"config", "source", "syndicate-to"},
+			"post-types": []map[string]string{
+				{"type": "note", "name": "Note"},
+				{"type": "article", "name": "Article"},
+				{"type": "photo", "name": "Photo"},
+				{"type": "video", "name": "Video"},
+				{"type": "audio", "name": "Audio"},
+			},
+		}
+	case "syndicate-to":
+		response = map[string]interface{}{"syndicate-to": []string{}}
+	case "source":
+		entryID, _, _, err := entryForPostURL(r.URL.Query().Get("url"), r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_request", "url is not a post on this site")
+			return
+		}
+		source, err := micropubSource(entryID, baseURL)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_request", "Post not found")
+			return
+		}
+		if wanted := r.URL.Query()["properties[]"]; len(wanted) > 0 || len(r.URL.Query()["properties"]) > 0 {
+			wanted = append(wanted, r.URL.Query()["properties"]...)
+			properties := make(map[string]interface{})
+			for _, name := range wanted {
+				if value, ok := source[name]; ok {
+					properties[name] = value
+				}
+			}
+			response = map[string]interface{}{"properties": properties}
+		} else {
+			response = map[string]interface{}{"type": []string{"h-entry"}, "properties": source}
+		}
+	default:
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Unsupported query")
+		return
+	}
 
-            .entry {
-                margin-bottom: 12px;
-                border-radius: 0;
-                border-left: none;
-                border-right: none;
-            }
-        }
-`, bgColor, textColor, headerBg, borderColor, secondaryText, cardBg, hoverShadow, subtleBorder, accentColor)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
-// Helper functions for color manipulation
-func isLightHexColor(hex string) bool {
-	if len(hex) != 7 || hex[0] != '#' {
-		return true // default to light
+// micropubSource returns the Micropub properties of an entry
+func micropubSource(entryID int, baseURL string) (map[string]interface{}, error) {
+	var content, slug string
+	var status sql.NullString
+	var createdAt time.Time
+	err := db.QueryRow("SELECT content, COALESCE(slug, ''), status, created_at FROM entries WHERE id = ?", entryID).
+		Scan(&content, &slug, &status, &createdAt)
+	if err != nil {
+		return nil, err
 	}
-	r, _ := strconv.ParseInt(hex[1:3], 16, 64)
-	g, _ := strconv.ParseInt(hex[3:5], 16, 64)
-	b, _ := strconv.ParseInt(hex[5:7], 16, 64)
-	luminance := (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 255
-	return luminance > 0.5
+
+	properties := map[string]interface{}{
+		"content":     []string{content},
+		"published":   []string{createdAt.Format(time.RFC3339)},
+		"url":         []string{baseURL + "/posts/" + slug + "/"},
+		"mp-slug":     []string{slug},
+		"post-status": []string{"published"},
+		"category":    []string{},
+	}
+	if status.String == "draft" {
+		properties["post-status"] = []string{"draft"}
+	} else if status.String == "unlisted" {
+		properties["visibility"] = []string{"unlisted"}
+	}
+	if tags := getTagsForEntries([]int{entryID})[entryID]; len(tags) > 0 {
+		properties["category"] = tags
+	}
+	for _, item := range getMediaForEntries([]int{entryID})[entryID] {
+		var value interface{} = baseURL + string(item.URL)
+		if item.AltText != "" {
+			value = map[string]string{"value": baseURL + string(item.URL), "alt": item.AltText}
+		}
+		list, _ := properties[item.MediaType].([]interface{})
+		properties[item.MediaType] = append(list, value)
+	}
+	return properties, nil
 }
 
-func adjustHexBrightness(hex string, percent int) string {
-	if len(hex) != 7 || hex[0] != '#' {
-		return hex
+// handleMicropubMedia implements the Micropub media endpoint. Files are
+// validated like editor uploads and can then be referenced by URL in posts.
+func handleMicropubMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limitTokenRequest(w, r)
+	token := authenticateAccessToken(r)
+	if token == nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "A valid access token is required")
+		return
+	}
+	if !requireTokenScope(w, token, "media") {
+		return
+	}
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Expected a multipart upload")
+		return
 	}
-	r, _ := strconv.ParseInt(hex[1:3], 16, 64)
-	g, _ := strconv.ParseInt(hex[3:5], 16, 64)
-	b, _ := strconv.ParseInt(hex[5:7], 16, 64)
 
-	r = clamp(r + int64(float64(r)*float64(percent)/100), 0, 255)
-	g = clamp(g + int64(float64(g)*float64(percent)/100), 0, 255)
-	b = clamp(b + int64(float64(b)*float64(percent)/100), 0, 255)
+	path, _, err := saveUploadedMedia(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
 
-	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	w.Header().Set("Location", getBaseURL(r)+"/uploads/"+path)
+	w.WriteHeader(http.StatusCreated)
 }
 
-func blendColors(color1, color2 string, ratio float64) string {
-	if len(color1) != 7 || color1[0] != '#' || len(color2) != 7 || color2[0] != '#' {
-		return color1
+// saveUploadedMedia stores the "file" field of a multipart request as a media
+// file that isn't attached to an entry yet, and returns its path and type
+func saveUploadedMedia(r *http.Request) (string, string, error) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return "", "", fmt.Errorf("the file field is missing")
 	}
-	r1, _ := strconv.ParseInt(color1[1:3], 16, 64)
-	g1, _ := strconv.ParseInt(color1[3:5], 16, 64)
-	b1, _ := strconv.ParseInt(color1[5:7], 16, 64)
-	r2, _ := strconv.ParseInt(color2[1:3], 16, 64)
-	g2, _ := strconv.ParseInt(color2[3:5], 16, 64)
-	b2, _ := strconv.ParseInt(color2[5:7], 16, 64)
+	defer file.Close()
 
-	r := int64(float64(r1)*(1-ratio) + float64(r2)*ratio)
-	g := int64(float64(g1)*(1-ratio) + float64(g2)*ratio)
-	b := int64(float64(b1)*(1-ratio) + float64(b2)*ratio)
+	mediaType := mediaTypeForFile(header.Filename, "photo")
+	name := strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
+	path, err := validateMediaAndSave(file, header.Filename, name, time.Now(), mediaType)
+	if err != nil {
+		return "", "", err
+	}
+	if mediaType == "photo" {
+		if err := createImageVariants(path); err != nil {
+			log.Printf("Error resizing %s: %v", path, err)
+		}
+	}
+	return path, mediaType, nil
+}
+
+// ============================================================================
+// Admin API
+// ============================================================================
+
+// apiEntry is an entry as returned by the admin API
+type apiEntry struct {
+	ID            int        `json:"id"`
+	Title         string     `json:"title"`
+	Content       string     `json:"content"`
+	ContentFormat string     `json:"content_format"`
+	Slug          string     `json:"slug"`
+	SlugPinned    bool       `json:"slug_pinned"`
+	URL           string     `json:"url"`
+	Status        string     `json:"status"`
+	PublishAt     *time.Time `json:"publish_at"` // set while scheduled
+	CreatedAt     time.Time  `json:"created_at"`
+	Tags          []string   `json:"tags"`
+	Media         []apiMedia `json:"media"`
+}
+
+// apiMedia is a media item of an entry
+type apiMedia struct {
+	ID        int    `json:"id"`
+	EntryID   int    `json:"entry_id"`
+	URL       string `json:"url"`
+	MediaType string `json:"media_type"`
+	AltText   string `json:"alt_text"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// apiEntryInput is the body of a create or update request. Fields left out of
+// an update keep their current value.
+type apiEntryInput struct {
+	Content   *string          `json:"content"`
+	Status    *string          `json:"status"`     // "published", "draft" or "unlisted"
+	PublishAt *string          `json:"publish_at"` // RFC 3339, in the future; "" publishes now
+	Slug      *string          `json:"slug"`       // "" goes back to a generated slug
+	Tags      *[]string        `json:"tags"`
+	Media     *[]apiMediaInput `json:"media"` // replaces all media items
+}
 
-	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+type apiMediaInput struct {
+	URL     string `json:"url"`
+	AltText string `json:"alt_text"`
 }
 
-func clamp(value, min, max int64) int64 {
-	if value < min {
-		return min
-	}
-	if value > max {
-		return max
-	}
-	return value
+// apiSettings are the site settings readable and writable through the API
+type apiSettings struct {
+	SiteTitle         string `json:"site_title"`
+	SiteSubtitle      string `json:"site_subtitle"`
+	UserInitial       string `json:"user_initial"`
+	AvatarURL         string `json:"avatar_url,omitempty"`
+	AvatarPreference  string `json:"avatar_preference"`
+	SiteTheme         string `json:"site_theme"`
+	CustomBgColor     string `json:"custom_bg_color"`
+	CustomTextColor   string `json:"custom_text_color"`
+	CustomAccentColor string `json:"custom_accent_color"`
 }
 
-func ifThen(condition bool, trueVal, falseVal int) int {
-	if condition {
-		return trueVal
-	}
-	return falseVal
+// apiSettingsInput is the body of a settings update. Fields left out keep
+// their current value.
+type apiSettingsInput struct {
+	SiteTitle         *string `json:"site_title"`
+	SiteSubtitle      *string `json:"site_subtitle"`
+	UserInitial       *string `json:"user_initial"`
+	AvatarPreference  *string `json:"avatar_preference"`
+	SiteTheme         *string `json:"site_theme"`
+	CustomBgColor     *string `json:"custom_bg_color"`
+	CustomTextColor   *string `json:"custom_text_color"`
+	CustomAccentColor *string `json:"custom_accent_color"`
 }
 
-// Authentication handlers
-func handleLogin(w http.ResponseWriter, r *http.Request) {
-	type LoginData struct {
-		Error    string
-		Redirect string
-	}
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
 
-	redirect := r.URL.Query().Get("redirect")
-	if redirect == "" {
-		redirect = "/admin"
-	}
+func writeAPIMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use "+strings.Join(allowed, " or "))
+}
 
-	if r.Method == http.MethodGet {
-		tmpl := template.Must(template.New("login").Parse(loginTemplate))
-		tmpl.Execute(w, LoginData{Redirect: redirect})
+// handleAPIv1 routes the admin API under /api/v1/. Every request needs an
+// access token in the Authorization header with the api scope and the scope
+// of the operation: read for GET requests, and create, update, delete, media
+// or settings for changes.
+func handleAPIv1(w http.ResponseWriter, r *http.Request) {
+	limitTokenRequest(w, r)
+	token := authenticateBearerToken(r)
+	if token == nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "A valid access token is required in the Authorization header")
+		return
+	}
+	if !requireTokenScope(w, token, "api") {
 		return
 	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		defer markContentChanged()
+	}
 
-	if r.Method == http.MethodPost {
-		err := r.ParseForm()
+	resource, id, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/"), "/"), "/")
+	switch {
+	case resource == "entries" && id == "":
+		switch r.Method {
+		case http.MethodGet:
+			if requireTokenScope(w, token, "read") {
+				handleAPIListEntries(w, r)
+			}
+		case http.MethodPost:
+			if requireTokenScope(w, token, "create") {
+				handleAPISaveEntry(w, r, 0, token.UserID)
+			}
+		default:
+			writeAPIMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	case resource == "entries":
+		entryID, err := strconv.Atoi(id)
 		if err != nil {
-			http.Error(w, "Bad request", http.StatusBadRequest)
+			writeJSONError(w, http.StatusNotFound, "not_found", "No such entry")
 			return
 		}
-
-		password := r.FormValue("password")
-		redirectPath := r.FormValue("redirect")
-		if redirectPath == "" {
-			redirectPath = "/admin"
+		switch r.Method {
+		case http.MethodGet:
+			if requireTokenScope(w, token, "read") {
+				handleAPIGetEntry(w, r, entryID)
+			}
+		case http.MethodPatch:
+			if requireTokenScope(w, token, "update") {
+				handleAPISaveEntry(w, r, entryID, token.UserID)
+			}
+		case http.MethodDelete:
+			if requireTokenScope(w, token, "delete") {
+				handleAPIDeleteEntry(w, r, entryID)
+			}
+		default:
+			writeAPIMethodNotAllowed(w, http.MethodGet, http.MethodPatch, http.MethodDelete)
+		}
+	case resource == "media" && id == "":
+		switch r.Method {
+		case http.MethodGet:
+			if requireTokenScope(w, token, "read") {
+				handleAPIListMedia(w, r)
+			}
+		case http.MethodPost:
+			if requireTokenScope(w, token, "media") {
+				handleAPIUploadMedia(w, r)
+			}
+		default:
+			writeAPIMethodNotAllowed(w, http.MethodGet, http.MethodPost)
 		}
-
-		// Get admin password hash from database
-		var passwordHash sql.NullString
-		err = db.QueryRow("SELECT admin_password_hash FROM site_settings WHERE id = 1").Scan(&passwordHash)
-
-		// Check if password is valid using bcrypt
-		var passwordValid bool
-		if err == nil && passwordHash.Valid && passwordHash.String != "" {
-			err = bcrypt.CompareHashAndPassword([]byte(passwordHash.String), []byte(password))
-			passwordValid = (err == nil)
+	case resource == "media":
+		mediaID, err := strconv.Atoi(id)
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, "not_found", "No such media item")
+			return
 		}
-
-		if !passwordValid {
-			tmpl := template.Must(template.New("login").Parse(loginTemplate))
-			tmpl.Execute(w, LoginData{
-				Error:    "Invalid password",
-				Redirect: redirectPath,
-			})
+		if r.Method != http.MethodDelete {
+			writeAPIMethodNotAllowed(w, http.MethodDelete)
 			return
 		}
+		if requireTokenScope(w, token, "delete") {
+			handleAPIDeleteMedia(w, r, mediaID)
+		}
+	case resource == "settings" && id == "":
+		switch r.Method {
+		case http.MethodGet:
+			if requireTokenScope(w, token, "read") {
+				handleAPIGetSettings(w, r)
+			}
+		case http.MethodPatch:
+			if requireTokenScope(w, token, "settings") {
+				handleAPIUpdateSettings(w, r)
+			}
+		default:
+			writeAPIMethodNotAllowed(w, http.MethodGet, http.MethodPatch)
+		}
+	default:
+		writeJSONError(w, http.StatusNotFound, "not_found", "Unknown API endpoint")
+	}
+}
 
-		// Create session
-		token, err := createSession()
-		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+// getAPIEntries loads entries of any status, newest first, optionally
+// restricted by a WHERE condition. It also reports whether more follow.
+func getAPIEntries(condition string, args []interface{}, offset, limit int, baseURL string) ([]apiEntry, bool, error) {
+	query := `
+		SELECT id, title, content, content_format, slug, slug_pinned, status, publish_at, created_at
+		FROM entries`
+	if condition != "" {
+		query += " WHERE " + condition
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?"
+
+	rows, err := db.Query(query, append(args, limit+1, offset)...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	entries := []apiEntry{}
+	for rows.Next() {
+		var e apiEntry
+		var title, contentFormat, slug, status sql.NullString
+		var publishAt sql.NullTime
+		if err := rows.Scan(&e.ID, &title, &e.Content, &contentFormat, &slug, &e.SlugPinned, &status, &publishAt, &e.CreatedAt); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
 		}
+		e.Title = title.String
+		e.ContentFormat = contentFormat.String
+		e.Slug = slug.String
+		e.URL = baseURL + "/posts/" + slug.String + "/"
+		e.Status = status.String
+		if e.Status == "" {
+			e.Status = "published"
+		}
+		if publishAt.Valid {
+			e.PublishAt = &publishAt.Time
+		}
+		entries = append(entries, e)
+	}
+	hasMore := len(entries) > limit
+	if hasMore {
+		entries = entries[:limit]
+	}
 
-		// Set cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session_token",
-			Value:    token,
-			Path:     "/",
-			MaxAge:   3600, // 60 minutes
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-		})
+	ids := make([]int, len(entries))
+	for i := range entries {
+		ids[i] = entries[i].ID
+	}
+	tagsByEntry := getTagsForEntries(ids)
+	mediaByEntry := getMediaForEntries(ids)
+	for i := range entries {
+		entries[i].Tags = tagsByEntry[entries[i].ID]
+		if entries[i].Tags == nil {
+			entries[i].Tags = []string{}
+		}
+		entries[i].Media = []apiMedia{}
+		for _, item := range mediaByEntry[entries[i].ID] {
+			entries[i].Media = append(entries[i].Media, newAPIMedia(item, entries[i].ID, baseURL))
+		}
+	}
+	return entries, hasMore, nil
+}
 
-		http.Redirect(w, r, redirectPath, http.StatusSeeOther)
-		return
+func newAPIMedia(item EntryMedia, entryID int, baseURL string) apiMedia {
+	m := apiMedia{
+		ID:        item.ID,
+		EntryID:   entryID,
+		URL:       baseURL + "/uploads/" + item.Path,
+		MediaType: item.MediaType,
+		AltText:   item.AltText,
+	}
+	if item.ThumbnailPath != "" {
+		m.Thumbnail = baseURL + "/uploads/" + item.ThumbnailPath
 	}
+	return m
+}
 
-	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// getAPIEntry loads one entry, returning sql.ErrNoRows if it doesn't exist
+func getAPIEntry(entryID int, baseURL string) (apiEntry, error) {
+	entries, _, err := getAPIEntries("id = ?", []interface{}{entryID}, 0, 1, baseURL)
+	if err != nil {
+		return apiEntry{}, err
+	}
+	if len(entries) == 0 {
+		return apiEntry{}, sql.ErrNoRows
+	}
+	return entries[0], nil
 }
 
-func handleSetPrivacyPassword(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// handleAPIListEntries lists entries, filtered by ?status= and paged with
+// ?limit= (at most 100) and ?offset=
+func handleAPIListEntries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
 	}
 
-	password := r.FormValue("password")
-	if password == "" {
-		showMessage(w, r, "Password cannot be empty", "error")
+	var condition string
+	var args []interface{}
+	switch status := query.Get("status"); status {
+	case "":
+	case "scheduled":
+		condition = "status = 'published' AND publish_at > datetime('now')"
+	case "published":
+		condition = publicEntryCondition
+	case "draft", "unlisted":
+		condition = "status = ?"
+		args = append(args, status)
+	default:
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "status must be published, scheduled, draft or unlisted")
 		return
 	}
 
-	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	entries, hasMore, err := getAPIEntries(condition, args, offset, limit, getBaseURL(r))
 	if err != nil {
-		log.Printf("Error hashing password: %v", err)
-		showMessage(w, r, "Failed to set password", "error")
+		log.Printf("Error listing entries for API: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to list entries")
 		return
 	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": entries, "has_more": hasMore})
+}
 
-	// Update viewer password in site_settings
-	_, err = db.Exec(`UPDATE site_settings SET viewer_password_hash = ? WHERE id = 1`, string(hashedPassword))
-
+func handleAPIGetEntry(w http.ResponseWriter, r *http.Request, entryID int) {
+	entry, err := getAPIEntry(entryID, getBaseURL(r))
+	if err == sql.ErrNoRows {
+		writeJSONError(w, http.StatusNotFound, "not_found", "No such entry")
+		return
+	}
 	if err != nil {
-		log.Printf("Error saving viewer password: %v", err)
-		showMessage(w, r, "Failed to save viewer password", "error")
+		log.Printf("Error loading entry %d for API: %v", entryID, err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to load the entry")
 		return
 	}
-
-	showMessage(w, r, "Viewer password set successfully!", "success")
+	writeJSON(w, http.StatusOK, entry)
 }
 
-func handleRemovePrivacyPassword(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleAPISaveEntry creates an entry (entryID 0) credited to authorID, or
+// updates one, from a JSON body and responds with the saved entry
+func handleAPISaveEntry(w http.ResponseWriter, r *http.Request, entryID, authorID int) {
+	var input apiEntryInput
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&input); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "The body must be a JSON object")
 		return
 	}
+	if entryID != 0 {
+		var exists bool
+		db.QueryRow("SELECT COUNT(*) FROM entries WHERE id = ?", entryID).Scan(&exists)
+		if !exists {
+			writeJSONError(w, http.StatusNotFound, "not_found", "No such entry")
+			return
+		}
+	}
 
-	// Remove the viewer password from site_settings
-	_, err := db.Exec("UPDATE site_settings SET viewer_password_hash = NULL WHERE id = 1")
+	savedID, err := saveAPIEntry(entryID, authorID, input, r)
 	if err != nil {
-		log.Printf("Error removing viewer password: %v", err)
-		showMessage(w, r, "Failed to remove viewer password", "error")
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	entry, err := getAPIEntry(savedID, getBaseURL(r))
+	if err != nil {
+		log.Printf("Error loading entry %d for API: %v", savedID, err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "The entry was saved but could not be loaded")
 		return
 	}
 
-	showMessage(w, r, "Your blog is now public! Password protection removed successfully.", "success")
+	status := http.StatusOK
+	if entryID == 0 {
+		w.Header().Set("Location", fmt.Sprintf("%s/api/v1/entries/%d", getBaseURL(r), savedID))
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, entry)
 }
 
-func getSiteSettings() (SiteSettings, error) {
-	var settings SiteSettings
-	var viewerPasswordHash, adminPasswordHash, avatarPath, avatarPreference sql.NullString
-	var customBgColor, customTextColor, customAccentColor sql.NullString
-
-	err := db.QueryRow(`
-		SELECT site_title, site_subtitle, user_initial, avatar_path, avatar_preference, site_theme,
-		       viewer_password_hash, admin_password_hash, custom_bg_color, custom_text_color, custom_accent_color
-		FROM site_settings WHERE id = 1
-	`).Scan(&settings.SiteTitle, &settings.SiteSubtitle, &settings.UserInitial, &avatarPath, &avatarPreference,
-		&settings.SiteTheme, &viewerPasswordHash, &adminPasswordHash, &customBgColor, &customTextColor, &customAccentColor)
-
-	if err != nil {
-		// If query fails, it might be due to missing columns - try running migrations
-		if migErr := runDatabaseMigrations(); migErr == nil {
-			// Retry the query after migrations
-			err = db.QueryRow(`
-				SELECT site_title, site_subtitle, user_initial, avatar_path, avatar_preference, site_theme,
-				       viewer_password_hash, admin_password_hash, custom_bg_color, custom_text_color, custom_accent_color
-				FROM site_settings WHERE id = 1
-			`).Scan(&settings.SiteTitle, &settings.SiteSubtitle, &settings.UserInitial, &avatarPath, &avatarPreference,
-				&settings.SiteTheme, &viewerPasswordHash, &adminPasswordHash, &customBgColor, &customTextColor, &customAccentColor)
-		}
+// saveAPIEntry applies an API request to a new (entryID 0) or existing entry
+// the way the editor does, and returns the entry's ID. New entries are
+// credited to authorID. Errors describe what is wrong with the request.
+func saveAPIEntry(entryID, authorID int, input apiEntryInput, r *http.Request) (int, error) {
+	content, contentFormat, status := "", "markdown", "published"
+	currentSlug, slugPinned := "", false
+	var publishAt sql.NullTime
+	createdAt := time.Now()
+	var tags []string
+	var media []EntryMedia
+	if entryID != 0 {
+		var contentFormatValue, statusValue, slugValue sql.NullString
+		err := db.QueryRow("SELECT content, content_format, status, publish_at, created_at, slug, slug_pinned FROM entries WHERE id = ?", entryID).
+			Scan(&content, &contentFormatValue, &statusValue, &publishAt, &createdAt, &slugValue, &slugPinned)
 		if err != nil {
-			return settings, err
+			log.Printf("Error loading entry %d for API: %v", entryID, err)
+			return 0, fmt.Errorf("failed to load the entry")
 		}
+		contentFormat = contentFormatValue.String
+		status = statusValue.String
+		currentSlug = slugValue.String
+		tags = getTagsForEntries([]int{entryID})[entryID]
+		media = getMediaForEntries([]int{entryID})[entryID]
 	}
+	currentStatus := status
 
-	if avatarPath.Valid {
-		settings.AvatarPath = avatarPath.String
+	if input.Content != nil {
+		content = *input.Content
 	}
-
-	if avatarPreference.Valid {
-		settings.AvatarPreference = avatarPreference.String
-	} else {
-		settings.AvatarPreference = "initials" // default
+	if len(content) > 2000 {
+		content = content[:2000]
 	}
-
-	// Set custom colors with defaults
-	if customBgColor.Valid && customBgColor.String != "" {
-		settings.CustomBgColor = customBgColor.String
-	} else {
-		settings.CustomBgColor = "#fafafa"
+	if input.Status != nil {
+		switch *input.Status {
+		case "published", "draft", "unlisted":
+			status = *input.Status
+		default:
+			return 0, fmt.Errorf("status must be published, draft or unlisted")
+		}
 	}
-	if customTextColor.Valid && customTextColor.String != "" {
-		settings.CustomTextColor = customTextColor.String
-	} else {
-		settings.CustomTextColor = "#262626"
+
+	// A custom slug is pinned; clearing it goes back to generated slugs
+	customSlug := ""
+	if slugPinned {
+		customSlug = currentSlug
 	}
-	if customAccentColor.Valid && customAccentColor.String != "" {
-		settings.CustomAccentColor = customAccentColor.String
-	} else {
-		settings.CustomAccentColor = "#0095f6"
+	if input.Slug != nil {
+		customSlug = normalizeSlug(*input.Slug)
+		if customSlug != "" && slugTaken(customSlug, entryID) {
+			return 0, fmt.Errorf("the slug %q is already used by another post", customSlug)
+		}
 	}
 
-	settings.HasViewerPassword = viewerPasswordHash.Valid && viewerPasswordHash.String != ""
-	settings.HasAdminPassword = adminPasswordHash.Valid && adminPasswordHash.String != ""
-
-	return settings, nil
-}
-
-func handleSettings(w http.ResponseWriter, r *http.Request) {
-	handleSettingsWithView(w, r, "site-info")
-}
-
-func handleSettingsAppearance(w http.ResponseWriter, r *http.Request) {
-	handleSettingsWithView(w, r, "appearance")
-}
-
-func handleSettingsSecurity(w http.ResponseWriter, r *http.Request) {
-	handleSettingsWithView(w, r, "security")
-}
-
-func handleSettingsBackup(w http.ResponseWriter, r *http.Request) {
-	handleSettingsWithView(w, r, "backup")
-}
-
-func handleSettingsWithView(w http.ResponseWriter, r *http.Request, view string) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	if input.Tags != nil {
+		tags = parseTagInput(strings.Join(*input.Tags, ","))
 	}
 
-	settings, err := getSiteSettings()
-	if err != nil {
-		log.Printf("Error fetching settings: %v", err)
-		http.Error(w, "Error fetching settings", http.StatusInternalServerError)
-		return
+	// Media items that stay keep their thumbnails
+	if input.Media != nil {
+		thumbnails := make(map[string]string)
+		for _, item := range media {
+			thumbnails[item.Path] = item.ThumbnailPath
+		}
+		media = nil
+		for _, item := range *input.Media {
+			name, err := uploadedFileForURL(item.URL, r)
+			if err != nil {
+				return 0, fmt.Errorf("media %q %v", item.URL, err)
+			}
+			media = append(media, EntryMedia{
+				Path:          name,
+				MediaType:     mediaTypeForFile(name, "photo"),
+				ThumbnailPath: thumbnails[name],
+				AltText:       strings.TrimSpace(item.AltText),
+			})
+		}
+	}
+	if strings.TrimSpace(content) == "" && len(media) == 0 {
+		return 0, fmt.Errorf("an entry needs content or media")
 	}
 
-	// Read flash messages from cookies
-	var message, messageType string
-	if cookie, err := r.Cookie("flash_message"); err == nil {
-		message = cookie.Value
-		http.SetCookie(w, &http.Cookie{
-			Name:   "flash_message",
-			Value:  "",
-			Path:   "/",
-			MaxAge: -1,
-		})
+	// Scheduling moves the publication time; publishing a draft or a
+	// scheduled entry right away dates it now
+	var newPublishAt *time.Time
+	if publishAt.Valid && publishAt.Time.After(time.Now()) {
+		newPublishAt = &publishAt.Time
 	}
-	if cookie, err := r.Cookie("flash_type"); err == nil {
-		messageType = cookie.Value
-		http.SetCookie(w, &http.Cookie{
-			Name:   "flash_type",
-			Value:  "",
-			Path:   "/",
-			MaxAge: -1,
-		})
+	if input.PublishAt != nil {
+		newPublishAt = nil
+		if *input.PublishAt != "" {
+			t, err := time.Parse(time.RFC3339, *input.PublishAt)
+			if err != nil || !t.After(time.Now()) {
+				return 0, fmt.Errorf("publish_at must be an RFC 3339 time in the future")
+			}
+			newPublishAt = &t
+		}
 	}
-
-	// Also check URL query params for messages (used by domain handlers)
-	if msg := r.URL.Query().Get("success"); msg != "" {
-		message = msg
-		messageType = "success"
-	} else if msg := r.URL.Query().Get("error"); msg != "" {
-		message = msg
-		messageType = "error"
+	if status != "published" {
+		newPublishAt = nil
+	}
+	if newPublishAt != nil {
+		createdAt = *newPublishAt
+	} else if status == "published" && currentStatus != "unlisted" && !isEntryPublic(currentStatus, publishAt) {
+		createdAt = time.Now()
 	}
 
-	// Get custom domain info
-	customDomain, _ := getCustomDomain()
-	instanceHostname := getInstanceHostname()
-
-	// Custom domains only available for *.postastiq.com subdomains
-	customDomainEnabled := isPostastiqSubdomain(instanceHostname)
-
-	// Check if current request is from a postastiq.com subdomain (for showing enable button)
-	canEnableCustomDomain := false
-	if !customDomainEnabled {
-		currentHost := getHostFromRequest(r)
-		canEnableCustomDomain = isPostastiqSubdomain(currentHost)
+	finalTitle := entryTitle(content, contentFormat, createdAt)
+	slug := generateSlug(finalTitle, createdAt)
+	if customSlug != "" {
+		slug = customSlug
+	}
+
+	// The status is saved with the content, so an edit that unpublishes an
+	// entry is never briefly public
+	var revisionID int64
+	event := "entry.updated"
+	if entryID == 0 {
+		event = "entry.created"
+		result, err := db.Exec(`
+			INSERT INTO entries (title, content, photo_path, media_type, slug, slug_pinned, content_format, author_id, status, publish_at, created_at)
+			VALUES (?, ?, '', 'photo', ?, ?, 'markdown', ?, ?, ?, ?)
+		`, finalTitle, content, slug, customSlug != "", authorID, status, publishAtValue(newPublishAt), createdAt.UTC().Format(sqliteTimeLayout))
+		if err != nil {
+			log.Printf("Error inserting entry: %v", err)
+			return 0, fmt.Errorf("failed to create the entry")
+		}
+		id, _ := result.LastInsertId()
+		entryID = int(id)
+	} else {
+		var err error
+		revisionID, err = saveEntryRevision(entryID)
+		if err != nil {
+			log.Printf("Error saving revision: %v", err)
+		}
+		_, err = db.Exec("UPDATE entries SET title = ?, content = ?, slug = ?, slug_pinned = ?, status = ?, publish_at = ?, created_at = ? WHERE id = ?",
+			finalTitle, content, slug, customSlug != "", status, publishAtValue(newPublishAt), createdAt.UTC().Format(sqliteTimeLayout), entryID)
+		if err != nil {
+			log.Printf("Error updating entry: %v", err)
+			return 0, fmt.Errorf("failed to update the entry")
+		}
+		recordSlugChange(entryID, currentSlug, slug)
 	}
 
-	// Set page title based on view
-	pageTitles := map[string]string{
-		"site-info":  "Site Info",
-		"appearance": "Appearance",
-		"security":   "Security",
-		"backup":     "Backup",
+	if err := setEntryMedia(int64(entryID), media); err != nil {
+		log.Printf("Error saving media: %v", err)
 	}
-	pageTitle := pageTitles[view]
-	if pageTitle == "" {
-		pageTitle = "Settings"
+	if err := syncPrimaryMedia(int64(entryID)); err != nil {
+		log.Printf("Error updating primary media: %v", err)
+	}
+	syncSearchIndex(int64(entryID))
+	if err := setEntryTags(int64(entryID), tags); err != nil {
+		log.Printf("Error saving tags: %v", err)
 	}
+	if revisionID > 0 {
+		discardUnchangedRevision(revisionID)
+	}
+	announceEntryChange(entryID, getBaseURL(r))
+	triggerEntryWebhooks(event, entryID, getBaseURL(r))
+	return entryID, nil
+}
 
-	data := SettingsPageData{
-		Settings:              settings,
-		EnableSubtitle:        enableSubtitle,
-		Message:               message,
-		MessageType:           messageType,
-		CustomDomain:          customDomain,
-		InstanceHostname:      instanceHostname,
-		CustomDomainEnabled:   customDomainEnabled,
-		CanEnableCustomDomain: canEnableCustomDomain,
-		View:                  view,
-		PageTitle:             pageTitle,
+func handleAPIDeleteEntry(w http.ResponseWriter, r *http.Request, entryID int) {
+	var exists bool
+	db.QueryRow("SELECT COUNT(*) FROM entries WHERE id = ?", entryID).Scan(&exists)
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "not_found", "No such entry")
+		return
+	}
+	if err := deleteEntry(entryID, getBaseURL(r)); err != nil {
+		log.Printf("Error deleting entry %d: %v", entryID, err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to delete the entry")
+		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	tmpl, err := template.New("settings").Parse(settingsTemplate)
+// handleAPIListMedia lists the media items of all entries, newest entry first
+func handleAPIListMedia(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`
+		SELECT m.id, m.entry_id, m.path, m.media_type, m.thumbnail_path, m.alt_text
+		FROM entry_media m JOIN entries e ON e.id = m.entry_id
+		ORDER BY e.created_at DESC, m.entry_id DESC, m.position
+	`)
 	if err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Error listing media for API: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to list media")
 		return
 	}
-	tmpl.Execute(w, data)
+	defer rows.Close()
+
+	baseURL := getBaseURL(r)
+	media := []apiMedia{}
+	for rows.Next() {
+		var item EntryMedia
+		var entryID int
+		var thumbnailPath, altText sql.NullString
+		if err := rows.Scan(&item.ID, &entryID, &item.Path, &item.MediaType, &thumbnailPath, &altText); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		item.ThumbnailPath = thumbnailPath.String
+		item.AltText = altText.String
+		media = append(media, newAPIMedia(item, entryID, baseURL))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"media": media})
 }
 
-func handleSettingsUpdate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleAPIUploadMedia stores the multipart "file" field. The returned URL
+// can then be attached to entries through their media list.
+func handleAPIUploadMedia(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Expected a multipart upload")
+		return
+	}
+	path, mediaType, err := saveUploadedMedia(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", err.Error())
 		return
 	}
 
-	// Get the section being updated
-	section := r.FormValue("section")
-	if section == "" {
-		section = "site-info"
+	fileURL := getBaseURL(r) + "/uploads/" + path
+	w.Header().Set("Location", fileURL)
+	writeJSON(w, http.StatusCreated, map[string]string{"url": fileURL, "media_type": mediaType})
+}
+
+// handleAPIDeleteMedia removes a media item from its entry. The file stays,
+// as earlier revisions of the entry may use it.
+func handleAPIDeleteMedia(w http.ResponseWriter, r *http.Request, mediaID int) {
+	var entryID int
+	if err := db.QueryRow("SELECT entry_id FROM entry_media WHERE id = ?", mediaID).Scan(&entryID); err != nil {
+		writeJSONError(w, http.StatusNotFound, "not_found", "No such media item")
+		return
 	}
 
-	switch section {
-	case "site-info":
-		handleSiteInfoUpdate(w, r)
-	case "appearance":
-		handleAppearanceUpdate(w, r)
-	case "security":
-		handleSecurityUpdate(w, r)
-	default:
-		showSettingsMessage(w, r, "Invalid section", "error", section)
+	revisionID, err := saveEntryRevision(entryID)
+	if err != nil {
+		log.Printf("Error saving revision: %v", err)
+	}
+	var kept []EntryMedia
+	for _, item := range getMediaForEntries([]int{entryID})[entryID] {
+		if item.ID != mediaID {
+			kept = append(kept, item)
+		}
+	}
+	if err := setEntryMedia(int64(entryID), kept); err != nil {
+		log.Printf("Error removing media item %d: %v", mediaID, err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to remove the media item")
+		return
+	}
+	if err := syncPrimaryMedia(int64(entryID)); err != nil {
+		log.Printf("Error updating primary media: %v", err)
 	}
+	if revisionID > 0 {
+		discardUnchangedRevision(revisionID)
+	}
+	announceEntryChange(entryID, getBaseURL(r))
+	triggerEntryWebhooks("entry.updated", entryID, getBaseURL(r))
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func handleSiteInfoUpdate(w http.ResponseWriter, r *http.Request) {
-	siteTitle := r.FormValue("site_title")
-	siteSubtitle := r.FormValue("site_subtitle")
-	userInitial := r.FormValue("user_initial")
+func getAPISettings(baseURL string) (apiSettings, error) {
+	settings, err := getSiteSettings()
+	if err != nil {
+		return apiSettings{}, err
+	}
+	s := apiSettings{
+		SiteTitle:         settings.SiteTitle,
+		SiteSubtitle:      settings.SiteSubtitle,
+		UserInitial:       settings.UserInitial,
+		AvatarPreference:  settings.AvatarPreference,
+		SiteTheme:         settings.SiteTheme,
+		CustomBgColor:     settings.CustomBgColor,
+		CustomTextColor:   settings.CustomTextColor,
+		CustomAccentColor: settings.CustomAccentColor,
+	}
+	if settings.AvatarPath != "" {
+		s.AvatarURL = baseURL + "/uploads/" + settings.AvatarPath
+	}
+	return s, nil
+}
 
-	// Validate inputs
-	if siteTitle == "" || userInitial == "" {
-		showSettingsMessage(w, r, "Site title and user initial are required", "error", "site-info")
+func handleAPIGetSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := getAPISettings(getBaseURL(r))
+	if err != nil {
+		log.Printf("Error getting settings for API: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to load settings")
 		return
 	}
-	if enableSubtitle && siteSubtitle == "" {
-		showSettingsMessage(w, r, "Site subtitle is required", "error", "site-info")
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// handleAPIUpdateSettings changes the site info and appearance settings,
+// validated like the settings forms
+func handleAPIUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var input apiSettingsInput
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&input); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "The body must be a JSON object")
 		return
 	}
-
-	if len(userInitial) > 3 {
-		showSettingsMessage(w, r, "User initial must be 1-3 characters", "error", "site-info")
+	baseURL := getBaseURL(r)
+	s, err := getAPISettings(baseURL)
+	if err != nil {
+		log.Printf("Error getting settings for API: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to load settings")
 		return
 	}
 
-	// Handle avatar upload
-	file, header, err := r.FormFile("avatar")
-	if err == nil {
-		defer file.Close()
-		avatarPath, err := validateAndResizeAvatar(file, header.Filename)
-		if err != nil {
-			log.Printf("Error saving avatar: %v", err)
-			showSettingsMessage(w, r, "Failed to save avatar: "+err.Error(), "error", "site-info")
-			return
+	set := func(field *string, value *string) {
+		if value != nil {
+			*field = strings.TrimSpace(*value)
 		}
+	}
+	set(&s.SiteTitle, input.SiteTitle)
+	set(&s.SiteSubtitle, input.SiteSubtitle)
+	set(&s.UserInitial, input.UserInitial)
+	set(&s.AvatarPreference, input.AvatarPreference)
+	set(&s.SiteTheme, input.SiteTheme)
+	set(&s.CustomBgColor, input.CustomBgColor)
+	set(&s.CustomTextColor, input.CustomTextColor)
+	set(&s.CustomAccentColor, input.CustomAccentColor)
 
-		// Update avatar path in database
-		_, err = db.Exec("UPDATE site_settings SET avatar_path = ? WHERE id = 1", avatarPath)
-		if err != nil {
-			log.Printf("Error updating avatar path: %v", err)
-			showSettingsMessage(w, r, "Failed to update avatar", "error", "site-info")
-			return
-		}
+	var problem string
+	switch {
+	case s.SiteTitle == "" || s.UserInitial == "":
+		problem = "site_title and user_initial are required"
+	case enableSubtitle && s.SiteSubtitle == "":
+		problem = "site_subtitle is required"
+	case len(s.UserInitial) > 3:
+		problem = "user_initial must be 1-3 characters"
+	case s.AvatarPreference != "avatar" && s.AvatarPreference != "initials":
+		problem = "avatar_preference must be avatar or initials"
+	case s.SiteTheme != "default" && s.SiteTheme != "dark" && s.SiteTheme != "custom":
+		problem = "site_theme must be default, dark or custom"
+	case !hexColorPattern.MatchString(s.CustomBgColor) || !hexColorPattern.MatchString(s.CustomTextColor) || !hexColorPattern.MatchString(s.CustomAccentColor):
+		problem = "custom colors must be hex colors such as #ffffff"
+	}
+	if problem != "" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", problem)
+		return
 	}
 
-	// Update site info settings
 	_, err = db.Exec(`
 		UPDATE site_settings
-		SET site_title = ?, site_subtitle = ?, user_initial = ?
+		SET site_title = ?, site_subtitle = ?, user_initial = ?, avatar_preference = ?,
+		    site_theme = ?, custom_bg_color = ?, custom_text_color = ?, custom_accent_color = ?
 		WHERE id = 1
-	`, siteTitle, siteSubtitle, userInitial)
-
+	`, s.SiteTitle, s.SiteSubtitle, s.UserInitial, s.AvatarPreference, s.SiteTheme, s.CustomBgColor, s.CustomTextColor, s.CustomAccentColor)
 	if err != nil {
-		log.Printf("Error updating settings: %v", err)
-		showSettingsMessage(w, r, "Failed to update settings", "error", "site-info")
+		log.Printf("Error updating settings from API: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to update settings")
 		return
 	}
+	handleAPIGetSettings(w, r)
+}
 
-	showSettingsMessage(w, r, "Site info updated successfully!", "success", "site-info")
+// ============================================================================
+// IndieAuth
+// ============================================================================
+
+// indieAuthScope is a scope an IndieAuth client can request
+type indieAuthScope struct {
+	Name        string
+	Description string
+	Checked     bool // requested by the client
 }
 
-func handleAppearanceUpdate(w http.ResponseWriter, r *http.Request) {
-	siteTheme := r.FormValue("site_theme")
-	avatarPreference := r.FormValue("avatar_preference")
-	customBgColor := r.FormValue("custom_bg_color")
-	customTextColor := r.FormValue("custom_text_color")
-	customAccentColor := r.FormValue("custom_accent_color")
+// indieAuthScopes lists the scopes this server grants, in display order
+var indieAuthScopes = []indieAuthScope{
+	{Name: "profile", Description: "See the site name and avatar"},
+	{Name: "create", Description: "Create posts"},
+	{Name: "update", Description: "Edit posts"},
+	{Name: "delete", Description: "Delete posts"},
+	{Name: "media", Description: "Upload media"},
+}
 
-	// Set default avatar preference if not provided
-	if avatarPreference == "" {
-		avatarPreference = "initials"
+// indieAuthCode is an authorization code waiting to be redeemed. Codes are
+// single-use and expire after 10 minutes.
+type indieAuthCode struct {
+	UserID        int // who approved the request
+	ClientID      string
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+var indieAuthCodes = make(map[string]*indieAuthCode)
+var indieAuthMutex sync.Mutex
+
+// IndieAuthPageData is the data of the IndieAuth consent screen
+type IndieAuthPageData struct {
+	Error               string
+	SiteTitle           string
+	Me                  string
+	ClientID            string
+	ClientHost          string
+	RedirectURI         string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Scopes              []indieAuthScope
+	NeedsPassword       bool
+}
+
+// handleIndieAuthMetadata serves the authorization server metadata that
+// IndieAuth clients discover from the indieauth-metadata link
+func handleIndieAuthMetadata(w http.ResponseWriter, r *http.Request) {
+	baseURL := getBaseURL(r)
+	var scopes []string
+	for _, scope := range indieAuthScopes {
+		scopes = append(scopes, scope.Name)
 	}
 
-	// Set default custom colors if not provided
-	if customBgColor == "" {
-		customBgColor = "#fafafa"
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                 baseURL + "/",
+		"authorization_endpoint": baseURL + "/indieauth/auth",
+		"token_endpoint":         baseURL + "/indieauth/token",
+		"revocation_endpoint":    baseURL + "/indieauth/revoke",
+		"revocation_endpoint_auth_methods_supported":     []string{"none"},
+		"scopes_supported":                               scopes,
+		"response_types_supported":                       []string{"code"},
+		"grant_types_supported":                          []string{"authorization_code"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"authorization_response_iss_parameter_supported": true,
+	})
+}
+
+// validateIndieAuthClient checks the client_id and redirect_uri of a request.
+// Client metadata isn't fetched, so the redirect URI must be on the client's
+// own host.
+func validateIndieAuthClient(clientID, redirectURI string) (*url.URL, error) {
+	client, err := url.Parse(clientID)
+	if err != nil || (client.Scheme != "http" && client.Scheme != "https") || client.Host == "" ||
+		client.Fragment != "" || client.User != nil {
+		return nil, fmt.Errorf("client_id must be an http(s) URL")
 	}
-	if customTextColor == "" {
-		customTextColor = "#262626"
+	redirect, err := url.Parse(redirectURI)
+	if err != nil || (redirect.Scheme != "http" && redirect.Scheme != "https") || redirect.Host == "" {
+		return nil, fmt.Errorf("redirect_uri must be an http(s) URL")
 	}
-	if customAccentColor == "" {
-		customAccentColor = "#0095f6"
+	if redirect.Scheme != client.Scheme || !strings.EqualFold(redirect.Host, client.Host) {
+		return nil, fmt.Errorf("redirect_uri must be on the same host as client_id")
 	}
+	return client, nil
+}
 
-	// Validate color format (basic hex color validation)
-	colorRegex := regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
-	if !colorRegex.MatchString(customBgColor) || !colorRegex.MatchString(customTextColor) || !colorRegex.MatchString(customAccentColor) {
-		showSettingsMessage(w, r, "Invalid color format. Please use hex colors (e.g., #ffffff)", "error", "appearance")
+// redirectIndieAuth sends the user back to the client with the given
+// parameters
+func redirectIndieAuth(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, _ := url.Parse(redirectURI)
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("iss", getBaseURL(r)+"/")
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func renderIndieAuthPage(w http.ResponseWriter, status int, data IndieAuthPageData) {
+	if settings, err := getSiteSettings(); err == nil {
+		data.SiteTitle = settings.SiteTitle
+	}
+	tmpl := template.Must(template.New("indieauth").Parse(indieAuthTemplate))
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
+
+// handleIndieAuthAuthorize is the authorization endpoint. GET shows the
+// consent screen, a POST from it issues an authorization code, and a POST
+// from a client redeems a code for the profile URL alone.
+func handleIndieAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && r.FormValue("grant_type") == "authorization_code" {
+		code, errCode, errDescription := redeemIndieAuthCode(r)
+		if code == nil {
+			writeJSONError(w, http.StatusBadRequest, errCode, errDescription)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(indieAuthProfileResponse(r, code.Scope, nil))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Update appearance settings including custom colors
-	_, err := db.Exec(`
-		UPDATE site_settings
-		SET site_theme = ?, avatar_preference = ?, custom_bg_color = ?, custom_text_color = ?, custom_accent_color = ?
-		WHERE id = 1
-	`, siteTheme, avatarPreference, customBgColor, customTextColor, customAccentColor)
+	user := currentUser(r)
+	data := IndieAuthPageData{
+		Me:                  getBaseURL(r) + "/",
+		ClientID:            r.FormValue("client_id"),
+		RedirectURI:         r.FormValue("redirect_uri"),
+		State:               r.FormValue("state"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+		NeedsPassword:       user == nil,
+	}
 
+	// Problems with the client itself can't be reported back to it
+	client, err := validateIndieAuthClient(data.ClientID, data.RedirectURI)
 	if err != nil {
-		log.Printf("Error updating appearance settings: %v", err)
-		showSettingsMessage(w, r, "Failed to update appearance settings", "error", "appearance")
+		data.Error = err.Error()
+		renderIndieAuthPage(w, http.StatusBadRequest, data)
 		return
 	}
+	data.ClientHost = client.Host
 
-	showSettingsMessage(w, r, "Appearance updated successfully!", "success", "appearance")
-}
+	if r.Method == http.MethodGet {
+		if rt := r.FormValue("response_type"); rt != "code" && rt != "id" {
+			redirectIndieAuth(w, r, data.RedirectURI, url.Values{"error": {"unsupported_response_type"}, "state": {data.State}})
+			return
+		}
+	}
+	// The consent form carries these along, so check them again on POST
+	if !validIndieAuthChallenge(data) {
+		redirectIndieAuth(w, r, data.RedirectURI, url.Values{
+			"error":             {"invalid_request"},
+			"error_description": {"state and an S256 code_challenge are required"},
+			"state":             {data.State},
+		})
+		return
+	}
 
-func handleSecurityUpdate(w http.ResponseWriter, r *http.Request) {
-	adminPassword := r.FormValue("admin_password")
-	adminPasswordConfirm := r.FormValue("admin_password_confirm")
-	viewerPassword := r.FormValue("viewer_password")
-	removeViewerPassword := r.FormValue("remove_viewer_password")
+	if r.Method == http.MethodGet {
+		// "post" is the older name for creating posts and uploading media
+		requested := make(map[string]bool)
+		for _, scope := range strings.Fields(r.FormValue("scope")) {
+			requested[scope] = true
+			if scope == "post" {
+				requested["create"], requested["media"] = true, true
+			}
+		}
+		for _, scope := range indieAuthScopes {
+			if requested[scope.Name] {
+				scope.Checked = true
+				data.Scopes = append(data.Scopes, scope)
+			}
+		}
+		renderIndieAuthPage(w, http.StatusOK, data)
+		return
+	}
 
-	// Handle admin password update
-	if adminPassword != "" {
-		if adminPassword != adminPasswordConfirm {
-			showSettingsMessage(w, r, "Admin passwords do not match", "error", "security")
-			return
+	// Consent form submitted
+	if r.FormValue("action") != "approve" {
+		redirectIndieAuth(w, r, data.RedirectURI, url.Values{"error": {"access_denied"}, "state": {data.State}})
+		return
+	}
+	var wait time.Duration
+	if data.NeedsPassword {
+		if wait = loginWait(r, loginFormAdmin); wait == 0 {
+			defer releaseLoginAttempt(r, loginFormAdmin)
+			user = authenticateUser(r.FormValue("username"), r.FormValue("password"))
+			if user != nil && user.TwoFactorEnabled && !checkSecondFactor(user, r.FormValue("code")) {
+				user = nil
+			}
+			if user == nil {
+				recordLoginFailure(r, loginFormAdmin, r.FormValue("username"))
+			} else {
+				recordLoginSuccess(r, loginFormAdmin)
+			}
+		}
+	}
+	// Tokens aren't limited by role, so authors can't authorize apps
+	if user == nil || !user.CanPublish() {
+		for _, scope := range indieAuthScopes {
+			if slices.Contains(r.Form["requested_scope"], scope.Name) {
+				scope.Checked = slices.Contains(r.Form["scope"], scope.Name)
+				data.Scopes = append(data.Scopes, scope)
+			}
 		}
+		status := http.StatusUnauthorized
+		data.Error = "Invalid username, password or authentication code"
+		if user != nil {
+			status = http.StatusForbidden
+			data.Error = "Only owners and editors can authorize apps"
+		} else if wait > 0 {
+			status = http.StatusTooManyRequests
+			data.Error = rejectLoginAttempt(w, wait)
+		}
+		renderIndieAuthPage(w, status, data)
+		return
+	}
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(adminPassword), bcrypt.DefaultCost)
-		if err != nil {
-			log.Printf("Error hashing admin password: %v", err)
-			showSettingsMessage(w, r, "Failed to update admin password", "error", "security")
-			return
+	var granted []string
+	for _, scope := range indieAuthScopes {
+		if slices.Contains(r.Form["scope"], scope.Name) {
+			granted = append(granted, scope.Name)
 		}
+	}
 
-		// Update password and clear password_change_required flag
-		_, err = db.Exec("UPDATE site_settings SET admin_password_hash = ?, password_change_required = 0 WHERE id = 1", string(hashedPassword))
-		if err != nil {
-			log.Printf("Error updating admin password: %v", err)
-			showSettingsMessage(w, r, "Failed to update admin password", "error", "security")
-			return
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	indieAuthMutex.Lock()
+	now := time.Now()
+	for key, c := range indieAuthCodes {
+		if now.After(c.ExpiresAt) {
+			delete(indieAuthCodes, key)
 		}
 	}
+	indieAuthCodes[code] = &indieAuthCode{
+		UserID:        user.ID,
+		ClientID:      data.ClientID,
+		RedirectURI:   data.RedirectURI,
+		Scope:         strings.Join(granted, " "),
+		CodeChallenge: data.CodeChallenge,
+		ExpiresAt:     now.Add(10 * time.Minute),
+	}
+	indieAuthMutex.Unlock()
 
-	// Handle viewer password
-	if removeViewerPassword == "true" {
-		_, err := db.Exec("UPDATE site_settings SET viewer_password_hash = NULL WHERE id = 1")
-		if err != nil {
-			log.Printf("Error removing viewer password: %v", err)
-			showSettingsMessage(w, r, "Failed to remove viewer password", "error", "security")
-			return
+	redirectIndieAuth(w, r, data.RedirectURI, url.Values{"code": {code}, "state": {data.State}})
+}
+
+// validIndieAuthChallenge reports whether a request has a state and a PKCE
+// challenge that an S256 verifier can match
+func validIndieAuthChallenge(data IndieAuthPageData) bool {
+	if data.State == "" || data.CodeChallengeMethod != "S256" {
+		return false
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(data.CodeChallenge)
+	return err == nil && len(challenge) == sha256.Size
+}
+
+// indieAuthGrantsAccess reports whether a granted scope holds anything
+// besides the profile, which is answered without an access token
+func indieAuthGrantsAccess(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s != "profile" {
+			return true
 		}
-	} else if viewerPassword != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(viewerPassword), bcrypt.DefaultCost)
-		if err != nil {
-			log.Printf("Error hashing viewer password: %v", err)
-			showSettingsMessage(w, r, "Failed to update viewer password", "error", "security")
-			return
+	}
+	return false
+}
+
+// redeemIndieAuthCode looks up and consumes the authorization code of a
+// redemption request, checking the client, redirect URI and PKCE verifier.
+// On failure it returns nil with an OAuth error code and description.
+func redeemIndieAuthCode(r *http.Request) (*indieAuthCode, string, string) {
+	indieAuthMutex.Lock()
+	code, ok := indieAuthCodes[r.FormValue("code")]
+	delete(indieAuthCodes, r.FormValue("code"))
+	indieAuthMutex.Unlock()
+
+	if !ok || time.Now().After(code.ExpiresAt) {
+		return nil, "invalid_grant", "The authorization code is invalid or has expired"
+	}
+	if code.ClientID != r.FormValue("client_id") || code.RedirectURI != r.FormValue("redirect_uri") {
+		return nil, "invalid_grant", "client_id or redirect_uri don't match the authorization request"
+	}
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.CodeChallenge {
+		return nil, "invalid_grant", "The code_verifier doesn't match the code_challenge"
+	}
+	return code, "", ""
+}
+
+// indieAuthProfileResponse builds the "me" response of a code redemption,
+// including the site's name and avatar when the profile scope was granted
+func indieAuthProfileResponse(r *http.Request, scope string, extra map[string]interface{}) map[string]interface{} {
+	me := getBaseURL(r) + "/"
+	response := map[string]interface{}{"me": me}
+	for key, value := range extra {
+		response[key] = value
+	}
+	for _, s := range strings.Fields(scope) {
+		if s != "profile" {
+			continue
 		}
-
-		_, err = db.Exec("UPDATE site_settings SET viewer_password_hash = ? WHERE id = 1", string(hashedPassword))
-		if err != nil {
-			log.Printf("Error updating viewer password: %v", err)
-			showSettingsMessage(w, r, "Failed to update viewer password", "error", "security")
-			return
+		profile := map[string]string{"url": me}
+		if settings, err := getSiteSettings(); err == nil {
+			profile["name"] = settings.SiteTitle
+			if settings.AvatarPath != "" {
+				profile["photo"] = getBaseURL(r) + "/uploads/" + settings.AvatarPath
+			}
 		}
+		response["profile"] = profile
 	}
-
-	showSettingsMessage(w, r, "Security settings updated successfully!", "success", "security")
+	return response
 }
 
-func handleBackup(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// handleIndieAuthToken is the token endpoint. It exchanges authorization
+// codes for access tokens, revokes tokens (action=revoke), and on GET tells
+// resource servers what a bearer token grants.
+func handleIndieAuthToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		token := authenticateAccessToken(r)
+		if token == nil {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized", "A valid access token is required")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"me":        getBaseURL(r) + "/",
+			"client_id": token.ClientID,
+			"scope":     token.Scope,
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Get database path
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "/app/data/blog.db"
+	if r.FormValue("action") == "revoke" {
+		revokeAccessToken(r.FormValue("token"))
+		w.WriteHeader(http.StatusOK)
+		return
 	}
-
-	// Set headers for ZIP download
-	timestamp := time.Now().Format("2006-01-02")
-	filename := fmt.Sprintf("postastiq-backup-%s.zip", timestamp)
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-
-	// Create ZIP writer
-	zipWriter := zip.NewWriter(w)
-	defer zipWriter.Close()
-
-	// Add database file to ZIP
-	dbFile, err := os.Open(dbPath)
-	if err != nil {
-		log.Printf("Error opening database for backup: %v", err)
-		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+	if r.FormValue("grant_type") != "authorization_code" {
+		writeJSONError(w, http.StatusBadRequest, "unsupported_grant_type", "Only authorization_code is supported")
 		return
 	}
-	defer dbFile.Close()
 
-	dbInfo, err := dbFile.Stat()
-	if err != nil {
-		log.Printf("Error getting database info: %v", err)
-		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+	code, errCode, errDescription := redeemIndieAuthCode(r)
+	if code == nil {
+		writeJSONError(w, http.StatusBadRequest, errCode, errDescription)
 		return
 	}
-
-	dbHeader, err := zip.FileInfoHeader(dbInfo)
-	if err != nil {
-		log.Printf("Error creating ZIP header for database: %v", err)
-		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+	if !indieAuthGrantsAccess(code.Scope) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_grant", "The authorization was for signing in only; no access token can be issued")
 		return
 	}
-	dbHeader.Name = "blog.db"
-	dbHeader.Method = zip.Deflate
 
-	dbWriter, err := zipWriter.CreateHeader(dbHeader)
+	name := code.ClientID
+	if client, err := url.Parse(code.ClientID); err == nil {
+		name = client.Host
+	}
+	token, err := createAccessToken(name, code.Scope, code.ClientID, code.UserID)
 	if err != nil {
-		log.Printf("Error creating ZIP entry for database: %v", err)
-		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		log.Printf("Error creating access token: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Failed to issue a token")
 		return
 	}
 
-	_, err = io.Copy(dbWriter, dbFile)
-	if err != nil {
-		log.Printf("Error writing database to ZIP: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(indieAuthProfileResponse(r, code.Scope, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"scope":        code.Scope,
+	}))
+}
+
+// handleIndieAuthRevoke is the token revocation endpoint (RFC 7009). It
+// answers 200 whether or not the token existed.
+func handleIndieAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	revokeAccessToken(r.FormValue("token"))
+	w.WriteHeader(http.StatusOK)
+}
 
-	// Add uploads directory to ZIP
-	err = filepath.Walk(uploadsDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// ============================================================================
+// ActivityPub
+// ============================================================================
 
-		// Skip the uploads directory itself
-		if path == uploadsDir {
-			return nil
-		}
+// activityPubUsername is the account name of the blog on the fediverse, so
+// readers follow it as @blog@<host>
+const activityPubUsername = "blog"
 
-		// Get relative path
-		relPath, err := filepath.Rel(uploadsDir, path)
-		if err != nil {
-			return err
-		}
+const (
+	activityStreamsContext = "https://www.w3.org/ns/activitystreams"
+	activityStreamsPublic  = "https://www.w3.org/ns/activitystreams#Public"
+	activityJSONType       = "application/activity+json"
+	activityPubPageSize    = 20
+	maxActivityPubAttempts = 8
+)
 
-		// Create ZIP header
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
-		}
-		header.Name = filepath.Join("uploads", relPath)
+var (
+	// activityPubQueueSignal wakes the sender when new activities are queued
+	activityPubQueueSignal = make(chan struct{}, 1)
 
-		if info.IsDir() {
-			header.Name += "/"
-		} else {
-			header.Method = zip.Deflate
-		}
+	// activityPubGoneChecks holds followers that said they were deleted,
+	// waiting for their server to confirm it. activityPubGoneHosts has the
+	// hosts with a check waiting or running, so a server is only asked about
+	// one account at a time.
+	activityPubGoneChecks = make(chan activityPubGoneCheck, 100)
+	activityPubGoneHosts  = make(map[string]bool)
+	activityPubGoneMutex  sync.Mutex
 
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return err
+	// activityPubKeyMutex keeps two requests from generating different keys
+	activityPubKeyMutex sync.Mutex
+
+	signatureParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+	// errActivityPubGone is returned when a fetched document was deleted
+	errActivityPubGone = fmt.Errorf("document is gone")
+)
+
+// activityPubActor is the blog's actor document
+type activityPubActor struct {
+	Context                   []string            `json:"@context"`
+	ID                        string              `json:"id"`
+	Type                      string              `json:"type"`
+	PreferredUsername         string              `json:"preferredUsername"`
+	Name                      string              `json:"name"`
+	Summary                   string              `json:"summary,omitempty"`
+	URL                       string              `json:"url"`
+	Inbox                     string              `json:"inbox"`
+	Outbox                    string              `json:"outbox"`
+	Followers                 string              `json:"followers"`
+	Following                 string              `json:"following"`
+	Icon                      *activityPubImage   `json:"icon,omitempty"`
+	PublicKey                 activityPubKeyField `json:"publicKey"`
+	ManuallyApprovesFollowers bool                `json:"manuallyApprovesFollowers"`
+	Discoverable              bool                `json:"discoverable"`
+}
+
+type activityPubImage struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType,omitempty"`
+	URL       string `json:"url"`
+}
+
+type activityPubKeyField struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// activityPubNote is a post as followers see it
+type activityPubNote struct {
+	Context      string                  `json:"@context,omitempty"`
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	AttributedTo string                  `json:"attributedTo,omitempty"`
+	Name         string                  `json:"name,omitempty"`
+	Content      string                  `json:"content,omitempty"`
+	URL          string                  `json:"url,omitempty"`
+	Published    string                  `json:"published,omitempty"`
+	Updated      string                  `json:"updated,omitempty"`
+	To           []string                `json:"to,omitempty"`
+	Cc           []string                `json:"cc,omitempty"`
+	Attachment   []activityPubAttachment `json:"attachment,omitempty"`
+	Tag          []activityPubTag        `json:"tag,omitempty"`
+}
+
+type activityPubAttachment struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType"`
+	URL       string `json:"url"`
+	Name      string `json:"name,omitempty"`
+}
+
+type activityPubTag struct {
+	Type string `json:"type"`
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+// activityPubActivity wraps an object in a Create, Update, Delete or Accept
+type activityPubActivity struct {
+	Context   string      `json:"@context,omitempty"`
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Actor     string      `json:"actor"`
+	Object    interface{} `json:"object"`
+	Published string      `json:"published,omitempty"`
+	To        []string    `json:"to,omitempty"`
+	Cc        []string    `json:"cc,omitempty"`
+}
+
+// activityPubCollection is an OrderedCollection or one of its pages
+type activityPubCollection struct {
+	Context      string                `json:"@context,omitempty"`
+	ID           string                `json:"id"`
+	Type         string                `json:"type"`
+	TotalItems   *int                  `json:"totalItems,omitempty"`
+	First        string                `json:"first,omitempty"`
+	PartOf       string                `json:"partOf,omitempty"`
+	Next         string                `json:"next,omitempty"`
+	Prev         string                `json:"prev,omitempty"`
+	OrderedItems []activityPubActivity `json:"orderedItems,omitempty"`
+}
+
+// remoteActor is the part of another server's actor document we use. Keys
+// published as their own document fill Owner and PublicKeyPem instead.
+type remoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	Endpoints struct {
+		SharedInbox string `json:"sharedInbox"`
+	} `json:"endpoints"`
+	PublicKey    activityPubKeyField `json:"publicKey"`
+	Owner        string              `json:"owner"`
+	PublicKeyPem string              `json:"publicKeyPem"`
+}
+
+func activityPubActorURL(baseURL string) string {
+	return baseURL + "/activitypub/actor"
+}
+
+func activityPubObjectURL(baseURL string, entryID int) string {
+	return fmt.Sprintf("%s/activitypub/posts/%d", baseURL, entryID)
+}
+
+// getActivityPubKey returns the key activities are signed with, generating
+// and storing it on first use
+func getActivityPubKey() (*rsa.PrivateKey, error) {
+	activityPubKeyMutex.Lock()
+	defer activityPubKeyMutex.Unlock()
+
+	var keyPEM sql.NullString
+	if err := db.QueryRow("SELECT activitypub_private_key FROM site_settings WHERE id = 1").Scan(&keyPEM); err != nil {
+		return nil, err
+	}
+	if keyPEM.String != "" {
+		block, _ := pem.Decode([]byte(keyPEM.String))
+		if block == nil {
+			return nil, fmt.Errorf("stored ActivityPub key is not valid PEM")
 		}
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
 
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			_, err = io.Copy(writer, file)
-			if err != nil {
-				return err
-			}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if _, err := db.Exec("UPDATE site_settings SET activitypub_private_key = ? WHERE id = 1", string(encoded)); err != nil {
+		return nil, err
+	}
+	log.Println("Generated ActivityPub signing key")
+	return key, nil
+}
+
+func writeActivityJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", activityJSONType+"; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+}
+
+// handleWebFinger resolves acct:blog@<host>, and the actor and home page
+// URLs, to the blog's actor
+func handleWebFinger(w http.ResponseWriter, r *http.Request) {
+	resource := strings.TrimSpace(r.URL.Query().Get("resource"))
+	if resource == "" {
+		http.Error(w, "resource is required", http.StatusBadRequest)
+		return
+	}
+	baseURL := getBaseURL(r)
+	actorURL := activityPubActorURL(baseURL)
+
+	if strings.HasPrefix(resource, "acct:") {
+		user, host, _ := strings.Cut(strings.TrimPrefix(resource, "acct:"), "@")
+		if !strings.EqualFold(user, activityPubUsername) || !isOwnURL(&url.URL{Scheme: "https", Host: host}, r) {
+			http.NotFound(w, r)
+			return
 		}
+	} else if resource != actorURL && !sameURL(resource, baseURL+"/") {
+		http.NotFound(w, r)
+		return
+	}
 
-		return nil
+	w.Header().Set("Content-Type", "application/jrd+json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"subject": "acct:" + activityPubUsername + "@" + r.Host,
+		"aliases": []string{actorURL, baseURL + "/"},
+		"links": []map[string]string{
+			{"rel": "self", "type": activityJSONType, "href": actorURL},
+			{"rel": "http://webfinger.net/rel/profile-page", "type": "text/html", "href": baseURL + "/"},
+		},
 	})
+}
 
+// handleActivityPubActor serves the blog's actor, built from the site title,
+// subtitle and avatar
+func handleActivityPubActor(w http.ResponseWriter, r *http.Request) {
+	key, err := getActivityPubKey()
 	if err != nil {
-		log.Printf("Error adding uploads to ZIP: %v", err)
+		log.Printf("Error loading ActivityPub key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		log.Printf("Error encoding ActivityPub public key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
-	log.Printf("Backup created successfully: %s", filename)
+	settings, err := getSiteSettings()
+	if err != nil {
+		log.Printf("Error getting site settings for ActivityPub actor: %v", err)
+		settings = SiteSettings{SiteTitle: "My Blog"}
+	}
+
+	baseURL := getBaseURL(r)
+	actorURL := activityPubActorURL(baseURL)
+	actor := activityPubActor{
+		Context:           []string{activityStreamsContext, "https://w3id.org/security/v1"},
+		ID:                actorURL,
+		Type:              "Person",
+		PreferredUsername: activityPubUsername,
+		Name:              settings.SiteTitle,
+		URL:               baseURL + "/",
+		Inbox:             baseURL + "/activitypub/inbox",
+		Outbox:            baseURL + "/activitypub/outbox",
+		Followers:         baseURL + "/activitypub/followers",
+		Following:         baseURL + "/activitypub/following",
+		PublicKey: activityPubKeyField{
+			ID:           actorURL + "#main-key",
+			Owner:        actorURL,
+			PublicKeyPem: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+		},
+		Discoverable: true,
+	}
+	if settings.SiteSubtitle != "" {
+		actor.Summary = "<p>" + html.EscapeString(settings.SiteSubtitle) + "</p>"
+	}
+	if settings.AvatarPreference == "avatar" && settings.AvatarPath != "" {
+		actor.Icon = &activityPubImage{
+			Type:      "Image",
+			MediaType: mediaMIMEType(settings.AvatarPath, "photo"),
+			URL:       baseURL + "/uploads/" + settings.AvatarPath,
+		}
+	}
+	writeActivityJSON(w, actor)
 }
 
-func handleRestore(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// newActivityPubNote turns a public entry into a Note. Tags are appended to
+// the content as hashtag links, the way fediverse servers display them.
+func newActivityPubNote(entry EntryDisplay, updated time.Time, baseURL string) activityPubNote {
+	note := activityPubNote{
+		ID:           activityPubObjectURL(baseURL, entry.ID),
+		Type:         "Note",
+		AttributedTo: activityPubActorURL(baseURL),
+		Content:      string(entry.FullContent),
+		URL:          fmt.Sprintf("%s/posts/%s/", baseURL, entry.Slug),
+		Published:    entry.CreatedAt.UTC().Format(time.RFC3339),
+		To:           []string{activityStreamsPublic},
+		Cc:           []string{baseURL + "/activitypub/followers"},
+	}
+	if updated.After(entry.CreatedAt) {
+		note.Updated = updated.UTC().Format(time.RFC3339)
+	}
+
+	var hashtags []string
+	for _, tag := range entry.Tags {
+		tagURL := baseURL + "/tags/" + url.PathEscape(tag) + "/"
+		note.Tag = append(note.Tag, activityPubTag{Type: "Hashtag", Href: tagURL, Name: "#" + tag})
+		hashtags = append(hashtags, fmt.Sprintf(`<a href="%s" class="mention hashtag" rel="tag">#%s</a>`,
+			html.EscapeString(tagURL), html.EscapeString(tag)))
+	}
+	if len(hashtags) > 0 {
+		note.Content += "<p>" + strings.Join(hashtags, " ") + "</p>"
+	}
+
+	for _, media := range entry.Media {
+		note.Attachment = append(note.Attachment, activityPubAttachment{
+			Type:      "Document",
+			MediaType: mediaMIMEType(media.Path, media.MediaType),
+			URL:       baseURL + string(media.URL),
+			Name:      media.AltText,
+		})
 	}
+	return note
+}
 
-	// Use small memory buffer (4MB) - excess streams to temp files automatically
-	// This keeps memory usage low for large backup files
-	err := r.ParseMultipartForm(4 << 20)
+// getActivityPubNote loads a public entry as a Note, returning false if it
+// doesn't exist or readers can't see it
+func getActivityPubNote(entryID int, baseURL string) (activityPubNote, bool) {
+	entries, _, err := queryEntries("id = ?", []interface{}{entryID}, 0, 1)
 	if err != nil {
-		log.Printf("Error parsing restore form: %v", err)
-		showSettingsMessage(w, r, "Failed to parse upload", "error", "backup")
-		return
+		log.Printf("Error loading entry %d for ActivityPub: %v", entryID, err)
+		return activityPubNote{}, false
+	}
+	if len(entries) == 0 {
+		return activityPubNote{}, false
+	}
+	updated, _ := feedTimes(entries)
+	return newActivityPubNote(entries[0], updated[0], baseURL), true
+}
+
+// newEntryActivity wraps an entry's object in an activity addressed to the
+// public and the blog's followers
+func newEntryActivity(activityType string, object interface{}, objectID, baseURL string) activityPubActivity {
+	return activityPubActivity{
+		Context:   activityStreamsContext,
+		ID:        fmt.Sprintf("%s#%s-%d", objectID, strings.ToLower(activityType), time.Now().UnixNano()),
+		Type:      activityType,
+		Actor:     activityPubActorURL(baseURL),
+		Object:    object,
+		Published: time.Now().UTC().Format(time.RFC3339),
+		To:        []string{activityStreamsPublic},
+		Cc:        []string{baseURL + "/activitypub/followers"},
 	}
+}
 
-	// Get uploaded file
-	file, header, err := r.FormFile("backup_file")
+// handleActivityPubObject serves the Note of a public entry
+func handleActivityPubObject(w http.ResponseWriter, r *http.Request) {
+	entryID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/activitypub/posts/"))
 	if err != nil {
-		log.Printf("Error getting backup file: %v", err)
-		showSettingsMessage(w, r, "No backup file provided", "error", "backup")
+		http.NotFound(w, r)
 		return
 	}
+	note, ok := getActivityPubNote(entryID, getBaseURL(r))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	note.Context = activityStreamsContext
+	writeActivityJSON(w, note)
+}
 
-	// Validate file extension
-	if !strings.HasSuffix(strings.ToLower(header.Filename), ".zip") {
-		file.Close()
-		showSettingsMessage(w, r, "Invalid file type. Please upload a ZIP file.", "error", "backup")
+// handleActivityPubOutbox lists the blog's public posts as Create activities,
+// newest first, in pages of activityPubPageSize
+func handleActivityPubOutbox(w http.ResponseWriter, r *http.Request) {
+	baseURL := getBaseURL(r)
+	outboxURL := baseURL + "/activitypub/outbox"
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM entries WHERE " + publicEntryCondition).Scan(&total); err != nil {
+			log.Printf("Error counting entries for outbox: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeActivityJSON(w, activityPubCollection{
+			Context:    activityStreamsContext,
+			ID:         outboxURL,
+			Type:       "OrderedCollection",
+			TotalItems: &total,
+			First:      outboxURL + "?page=1",
+		})
 		return
 	}
 
-	// Stream upload to temp file using small buffer to minimize memory
-	tempFile, err := os.CreateTemp("", "postastiq-restore-*.zip")
+	entries, hasMore, err := getEntries((page-1)*activityPubPageSize, activityPubPageSize)
 	if err != nil {
-		file.Close()
-		log.Printf("Error creating temp file: %v", err)
-		showSettingsMessage(w, r, "Failed to process upload", "error", "backup")
+		log.Printf("Error getting entries for outbox: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	tempPath := tempFile.Name()
-	defer os.Remove(tempPath)
+	updated, _ := feedTimes(entries)
 
-	// Use small copy buffer (32KB) to minimize memory usage
-	copyBuf := make([]byte, 32*1024)
-	written, err := io.CopyBuffer(tempFile, file, copyBuf)
-	tempFile.Close()
-	file.Close()
+	collection := activityPubCollection{
+		Context:      activityStreamsContext,
+		ID:           fmt.Sprintf("%s?page=%d", outboxURL, page),
+		Type:         "OrderedCollectionPage",
+		PartOf:       outboxURL,
+		OrderedItems: []activityPubActivity{},
+	}
+	if hasMore {
+		collection.Next = fmt.Sprintf("%s?page=%d", outboxURL, page+1)
+	}
+	if page > 1 {
+		collection.Prev = fmt.Sprintf("%s?page=%d", outboxURL, page-1)
+	}
+	for i, entry := range entries {
+		note := newActivityPubNote(entry, updated[i], baseURL)
+		activity := newEntryActivity("Create", note, note.ID, baseURL)
+		activity.ID = note.ID + "#create"
+		activity.Context = ""
+		activity.Published = note.Published
+		collection.OrderedItems = append(collection.OrderedItems, activity)
+	}
+	writeActivityJSON(w, collection)
+}
 
-	// Release multipart form data to free memory
-	r.MultipartForm.RemoveAll()
-	runtime.GC()
+// handleActivityPubFollowers publishes how many accounts follow the blog
+// without listing them. Following is always empty.
+func handleActivityPubFollowers(w http.ResponseWriter, r *http.Request) {
+	var total int
+	if r.URL.Path == "/activitypub/followers" {
+		db.QueryRow("SELECT COUNT(*) FROM activitypub_followers").Scan(&total)
+	}
+	writeActivityJSON(w, activityPubCollection{
+		Context:    activityStreamsContext,
+		ID:         getBaseURL(r) + r.URL.Path,
+		Type:       "OrderedCollection",
+		TotalItems: &total,
+	})
+}
 
-	if err != nil {
-		log.Printf("Error writing to temp file: %v", err)
-		showSettingsMessage(w, r, "Failed to save upload", "error", "backup")
-		return
+// activityObjectID returns the ID of an activity's object, which may be given
+// as a URL or embedded
+func activityObjectID(raw json.RawMessage) string {
+	var id string
+	if json.Unmarshal(raw, &id) == nil {
+		return id
 	}
-	log.Printf("Received backup file: %s (%d bytes)", header.Filename, written)
+	var object struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(raw, &object)
+	return object.ID
+}
 
-	// Get paths
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "/app/data/blog.db"
+// handleActivityPubInbox receives activities from other servers. Follow adds
+// a follower and is accepted right away; Undo of a Follow and deletion of the
+// account remove it. Other activities are acknowledged and ignored.
+func handleActivityPubInbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	tempDBPath := dbPath + ".restore-temp"
 
-	// Process ZIP file with minimal memory: extract database first
-	// We open/close the ZIP reader for each phase to allow GC between phases
-	err = extractDatabaseFromZip(tempPath, tempDBPath, copyBuf)
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
 	if err != nil {
-		if err.Error() == "missing blog.db" {
-			showSettingsMessage(w, r, "Invalid backup: missing blog.db", "error", "backup")
-		} else {
-			log.Printf("Error extracting database: %v", err)
-			showSettingsMessage(w, r, "Failed to extract database", "error", "backup")
-		}
+		http.Error(w, "Error reading request", http.StatusBadRequest)
 		return
 	}
-
-	// Close current database connection before replacing file
-	if db != nil {
-		db.Close()
-		db = nil
+	var activity struct {
+		ID     string          `json:"id"`
+		Type   string          `json:"type"`
+		Actor  string          `json:"actor"`
+		Object json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal(body, &activity); err != nil || activity.Actor == "" {
+		http.Error(w, "Invalid activity", http.StatusBadRequest)
+		return
 	}
 
-	// Move temp database to final location (atomic on same filesystem)
-	if err := os.Rename(tempDBPath, dbPath); err != nil {
-		log.Printf("Error replacing database file: %v", err)
-		os.Remove(tempDBPath)
-		reconnectDB(dbPath)
-		showSettingsMessage(w, r, "Failed to replace database", "error", "backup")
+	baseURL := getBaseURL(r)
+
+	// A deleted account's key is gone with it, so its signature can't be
+	// checked. Its server is asked instead, later.
+	if activity.Type == "Delete" && activityObjectID(activity.Object) == activity.Actor {
+		queueActivityPubGoneCheck(activity.Actor, baseURL)
+		w.WriteHeader(http.StatusAccepted)
 		return
 	}
 
-	// Reconnect to the restored database immediately
-	if err := reconnectDB(dbPath); err != nil {
-		log.Printf("Error reconnecting to restored database: %v", err)
-		showSettingsMessage(w, r, "Database restored but reconnection failed. Please restart the server.", "error", "backup")
+	actor, err := verifyActivityPubSignature(r, body, baseURL)
+	if err != nil {
+		log.Printf("Rejected %s activity from %s: %v", activity.Type, activity.Actor, err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if actor.ID != activity.Actor {
+		http.Error(w, "Signature does not match the actor", http.StatusUnauthorized)
 		return
 	}
 
-	// Run database migrations to add any missing columns from newer versions
-	if err := runDatabaseMigrations(); err != nil {
-		log.Printf("Warning: some database migrations failed after restore: %v", err)
-		// Continue anyway - the core restore worked
+	switch activity.Type {
+	case "Follow":
+		if activityObjectID(activity.Object) != activityPubActorURL(baseURL) {
+			http.Error(w, "Only the blog can be followed", http.StatusBadRequest)
+			return
+		}
+		if err := addActivityPubFollower(actor, activity.ID); err != nil {
+			log.Printf("Error adding follower %s: %v", actor.ID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		actorURL := activityPubActorURL(baseURL)
+		queueActivity(actor.Inbox, activityPubActivity{
+			Context: activityStreamsContext,
+			ID:      fmt.Sprintf("%s#accept-%d", actorURL, time.Now().UnixNano()),
+			Type:    "Accept",
+			Actor:   actorURL,
+			Object:  json.RawMessage(body),
+		})
+		log.Printf("New ActivityPub follower: %s", actor.ID)
+
+	case "Undo":
+		var undone struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(activity.Object, &undone)
+		var followID string
+		db.QueryRow("SELECT follow_id FROM activitypub_followers WHERE actor_id = ?", actor.ID).Scan(&followID)
+		if undone.Type == "Follow" || (followID != "" && activityObjectID(activity.Object) == followID) {
+			if _, err := db.Exec("DELETE FROM activitypub_followers WHERE actor_id = ?", actor.ID); err != nil {
+				log.Printf("Error removing follower %s: %v", actor.ID, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("ActivityPub follower left: %s", actor.ID)
+		}
 	}
 
-	// Force GC before extracting uploads
-	runtime.GC()
+	w.WriteHeader(http.StatusAccepted)
+}
 
-	// Extract upload files (database is already reconnected)
-	err = extractUploadsFromZip(tempPath, uploadsDir, copyBuf)
-	if err != nil {
-		log.Printf("Warning: some upload files may not have been restored: %v", err)
+func addActivityPubFollower(actor *remoteActor, followID string) error {
+	if actor.Inbox == "" {
+		return fmt.Errorf("actor has no inbox")
 	}
+	_, err := db.Exec(`
+		INSERT INTO activitypub_followers (actor_id, inbox, shared_inbox, follow_id, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(actor_id) DO UPDATE SET inbox = excluded.inbox, shared_inbox = excluded.shared_inbox, follow_id = excluded.follow_id
+	`, actor.ID, actor.Inbox, actor.Endpoints.SharedInbox, followID, time.Now().UTC().Format(sqliteTimeLayout))
+	return err
+}
 
-	log.Printf("Backup restored successfully from: %s", header.Filename)
-	showSettingsMessage(w, r, "Backup restored successfully!", "success", "backup")
+// httpSigningString builds the string covered by an HTTP signature from the
+// listed headers
+func httpSigningString(headers []string, method, target, host string, header http.Header) string {
+	lines := make([]string, len(headers))
+	for i, name := range headers {
+		var value string
+		switch name {
+		case "(request-target)":
+			value = strings.ToLower(method) + " " + target
+		case "host":
+			value = host
+		default:
+			value = strings.Join(header.Values(name), ", ")
+		}
+		lines[i] = name + ": " + value
+	}
+	return strings.Join(lines, "\n")
 }
 
-// extractDatabaseFromZip extracts only the blog.db file from the ZIP
-func extractDatabaseFromZip(zipPath, destPath string, buf []byte) error {
-	zipReader, err := zip.OpenReader(zipPath)
+func bodyDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signActivityPubRequest signs an outgoing request with the blog's key using
+// HTTP Signatures (draft-cavage, rsa-sha256), as Mastodon expects. Requests
+// with a body also carry its digest.
+func signActivityPubRequest(req *http.Request, body []byte, keyID string) error {
+	key, err := getActivityPubKey()
 	if err != nil {
-		return fmt.Errorf("invalid ZIP file: %w", err)
+		return err
 	}
-	defer zipReader.Close()
 
-	for _, f := range zipReader.File {
-		if f.Name == "blog.db" {
-			rc, err := f.Open()
-			if err != nil {
-				return fmt.Errorf("failed to open blog.db in ZIP: %w", err)
-			}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		req.Header.Set("Digest", bodyDigest(body))
+		headers = append(headers, "digest")
+	}
 
-			outFile, err := os.Create(destPath)
-			if err != nil {
-				rc.Close()
-				return fmt.Errorf("failed to create database file: %w", err)
-			}
+	hashed := sha256.Sum256([]byte(httpSigningString(headers, req.Method, req.URL.RequestURI(), req.URL.Host, req.Header)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(signature)))
+	return nil
+}
 
-			_, err = io.CopyBuffer(outFile, rc, buf)
-			outFile.Close()
-			rc.Close()
+// verifyActivityPubSignature checks the HTTP signature of an incoming activity
+// against its digest and the signer's published key, and returns the signer
+func verifyActivityPubSignature(r *http.Request, body []byte, baseURL string) (*remoteActor, error) {
+	params := make(map[string]string)
+	for _, m := range signatureParamPattern.FindAllStringSubmatch(r.Header.Get("Signature"), -1) {
+		params[m[1]] = m[2]
+	}
+	keyID := params["keyId"]
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if keyID == "" || err != nil || len(signature) == 0 {
+		return nil, fmt.Errorf("missing or malformed Signature header")
+	}
 
-			if err != nil {
-				os.Remove(destPath)
-				return fmt.Errorf("failed to write database file: %w", err)
-			}
-			return nil
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+	for _, required := range []string{"(request-target)", "host", "date", "digest"} {
+		if !slices.Contains(headers, required) {
+			return nil, fmt.Errorf("signature does not cover %s", required)
 		}
 	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil || time.Since(date).Abs() > 12*time.Hour {
+		return nil, fmt.Errorf("missing or stale Date header")
+	}
+	algorithm, digest, _ := strings.Cut(r.Header.Get("Digest"), "=")
+	if !strings.EqualFold(algorithm, "SHA-256") || "SHA-256="+digest != bodyDigest(body) {
+		return nil, fmt.Errorf("digest does not match the body")
+	}
 
-	return fmt.Errorf("missing blog.db")
-}
-
-// extractUploadsFromZip extracts upload files from the ZIP one at a time
-func extractUploadsFromZip(zipPath, uploadsDir string, buf []byte) error {
-	zipReader, err := zip.OpenReader(zipPath)
+	actor, err := fetchRemoteActor(keyID, baseURL)
 	if err != nil {
-		return fmt.Errorf("failed to open ZIP: %w", err)
+		return nil, fmt.Errorf("fetching key %s: %v", keyID, err)
+	}
+	block, _ := pem.Decode([]byte(actor.PublicKey.PublicKeyPem))
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not valid PEM", keyID)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not an RSA key", keyID)
 	}
-	defer zipReader.Close()
 
-	var lastErr error
-	for _, f := range zipReader.File {
-		if !strings.HasPrefix(f.Name, "uploads/") {
-			continue
-		}
+	hashed := sha256.Sum256([]byte(httpSigningString(headers, r.Method, r.URL.RequestURI(), r.Host, r.Header)))
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signature); err != nil {
+		return nil, fmt.Errorf("signature verification failed")
+	}
+	return actor, nil
+}
 
-		relPath := strings.TrimPrefix(f.Name, "uploads/")
-		if relPath == "" {
-			continue
+// fetchRemoteActor loads the actor owning a signing key. The key is usually a
+// fragment of the actor document but may be a document of its own. Documents
+// must carry the ID they were fetched from, and the key and its owner must be
+// on the same host, so one server can't speak for another's accounts.
+func fetchRemoteActor(keyID, baseURL string) (*remoteActor, error) {
+	var doc remoteActor
+	if err := fetchActivityPubDocument(keyID, baseURL, &doc); err != nil {
+		return nil, err
+	}
+	if !sameURL(doc.ID, keyID) {
+		return nil, fmt.Errorf("document at %s claims to be %s", keyID, doc.ID)
+	}
+	if doc.PublicKeyPem != "" && doc.Owner != "" {
+		key := activityPubKeyField{ID: keyID, Owner: doc.Owner, PublicKeyPem: doc.PublicKeyPem}
+		doc = remoteActor{}
+		if err := fetchActivityPubDocument(key.Owner, baseURL, &doc); err != nil {
+			return nil, err
 		}
-
-		targetPath := filepath.Join(uploadsDir, relPath)
-
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(targetPath, 0755)
-			continue
+		if !sameURL(doc.ID, key.Owner) {
+			return nil, fmt.Errorf("document at %s claims to be %s", key.Owner, doc.ID)
 		}
-
-		// Ensure parent directory exists
-		os.MkdirAll(filepath.Dir(targetPath), 0755)
-
-		// Extract file with minimal memory
-		if err := extractSingleFile(f, targetPath, buf); err != nil {
-			log.Printf("Error extracting %s: %v", f.Name, err)
-			lastErr = err
+		if doc.PublicKey.ID != keyID {
+			return nil, fmt.Errorf("owner does not list key %s", keyID)
 		}
+		doc.PublicKey = key
 	}
-
-	return lastErr
+	if doc.PublicKey.ID != keyID || doc.PublicKey.Owner != doc.ID {
+		return nil, fmt.Errorf("key is not published by its owner")
+	}
+	keyURL, err := url.Parse(keyID)
+	if err != nil {
+		return nil, err
+	}
+	actorURL, err := url.Parse(doc.ID)
+	if err != nil || !strings.EqualFold(keyURL.Host, actorURL.Host) {
+		return nil, fmt.Errorf("key %s and its owner are on different hosts", keyID)
+	}
+	return &doc, nil
 }
 
-// extractSingleFile extracts a single file from ZIP using provided buffer
-func extractSingleFile(f *zip.File, destPath string, buf []byte) error {
-	rc, err := f.Open()
-	if err != nil {
-		return err
+// fetchActivityPubDocument fetches an ActivityPub document with a signed GET,
+// which servers in secure mode require
+func fetchActivityPubDocument(rawURL, baseURL string, v interface{}) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("not an http(s) URL")
 	}
-	defer rc.Close()
+	u.Fragment = ""
 
-	outFile, err := os.Create(destPath)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
-
-	_, err = io.CopyBuffer(outFile, rc, buf)
-	return err
-}
-
-// reconnectDB closes the existing connection and opens a new one
-func reconnectDB(dbPath string) error {
-	// Close existing connection if any
-	if db != nil {
-		db.Close()
-		db = nil
+	req.Header.Set("Accept", activityJSONType+`, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`)
+	if err := signActivityPubRequest(req, nil, activityPubActorURL(baseURL)+"#main-key"); err != nil {
+		return err
 	}
-
-	var err error
-	db, err = sql.Open("sqlite3", dbPath)
+	resp, err := publicHTTPClient.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		return errActivityPubGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
 
-	// Configure connection pool for better reliability
-	db.SetMaxOpenConns(1)
-	db.SetMaxIdleConns(1)
-
-	return db.Ping()
+// announceEntryChange notifies other sites that an entry was created, edited
+// or deleted: linked pages by Webmention and followers by ActivityPub
+func announceEntryChange(entryID int, baseURL string) {
+	queueWebmentions(entryID, baseURL)
+	queueEntryActivities(entryID, baseURL)
 }
 
-func handleViewerAuth(w http.ResponseWriter, r *http.Request) {
-	// Get settings from database
-	settings, err := getSiteSettings()
-	if err != nil {
-		log.Printf("Error getting site settings: %v", err)
-		settings = SiteSettings{
-			SiteTitle: "My Blog",
-		}
+// queueEntryActivities tells followers about a change to an entry: Create
+// when it becomes public, Update on later edits and Delete once it's deleted
+// or no longer public. Scheduled entries are created when they go live.
+// Objects keep the address they were first announced under.
+func queueEntryActivities(entryID int, baseURL string) {
+	var viewerPassword sql.NullString
+	db.QueryRow("SELECT viewer_password_hash FROM site_settings WHERE id = 1").Scan(&viewerPassword)
+	if viewerPassword.String != "" {
+		return
 	}
 
-	if r.Method == http.MethodGet {
-		data := struct {
-			SiteTitle string
-			Error     string
-			Redirect  string
-		}{
-			SiteTitle: settings.SiteTitle,
-			Redirect:  r.URL.Query().Get("redirect"),
-		}
+	var state, announcedBaseURL sql.NullString
+	db.QueryRow("SELECT state, base_url FROM activitypub_posts WHERE entry_id = ?", entryID).Scan(&state, &announcedBaseURL)
+	announced := state.String == "published"
+	if announced {
+		baseURL = announcedBaseURL.String
+	}
 
-		tmpl, err := template.New("viewer-password").Parse(viewerPasswordTemplate)
-		if err != nil {
-			http.Error(w, "Template error", http.StatusInternalServerError)
-			return
-		}
-		tmpl.Execute(w, data)
+	var status sql.NullString
+	var publishAt sql.NullTime
+	err := db.QueryRow("SELECT status, publish_at FROM entries WHERE id = ?", entryID).Scan(&status, &publishAt)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error loading entry %d for ActivityPub: %v", entryID, err)
 		return
 	}
+	objectID := activityPubObjectURL(baseURL, entryID)
 
-	if r.Method == http.MethodPost {
-		password := r.FormValue("password")
-		redirect := r.FormValue("redirect")
-
-		// Get stored password hash from site_settings (not privacy_settings)
-		var passwordHash sql.NullString
-		err := db.QueryRow("SELECT viewer_password_hash FROM site_settings WHERE id = 1").Scan(&passwordHash)
-		if err != nil || !passwordHash.Valid {
-			// No password set, redirect to home or specified page
-			if redirect != "" {
-				http.Redirect(w, r, redirect, http.StatusSeeOther)
-			} else {
-				http.Redirect(w, r, "/", http.StatusSeeOther)
-			}
-			return
-		}
-
-		// Verify password
-		err = bcrypt.CompareHashAndPassword([]byte(passwordHash.String), []byte(password))
-		if err != nil {
-			data := struct {
-				SiteTitle string
-				Error     string
-				Redirect  string
-			}{
-				SiteTitle: settings.SiteTitle,
-				Error:     "Incorrect password",
-				Redirect:  redirect,
-			}
-
-			tmpl, _ := template.New("viewer-password").Parse(viewerPasswordTemplate)
-			tmpl.Execute(w, data)
+	if err == nil && isEntryPublic(status.String, publishAt) {
+		note, ok := getActivityPubNote(entryID, baseURL)
+		if !ok {
 			return
 		}
-
-		// Password correct, set viewer session cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "viewer_authenticated",
-			Value:    "true",
-			Path:     "/",
-			MaxAge:   86400 * 30, // 30 days
-			HttpOnly: true,
-		})
-
-		// Redirect to original page or home
-		if redirect != "" && strings.HasPrefix(redirect, "/") {
-			http.Redirect(w, r, redirect, http.StatusSeeOther)
-		} else {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+		activityType := "Create"
+		if announced {
+			activityType = "Update"
 		}
+		queueActivityForFollowers(newEntryActivity(activityType, note, objectID, baseURL))
+		setActivityPubPostState(entryID, baseURL, "published")
 		return
 	}
 
-	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-}
-
-func requireViewerAuth(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Check if viewer password is set in site_settings
-		var passwordHash sql.NullString
-		err := db.QueryRow("SELECT viewer_password_hash FROM site_settings WHERE id = 1").Scan(&passwordHash)
-		if err != nil || !passwordHash.Valid || passwordHash.String == "" {
-			// No password set, allow access
-			next(w, r)
-			return
-		}
-
-		// Check if user is authenticated
-		cookie, err := r.Cookie("viewer_authenticated")
-		if err != nil || cookie.Value != "true" {
-			// Not authenticated, redirect to password page with return URL
-			redirectURL := "/viewer-auth?redirect=" + template.URLQueryEscaper(r.URL.Path)
-			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
-			return
-		}
-
-		next(w, r)
+	if announced {
+		tombstone := map[string]string{"id": objectID, "type": "Tombstone"}
+		queueActivityForFollowers(newEntryActivity("Delete", tombstone, objectID, baseURL))
 	}
-}
-
-func handleLogout(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_token")
-	if err == nil {
-		deleteSession(cookie.Value)
+	if err == nil && status.String == "published" && publishAt.Valid {
+		setActivityPubPostState(entryID, baseURL, "scheduled")
+	} else if _, err := db.Exec("DELETE FROM activitypub_posts WHERE entry_id = ?", entryID); err != nil {
+		log.Printf("Error clearing ActivityPub state of entry %d: %v", entryID, err)
 	}
+}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		HttpOnly: true,
-	})
-
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+// queueScheduledEntryActivities announces a scheduled entry that just went
+// live, under the address it was scheduled from
+func queueScheduledEntryActivities(entryID int) {
+	var baseURL string
+	err := db.QueryRow("SELECT base_url FROM activitypub_posts WHERE entry_id = ? AND state = 'scheduled'", entryID).Scan(&baseURL)
+	if err == nil {
+		queueEntryActivities(entryID, baseURL)
+	}
 }
 
-func isPasswordChangeRequired() bool {
-	var required sql.NullInt64
-	err := db.QueryRow("SELECT password_change_required FROM site_settings WHERE id = 1").Scan(&required)
+func setActivityPubPostState(entryID int, baseURL, state string) {
+	_, err := db.Exec(`
+		INSERT INTO activitypub_posts (entry_id, base_url, state, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(entry_id) DO UPDATE SET base_url = excluded.base_url, state = excluded.state, updated_at = excluded.updated_at
+	`, entryID, baseURL, state, time.Now().UTC().Format(sqliteTimeLayout))
 	if err != nil {
-		return false
+		log.Printf("Error saving ActivityPub state of entry %d: %v", entryID, err)
 	}
-	return required.Valid && required.Int64 == 1
 }
 
-func handleChangePassword(w http.ResponseWriter, r *http.Request) {
-	// Verify user is authenticated
-	if !isAuthenticated(r) {
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
+// queueActivity stores an activity for delivery to one inbox
+func queueActivity(inbox string, activity activityPubActivity) {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		log.Printf("Error encoding %s activity: %v", activity.Type, err)
 		return
 	}
+	now := time.Now().UTC().Format(sqliteTimeLayout)
+	if _, err := db.Exec(`
+		INSERT INTO activitypub_deliveries (inbox, activity, status, attempts, next_attempt_at, last_error, created_at)
+		VALUES (?, ?, 'pending', 0, ?, '', ?)
+	`, inbox, string(body), now, now); err != nil {
+		log.Printf("Error queueing %s activity to %s: %v", activity.Type, inbox, err)
+	}
+	signalActivityPubSender()
+}
 
-	// Only allow access if password change is required (first login)
-	// Otherwise redirect to settings page for password changes
-	if !isPasswordChangeRequired() {
-		http.Redirect(w, r, "/admin/settings", http.StatusSeeOther)
+// queueActivityForFollowers stores an activity for delivery to every
+// follower, once per server when followers share an inbox
+func queueActivityForFollowers(activity activityPubActivity) {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		log.Printf("Error encoding %s activity: %v", activity.Type, err)
 		return
 	}
-
-	type ChangePasswordData struct {
-		Error   string
-		Success string
+	now := time.Now().UTC().Format(sqliteTimeLayout)
+	if _, err := db.Exec(`
+		INSERT INTO activitypub_deliveries (inbox, activity, status, attempts, next_attempt_at, last_error, created_at)
+		SELECT DISTINCT CASE WHEN shared_inbox != '' THEN shared_inbox ELSE inbox END, ?, 'pending', 0, ?, '', ?
+		FROM activitypub_followers
+	`, string(body), now, now); err != nil {
+		log.Printf("Error queueing %s activity for followers: %v", activity.Type, err)
 	}
+	signalActivityPubSender()
+}
 
-	if r.Method == http.MethodGet {
-		tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
-		tmpl.Execute(w, ChangePasswordData{})
-		return
+func signalActivityPubSender() {
+	select {
+	case activityPubQueueSignal <- struct{}{}:
+	default:
 	}
+}
 
-	if r.Method == http.MethodPost {
-		err := r.ParseForm()
-		if err != nil {
-			http.Error(w, "Bad request", http.StatusBadRequest)
-			return
+// startActivityPubSender delivers queued activities in the background,
+// checking the queue every minute and whenever something is queued
+func startActivityPubSender() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for {
+			sendQueuedActivities()
+			select {
+			case <-ticker.C:
+			case <-activityPubQueueSignal:
+			}
 		}
+	}()
+}
 
-		newPassword := r.FormValue("new_password")
-		confirmPassword := r.FormValue("confirm_password")
+// activityPubGoneCheck is a follower to look up after an unsigned Delete
+type activityPubGoneCheck struct {
+	ActorID string
+	BaseURL string
+	Host    string
+}
 
-		// Validate passwords
-		if newPassword == "" {
-			tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
-			tmpl.Execute(w, ChangePasswordData{Error: "Password cannot be empty"})
-			return
-		}
+// queueActivityPubGoneCheck queues a lookup of a follower that says it was
+// deleted. Anyone can send the Delete, so nothing is queued for accounts that
+// don't follow the blog, or while their server is already being asked.
+func queueActivityPubGoneCheck(actorID, baseURL string) {
+	var exists int
+	if db.QueryRow("SELECT 1 FROM activitypub_followers WHERE actor_id = ?", actorID).Scan(&exists) != nil {
+		return
+	}
+	u, err := url.Parse(actorID)
+	if err != nil || u.Host == "" {
+		return
+	}
+	host := strings.ToLower(u.Host)
 
-		if len(newPassword) < 8 {
-			tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
-			tmpl.Execute(w, ChangePasswordData{Error: "Password must be at least 8 characters long"})
-			return
+	activityPubGoneMutex.Lock()
+	defer activityPubGoneMutex.Unlock()
+	if activityPubGoneHosts[host] {
+		return
+	}
+	select {
+	case activityPubGoneChecks <- activityPubGoneCheck{ActorID: actorID, BaseURL: baseURL, Host: host}:
+		activityPubGoneHosts[host] = true
+	default:
+	}
+}
+
+// startActivityPubGoneChecker looks up queued followers in the background
+func startActivityPubGoneChecker() {
+	go func() {
+		for c := range activityPubGoneChecks {
+			checkActivityPubGone(c)
 		}
+	}()
+}
 
-		if newPassword != confirmPassword {
-			tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
-			tmpl.Execute(w, ChangePasswordData{Error: "Passwords do not match"})
+// checkActivityPubGone removes the follower if its server says the account
+// is gone
+func checkActivityPubGone(c activityPubGoneCheck) {
+	defer func() {
+		activityPubGoneMutex.Lock()
+		delete(activityPubGoneHosts, c.Host)
+		activityPubGoneMutex.Unlock()
+	}()
+	if err := fetchActivityPubDocument(c.ActorID, c.BaseURL, &remoteActor{}); err == errActivityPubGone {
+		if _, err := db.Exec("DELETE FROM activitypub_followers WHERE actor_id = ?", c.ActorID); err != nil {
+			log.Printf("Error removing deleted follower %s: %v", c.ActorID, err)
 			return
 		}
+		log.Printf("ActivityPub follower deleted: %s", c.ActorID)
+	}
+}
 
-		// Hash the new password
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-		if err != nil {
-			log.Printf("Error hashing password: %v", err)
-			tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
-			tmpl.Execute(w, ChangePasswordData{Error: "Failed to update password"})
-			return
+// sendQueuedActivities delivers the activities that are due. Failures are
+// retried with exponential backoff up to maxActivityPubAttempts times.
+func sendQueuedActivities() {
+	db.Exec("DELETE FROM activitypub_deliveries WHERE status != 'pending' AND created_at < datetime('now', '-30 days')")
+
+	rows, err := db.Query(`
+		SELECT id, inbox, activity, attempts FROM activitypub_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= ?
+		ORDER BY next_attempt_at
+		LIMIT 20
+	`, time.Now().UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		log.Printf("Error reading ActivityPub delivery queue: %v", err)
+		return
+	}
+	type queued struct {
+		id, attempts    int
+		inbox, activity string
+	}
+	var due []queued
+	for rows.Next() {
+		var q queued
+		if err := rows.Scan(&q.id, &q.inbox, &q.activity, &q.attempts); err == nil {
+			due = append(due, q)
 		}
+	}
+	rows.Close()
 
-		// Update password and clear the password_change_required flag
-		_, err = db.Exec("UPDATE site_settings SET admin_password_hash = ?, password_change_required = 0 WHERE id = 1", string(hashedPassword))
+	for _, q := range due {
+		status, retry, err := deliverActivity(q.inbox, []byte(q.activity))
+		lastError := ""
 		if err != nil {
-			log.Printf("Error updating password: %v", err)
-			tmpl := template.Must(template.New("change-password").Parse(changePasswordTemplate))
-			tmpl.Execute(w, ChangePasswordData{Error: "Failed to update password"})
-			return
+			lastError = err.Error()
+		}
+		attempts := q.attempts + 1
+		nextAttempt := time.Now().UTC()
+		if retry {
+			if attempts >= maxActivityPubAttempts {
+				status = "failed"
+			} else {
+				status = "pending"
+				nextAttempt = nextAttempt.Add(time.Minute << (2 * uint(attempts)))
+			}
+		}
+		if _, err := db.Exec(`
+			UPDATE activitypub_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?
+			WHERE id = ?
+		`, status, attempts, nextAttempt.Format(sqliteTimeLayout), lastError, q.id); err != nil {
+			log.Printf("Error updating ActivityPub delivery queue: %v", err)
 		}
 
-		log.Println("Admin password changed successfully, password_change_required cleared")
-
-		// Redirect to admin panel
-		http.Redirect(w, r, "/admin", http.StatusSeeOther)
-		return
+		if status == "gone" {
+			// The account was deleted; stop delivering to it
+			db.Exec("DELETE FROM activitypub_followers WHERE inbox = ?", q.inbox)
+			log.Printf("Removed ActivityPub follower with deleted inbox %s", q.inbox)
+		} else if status == "sent" {
+			log.Printf("Delivered activity to %s", q.inbox)
+		} else if err != nil {
+			log.Printf("Delivering activity to %s: %v", q.inbox, err)
+		}
 	}
-
-	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
-// HealthResponse represents the JSON response for the health endpoint
-type HealthResponse struct {
-	Status    string `json:"status"`
-	Bootstrap bool   `json:"bootstrap"`
-}
+// deliverActivity posts a signed activity to an inbox. It returns the
+// resulting queue status ("sent", "gone" or "failed") and whether the attempt
+// should be retried later.
+func deliverActivity(inbox string, body []byte) (string, bool, error) {
+	var activity struct {
+		Actor string `json:"actor"`
+	}
+	if err := json.Unmarshal(body, &activity); err != nil {
+		return "failed", false, err
+	}
 
-// adminRouter handles all /admin routes with a single prefix handler
-// This ensures all admin paths bypass viewer auth and only require admin auth
-func adminRouter(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
+	req, err := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return "failed", false, err
+	}
+	req.Header.Set("Content-Type", activityJSONType)
+	if err := signActivityPubRequest(req, body, activity.Actor+"#main-key"); err != nil {
+		return "", true, err
+	}
+	resp, err := publicHTTPClient.Do(req)
+	if err != nil {
+		return "", true, err
+	}
+	resp.Body.Close()
 
 	switch {
-	case path == "/admin" || path == "/admin/":
-		handleAdminIndex(w, r)
-	case path == "/admin/create":
-		handleCreate(w, r)
-	case path == "/admin/update":
-		handleUpdate(w, r)
-	case path == "/admin/delete":
-		handleDelete(w, r)
-	case path == "/admin/entries":
-		handleGetEntriesForAdmin(w, r)
-	case path == "/admin/privacy/set":
-		handleSetPrivacyPassword(w, r)
-	case path == "/admin/privacy/remove":
-		handleRemovePrivacyPassword(w, r)
-	case path == "/admin/settings":
-		handleSettings(w, r)
-	case path == "/admin/settings/appearance":
-		handleSettingsAppearance(w, r)
-	case path == "/admin/settings/security":
-		handleSettingsSecurity(w, r)
-	case path == "/admin/settings/backup":
-		handleSettingsBackup(w, r)
-	case path == "/admin/settings/update":
-		handleSettingsUpdate(w, r)
-	case path == "/admin/backup":
-		handleBackup(w, r)
-	case path == "/admin/restore":
-		handleRestore(w, r)
-	case path == "/admin/domain/add":
-		handleDomainAdd(w, r)
-	case path == "/admin/domain/verify":
-		handleDomainVerify(w, r)
-	case path == "/admin/domain/activate":
-		handleDomainActivate(w, r)
-	case path == "/admin/domain/remove":
-		handleDomainRemove(w, r)
-	case path == "/admin/domain/status":
-		handleDomainStatus(w, r)
-	case path == "/admin/domain/set-hostname":
-		handleSetInstanceHostname(w, r)
-	case path == "/admin/domain/enable":
-		handleEnableCustomDomain(w, r)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return "sent", false, nil
+	case resp.StatusCode == http.StatusGone:
+		return "gone", false, fmt.Errorf("inbox returned %s", resp.Status)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return "", true, fmt.Errorf("inbox returned %s", resp.Status)
 	default:
-		http.NotFound(w, r)
+		return "failed", false, fmt.Errorf("inbox returned %s", resp.Status)
 	}
 }
 
 // ============================================================================
-// Custom Domain HTTP Handlers
+// Webhooks
 // ============================================================================
 
-func handleDomainAdd(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	domain := strings.TrimSpace(r.FormValue("domain"))
-	if domain == "" {
-		http.Redirect(w, r, "/admin/settings?error=Domain+cannot+be+empty", http.StatusSeeOther)
-		return
-	}
+// Webhook is an endpoint that receives a signed JSON payload when one of its
+// events happens
+type Webhook struct {
+	ID        int
+	URL       string
+	Secret    string // key of the HMAC signature
+	Events    []string
+	CreatedAt time.Time
+}
 
-	cd, err := addCustomDomain(domain)
-	if err != nil {
-		http.Redirect(w, r, "/admin/settings?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
-		return
-	}
+// WebhookDelivery is one payload sent, or waiting to be sent, to a webhook
+type WebhookDelivery struct {
+	ID             int
+	WebhookURL     string
+	Event          string
+	Status         string // "pending", "sent" or "failed"
+	Attempts       int
+	ResponseStatus int
+	LastError      string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
 
-	log.Printf("Custom domain added: %s (token: %s)", cd.Domain, cd.VerificationToken[:20]+"...")
-	http.Redirect(w, r, "/admin/settings?success=Domain+added.+Please+configure+DNS+records.", http.StatusSeeOther)
+// webhookEntry describes the entry an entry.* event is about
+type webhookEntry struct {
+	ID        int        `json:"id"`
+	Title     string     `json:"title"`
+	Slug      string     `json:"slug"`
+	URL       string     `json:"url"`
+	Status    string     `json:"status"`
+	PublishAt *time.Time `json:"publish_at,omitempty"` // set while scheduled
 }
 
-func handleDomainVerify(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// webhookEvents are the events a webhook can subscribe to, in display order
+var webhookEvents = []string{"entry.created", "entry.published", "entry.updated", "entry.deleted", "backup.created", "backup.restored"}
 
-	err := verifyCustomDomain()
-	if err != nil {
-		http.Redirect(w, r, "/admin/settings?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
-		return
-	}
+const maxWebhookAttempts = 5
 
-	// Automatically activate after verification
-	err = activateCustomDomain()
+var (
+	// webhookQueueSignal wakes the sender when new deliveries are queued
+	webhookQueueSignal = make(chan struct{}, 1)
+
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// getWebhooks returns all webhooks, oldest first
+func getWebhooks() ([]Webhook, error) {
+	rows, err := db.Query("SELECT id, url, secret, events, created_at FROM webhooks ORDER BY id")
 	if err != nil {
-		log.Printf("Domain verified but activation failed: %v", err)
-		http.Redirect(w, r, "/admin/settings?success=Domain+verified.+Activation+pending.", http.StatusSeeOther)
-		return
+		return nil, err
 	}
+	defer rows.Close()
 
-	http.Redirect(w, r, "/admin/settings?success=Domain+verified+and+activated!", http.StatusSeeOther)
-}
-
-func handleDomainActivate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	var webhooks []Webhook
+	for rows.Next() {
+		var h Webhook
+		var events string
+		if err := rows.Scan(&h.ID, &h.URL, &h.Secret, &events, &h.CreatedAt); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		h.Events = strings.Fields(events)
+		webhooks = append(webhooks, h)
 	}
+	return webhooks, nil
+}
 
-	err := activateCustomDomain()
+// getWebhookDeliveries returns the most recent deliveries, newest first
+func getWebhookDeliveries(limit int) ([]WebhookDelivery, error) {
+	rows, err := db.Query(`
+		SELECT d.id, COALESCE(h.url, ''), d.event, d.status, d.attempts, d.response_status, d.last_error, d.created_at, d.updated_at
+		FROM webhook_deliveries d
+		LEFT JOIN webhooks h ON h.id = d.webhook_id
+		ORDER BY d.id DESC
+		LIMIT ?
+	`, limit)
 	if err != nil {
-		http.Redirect(w, r, "/admin/settings?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
-		return
+		return nil, err
 	}
+	defer rows.Close()
 
-	http.Redirect(w, r, "/admin/settings?success=Domain+activated!", http.StatusSeeOther)
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookURL, &d.Event, &d.Status, &d.Attempts, &d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
 }
 
-func handleDomainRemove(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// newWebhookPayload builds the JSON body sent for an event
+func newWebhookPayload(event string, data interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"event":      event,
+		"created_at": time.Now().UTC().Format(time.RFC3339),
+		"data":       data,
+	})
+}
 
-	err := removeCustomDomain()
+// queueWebhookDelivery stores a payload for delivery to one webhook and
+// returns the delivery's ID
+func queueWebhookDelivery(webhookID int, event string, payload []byte) (int64, error) {
+	now := time.Now().UTC().Format(sqliteTimeLayout)
+	result, err := db.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, status, attempts, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, 'pending', 0, ?, ?, ?)
+	`, webhookID, event, string(payload), now, now, now)
 	if err != nil {
-		http.Redirect(w, r, "/admin/settings?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
-		return
+		return 0, err
 	}
-
-	http.Redirect(w, r, "/admin/settings?success=Domain+removed.", http.StatusSeeOther)
+	return result.LastInsertId()
 }
 
-func handleDomainStatus(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// triggerWebhooks queues an event for every webhook subscribed to it
+func triggerWebhooks(event string, data interface{}) {
+	webhooks, err := getWebhooks()
+	if err != nil {
+		log.Printf("Error loading webhooks: %v", err)
 		return
 	}
-
-	cd, err := getCustomDomain()
+	payload, err := newWebhookPayload(event, data)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": err.Error(),
-		})
+		log.Printf("Error encoding %s webhook payload: %v", event, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if cd == nil {
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"configured": false,
-		})
-		return
+	queued := false
+	for _, h := range webhooks {
+		if !slices.Contains(h.Events, event) {
+			continue
+		}
+		if _, err := queueWebhookDelivery(h.ID, event, payload); err != nil {
+			log.Printf("Error queueing %s webhook to %s: %v", event, h.URL, err)
+			continue
+		}
+		queued = true
 	}
-
-	response := map[string]interface{}{
-		"configured":    true,
-		"domain":        cd.Domain,
-		"verified":      cd.VerifiedAt.Valid,
-		"activated":     cd.ActivatedAt.Valid,
-		"attempts":      cd.VerificationAttempts,
-		"attemptsLeft":  5 - cd.VerificationAttempts,
+	if queued {
+		select {
+		case webhookQueueSignal <- struct{}{}:
+		default:
+		}
 	}
+}
 
-	if cd.VerifiedAt.Valid {
-		response["verifiedAt"] = cd.VerifiedAt.Time.Format(time.RFC3339)
+// getWebhookEntry describes an entry for entry.* events, returning false if
+// it doesn't exist
+func getWebhookEntry(entryID int, baseURL string) (webhookEntry, bool) {
+	var title, slug, status sql.NullString
+	var publishAt sql.NullTime
+	err := db.QueryRow("SELECT title, slug, status, publish_at FROM entries WHERE id = ?", entryID).Scan(&title, &slug, &status, &publishAt)
+	if err != nil {
+		return webhookEntry{}, false
 	}
-	if cd.ActivatedAt.Valid {
-		response["activatedAt"] = cd.ActivatedAt.Time.Format(time.RFC3339)
+	entry := webhookEntry{
+		ID:     entryID,
+		Title:  title.String,
+		Slug:   slug.String,
+		URL:    baseURL + "/posts/" + slug.String + "/",
+		Status: status.String,
 	}
-	if cd.LastVerifiedAt.Valid {
-		response["lastVerifiedAt"] = cd.LastVerifiedAt.Time.Format(time.RFC3339)
+	if entry.Status == "" {
+		entry.Status = "published"
 	}
+	if publishAt.Valid {
+		entry.PublishAt = &publishAt.Time
+	}
+	return entry, true
+}
 
-	json.NewEncoder(w).Encode(response)
+// triggerEntryWebhooks queues entry.created, entry.published or
+// entry.updated for an entry. A scheduled entry remembers the address it was
+// saved from for the entry.published event sent when it goes live.
+func triggerEntryWebhooks(event string, entryID int, baseURL string) {
+	entry, ok := getWebhookEntry(entryID, baseURL)
+	if !ok {
+		return
+	}
+	if entry.PublishAt != nil {
+		if _, err := db.Exec("UPDATE entries SET scheduled_base_url = ? WHERE id = ?", baseURL, entryID); err != nil {
+			log.Printf("Error saving the address of scheduled entry %d: %v", entryID, err)
+		}
+	}
+	triggerWebhooks(event, entry)
 }
 
-func handleSetInstanceHostname(w http.ResponseWriter, r *http.Request) {
-	// Deprecated - redirect to enable endpoint
-	http.Redirect(w, r, "/admin/domain/enable", http.StatusSeeOther)
+// startWebhookSender delivers queued webhooks in the background, checking the
+// queue every minute and whenever something is queued
+func startWebhookSender() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for {
+			sendQueuedWebhooks()
+			select {
+			case <-ticker.C:
+			case <-webhookQueueSignal:
+			}
+		}
+	}()
 }
 
-// handleEnableCustomDomain enables custom domain feature by capturing hostname from current request
-func handleEnableCustomDomain(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// sendQueuedWebhooks delivers the webhooks that are due. Failures are retried
+// with exponential backoff up to maxWebhookAttempts times. The log keeps 30
+// days of finished deliveries.
+func sendQueuedWebhooks() {
+	db.Exec("DELETE FROM webhook_deliveries WHERE status != 'pending' AND created_at < datetime('now', '-30 days')")
+
+	rows, err := db.Query(`
+		SELECT id FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= ?
+		ORDER BY next_attempt_at
+		LIMIT 20
+	`, time.Now().UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		log.Printf("Error reading webhook queue: %v", err)
 		return
 	}
+	var due []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			due = append(due, id)
+		}
+	}
+	rows.Close()
 
-	// Check if already enabled
-	existingHostname := getInstanceHostname()
-	if existingHostname != "" {
-		http.Redirect(w, r, "/admin/settings?error=Custom+domains+already+enabled", http.StatusSeeOther)
-		return
+	for _, id := range due {
+		attemptWebhookDelivery(id, true)
 	}
+}
 
-	// Get hostname from current request
-	currentHost := getHostFromRequest(r)
-	if currentHost == "" {
-		http.Redirect(w, r, "/admin/settings?error=Could+not+detect+hostname", http.StatusSeeOther)
-		return
+// attemptWebhookDelivery sends a queued delivery once and records the result.
+// Failed attempts are scheduled again if retry is set and attempts remain,
+// unless the endpoint rejected the payload with a 4xx other than 429.
+// It returns the delivery's new status and the error of a failed attempt.
+func attemptWebhookDelivery(deliveryID int, retry bool) (string, error) {
+	var webhookURL, secret sql.NullString
+	var event, payload string
+	var attempts int
+	err := db.QueryRow(`
+		SELECT h.url, h.secret, d.event, d.payload, d.attempts
+		FROM webhook_deliveries d LEFT JOIN webhooks h ON h.id = d.webhook_id
+		WHERE d.id = ?
+	`, deliveryID).Scan(&webhookURL, &secret, &event, &payload, &attempts)
+	if err != nil {
+		return "", err
 	}
 
-	// Only allow postastiq.com subdomains
-	if !isPostastiqSubdomain(currentHost) {
-		http.Redirect(w, r, "/admin/settings?error=Custom+domains+only+available+for+postastiq.com+subdomains", http.StatusSeeOther)
-		return
+	var responseStatus int
+	if webhookURL.Valid {
+		responseStatus, err = sendWebhook(webhookURL.String, secret.String, deliveryID, event, []byte(payload))
+	} else {
+		err = fmt.Errorf("the webhook was deleted")
+		retry = false
 	}
 
-	// Store the hostname
-	err := setInstanceHostname(currentHost)
+	status := "sent"
+	lastError := ""
+	attempts++
+	nextAttempt := time.Now().UTC()
 	if err != nil {
-		log.Printf("Failed to enable custom domains: %v", err)
-		http.Redirect(w, r, "/admin/settings?error=Failed+to+enable+custom+domains", http.StatusSeeOther)
-		return
+		lastError = err.Error()
+		status = "failed"
+		rejected := responseStatus >= 400 && responseStatus < 500 && responseStatus != http.StatusTooManyRequests
+		if retry && !rejected && attempts < maxWebhookAttempts {
+			status = "pending"
+			nextAttempt = nextAttempt.Add(time.Minute << (2 * uint(attempts)))
+		}
+		log.Printf("Webhook %s to %s: %v", event, webhookURL.String, err)
+	}
+	_, dbErr := db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, next_attempt_at = ?, response_status = ?, last_error = ?, updated_at = ?
+		WHERE id = ?
+	`, status, attempts, nextAttempt.Format(sqliteTimeLayout), responseStatus, lastError,
+		time.Now().UTC().Format(sqliteTimeLayout), deliveryID)
+	if dbErr != nil {
+		log.Printf("Error updating webhook delivery %d: %v", deliveryID, dbErr)
+	}
+	return status, err
+}
+
+// sendWebhook posts a payload signed with the webhook's secret. Receivers
+// verify X-Postastiq-Signature, "sha256=" followed by the hex HMAC-SHA256 of
+// the body. It returns the response status code.
+func sendWebhook(webhookURL, secret string, deliveryID int, event string, payload []byte) (int, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Postastiq-Webhook")
+	req.Header.Set("X-Postastiq-Event", event)
+	req.Header.Set("X-Postastiq-Delivery", strconv.Itoa(deliveryID))
+	req.Header.Set("X-Postastiq-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
 
-	log.Printf("Custom domains enabled for: %s", currentHost)
-	http.Redirect(w, r, "/admin/settings?success=Custom+domains+enabled+for+"+url.QueryEscape(currentHost), http.StatusSeeOther)
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
 }
 
-// ============================================================================
-// End Custom Domain HTTP Handlers
-// ============================================================================
+// handleWebhookUpdate adds, deletes and tests webhooks from the settings page
+func handleWebhookUpdate(w http.ResponseWriter, r *http.Request) {
+	switch r.FormValue("action") {
+	case "create":
+		webhookURL := strings.TrimSpace(r.FormValue("webhook_url"))
+		u, err := url.Parse(webhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			showSettingsMessage(w, r, "Enter an http(s) URL", "error", "webhooks")
+			return
+		}
+		var events []string
+		for _, event := range webhookEvents {
+			if slices.Contains(r.Form["webhook_event"], event) {
+				events = append(events, event)
+			}
+		}
+		if len(events) == 0 {
+			showSettingsMessage(w, r, "Select at least one event", "error", "webhooks")
+			return
+		}
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			log.Printf("Error generating webhook secret: %v", err)
+			showSettingsMessage(w, r, "Failed to add webhook", "error", "webhooks")
+			return
+		}
+		_, err = db.Exec("INSERT INTO webhooks (url, secret, events) VALUES (?, ?, ?)",
+			webhookURL, hex.EncodeToString(b), strings.Join(events, " "))
+		if err != nil {
+			log.Printf("Error adding webhook: %v", err)
+			showSettingsMessage(w, r, "Failed to add webhook", "error", "webhooks")
+			return
+		}
+		showSettingsMessage(w, r, "Webhook added", "success", "webhooks")
+	case "delete":
+		id := r.FormValue("webhook_id")
+		if _, err := db.Exec("DELETE FROM webhooks WHERE id = ?", id); err != nil {
+			log.Printf("Error deleting webhook: %v", err)
+			showSettingsMessage(w, r, "Failed to delete webhook", "error", "webhooks")
+			return
+		}
+		db.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id)
+		showSettingsMessage(w, r, "Webhook deleted", "success", "webhooks")
+	case "test":
+		// Test events are sent right away, without retries
+		webhookID, _ := strconv.Atoi(r.FormValue("webhook_id"))
+		var exists bool
+		db.QueryRow("SELECT COUNT(*) FROM webhooks WHERE id = ?", webhookID).Scan(&exists)
+		if !exists {
+			showSettingsMessage(w, r, "Webhook not found", "error", "webhooks")
+			return
+		}
+		payload, err := newWebhookPayload("ping", map[string]string{"message": "Test event from the webhook settings"})
+		if err == nil {
+			var deliveryID int64
+			deliveryID, err = queueWebhookDelivery(webhookID, "ping", payload)
+			if err == nil {
+				_, err = attemptWebhookDelivery(int(deliveryID), false)
+			}
+		}
+		if err != nil {
+			showSettingsMessage(w, r, "Test failed: "+err.Error(), "error", "webhooks")
+			return
+		}
+		showSettingsMessage(w, r, "Test event delivered", "success", "webhooks")
+	default:
+		showSettingsMessage(w, r, "Invalid action", "error", "webhooks")
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
+// ============================================================================
+// Podcast feed
+// ============================================================================
 
-	// Check if admin password has been set (bootstrap complete)
-	var passwordHash sql.NullString
-	err := db.QueryRow("SELECT admin_password_hash FROM site_settings WHERE id = 1").Scan(&passwordHash)
+// PodcastSettings holds the iTunes channel tags of the podcast feed
+type PodcastSettings struct {
+	Author    string
+	Email     string
+	Category  string // "Technology" or "Arts > Books"
+	Explicit  bool
+	ImagePath string // channel artwork in uploads
+}
 
-	bootstrapComplete := err == nil && passwordHash.Valid && passwordHash.String != ""
+// podcastEntriesCondition restricts an entries query to posts with audio
+const podcastEntriesCondition = "id IN (SELECT entry_id FROM entry_media WHERE media_type = 'audio')"
 
-	if !bootstrapComplete {
-		// Still initializing - admin password not yet set
-		w.WriteHeader(http.StatusServiceUnavailable)
-		json.NewEncoder(w).Encode(HealthResponse{
-			Status:    "initializing",
-			Bootstrap: false,
-		})
+func getPodcastSettings() (PodcastSettings, error) {
+	var settings PodcastSettings
+	var author, email, category, imagePath sql.NullString
+	err := db.QueryRow(`
+		SELECT podcast_author, podcast_email, podcast_category, podcast_explicit, podcast_image_path
+		FROM site_settings WHERE id = 1
+	`).Scan(&author, &email, &category, &settings.Explicit, &imagePath)
+	settings.Author = author.String
+	settings.Email = email.String
+	settings.Category = category.String
+	settings.ImagePath = imagePath.String
+	return settings, err
+}
+
+func handlePodcastFeed(w http.ResponseWriter, r *http.Request) {
+	if checkNotModified(w, r, false) {
 		return
 	}
 
-	// App is ready
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(HealthResponse{
-		Status:    "ready",
-		Bootstrap: true,
-	})
-}
-
-func handleRSSFeed(w http.ResponseWriter, r *http.Request) {
-	// Get site settings
 	settings, err := getSiteSettings()
 	if err != nil {
-		log.Printf("Error getting site settings for RSS: %v", err)
+		log.Printf("Error getting site settings for podcast feed: %v", err)
 		settings = SiteSettings{
 			SiteTitle:    "My Blog",
 			SiteSubtitle: "A Personal Blog",
 		}
 	}
 
-	// Get latest 20 entries for RSS feed
-	entries, _, err := getEntries(0, 20)
+	podcast, err := getPodcastSettings()
 	if err != nil {
-		http.Error(w, "Error generating RSS feed", http.StatusInternalServerError)
-		log.Printf("Error getting entries for RSS: %v", err)
+		log.Printf("Error getting podcast settings: %v", err)
+	}
+
+	entries, _, err := queryEntries(podcastEntriesCondition, nil, 0, 100)
+	if err != nil {
+		http.Error(w, "Error generating podcast feed", http.StatusInternalServerError)
+		log.Printf("Error getting entries for podcast feed: %v", err)
 		return
 	}
 
-	// Build RSS feed XML
 	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
 
-	// Get the base URL from the request
-	scheme := "http"
-	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
-		scheme = "https"
+	baseURL := getBaseURL(r)
+	author := podcast.Author
+	if author == "" {
+		author = settings.SiteTitle
+	}
+	explicit := "false"
+	if podcast.Explicit {
+		explicit = "true"
 	}
-	baseURL := fmt.Sprintf("%s://%s", scheme, r.Host)
 
-	// Start RSS feed
 	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`)
-	fmt.Fprintf(w, `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">`)
+	fmt.Fprintf(w, `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">`)
 	fmt.Fprintf(w, `<channel>`)
 	fmt.Fprintf(w, `<title>%s</title>`, html.EscapeString(settings.SiteTitle))
-	fmt.Fprintf(w, `<link>%s</link>`, html.EscapeString(baseURL))
+	fmt.Fprintf(w, `<link>%s/</link>`, html.EscapeString(baseURL))
 	fmt.Fprintf(w, `<description>%s</description>`, html.EscapeString(settings.SiteSubtitle))
 	fmt.Fprintf(w, `<language>en-us</language>`)
-	fmt.Fprintf(w, `<atom:link href="%s/rss" rel="self" type="application/rss+xml" />`, html.EscapeString(baseURL))
-
-	// Add lastBuildDate (current time)
-	fmt.Fprintf(w, `<lastBuildDate>%s</lastBuildDate>`, time.Now().UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(w, `<atom:link href="%s/podcast.xml" rel="self" type="application/rss+xml" />`, html.EscapeString(baseURL))
+	_, lastBuild := feedTimes(entries)
+	fmt.Fprintf(w, `<lastBuildDate>%s</lastBuildDate>`, lastBuild.UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(w, `<itunes:author>%s</itunes:author>`, html.EscapeString(author))
+	fmt.Fprintf(w, `<itunes:summary>%s</itunes:summary>`, html.EscapeString(settings.SiteSubtitle))
+	fmt.Fprintf(w, `<itunes:type>episodic</itunes:type>`)
+	fmt.Fprintf(w, `<itunes:explicit>%s</itunes:explicit>`, explicit)
+	if podcast.Email != "" {
+		fmt.Fprintf(w, `<itunes:owner><itunes:name>%s</itunes:name><itunes:email>%s</itunes:email></itunes:owner>`,
+			html.EscapeString(author), html.EscapeString(podcast.Email))
+	}
+	if podcast.ImagePath != "" {
+		imageURL := baseURL + "/uploads/" + podcast.ImagePath
+		fmt.Fprintf(w, `<itunes:image href="%s" />`, html.EscapeString(imageURL))
+		fmt.Fprintf(w, `<image><url>%s</url><title>%s</title><link>%s/</link></image>`,
+			html.EscapeString(imageURL), html.EscapeString(settings.SiteTitle), html.EscapeString(baseURL))
+	}
+	if podcast.Category != "" {
+		// "Parent > Child" becomes a nested subcategory
+		parts := strings.SplitN(podcast.Category, ">", 2)
+		fmt.Fprintf(w, `<itunes:category text="%s">`, html.EscapeString(strings.TrimSpace(parts[0])))
+		if len(parts) == 2 {
+			fmt.Fprintf(w, `<itunes:category text="%s" />`, html.EscapeString(strings.TrimSpace(parts[1])))
+		}
+		fmt.Fprintf(w, `</itunes:category>`)
+	}
 
-	// Add items
 	for _, entry := range entries {
-		fmt.Fprintf(w, `<item>`)
-
-		// Title - use content preview if no title
-		title := entry.Title
-		if title == "" {
-			// Use first 60 characters of content as title
-			contentRunes := []rune(string(entry.Content))
-			if len(contentRunes) > 60 {
-				title = string(contentRunes[:60]) + "..."
-			} else {
-				title = string(entry.Content)
+		// The first audio item is the episode
+		var episode *EntryMedia
+		for i := range entry.Media {
+			if entry.Media[i].MediaType == "audio" {
+				episode = &entry.Media[i]
+				break
 			}
 		}
-		fmt.Fprintf(w, `<title>%s</title>`, html.EscapeString(title))
+		if episode == nil {
+			continue
+		}
 
-		// Link to individual post
 		postURL := fmt.Sprintf("%s/posts/%s/", baseURL, entry.Slug)
-		fmt.Fprintf(w, `<link>%s</link>`, html.EscapeString(postURL))
 
-		// GUID (unique identifier)
+		fmt.Fprintf(w, `<item>`)
+		fmt.Fprintf(w, `<title>%s</title>`, html.EscapeString(feedEntryTitle(entry)))
+		fmt.Fprintf(w, `<link>%s</link>`, html.EscapeString(postURL))
 		fmt.Fprintf(w, `<guid isPermaLink="true">%s</guid>`, html.EscapeString(postURL))
+		fmt.Fprintf(w, `<description><![CDATA[%s]]></description>`, string(entry.FullContent))
+		fmt.Fprintf(w, `<enclosure url="%s" type="%s" length="%d" />`,
+			html.EscapeString(baseURL+string(episode.URL)), mediaMIMEType(episode.Path, episode.MediaType), uploadFileSize(episode.Path))
+		fmt.Fprintf(w, `<pubDate>%s</pubDate>`, entry.CreatedAt.UTC().Format(time.RFC1123Z))
+		if episode.Duration > 0 {
+			fmt.Fprintf(w, `<itunes:duration>%d</itunes:duration>`, episode.Duration)
+		}
+		if episode.Thumbnail != "" {
+			fmt.Fprintf(w, `<itunes:image href="%s" />`, html.EscapeString(baseURL+string(episode.Thumbnail)))
+		}
+		fmt.Fprintf(w, `<itunes:explicit>%s</itunes:explicit>`, explicit)
+		fmt.Fprintf(w, `<itunes:episodeType>full</itunes:episodeType>`)
+		fmt.Fprintf(w, `</item>`)
+	}
 
-		// Description (text content only - media handled via enclosure tag)
-		fmt.Fprintf(w, `<description><![CDATA[%s]]></description>`, string(entry.Content))
+	fmt.Fprintf(w, `</channel>`)
+	fmt.Fprintf(w, `</rss>`)
+}
+
+// audioDuration reads the playing time of an MP3, M4A, WAV or Ogg file from
+// its headers. It returns 0 if the format isn't recognised.
+func audioDuration(path string) time.Duration {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0
+	}
 
-		// Enclosure for photos (standard RSS 2.0 media handling)
-		if entry.HasPhoto && entry.Photo != "" {
-			photoURL := fmt.Sprintf("%s%s", baseURL, string(entry.Photo))
-			// Determine MIME type from file extension
-			mimeType := "image/jpeg" // default
-			photoStr := string(entry.Photo)
-			if strings.HasSuffix(strings.ToLower(photoStr), ".png") {
-				mimeType = "image/png"
-			} else if strings.HasSuffix(strings.ToLower(photoStr), ".gif") {
-				mimeType = "image/gif"
-			} else if strings.HasSuffix(strings.ToLower(photoStr), ".webp") {
-				mimeType = "image/webp"
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return 0
+	}
+
+	switch {
+	case string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return wavDuration(f)
+	case string(header[0:4]) == "OggS":
+		return oggDuration(f, info.Size())
+	case string(header[4:8]) == "ftyp":
+		return mp4Duration(f, info.Size())
+	case string(header[0:3]) == "ID3" || (header[0] == 0xFF && header[1]&0xE0 == 0xE0):
+		return mp3Duration(f, info.Size())
+	}
+	return 0
+}
+
+// wavDuration divides the size of the data chunk by the byte rate
+func wavDuration(f *os.File) time.Duration {
+	var byteRate uint32
+	offset := int64(12)
+	chunk := make([]byte, 16)
+	for {
+		if _, err := f.ReadAt(chunk[:8], offset); err != nil {
+			return 0
+		}
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+		switch string(chunk[0:4]) {
+		case "fmt ":
+			if _, err := f.ReadAt(chunk, offset+8); err != nil {
+				return 0
+			}
+			byteRate = binary.LittleEndian.Uint32(chunk[8:12])
+		case "data":
+			if byteRate == 0 {
+				return 0
 			}
-			fmt.Fprintf(w, `<enclosure url="%s" type="%s" length="0" />`, html.EscapeString(photoURL), mimeType)
+			return time.Duration(float64(size) / float64(byteRate) * float64(time.Second))
 		}
+		offset += 8 + int64(size) + int64(size%2)
+	}
+}
 
-		// Publication date
-		fmt.Fprintf(w, `<pubDate>%s</pubDate>`, entry.CreatedAt.UTC().Format(time.RFC1123Z))
+// oggDuration reads the sample rate from the Vorbis or Opus header and the
+// final granule position from the last page
+func oggDuration(f *os.File, size int64) time.Duration {
+	first := make([]byte, 128)
+	n, _ := f.ReadAt(first, 0)
+	first = first[:n]
 
-		fmt.Fprintf(w, `</item>`)
+	var rate, preSkip int64
+	if i := bytes.Index(first, []byte("\x01vorbis")); i >= 0 && i+16 <= len(first) {
+		rate = int64(binary.LittleEndian.Uint32(first[i+12:]))
+	} else if i := bytes.Index(first, []byte("OpusHead")); i >= 0 && i+12 <= len(first) {
+		rate = 48000 // Opus granule positions are always 48 kHz
+		preSkip = int64(binary.LittleEndian.Uint16(first[i+10:]))
+	}
+	if rate == 0 {
+		return 0
 	}
 
-	fmt.Fprintf(w, `</channel>`)
-	fmt.Fprintf(w, `</rss>`)
+	tailSize := int64(65536)
+	if tailSize > size {
+		tailSize = size
+	}
+	tail := make([]byte, tailSize)
+	if _, err := f.ReadAt(tail, size-tailSize); err != nil && err != io.EOF {
+		return 0
+	}
+	i := bytes.LastIndex(tail, []byte("OggS"))
+	if i < 0 || i+14 > len(tail) {
+		return 0
+	}
+	granule := int64(binary.LittleEndian.Uint64(tail[i+6:])) - preSkip
+	if granule <= 0 {
+		return 0
+	}
+	return time.Duration(float64(granule) / float64(rate) * float64(time.Second))
+}
+
+// mp4Duration reads the timescale and duration from the movie header
+// (moov/mvhd) of an MP4 or M4A file
+func mp4Duration(f *os.File, size int64) time.Duration {
+	header := make([]byte, 8)
+	var findBox func(start, end int64, name string) (int64, int64)
+	findBox = func(start, end int64, name string) (int64, int64) {
+		for offset := start; offset+8 <= end; {
+			if _, err := f.ReadAt(header, offset); err != nil {
+				return 0, 0
+			}
+			boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+			headerSize := int64(8)
+			if boxSize == 1 {
+				large := make([]byte, 8)
+				if _, err := f.ReadAt(large, offset+8); err != nil {
+					return 0, 0
+				}
+				boxSize = int64(binary.BigEndian.Uint64(large))
+				headerSize = 16
+			} else if boxSize == 0 {
+				boxSize = end - offset
+			}
+			if boxSize < headerSize {
+				return 0, 0
+			}
+			if string(header[4:8]) == name {
+				return offset + headerSize, offset + boxSize
+			}
+			offset += boxSize
+		}
+		return 0, 0
+	}
+
+	moovStart, moovEnd := findBox(0, size, "moov")
+	if moovEnd == 0 {
+		return 0
+	}
+	mvhdStart, mvhdEnd := findBox(moovStart, moovEnd, "mvhd")
+	if mvhdEnd == 0 {
+		return 0
+	}
+
+	mvhd := make([]byte, 32)
+	if _, err := f.ReadAt(mvhd, mvhdStart); err != nil {
+		return 0
+	}
+	var timescale, duration uint64
+	if mvhd[0] == 1 {
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+var (
+	mp3BitratesV1 = [3][16]int{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	}
+	mp3BitratesV2 = [3][16]int{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	mp3SampleRates = map[int][3]int{
+		3: {44100, 48000, 32000}, // MPEG 1
+		2: {22050, 24000, 16000}, // MPEG 2
+		0: {11025, 12000, 8000},  // MPEG 2.5
+	}
+)
+
+// mp3Duration uses the frame count of a Xing/Info or VBRI header when there
+// is one, and otherwise assumes a constant bitrate
+func mp3Duration(f *os.File, size int64) time.Duration {
+	// Skip the ID3v2 tag
+	start := int64(0)
+	id3 := make([]byte, 10)
+	if _, err := f.ReadAt(id3, 0); err != nil {
+		return 0
+	}
+	if string(id3[0:3]) == "ID3" {
+		tagSize := int64(id3[6]&0x7F)<<21 | int64(id3[7]&0x7F)<<14 | int64(id3[8]&0x7F)<<7 | int64(id3[9]&0x7F)
+		start = 10 + tagSize
+		if id3[5]&0x10 != 0 {
+			start += 10 // footer
+		}
+	}
+
+	buf := make([]byte, 8192)
+	n, _ := f.ReadAt(buf, start)
+	buf = buf[:n]
+
+	// Find the first frame header
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xFF || buf[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		version := int(buf[i+1]>>3) & 0x03 // 3 = MPEG 1, 2 = MPEG 2, 0 = MPEG 2.5
+		layer := 4 - int(buf[i+1]>>1)&0x03 // 1, 2 or 3
+		bitrateIndex := int(buf[i+2] >> 4)
+		rateIndex := int(buf[i+2]>>2) & 0x03
+		mono := buf[i+3]>>6 == 3
+		if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+
+		var bitrate int
+		if version == 3 {
+			bitrate = mp3BitratesV1[layer-1][bitrateIndex]
+		} else {
+			bitrate = mp3BitratesV2[layer-1][bitrateIndex]
+		}
+		sampleRate := mp3SampleRates[version][rateIndex]
+
+		samplesPerFrame := 1152
+		if layer == 1 {
+			samplesPerFrame = 384
+		} else if layer == 3 && version != 3 {
+			samplesPerFrame = 576
+		}
+
+		// Xing/Info header follows the side information
+		sideInfo := 32
+		if version == 3 && mono {
+			sideInfo = 17
+		} else if version != 3 && !mono {
+			sideInfo = 17
+		} else if version != 3 {
+			sideInfo = 9
+		}
+		var frames uint32
+		if x := i + 4 + sideInfo; x+12 <= len(buf) && (string(buf[x:x+4]) == "Xing" || string(buf[x:x+4]) == "Info") {
+			if binary.BigEndian.Uint32(buf[x+4:])&0x01 != 0 {
+				frames = binary.BigEndian.Uint32(buf[x+8:])
+			}
+		} else if v := i + 4 + 32; v+18 <= len(buf) && string(buf[v:v+4]) == "VBRI" {
+			frames = binary.BigEndian.Uint32(buf[v+14:])
+		}
+		if frames > 0 {
+			return time.Duration(float64(frames) * float64(samplesPerFrame) / float64(sampleRate) * float64(time.Second))
+		}
+
+		audioBytes := size - start - int64(i)
+		return time.Duration(float64(audioBytes) * 8 / float64(bitrate*1000) * float64(time.Second))
+	}
+	return 0
 }
 
 // resetAdminPassword resets the admin password from CLI
@@ -4703,17 +15939,69 @@ func resetAdminPassword(newPassword string) {
 		os.Exit(1)
 	}
 
-	// Update password and require change on next login
+	// Update password and require change on next login. Databases from
+	// before user accounts pick this up when the owner account is created.
 	_, err = database.Exec("UPDATE site_settings SET admin_password_hash = ?, password_change_required = 1 WHERE id = 1", string(hashedPassword))
 	if err != nil {
 		fmt.Printf("Error updating password: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Println("Admin password has been reset successfully.")
+	// Reset the first owner's account
+	username := "admin"
+	var ownerID int
+	err = database.QueryRow("SELECT id, username FROM users WHERE role = 'owner' ORDER BY id LIMIT 1").Scan(&ownerID, &username)
+	if err == nil {
+		_, err = database.Exec("UPDATE users SET password_hash = ?, password_change_required = 1 WHERE id = ?", string(hashedPassword), ownerID)
+		if err != nil {
+			fmt.Printf("Error updating password: %v\n", err)
+			os.Exit(1)
+		}
+		database.Exec("DELETE FROM sessions WHERE user_id = ?", ownerID)
+	}
+
+	fmt.Printf("Password for '%s' has been reset successfully.\n", username)
 	fmt.Println("Password change will be required on next login.")
 }
 
+// disableTwoFactorCLI turns off two-factor authentication for a user, or
+// for the first owner when no username is given, for when both the
+// authenticator app and the recovery codes are lost
+func disableTwoFactorCLI(username string) {
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "/app/data/blog.db"
+	}
+
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		fmt.Printf("Error connecting to database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	var userID int
+	if username == "" {
+		err = database.QueryRow("SELECT id, username FROM users WHERE role = 'owner' ORDER BY id LIMIT 1").Scan(&userID, &username)
+	} else {
+		username = strings.ToLower(strings.TrimSpace(username))
+		err = database.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	}
+	if err != nil {
+		fmt.Printf("Error: user not found: %v\n", err)
+		os.Exit(1)
+	}
+
+	_, err = database.Exec("UPDATE users SET totp_enabled = 0, totp_secret = '', totp_last_step = 0 WHERE id = ?", userID)
+	if err != nil {
+		fmt.Printf("Error disabling two-factor authentication: %v\n", err)
+		os.Exit(1)
+	}
+	database.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+
+	fmt.Printf("Two-factor authentication has been turned off for '%s'.\n", username)
+}
+
 // enableCustomDomainCLI enables custom domain feature via CLI (platform admin use)
 func enableCustomDomainCLI(hostname string) {
 	hostname = strings.ToLower(strings.TrimSpace(hostname))
@@ -4759,7 +16047,8 @@ func printHelp() {
 	fmt.Println("")
 	fmt.Println("Usage:")
 	fmt.Println("  postastiq                                   Start the web server")
-	fmt.Println("  postastiq --reset-password <pwd>            Reset admin password")
+	fmt.Println("  postastiq --reset-password <pwd>            Reset the owner's password")
+	fmt.Println("  postastiq --disable-2fa [username]          Turn off two-factor authentication (default: the owner)")
 	fmt.Println("  postastiq --enable-custom-domain <hostname> Enable custom domains (platform admin)")
 	fmt.Println("  postastiq --help                            Show this help message")
 	fmt.Println("")
@@ -4777,6 +16066,15 @@ func main() {
 		return
 	}
 
+	if len(os.Args) >= 2 && os.Args[1] == "--disable-2fa" {
+		username := ""
+		if len(os.Args) >= 3 {
+			username = os.Args[2]
+		}
+		disableTwoFactorCLI(username)
+		return
+	}
+
 	if len(os.Args) >= 3 && os.Args[1] == "--enable-custom-domain" {
 		enableCustomDomainCLI(os.Args[2])
 		return
@@ -4795,38 +16093,87 @@ func main() {
 	// Sync custom domain routes with Caddy on startup (runs in background)
 	go syncCustomDomainWithCaddy()
 
-	// Start session cleanup goroutine
+	// Start session and failed sign-in cleanup goroutine
 	go func() {
 		ticker := time.NewTicker(10 * time.Minute)
 		defer ticker.Stop()
 		for range ticker.C {
 			cleanupExpiredSessions()
+			cleanupLoginFailures()
 		}
 	}()
 
 	// Start domain re-validation cron (weekly)
 	startDomainRevalidationCron()
 
+	// Publish scheduled entries when they are due (checked every minute)
+	startScheduledPublishingCron()
+
+	// Send queued Webmentions and verify received ones in the background
+	startWebmentionSender()
+	startWebmentionVerifiers()
+	startActivityPubSender()
+	startActivityPubGoneChecker()
+
+	// Deliver queued webhooks in the background
+	startWebhookSender()
+
+	// Resize photos that don't have responsive copies yet
+	go createMissingImageVariants()
+
+	// Read the playing time of audio uploaded before it was stored
+	go fillMissingMediaDurations()
+
 	// Serve uploaded files
-	fs := http.FileServer(http.Dir(uploadsDir))
-	http.Handle("/uploads/", http.StripPrefix("/uploads/", fs))
+	http.Handle("/uploads/", uploadsHandler())
 
 	// Authentication routes
 	http.HandleFunc("/login", handleLogin)
 	http.HandleFunc("/logout", handleLogout)
 	http.HandleFunc("/viewer-auth", handleViewerAuth)
 	http.HandleFunc("/change-password", requireAuth(handleChangePassword))
+	http.HandleFunc("/invite/", handleInvite)
+	http.HandleFunc("/login/passkey/begin", handlePasskeyLoginBegin)
+	http.HandleFunc("/login/passkey/finish", handlePasskeyLoginFinish)
 
 	// Public endpoints (no auth required)
 	http.HandleFunc("/health", handleHealth)
 
 	// RSS feed (protected by privacy password if set)
 	http.HandleFunc("/rss", requireViewerAuth(handleRSSFeed))
+	http.HandleFunc("/atom.xml", requireViewerAuth(handleAtomFeed))
+	http.HandleFunc("/feed.json", requireViewerAuth(handleJSONFeed))
+	http.HandleFunc("/podcast.xml", requireViewerAuth(handlePodcastFeed))
 
 	// Blog viewer routes (protected by privacy password if set)
 	http.HandleFunc("/", requireViewerAuth(handleBlogFeed))
 	http.HandleFunc("/api/entries", requireViewerAuth(handleAPIEntries))
 	http.HandleFunc("/posts/", requireViewerAuth(handleSinglePost))
+	http.HandleFunc("/tags/", requireViewerAuth(handleTagRoutes))
+	http.HandleFunc("/search", requireViewerAuth(handleSearch))
+	http.HandleFunc("/webmention", requireViewerAuth(handleWebmention))
+
+	// Micropub (authenticated with access tokens)
+	http.HandleFunc("/micropub", handleMicropub)
+	http.HandleFunc("/micropub/media", handleMicropubMedia)
+
+	// Admin API (authenticated with access tokens)
+	http.HandleFunc("/api/v1/", handleAPIv1)
+
+	// IndieAuth authorization server
+	http.HandleFunc("/.well-known/oauth-authorization-server", handleIndieAuthMetadata)
+	http.HandleFunc("/indieauth/auth", handleIndieAuthAuthorize)
+	http.HandleFunc("/indieauth/token", handleIndieAuthToken)
+	http.HandleFunc("/indieauth/revoke", handleIndieAuthRevoke)
+
+	// ActivityPub, so the blog can be followed from the fediverse
+	http.HandleFunc("/.well-known/webfinger", requireViewerAuth(handleWebFinger))
+	http.HandleFunc("/activitypub/actor", requireViewerAuth(handleActivityPubActor))
+	http.HandleFunc("/activitypub/inbox", requireViewerAuth(handleActivityPubInbox))
+	http.HandleFunc("/activitypub/outbox", requireViewerAuth(handleActivityPubOutbox))
+	http.HandleFunc("/activitypub/followers", requireViewerAuth(handleActivityPubFollowers))
+	http.HandleFunc("/activitypub/following", requireViewerAuth(handleActivityPubFollowers))
+	http.HandleFunc("/activitypub/posts/", requireViewerAuth(handleActivityPubObject))
 
 	// Protected admin routes - use prefix pattern to catch all /admin* paths
 	// This ensures admin routes bypass viewer auth (only require admin auth)
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// setupTestDB opens a fresh database in a temporary directory. Fetches stay
+// allowed to loopback so handlers can talk to stub servers.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("DB_PATH", filepath.Join(dir, "blog.db"))
+	t.Setenv("UPLOADS_DIR", filepath.Join(dir, "uploads"))
+	t.Setenv("ALLOW_PRIVATE_FETCHES", "true")
+	if err := initDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+}
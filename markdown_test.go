@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		// Script URLs are not links
+		{"javascript link", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>"},
+		{"mixed case javascript link", "[x](JaVaScRiPt:alert(1))", "<p>[x](JaVaScRiPt:alert(1))</p>"},
+		{"javascript link after a space", "[x]( javascript:alert(1))", "<p>[x]( javascript:alert(1))</p>"},
+		{"entity-encoded javascript link", "[x](&#106;avascript:alert(1))", `<p><a href="&amp;#106;avascript:alert(1)">x</a></p>`},
+		{"vbscript link", "[x](vbscript:msgbox)", "<p>[x](vbscript:msgbox)</p>"},
+		{"data link", "[x](data:text/html,<script>alert(1)</script>)", "<p>[x](data:text/html,&lt;script&gt;alert(1)&lt;/script&gt;)</p>"},
+		{"javascript image", "![x](javascript:alert(1))", "<p>![x](javascript:alert(1))</p>"},
+
+		// Raw HTML is shown, not interpreted
+		{"script tag", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"event handler", "<img src=x onerror=alert(1)>", "<p>&lt;img src=x onerror=alert(1)&gt;</p>"},
+		{"raw link", `<a href="javascript:alert(1)">x</a>`, "<p>&lt;a href=&#34;javascript:alert(1)&#34;&gt;x&lt;/a&gt;</p>"},
+		{"HTML in a code span", "`<b>`", "<p><code>&lt;b&gt;</code></p>"},
+		{"HTML in a code block", "```\n<script>\n```", "<pre><code>&lt;script&gt;</code></pre>"},
+
+		// Quotes can't break out of attributes
+		{"quote in a link URL", `[x](https://e.com/"onmouseover="alert(1))`,
+			`<p><a href="https://e.com/&#34;onmouseover=&#34;alert(1)" target="_blank" rel="noopener noreferrer">x</a></p>`},
+		{"quote in link text", `[x" onclick="y](https://e.com/)`,
+			`<p><a href="https://e.com/" target="_blank" rel="noopener noreferrer">x&#34; onclick=&#34;y</a></p>`},
+		{"quote in alt text", `![a" onerror="alert(1)](/i.png)`,
+			`<p><img src="/i.png" alt="a&#34; onerror=&#34;alert(1)" loading="lazy"></p>`},
+
+		// Nesting
+		{"strong and em", "***both***", "<p><strong><em>both</em></strong></p>"},
+		{"em closing inside strong", "**bold *and italic***", "<p><strong>bold <em>and italic</em></strong></p>"},
+		{"strong closing inside em", "*italic **bold***", "<p><em>italic <strong>bold</strong></em></p>"},
+		{"strong opening inside em", "***strong** em*", "<p><em><strong>strong</strong> em</em></p>"},
+		{"em opening inside strong", "***em* strong**", "<p><strong><em>em</em> strong</strong></p>"},
+		{"em inside strong", "**a *b* c**", "<p><strong>a <em>b</em> c</strong></p>"},
+		{"underscores", "_a __b___", "<p><em>a <strong>b</strong></em></p>"},
+		{"unmatched delimiters", "**a***", "<p>**a***</p>"},
+		{"unclosed em", "*unclosed", "<p>*unclosed</p>"},
+		{"escaped delimiters", `\*not em\*`, "<p>*not em*</p>"},
+		{"strong link text", "[**bold link**](https://e.com/)",
+			`<p><a href="https://e.com/" target="_blank" rel="noopener noreferrer"><strong>bold link</strong></a></p>`},
+		{"link in strong", "**[link](/a) inside**", `<p><strong><a href="/a">link</a> inside</strong></p>`},
+		{"nested lists", "- a\n  - b\n    - c\n- d",
+			"<ul>\n<li>a\n<ul>\n<li>b\n<ul>\n<li>c\n</li>\n</ul>\n</li>\n</ul>\n</li>\n<li>d\n</li>\n</ul>"},
+		{"list in ordered list", "1. one\n   - inner\n2. two",
+			"<ol>\n<li>one\n<ul>\n<li>inner\n</li>\n</ul>\n</li>\n<li>two\n</li>\n</ol>"},
+		{"loose list item", "- item\n\n  para in item", "<ul>\n<li><p>item</p>\n<p>para in item</p>\n</li>\n</ul>"},
+		{"list and quote in a quote", "> quote\n> - item\n> > nested",
+			"<blockquote>\n<p>quote</p>\n<ul>\n<li>item\n</li>\n</ul>\n<blockquote>\n<p>nested</p>\n</blockquote>\n</blockquote>"},
+		{"code block in a quote", "> ```\n> code\n> ```", "<blockquote>\n<pre><code>code</code></pre>\n</blockquote>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(renderMarkdown(tt.in)); got != tt.want {
+				t.Errorf("renderMarkdown(%q)\ngot  %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderContentPreview(t *testing.T) {
+	long := strings.Repeat("word ", 40)
+	tests := []struct {
+		name, content, format string
+		want                  string
+		truncated             bool
+	}{
+		{"short Markdown", "**hi** [there](/x)", "markdown", "hi there", false},
+		{"link cut off", strings.Repeat("a", 140) + " [a long link text](https://example.com/)", "markdown",
+			strings.Repeat("a", 140) + " a long li...", true},
+		{"syntax doesn't count", "**" + strings.Repeat("b", 148) + "**", "markdown", strings.Repeat("b", 148), false},
+		{"HTML stays escaped", "`<script>` " + long, "markdown", "&lt;script&gt; " + long[:141] + "...", true},
+		{"plain text", long, "plain", long[:150] + "...", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := renderContentPreview(tt.content, tt.format, 150)
+			if string(got) != tt.want || truncated != tt.truncated {
+				t.Errorf("got %q, %v\nwant %q, %v", got, truncated, tt.want, tt.truncated)
+			}
+		})
+	}
+}
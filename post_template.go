@@ -6,6 +6,14 @@ const postTemplate = `<!DOCTYPE html>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <title>{{if .Entry.Title}}{{.Entry.Title}} - {{end}}{{.SiteTitle}}</title>
+    <link rel="alternate" type="application/rss+xml" title="{{.SiteTitle}}" href="/rss">
+    <link rel="alternate" type="application/atom+xml" title="{{.SiteTitle}}" href="/atom.xml">
+    <link rel="alternate" type="application/feed+json" title="{{.SiteTitle}}" href="/feed.json">
+    <link rel="micropub" href="/micropub">
+    <link rel="indieauth-metadata" href="/.well-known/oauth-authorization-server">
+    <link rel="authorization_endpoint" href="/indieauth/auth">
+    <link rel="token_endpoint" href="/indieauth/token">
+    <link rel="webmention" href="/webmention">
     <style>
 {{.ThemeCSS}}
     </style>
@@ -32,9 +40,12 @@ const postTemplate = `<!DOCTYPE html>
                         {{end}}
                     </div>
                 </div>
+                {{if gt (len .Entry.Media) 1}}
+                {{template "entry-gallery" .Entry}}
+                {{else}}
                 {{if .Entry.HasPhoto}}
-                <div class="entry-photo-container">
-                    <img src="{{.Entry.Photo}}" alt="Entry photo" class="entry-photo">
+                <div class="entry-photo-container{{if .Entry.PhotoPlaceholder}} has-placeholder{{end}}"{{if .Entry.PhotoPlaceholder}} style="background-image: url('{{.Entry.PhotoPlaceholder}}');"{{end}}>
+                    <img src="{{.Entry.Photo}}"{{if .Entry.PhotoSrcSet}} srcset="{{.Entry.PhotoSrcSet}}" sizes="{{.Entry.ImageSizes}}"{{end}} alt="{{.Entry.PhotoAlt}}" class="entry-photo" decoding="async">
                 </div>
                 {{end}}
                 {{if .Entry.HasAudio}}
@@ -83,6 +94,7 @@ const postTemplate = `<!DOCTYPE html>
                     {{end}}
                 </div>
                 {{end}}
+                {{end}}
                 <div class="entry-content">
                     {{if .Entry.IsTruncated}}
                         <span id="truncated-content">{{.Entry.Content}}</span>
@@ -92,9 +104,47 @@ const postTemplate = `<!DOCTYPE html>
                         {{.Entry.Content}}
                     {{end}}
                 </div>
-                <div class="entry-timestamp">{{.Entry.TimeAgo}}</div>
+                {{if .Entry.Tags}}
+                <div class="entry-tags">
+                    {{range .Entry.Tags}}<a href="/tags/{{.}}/" class="entry-tag">#{{.}}</a>{{end}}
+                </div>
+                {{end}}
+                <div class="entry-timestamp">{{if .Entry.Author}}{{.Entry.Author}} &middot; {{end}}{{.Entry.TimeAgo}}</div>
             </div>
 
+            {{with .Responses}}{{if or .Likes .Reposts .Replies}}
+            <div class="responses">
+                {{if .Likes}}
+                <div class="responses-heading">{{len .Likes}} like{{if ne (len .Likes) 1}}s{{end}}</div>
+                <div class="response-faces">
+                    {{range .Likes}}<a href="{{if .AuthorURL}}{{.AuthorURL}}{{else}}{{.Source}}{{end}}" class="response-face" title="{{.AuthorName}}" rel="nofollow ugc">{{.AuthorInitial}}</a>{{end}}
+                </div>
+                {{end}}
+                {{if .Reposts}}
+                <div class="responses-heading">{{len .Reposts}} repost{{if ne (len .Reposts) 1}}s{{end}}</div>
+                <div class="response-faces">
+                    {{range .Reposts}}<a href="{{.Source}}" class="response-face" title="{{.AuthorName}}" rel="nofollow ugc">{{.AuthorInitial}}</a>{{end}}
+                </div>
+                {{end}}
+                {{if .Replies}}
+                <div class="responses-heading">{{len .Replies}} response{{if ne (len .Replies) 1}}s{{end}}</div>
+                {{range .Replies}}
+                <div class="response">
+                    <div class="response-face">{{.AuthorInitial}}</div>
+                    <div class="response-body">
+                        <div class="response-meta">
+                            {{if .AuthorURL}}<a href="{{.AuthorURL}}" rel="nofollow ugc">{{.AuthorName}}</a>{{else}}{{.AuthorName}}{{end}}
+                            {{if eq .Type "reply"}}replied{{else if eq .Type "bookmark"}}bookmarked this{{else}}mentioned this{{end}}
+                            &middot; <a href="{{.Source}}" rel="nofollow ugc">{{.CreatedAt.Format "Jan 2, 2006"}}</a>
+                        </div>
+                        {{if .Content}}<div class="response-content">{{.Content}}</div>{{end}}
+                    </div>
+                </div>
+                {{end}}
+                {{end}}
+            </div>
+            {{end}}{{end}}
+
             <div style="text-align: center; margin-top: 32px;">
                 <a href="/" style="color: #0095f6; text-decoration: none; font-size: 14px; font-weight: 600;">← Back to all posts</a>
             </div>
@@ -125,3 +175,59 @@ const postTemplate = `<!DOCTYPE html>
     </script>
 </body>
 </html>`
+
+// responsesCSS styles the likes, reposts and replies received as webmentions
+// beneath a post
+const responsesCSS = `
+        .responses {
+            margin-top: 24px;
+            padding-top: 16px;
+            border-top: 1px solid rgba(128, 128, 128, 0.25);
+            font-size: 14px;
+        }
+
+        .responses-heading {
+            font-weight: 600;
+            margin: 12px 0 8px;
+        }
+
+        .response-faces {
+            display: flex;
+            flex-wrap: wrap;
+            gap: 6px;
+        }
+
+        .response-face {
+            width: 32px;
+            height: 32px;
+            flex-shrink: 0;
+            border-radius: 50%;
+            background: rgba(128, 128, 128, 0.2);
+            color: inherit;
+            font-weight: 600;
+            text-decoration: none;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+        }
+
+        .response {
+            display: flex;
+            gap: 10px;
+            margin-bottom: 14px;
+        }
+
+        .response-meta {
+            opacity: 0.7;
+        }
+
+        .response-meta a {
+            color: inherit;
+        }
+
+        .response-content {
+            margin-top: 4px;
+            line-height: 1.5;
+            overflow-wrap: anywhere;
+        }
+`
@@ -0,0 +1,63 @@
+package main
+
+const searchTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{if .Query}}{{.Query}} - {{end}}Search - {{.SiteTitle}}</title>
+    <meta name="robots" content="noindex">
+    <style>
+{{.ThemeCSS}}
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <div class="header-content">
+                <div>
+                    <h1><a href="/" style="color: inherit; text-decoration: none;">{{.SiteTitle}}</a></h1>
+                    {{if .EnableSubtitle}}<div class="subtitle">{{.SiteSubtitle}}</div>{{end}}
+                </div>
+            </div>
+        </div>
+
+        <div class="feed">
+            <form class="search-form" method="GET" action="/search">
+                <input type="search" name="q" value="{{.Query}}" placeholder="Search posts" maxlength="200" autofocus>
+                <button type="submit">Search</button>
+            </form>
+
+            {{if .Query}}
+            <div class="search-summary">
+                {{if .TotalResults}}{{.TotalResults}} result{{if ne .TotalResults 1}}s{{end}} for &ldquo;{{.Query}}&rdquo;{{else}}No posts found for &ldquo;{{.Query}}&rdquo;{{end}}
+            </div>
+
+            {{range .Results}}
+            <div class="entry">
+                <a href="/posts/{{.Slug}}/" class="search-result-title">{{if .Title}}{{.Title}}{{else}}Untitled{{end}}</a>
+                <div class="entry-content">{{.Snippet}}</div>
+                {{if .Tags}}
+                <div class="entry-tags">
+                    {{range .Tags}}<a href="/tags/{{.}}/" class="entry-tag">#{{.}}</a>{{end}}
+                </div>
+                {{end}}
+                <div class="entry-timestamp">{{.TimeAgo}}</div>
+            </div>
+            {{end}}
+
+            {{if or (gt .Page 1) .HasMore}}
+            <div class="search-pagination">
+                <span>{{if gt .Page 1}}<a href="/search?q={{.Query}}&page={{subtract .Page 1}}">&larr; Newer results</a>{{end}}</span>
+                <span>{{if .HasMore}}<a href="/search?q={{.Query}}&page={{add .Page 1}}">Older results &rarr;</a>{{end}}</span>
+            </div>
+            {{end}}
+            {{end}}
+
+            <div style="text-align: center; margin-top: 32px;">
+                <a href="/" style="color: #0095f6; text-decoration: none; font-size: 14px; font-weight: 600;">← Back to all posts</a>
+            </div>
+        </div>
+    </div>
+</body>
+</html>`
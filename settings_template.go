@@ -158,6 +158,7 @@ const settingsTemplate = `<!DOCTYPE html>
         label { display: block; margin-bottom: 8px; font-weight: 600; font-size: 14px; color: #262626; }
         input[type="text"],
         input[type="password"],
+        input[type="email"],
         select {
             width: 100%;
             padding: 12px 16px;
@@ -170,11 +171,13 @@ const settingsTemplate = `<!DOCTYPE html>
         }
         input[type="text"]:hover,
         input[type="password"]:hover,
+        input[type="email"]:hover,
         select:hover {
             border-color: #a8a8a8;
         }
         input[type="text"]:focus,
         input[type="password"]:focus,
+        input[type="email"]:focus,
         select:focus {
             outline: none;
             border-color: #0095f6;
@@ -375,6 +378,7 @@ const settingsTemplate = `<!DOCTYPE html>
             <div class="sidebar-section">
                 <div class="sidebar-section-title">Settings</div>
                 <ul class="sidebar-nav">
+                    {{if .User.CanChangeSettings}}
                     <li>
                         <a href="/admin/settings" class="{{if eq .View "site-info"}}active{{end}}">
                             <svg viewBox="0 0 24 24"><path d="M3 9l9-7 9 7v11a2 2 0 0 1-2 2H5a2 2 0 0 1-2-2z"></path><polyline points="9 22 9 12 15 12 15 22"></polyline></svg>
@@ -387,18 +391,47 @@ const settingsTemplate = `<!DOCTYPE html>
                             Appearance
                         </a>
                     </li>
+                    {{end}}
                     <li>
                         <a href="/admin/settings/security" class="{{if eq .View "security"}}active{{end}}">
                             <svg viewBox="0 0 24 24"><rect x="3" y="11" width="18" height="11" rx="2" ry="2"></rect><path d="M7 11V7a5 5 0 0 1 10 0v4"></path></svg>
                             Security
                         </a>
                     </li>
+                    {{if .User.CanChangeSettings}}
+                    <li>
+                        <a href="/admin/settings/podcast" class="{{if eq .View "podcast"}}active{{end}}">
+                            <svg viewBox="0 0 24 24"><path d="M12 1a3 3 0 0 0-3 3v8a3 3 0 0 0 6 0V4a3 3 0 0 0-3-3z"></path><path d="M19 10v2a7 7 0 0 1-14 0v-2"></path><line x1="12" y1="19" x2="12" y2="23"></line><line x1="8" y1="23" x2="16" y2="23"></line></svg>
+                            Podcast
+                        </a>
+                    </li>
+                    <li>
+                        <a href="/admin/settings/tokens" class="{{if eq .View "tokens"}}active{{end}}">
+                            <svg viewBox="0 0 24 24"><path d="M21 2l-2 2m-7.61 7.61a5.5 5.5 0 1 1-7.778 7.778 5.5 5.5 0 0 1 7.777-7.777zm0 0L15.5 7.5m0 0l3 3L22 7l-3-3m-3.5 3.5L19 4"></path></svg>
+                            Access Tokens
+                        </a>
+                    </li>
+                    <li>
+                        <a href="/admin/settings/webhooks" class="{{if eq .View "webhooks"}}active{{end}}">
+                            <svg viewBox="0 0 24 24"><path d="M10 13a5 5 0 0 0 7.54.54l3-3a5 5 0 0 0-7.07-7.07l-1.72 1.71"></path><path d="M14 11a5 5 0 0 0-7.54-.54l-3 3a5 5 0 0 0 7.07 7.07l1.71-1.71"></path></svg>
+                            Webhooks
+                        </a>
+                    </li>
+                    {{end}}
+                    {{if .User.IsOwner}}
+                    <li>
+                        <a href="/admin/settings/users" class="{{if eq .View "users"}}active{{end}}">
+                            <svg viewBox="0 0 24 24"><path d="M17 21v-2a4 4 0 0 0-4-4H5a4 4 0 0 0-4 4v2"></path><circle cx="9" cy="7" r="4"></circle><path d="M23 21v-2a4 4 0 0 0-3-3.87"></path><path d="M16 3.13a4 4 0 0 1 0 7.75"></path></svg>
+                            Users
+                        </a>
+                    </li>
                     <li>
                         <a href="/admin/settings/backup" class="{{if eq .View "backup"}}active{{end}}">
                             <svg viewBox="0 0 24 24"><path d="M21 15v4a2 2 0 0 1-2 2H5a2 2 0 0 1-2-2v-4"></path><polyline points="7 10 12 15 17 10"></polyline><line x1="12" y1="15" x2="12" y2="3"></line></svg>
                             Backup
                         </a>
                     </li>
+                    {{end}}
                 </ul>
             </div>
 
@@ -432,6 +465,7 @@ const settingsTemplate = `<!DOCTYPE html>
             <!-- Site Info Settings -->
             <div class="content-container">
                 <form method="POST" action="/admin/settings/update" enctype="multipart/form-data" id="settingsForm">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                     <input type="hidden" name="section" value="site-info">
 
                     <!-- Avatar Upload -->
@@ -503,6 +537,7 @@ const settingsTemplate = `<!DOCTYPE html>
             <!-- Appearance Settings -->
             <div class="content-container">
                 <form method="POST" action="/admin/settings/update" id="appearanceForm">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                     <input type="hidden" name="section" value="appearance">
 
                     <div class="form-group">
@@ -651,8 +686,43 @@ const settingsTemplate = `<!DOCTYPE html>
             <!-- Security Settings -->
             <div class="content-container">
                 <form method="POST" action="/admin/settings/update" id="securityForm">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                     <input type="hidden" name="section" value="security">
 
+                    <!-- Account -->
+                    <div class="settings-section">
+                        <div class="section-title">Your Account</div>
+                        <div class="form-group">
+                            <label>Username</label>
+                            <input type="text" value="{{.User.Username}}" readonly>
+                            <div class="file-info">You sign in with this name. Your role is {{.User.Role}}.</div>
+                        </div>
+                        <div class="form-group">
+                            <label for="displayName">Display Name</label>
+                            <input type="text" name="display_name" id="displayName" value="{{.User.DisplayName}}" maxlength="100" placeholder="{{.User.Username}}">
+                            <div class="file-info">Shown as the author of your posts when the blog has more than one user.</div>
+                        </div>
+                        <div class="form-group">
+                            <label for="email">Email (optional)</label>
+                            <input type="email" name="email" id="email" value="{{.User.Email}}" maxlength="254">
+                        </div>
+                    </div>
+
+                    <!-- Password -->
+                    <div class="settings-section">
+                        <div class="section-title">Your Password</div>
+                        <div class="form-group">
+                            <label for="newPassword">New Password</label>
+                            <input type="password" name="new_password" id="newPassword" placeholder="Leave blank to keep current password">
+                            <div class="file-info">At least 8 characters. Leave blank to keep your current password.</div>
+                        </div>
+                        <div class="form-group">
+                            <label for="newPasswordConfirm">Confirm New Password</label>
+                            <input type="password" name="new_password_confirm" id="newPasswordConfirm" placeholder="Leave blank to keep current password">
+                        </div>
+                    </div>
+
+                    {{if .User.CanChangeSettings}}
                     <!-- Public Access -->
                     <div class="settings-section">
                         <div class="section-title">Public Access Control</div>
@@ -675,33 +745,268 @@ const settingsTemplate = `<!DOCTYPE html>
                         </div>
                         {{end}}
                     </div>
+                    {{end}}
+
+                    <button type="submit" class="full-width">Save Security Settings</button>
+                </form>
+            </div>
+
+            {{if .User.CanChangeSettings}}
+            <!-- Photo Metadata -->
+            <div class="content-container">
+                <form method="POST" action="/admin/settings/update" id="photoMetadataForm">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="photo-metadata">
 
-                    <!-- Admin Password -->
                     <div class="settings-section">
-                        <div class="section-title">Admin Password</div>
+                        <div class="section-title">Photo Metadata</div>
+                        <div class="file-info" style="margin-bottom: 12px;">Location and other camera data is removed from uploaded photos, and phone photos are rotated upright. Choose any details you want to keep.</div>
+                        <div class="form-group">
+                            <label style="display: flex; align-items: center; gap: 8px; font-weight: normal; cursor: pointer;">
+                                <input type="checkbox" name="keep_photo_date" value="true"{{if .Settings.KeepPhotoDate}} checked{{end}}>
+                                Keep the date the photo was taken
+                            </label>
+                        </div>
                         <div class="form-group">
-                            <label for="adminPassword">New Admin Password</label>
-                            <input type="password" name="admin_password" id="adminPassword" placeholder="Leave blank to keep current password">
-                            <div class="file-info">Password for accessing the admin area. Leave blank to keep current password.</div>
+                            <label style="display: flex; align-items: center; gap: 8px; font-weight: normal; cursor: pointer;">
+                                <input type="checkbox" name="keep_photo_camera" value="true"{{if .Settings.KeepPhotoCamera}} checked{{end}}>
+                                Keep the camera make and model
+                            </label>
                         </div>
                         <div class="form-group">
-                            <label for="adminPasswordConfirm">Confirm Admin Password</label>
-                            <input type="password" name="admin_password_confirm" id="adminPasswordConfirm" placeholder="Leave blank to keep current password">
+                            <label style="display: flex; align-items: center; gap: 8px; font-weight: normal; cursor: pointer;">
+                                <input type="checkbox" name="keep_photo_color_profile" value="true"{{if .Settings.KeepPhotoColorProfile}} checked{{end}}>
+                                Keep the color profile
+                            </label>
+                            <div class="file-info">Wide-gamut photos can look washed out without it.</div>
                         </div>
                     </div>
 
-                    <button type="submit" class="full-width">Save Security Settings</button>
+                    <button type="submit" class="full-width">Save Photo Settings</button>
+                </form>
+            </div>
+            {{end}}
+
+            <!-- Two-Factor Authentication -->
+            <div class="content-container">
+                <div class="section-title">Two-Factor Authentication</div>
+                {{with .TwoFactor}}
+                {{if .NewRecoveryCodes}}
+                <div class="settings-section">
+                    <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 12px;">
+                        Save these recovery codes somewhere safe. Each one signs you in once if you lose your authenticator app. They won't be shown again.
+                    </p>
+                    <div style="display: grid; grid-template-columns: repeat(2, 1fr); gap: 8px; font-family: monospace; font-size: 15px; padding: 16px; background-color: #fafafa; border: 1px solid #dbdbdb; border-radius: 8px;">
+                        {{range .NewRecoveryCodes}}<span>{{.}}</span>{{end}}
+                    </div>
+                </div>
+                {{end}}
+                {{end}}
+
+                {{if .User.TwoFactorEnabled}}
+                <div style="padding: 12px 16px; background-color: #d4edda; border: 1px solid #c3e6cb; border-radius: 8px; margin-bottom: 16px;">
+                    <span style="color: #155724; font-size: 14px;">Signing in asks for a code from your authenticator app. {{.TwoFactor.RecoveryCodesLeft}} recovery code{{if ne .TwoFactor.RecoveryCodesLeft 1}}s{{end}} left.</span>
+                </div>
+                <form method="POST" action="/admin/settings/update" style="margin-bottom: 20px;" onsubmit="return confirm('Create new recovery codes? The current ones will stop working.');">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="two-factor">
+                    <input type="hidden" name="action" value="recovery_codes">
+                    <button type="submit" class="btn-secondary full-width">New Recovery Codes</button>
+                </form>
+                <form method="POST" action="/admin/settings/update">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="two-factor">
+                    <input type="hidden" name="action" value="disable">
+                    <div class="form-group">
+                        <label for="disable2faPassword">Current Password</label>
+                        <input type="password" name="password" id="disable2faPassword" autocomplete="current-password" required>
+                    </div>
+                    <button type="submit" class="btn-danger full-width">Turn Off Two-Factor Authentication</button>
+                </form>
+                {{else if .TwoFactor.SetupSecret}}
+                <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 16px;">
+                    Scan this code with an authenticator app, or enter the key by hand, then type the six-digit code the app shows.
+                </p>
+                {{if .TwoFactor.SetupQRCode}}
+                <div style="text-align: center; margin-bottom: 16px;">
+                    <img src="{{.TwoFactor.SetupQRCode}}" alt="QR code for your authenticator app" width="200" height="200">
+                </div>
+                {{end}}
+                <div class="form-group">
+                    <label>Setup Key</label>
+                    <input type="text" value="{{.TwoFactor.SetupSecret}}" readonly onclick="this.select()" style="font-family: monospace;">
+                </div>
+                <form method="POST" action="/admin/settings/update" style="margin-bottom: 12px;">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="two-factor">
+                    <input type="hidden" name="action" value="confirm">
+                    <div class="form-group">
+                        <label for="totpCode">Code</label>
+                        <input type="text" name="code" id="totpCode" inputmode="numeric" autocomplete="one-time-code" maxlength="6" required>
+                    </div>
+                    <button type="submit" class="full-width">Turn On Two-Factor Authentication</button>
+                </form>
+                <form method="POST" action="/admin/settings/update">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="two-factor">
+                    <input type="hidden" name="action" value="cancel">
+                    <button type="submit" class="btn-secondary full-width">Cancel</button>
+                </form>
+                {{else}}
+                <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 16px;">
+                    Ask for a code from an authenticator app, such as 1Password, Google Authenticator, or Aegis, each time you sign in.
+                </p>
+                <form method="POST" action="/admin/settings/update">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="two-factor">
+                    <input type="hidden" name="action" value="setup">
+                    <button type="submit" class="full-width">Set Up Two-Factor Authentication</button>
+                </form>
+                {{end}}
+            </div>
+
+            <!-- Passkeys -->
+            <div class="content-container">
+                <div class="section-title">Passkeys</div>
+                <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 16px;">
+                    Sign in with your fingerprint, face, screen lock, or a security key instead of your password. A passkey only works on the address it was added on.
+                </p>
+                {{if .Passkeys}}
+                <table style="width: 100%; font-size: 13px; border-collapse: collapse; margin-bottom: 16px;">
+                    {{range .Passkeys}}
+                    <tr style="border-bottom: 1px solid #efefef;">
+                        <td style="padding: 10px 0;">
+                            <form method="POST" action="/admin/settings/update" style="display: flex; gap: 8px; margin-bottom: 4px;">
+                                <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                                <input type="hidden" name="section" value="passkeys">
+                                <input type="hidden" name="action" value="rename">
+                                <input type="hidden" name="passkey_id" value="{{.ID}}">
+                                <input type="text" name="name" value="{{.Name}}" maxlength="100" required style="padding: 6px 10px;">
+                                <button type="submit" class="btn-secondary">Rename</button>
+                            </form>
+                            <div style="color: #8e8e8e;">added {{.CreatedAt.Format "Jan 2, 2006"}} &middot; {{if .LastUsedAt.Valid}}last used {{.LastUsedAt.Time.Format "Jan 2, 2006"}}{{else}}never used{{end}}</div>
+                        </td>
+                        <td style="padding: 10px 0 10px 12px; text-align: right; vertical-align: top;">
+                            <form method="POST" action="/admin/settings/update" onsubmit="return confirm('Delete this passkey? It will no longer sign you in.');">
+                                <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                                <input type="hidden" name="section" value="passkeys">
+                                <input type="hidden" name="action" value="delete">
+                                <input type="hidden" name="passkey_id" value="{{.ID}}">
+                                <button type="submit" class="btn-danger">Delete</button>
+                            </form>
+                        </td>
+                    </tr>
+                    {{end}}
+                </table>
+                {{end}}
+                <form id="passkeyForm">
+                    <div class="form-group">
+                        <label for="passkeyName">Name</label>
+                        <input type="text" id="passkeyName" maxlength="100" placeholder="Laptop" required>
+                        <div class="file-info">A name to recognize the device or password manager holding the passkey.</div>
+                    </div>
+                    <button type="submit" class="full-width">Add a Passkey</button>
+                </form>
+            </div>
+
+            <!-- Active Sessions -->
+            <div class="content-container">
+                <div class="section-title">Active Sessions</div>
+                <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 16px;">
+                    Browsers signed in to your account. Sessions end after an hour without use, or after 30 days when "Remember me" was checked.
+                </p>
+                {{if .Sessions}}
+                <table style="width: 100%; font-size: 13px; border-collapse: collapse; margin-bottom: 16px;">
+                    {{range .Sessions}}
+                    <tr style="border-bottom: 1px solid #efefef;">
+                        <td style="padding: 10px 0;">
+                            <div style="font-weight: 600; word-break: break-word;">{{if .UserAgent}}{{.UserAgent}}{{else}}Unknown browser{{end}}</div>
+                            <div style="color: #8e8e8e;">{{if .IP}}{{.IP}} &middot; {{end}}signed in {{.CreatedAt.Format "Jan 2, 2006 15:04"}} &middot; last seen {{.LastSeenAt.Format "Jan 2, 2006 15:04"}}{{if .Remember}} &middot; remembered{{end}}</div>
+                            {{if .Current}}<div style="color: #2e7d32;">This browser</div>{{end}}
+                        </td>
+                        <td style="padding: 10px 0 10px 12px; text-align: right; white-space: nowrap;">
+                            <form method="POST" action="/admin/settings/update">
+                                <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                                <input type="hidden" name="section" value="sessions">
+                                <input type="hidden" name="action" value="revoke">
+                                <input type="hidden" name="session_id" value="{{.ID}}">
+                                <button type="submit" class="btn-secondary">Sign Out</button>
+                            </form>
+                        </td>
+                    </tr>
+                    {{end}}
+                </table>
+                {{end}}
+                <form method="POST" action="/admin/settings/update" onsubmit="return confirm('Sign out every session, including this one?');">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="sessions">
+                    <input type="hidden" name="action" value="revoke_all">
+                    <button type="submit" class="btn-danger full-width">Sign Out Everywhere</button>
+                </form>
+            </div>
+
+            {{if .User.IsOwner}}
+            <!-- Failed Sign-ins -->
+            <div class="content-container">
+                <div class="section-title">Failed Sign-ins</div>
+                <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 16px;">
+                    Wrong passwords and codes from the last 30 days, for admin sign-in and the viewer password. After three failures an address has to wait between attempts, and ten in a row lock it out for 30 minutes.
+                </p>
+                {{if .LoginLockouts}}
+                <table style="width: 100%; font-size: 13px; border-collapse: collapse; margin-bottom: 16px;">
+                    {{range .LoginLockouts}}
+                    <tr style="border-bottom: 1px solid #efefef;">
+                        <td style="padding: 10px 0;">
+                            <div style="font-weight: 600; word-break: break-word;">{{.IP}}</div>
+                            <div style="color: #c62828;">{{if eq .Form "viewer"}}Viewer password{{else}}Admin sign-in{{end}} blocked until {{.Until.Format "Jan 2, 2006 15:04:05"}} &middot; {{.Failures}} failures</div>
+                        </td>
+                        <td style="padding: 10px 0 10px 12px; text-align: right; white-space: nowrap;">
+                            <form method="POST" action="/admin/settings/update">
+                                <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                                <input type="hidden" name="section" value="login-attempts">
+                                <input type="hidden" name="action" value="unlock">
+                                <input type="hidden" name="form" value="{{.Form}}">
+                                <input type="hidden" name="ip" value="{{.IP}}">
+                                <button type="submit" class="btn-secondary">Unblock</button>
+                            </form>
+                        </td>
+                    </tr>
+                    {{end}}
+                </table>
+                {{end}}
+                {{if .FailedLogins}}
+                <table style="width: 100%; font-size: 13px; border-collapse: collapse; margin-bottom: 16px;">
+                    {{range .FailedLogins}}
+                    <tr style="border-bottom: 1px solid #efefef;">
+                        <td style="padding: 10px 0;">
+                            <div style="font-weight: 600; word-break: break-word;">{{if eq .Form "viewer"}}Viewer password{{else}}Admin sign-in{{if .Username}} as {{.Username}}{{end}}{{end}}</div>
+                            <div style="color: #8e8e8e; word-break: break-word;">{{if .IP}}{{.IP}} &middot; {{end}}{{.CreatedAt.Format "Jan 2, 2006 15:04"}}{{if .UserAgent}} &middot; {{.UserAgent}}{{end}}</div>
+                        </td>
+                    </tr>
+                    {{end}}
+                </table>
+                <form method="POST" action="/admin/settings/update" onsubmit="return confirm('Clear the log of failed sign-ins?');">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="login-attempts">
+                    <input type="hidden" name="action" value="clear">
+                    <button type="submit" class="btn-secondary full-width">Clear Log</button>
                 </form>
+                {{else}}
+                <p style="font-size: 14px; color: #8e8e8e;">No failed sign-ins.</p>
+                {{end}}
             </div>
+            {{end}}
 
             <!-- Custom Domain Section -->
-            {{if .CanEnableCustomDomain}}
+            {{if not .User.IsOwner}}
+            {{else if .CanEnableCustomDomain}}
             <div class="content-container">
                 <div class="section-title">Custom Domain</div>
                 <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 16px;">
                     Enable custom domain support to connect your own domain to this blog.
                 </p>
                 <form method="POST" action="/admin/domain/enable">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                     <button type="submit">Enable Custom Domains</button>
                 </form>
                 <p style="font-size: 12px; color: #8e8e8e; margin-top: 12px;">
@@ -735,6 +1040,7 @@ const settingsTemplate = `<!DOCTYPE html>
                         </ul>
                     </div>
                     <form method="POST" action="/admin/domain/remove" onsubmit="return confirm('Are you sure you want to remove this custom domain?');">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                         <button type="submit" class="btn-danger">Remove Domain</button>
                     </form>
 
@@ -750,9 +1056,11 @@ const settingsTemplate = `<!DOCTYPE html>
                     </div>
                     <div style="display: flex; gap: 12px;">
                         <form method="POST" action="/admin/domain/activate">
+                            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                             <button type="submit">Activate Domain</button>
                         </form>
                         <form method="POST" action="/admin/domain/remove" onsubmit="return confirm('Are you sure you want to remove this domain?');">
+                            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                             <button type="submit" class="btn-secondary">Cancel</button>
                         </form>
                     </div>
@@ -814,9 +1122,11 @@ const settingsTemplate = `<!DOCTYPE html>
 
                     <div style="display: flex; gap: 12px; flex-wrap: wrap;">
                         <form method="POST" action="/admin/domain/verify">
+                            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                             <button type="submit">Verify Domain</button>
                         </form>
                         <form method="POST" action="/admin/domain/remove" onsubmit="return confirm('Are you sure you want to cancel and remove this domain?');">
+                            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                             <button type="submit" class="btn-secondary">Cancel</button>
                         </form>
                     </div>
@@ -832,6 +1142,7 @@ const settingsTemplate = `<!DOCTYPE html>
                         Connect your own domain to this blog. You'll need access to your domain's DNS settings.
                     </p>
                     <form method="POST" action="/admin/domain/add">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                         <div class="form-group">
                             <label for="domain">Domain Name</label>
                             <input type="text" name="domain" id="domain" placeholder="blog.example.com" required>
@@ -843,6 +1154,354 @@ const settingsTemplate = `<!DOCTYPE html>
             </div>
             {{end}}
 
+            {{else if eq .View "podcast"}}
+            <!-- Podcast Settings -->
+            <div class="content-container">
+                {{if not .EnableAudioUploads}}
+                <div style="padding: 12px 16px; background-color: #fff3cd; border: 1px solid #ffeeba; border-radius: 8px; margin-bottom: 16px;">
+                    <span style="color: #856404; font-size: 14px;">Audio uploads are disabled. Set ENABLE_AUDIO_UPLOADS=true to publish episodes.</span>
+                </div>
+                {{end}}
+                <form method="POST" action="/admin/settings/update" enctype="multipart/form-data" id="podcastForm">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="podcast">
+
+                    <div class="settings-section">
+                        <div class="section-title">Podcast Feed</div>
+                        <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 16px;">
+                            Posts with audio are published as episodes at <a href="/podcast.xml" target="_blank">/podcast.xml</a>. The site title and subtitle are used as the show name and description.
+                        </p>
+
+                        <div class="form-group">
+                            <label>Artwork</label>
+                            {{if .Podcast.ImagePath}}
+                            <img src="/uploads/{{.Podcast.ImagePath}}" alt="Podcast artwork" style="display: block; width: 120px; height: 120px; object-fit: cover; border-radius: 8px; margin-bottom: 12px;">
+                            {{end}}
+                            <input type="file" name="podcast_image" id="podcastImage" accept="image/jpeg,image/png" style="display: none;" onchange="document.getElementById('podcastImageName').textContent = this.files[0] ? this.files[0].name : 'Choose image'">
+                            <div class="custom-file-upload" onclick="document.getElementById('podcastImage').click()">
+                                <svg width="20" height="20" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
+                                    <path d="M21 15v4a2 2 0 0 1-2 2H5a2 2 0 0 1-2-2v-4"></path>
+                                    <polyline points="17 8 12 3 7 8"></polyline>
+                                    <line x1="12" y1="3" x2="12" y2="15"></line>
+                                </svg>
+                                <span id="podcastImageName">Choose image</span>
+                            </div>
+                            <div class="file-info" style="margin-top: 8px;">Square JPG or PNG, 1400x1400 to 3000x3000px. Episode thumbnails are used as episode artwork.</div>
+                        </div>
+
+                        <div class="form-group">
+                            <label for="podcastAuthor">Author</label>
+                            <input type="text" name="podcast_author" id="podcastAuthor" value="{{.Podcast.Author}}" placeholder="{{.Settings.SiteTitle}}">
+                            <div class="file-info">Shown as the podcast's creator. Defaults to the site title.</div>
+                        </div>
+
+                        <div class="form-group">
+                            <label for="podcastEmail">Owner Email</label>
+                            <input type="email" name="podcast_email" id="podcastEmail" value="{{.Podcast.Email}}">
+                            <div class="file-info">Used by podcast directories to verify ownership. It is visible in the feed.</div>
+                        </div>
+
+                        <div class="form-group">
+                            <label for="podcastCategory">Category</label>
+                            <input type="text" name="podcast_category" id="podcastCategory" value="{{.Podcast.Category}}" placeholder="Technology">
+                            <div class="file-info">An Apple Podcasts category. Use "Parent &gt; Child" for a subcategory, e.g. "Arts &gt; Books".</div>
+                        </div>
+
+                        <div class="form-group">
+                            <label style="display: flex; align-items: center; gap: 8px; font-weight: normal; cursor: pointer;">
+                                <input type="checkbox" name="podcast_explicit" value="true"{{if .Podcast.Explicit}} checked{{end}}>
+                                Episodes contain explicit content
+                            </label>
+                        </div>
+                    </div>
+
+                    <button type="submit" class="full-width">Save Podcast Settings</button>
+                </form>
+            </div>
+
+            {{else if eq .View "tokens"}}
+            <!-- Access Tokens -->
+            <div class="content-container">
+                {{if .NewToken}}
+                <div class="settings-section">
+                    <div class="section-title">New Token</div>
+                    <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 12px;">
+                        Copy this token now. It won't be shown again.
+                    </p>
+                    <input type="text" value="{{.NewToken}}" readonly onclick="this.select()" style="font-family: monospace;">
+                </div>
+                {{end}}
+
+                <div class="settings-section">
+                    <div class="section-title">Micropub</div>
+                    <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 16px;">
+                        Apps that support Micropub can post to this blog using the endpoint below and a token created here.
+                        Apps that support IndieAuth only need the blog's address; you approve them with your username and password, and their tokens are listed here too.
+                    </p>
+                    <input type="text" value="{{.MicropubEndpoint}}" readonly onclick="this.select()" style="font-family: monospace;">
+                </div>
+
+                <div class="settings-section">
+                    <div class="section-title">API</div>
+                    <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 16px;">
+                        Scripts and CI jobs can manage posts, media, and settings through the JSON API below.
+                        Send a token created here in an Authorization: Bearer header.
+                    </p>
+                    <input type="text" value="{{.APIEndpoint}}" readonly onclick="this.select()" style="font-family: monospace;">
+                </div>
+
+                <div class="settings-section">
+                    <div class="section-title">Tokens</div>
+                    {{if .AccessTokens}}
+                    <table style="width: 100%; font-size: 13px; border-collapse: collapse; margin-bottom: 8px;">
+                        {{range .AccessTokens}}
+                        <tr style="border-bottom: 1px solid #efefef;">
+                            <td style="padding: 10px 0;">
+                                <div style="font-weight: 600;">{{.Name}}{{if ne .UserID $.User.ID}} <span style="font-weight: normal; color: #8e8e8e;">@{{.Username}}</span>{{end}}</div>
+                                {{if .ClientID}}<div style="color: #8e8e8e; word-break: break-all;">Authorized through IndieAuth by {{.ClientID}}</div>{{end}}
+                                <div style="color: #8e8e8e;">{{.Scope}} &middot; created {{.CreatedAt.Format "Jan 2, 2006"}} &middot; {{if .LastUsedAt.Valid}}last used {{.LastUsedAt.Time.Format "Jan 2, 2006"}}{{else}}never used{{end}}</div>
+                            </td>
+                            <td style="padding: 10px 0; text-align: right;">
+                                <form method="POST" action="/admin/settings/update" onsubmit="return confirm('Revoke this token? Apps using it will stop working.');">
+                                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                                    <input type="hidden" name="section" value="tokens">
+                                    <input type="hidden" name="action" value="revoke">
+                                    <input type="hidden" name="token_id" value="{{.ID}}">
+                                    <button type="submit" class="btn-danger">Revoke</button>
+                                </form>
+                            </td>
+                        </tr>
+                        {{end}}
+                    </table>
+                    {{else}}
+                    <p style="font-size: 14px; color: #8e8e8e;">No tokens yet.</p>
+                    {{end}}
+                </div>
+
+                <form method="POST" action="/admin/settings/update">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="tokens">
+                    <input type="hidden" name="action" value="create">
+
+                    <div class="settings-section">
+                        <div class="section-title">Create Token</div>
+                        <div class="form-group">
+                            <label for="tokenName">Name</label>
+                            <input type="text" name="token_name" id="tokenName" maxlength="100" placeholder="Phone" required>
+                            <div class="file-info">A name to recognize the app or script using the token.</div>
+                        </div>
+
+                        <div class="form-group">
+                            <label>Permissions</label>
+                            {{range .TokenScopes}}
+                            <label style="display: flex; align-items: center; gap: 8px; font-weight: normal; cursor: pointer;">
+                                <input type="checkbox" name="token_scope" value="{{.}}"{{if or (eq . "create") (eq . "media")}} checked{{end}}>
+                                {{if eq . "create"}}Create posts{{else if eq . "update"}}Edit posts{{else if eq . "delete"}}Delete posts and media{{else if eq . "media"}}Upload media{{else if eq . "read"}}Read posts, media, and settings{{else if eq . "settings"}}Change settings{{else if eq . "api"}}Use the admin API{{end}}
+                            </label>
+                            {{end}}
+                        </div>
+                    </div>
+
+                    <button type="submit" class="full-width">Create Token</button>
+                </form>
+            </div>
+
+            {{else if eq .View "webhooks"}}
+            <!-- Webhooks -->
+            <div class="content-container">
+                <div class="settings-section">
+                    <div class="section-title">Endpoints</div>
+                    <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 16px;">
+                        Each event is POSTed as JSON. The X-Postastiq-Signature header holds "sha256=" and the hex HMAC-SHA256 of the body, keyed with the endpoint's secret.
+                        Failed deliveries are retried with increasing delays.
+                    </p>
+                    {{if .Webhooks}}
+                    <table style="width: 100%; font-size: 13px; border-collapse: collapse; margin-bottom: 8px;">
+                        {{range .Webhooks}}
+                        <tr style="border-bottom: 1px solid #efefef;">
+                            <td style="padding: 10px 0;">
+                                <div style="font-weight: 600; word-break: break-all;">{{.URL}}</div>
+                                <div style="color: #8e8e8e;">{{range $i, $e := .Events}}{{if $i}}, {{end}}{{$e}}{{end}} &middot; added {{.CreatedAt.Format "Jan 2, 2006"}}</div>
+                                <input type="text" value="{{.Secret}}" readonly onclick="this.select()" style="font-family: monospace; font-size: 12px; margin-top: 6px;">
+                            </td>
+                            <td style="padding: 10px 0 10px 12px; text-align: right; white-space: nowrap;">
+                                <form method="POST" action="/admin/settings/update" style="display: inline;">
+                                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                                    <input type="hidden" name="section" value="webhooks">
+                                    <input type="hidden" name="action" value="test">
+                                    <input type="hidden" name="webhook_id" value="{{.ID}}">
+                                    <button type="submit" class="btn-secondary">Send Test</button>
+                                </form>
+                                <form method="POST" action="/admin/settings/update" style="display: inline;" onsubmit="return confirm('Delete this webhook?');">
+                                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                                    <input type="hidden" name="section" value="webhooks">
+                                    <input type="hidden" name="action" value="delete">
+                                    <input type="hidden" name="webhook_id" value="{{.ID}}">
+                                    <button type="submit" class="btn-danger">Delete</button>
+                                </form>
+                            </td>
+                        </tr>
+                        {{end}}
+                    </table>
+                    {{else}}
+                    <p style="font-size: 14px; color: #8e8e8e;">No webhooks yet.</p>
+                    {{end}}
+                </div>
+
+                <form method="POST" action="/admin/settings/update">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="webhooks">
+                    <input type="hidden" name="action" value="create">
+
+                    <div class="settings-section">
+                        <div class="section-title">Add Webhook</div>
+                        <div class="form-group">
+                            <label for="webhookURL">URL</label>
+                            <input type="url" name="webhook_url" id="webhookURL" placeholder="https://example.com/hooks/blog" required>
+                            <div class="file-info">A secret for verifying signatures is generated when the webhook is added.</div>
+                        </div>
+
+                        <div class="form-group">
+                            <label>Events</label>
+                            {{range .WebhookEvents}}
+                            <label style="display: flex; align-items: center; gap: 8px; font-weight: normal; cursor: pointer;">
+                                <input type="checkbox" name="webhook_event" value="{{.}}" checked>
+                                {{if eq . "entry.created"}}Post created{{else if eq . "entry.published"}}Scheduled post went live{{else if eq . "entry.updated"}}Post updated{{else if eq . "entry.deleted"}}Post deleted{{else if eq . "backup.created"}}Backup downloaded{{else if eq . "backup.restored"}}Backup restored{{end}}
+                            </label>
+                            {{end}}
+                        </div>
+                    </div>
+
+                    <button type="submit" class="full-width">Add Webhook</button>
+                </form>
+
+                <div class="settings-section" style="margin-top: 24px;">
+                    <div class="section-title">Recent Deliveries</div>
+                    {{if .WebhookDeliveries}}
+                    <table style="width: 100%; font-size: 13px; border-collapse: collapse;">
+                        {{range .WebhookDeliveries}}
+                        <tr style="border-bottom: 1px solid #efefef;">
+                            <td style="padding: 8px 0;">
+                                <div><span style="font-weight: 600;">{{.Event}}</span> &middot; {{.CreatedAt.Format "Jan 2, 2006 15:04"}}</div>
+                                <div style="color: #8e8e8e; word-break: break-all;">{{if .WebhookURL}}{{.WebhookURL}}{{else}}Deleted webhook{{end}}</div>
+                                {{if .LastError}}<div style="color: #ed4956; word-break: break-all;">{{.LastError}}</div>{{end}}
+                            </td>
+                            <td style="padding: 8px 0 8px 12px; text-align: right; white-space: nowrap; vertical-align: top;">
+                                <div style="font-weight: 600; color: {{if eq .Status "sent"}}#2e7d32{{else if eq .Status "failed"}}#ed4956{{else}}#8e8e8e{{end}};">{{if eq .Status "sent"}}Delivered{{else if eq .Status "failed"}}Failed{{else if .Attempts}}Retrying{{else}}Queued{{end}}</div>
+                                <div style="color: #8e8e8e;">{{if .ResponseStatus}}HTTP {{.ResponseStatus}} &middot; {{end}}{{.Attempts}} {{if eq .Attempts 1}}attempt{{else}}attempts{{end}}</div>
+                            </td>
+                        </tr>
+                        {{end}}
+                    </table>
+                    {{else}}
+                    <p style="font-size: 14px; color: #8e8e8e;">No deliveries yet.</p>
+                    {{end}}
+                </div>
+            </div>
+
+            {{else if eq .View "users"}}
+            <!-- Users -->
+            <div class="content-container">
+                {{if .NewInvite}}
+                <div class="settings-section">
+                    <div class="section-title">New Invite</div>
+                    <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 12px;">
+                        Send this link to the person you're inviting. It works once and won't be shown again.
+                    </p>
+                    <input type="text" value="{{.NewInvite}}" readonly onclick="this.select()" style="font-family: monospace;">
+                </div>
+                {{end}}
+
+                <div class="settings-section">
+                    <div class="section-title">Users</div>
+                    <p style="font-size: 14px; color: #8e8e8e; margin-bottom: 16px;">
+                        Owners can change every setting and manage users. Editors can publish any post and change site settings. Authors write drafts and edit their own posts.
+                    </p>
+                    <table style="width: 100%; font-size: 13px; border-collapse: collapse; margin-bottom: 8px;">
+                        {{range .Users}}
+                        <tr style="border-bottom: 1px solid #efefef;">
+                            <td style="padding: 10px 0;">
+                                <div style="font-weight: 600;">{{.Name}}{{if ne .Name .Username}} <span style="font-weight: normal; color: #8e8e8e;">@{{.Username}}</span>{{end}}</div>
+                                <div style="color: #8e8e8e;">{{if .Email}}{{.Email}} &middot; {{end}}joined {{.CreatedAt.Format "Jan 2, 2006"}}</div>
+                                {{if eq .ID $.User.ID}}<div style="color: #2e7d32;">You</div>{{end}}
+                            </td>
+                            <td style="padding: 10px 0 10px 12px; text-align: right; white-space: nowrap;">
+                                {{if eq .ID $.User.ID}}
+                                {{.Role}}
+                                {{else}}
+                                <form method="POST" action="/admin/settings/update" style="display: inline-flex; gap: 8px;">
+                                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                                    <input type="hidden" name="section" value="users">
+                                    <input type="hidden" name="action" value="role">
+                                    <input type="hidden" name="user_id" value="{{.ID}}">
+                                    <select name="role" onchange="this.form.submit()" style="width: auto; padding: 6px 10px;">
+                                        {{$role := .Role}}
+                                        {{range $.UserRoles}}<option value="{{.}}"{{if eq . $role}} selected{{end}}>{{.}}</option>{{end}}
+                                    </select>
+                                </form>
+                                <form method="POST" action="/admin/settings/update" style="display: inline;" onsubmit="return confirm('Delete this user? Their posts will be moved to you.');">
+                                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                                    <input type="hidden" name="section" value="users">
+                                    <input type="hidden" name="action" value="delete">
+                                    <input type="hidden" name="user_id" value="{{.ID}}">
+                                    <button type="submit" class="btn-danger">Delete</button>
+                                </form>
+                                {{end}}
+                            </td>
+                        </tr>
+                        {{end}}
+                    </table>
+                </div>
+
+                {{if .UserInvites}}
+                <div class="settings-section">
+                    <div class="section-title">Pending Invites</div>
+                    <table style="width: 100%; font-size: 13px; border-collapse: collapse; margin-bottom: 8px;">
+                        {{range .UserInvites}}
+                        <tr style="border-bottom: 1px solid #efefef;">
+                            <td style="padding: 10px 0;">
+                                <div style="font-weight: 600;">{{if .Email}}{{.Email}}{{else}}Invite link{{end}}</div>
+                                <div style="color: #8e8e8e;">{{.Role}} &middot; created {{.CreatedAt.Format "Jan 2, 2006"}} &middot; expires {{.ExpiresAt.Format "Jan 2, 2006"}}</div>
+                            </td>
+                            <td style="padding: 10px 0; text-align: right;">
+                                <form method="POST" action="/admin/settings/update">
+                                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                                    <input type="hidden" name="section" value="users">
+                                    <input type="hidden" name="action" value="revoke_invite">
+                                    <input type="hidden" name="invite_id" value="{{.ID}}">
+                                    <button type="submit" class="btn-secondary">Revoke</button>
+                                </form>
+                            </td>
+                        </tr>
+                        {{end}}
+                    </table>
+                </div>
+                {{end}}
+
+                <form method="POST" action="/admin/settings/update">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="section" value="users">
+                    <input type="hidden" name="action" value="invite">
+
+                    <div class="settings-section">
+                        <div class="section-title">Invite Someone</div>
+                        <div class="form-group">
+                            <label for="inviteRole">Role</label>
+                            <select name="role" id="inviteRole">
+                                {{range .UserRoles}}<option value="{{.}}"{{if eq . "author"}} selected{{end}}>{{.}}</option>{{end}}
+                            </select>
+                        </div>
+                        <div class="form-group">
+                            <label for="inviteEmail">Email (optional)</label>
+                            <input type="email" name="email" id="inviteEmail" maxlength="254">
+                            <div class="file-info">Just a note to recognize the invite. Invite links expire after 7 days.</div>
+                        </div>
+                    </div>
+
+                    <button type="submit" class="full-width">Create Invite Link</button>
+                </form>
+            </div>
+
             {{else if eq .View "backup"}}
             <!-- Backup Settings -->
             <div class="content-container">
@@ -865,6 +1524,7 @@ const settingsTemplate = `<!DOCTYPE html>
                         Upload a backup ZIP file to restore your blog. This will replace all current data.
                     </p>
                     <form method="POST" action="/admin/restore" enctype="multipart/form-data" onsubmit="return confirmRestore()">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                         <div style="display: flex; gap: 12px; align-items: center; flex-wrap: wrap;">
                             <input type="file" name="backup_file" id="restoreFile" accept=".zip" required style="display: none;">
                             <div class="custom-file-upload" onclick="document.getElementById('restoreFile').click()">
@@ -894,6 +1554,16 @@ const settingsTemplate = `<!DOCTYPE html>
             document.querySelector('.sidebar-overlay').classList.toggle('open');
         }
 
+        function fromBase64URL(s) {
+            const binary = atob(s.replace(/-/g, '+').replace(/_/g, '/'));
+            return Uint8Array.from(binary, function(c) { return c.charCodeAt(0); });
+        }
+
+        function toBase64URL(buffer) {
+            const binary = String.fromCharCode.apply(null, new Uint8Array(buffer));
+            return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+        }
+
         function confirmRestore() {
             return confirm('Are you sure you want to restore from this backup? This will replace ALL current data including posts, settings, and media files. This action cannot be undone.');
         }
@@ -924,19 +1594,64 @@ const settingsTemplate = `<!DOCTYPE html>
             const securityForm = document.getElementById('securityForm');
             if (securityForm) {
                 securityForm.addEventListener('submit', function(e) {
-                    const adminPass = document.getElementById('adminPassword').value;
-                    const adminPassConfirm = document.getElementById('adminPasswordConfirm').value;
+                    const newPass = document.getElementById('newPassword').value;
+                    const newPassConfirm = document.getElementById('newPasswordConfirm').value;
 
-                    if (adminPass || adminPassConfirm) {
-                        if (adminPass !== adminPassConfirm) {
+                    if (newPass || newPassConfirm) {
+                        if (newPass !== newPassConfirm) {
                             e.preventDefault();
-                            alert('Admin passwords do not match');
+                            alert('Passwords do not match');
                             return false;
                         }
                     }
                 });
             }
 
+            // Passkey registration
+            const passkeyForm = document.getElementById('passkeyForm');
+            if (passkeyForm) {
+                passkeyForm.addEventListener('submit', async function(e) {
+                    e.preventDefault();
+                    if (!window.PublicKeyCredential) {
+                        alert('This browser does not support passkeys');
+                        return;
+                    }
+                    try {
+                        const options = await (await fetch('/admin/passkeys/begin', {
+                            method: 'POST',
+                            headers: {'X-CSRF-Token': {{$.CSRFToken}}}
+                        })).json();
+                        options.challenge = fromBase64URL(options.challenge);
+                        options.user.id = fromBase64URL(options.user.id);
+                        options.excludeCredentials.forEach(function(c) { c.id = fromBase64URL(c.id); });
+
+                        const credential = await navigator.credentials.create({publicKey: options});
+                        const response = await fetch('/admin/passkeys/finish', {
+                            method: 'POST',
+                            headers: {'Content-Type': 'application/json', 'X-CSRF-Token': {{$.CSRFToken}}},
+                            body: JSON.stringify({
+                                id: credential.id,
+                                name: document.getElementById('passkeyName').value,
+                                response: {
+                                    clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                                    attestationObject: toBase64URL(credential.response.attestationObject)
+                                }
+                            })
+                        });
+                        const result = await response.json();
+                        if (!response.ok) {
+                            alert(result.error_description || 'The passkey could not be added');
+                            return;
+                        }
+                        window.location.reload();
+                    } catch (err) {
+                        if (err.name !== 'NotAllowedError') {
+                            alert('The passkey could not be added: ' + err.message);
+                        }
+                    }
+                });
+            }
+
             // Avatar preview
             const avatar = document.getElementById('avatar');
             if (avatar) {
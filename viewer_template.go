@@ -860,12 +860,336 @@ const darkThemeCSS = `
         }
 `
 
+// markdownContentCSS styles the elements produced by renderMarkdown. It only
+// uses inherited colors so it works with every theme.
+const markdownContentCSS = `
+        .entry-content p {
+            margin: 0 0 0.6em;
+        }
+
+        .entry-content p:last-child,
+        .entry-content ul:last-child,
+        .entry-content ol:last-child {
+            margin-bottom: 0;
+        }
+
+        .entry-content h1,
+        .entry-content h2,
+        .entry-content h3,
+        .entry-content h4,
+        .entry-content h5,
+        .entry-content h6 {
+            font-size: 1.1em;
+            font-weight: 700;
+            line-height: 1.3;
+            margin: 0 0 0.4em;
+        }
+
+        .entry-content h1 {
+            font-size: 1.3em;
+        }
+
+        .entry-content ul,
+        .entry-content ol {
+            margin: 0 0 0.6em 1.4em;
+        }
+
+        .entry-content blockquote {
+            margin: 0 0 0.6em;
+            padding-left: 12px;
+            border-left: 3px solid currentColor;
+            opacity: 0.85;
+        }
+
+        .entry-content code {
+            font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace;
+            font-size: 0.9em;
+            background: rgba(127, 127, 127, 0.15);
+            padding: 1px 4px;
+            border-radius: 4px;
+        }
+
+        .entry-content pre {
+            background: rgba(127, 127, 127, 0.15);
+            padding: 12px;
+            border-radius: 8px;
+            overflow-x: auto;
+            margin: 0 0 0.6em;
+        }
+
+        .entry-content pre code {
+            background: none;
+            padding: 0;
+        }
+
+        .entry-content img {
+            max-width: 100%;
+            height: auto;
+        }
+
+        .entry-content hr {
+            border: none;
+            border-top: 1px solid rgba(127, 127, 127, 0.3);
+            margin: 0.8em 0;
+        }
+`
+
+// entryTagsCSS styles the tag links shown under entries and the tag archive
+// header. Like markdownContentCSS it only uses inherited colors.
+const entryTagsCSS = `
+        .entry-tags {
+            display: flex;
+            flex-wrap: wrap;
+            gap: 6px;
+            margin-top: 10px;
+        }
+
+        .entry-tag {
+            font-size: 13px;
+            color: inherit;
+            opacity: 0.7;
+            text-decoration: none;
+        }
+
+        .entry-tag:hover {
+            opacity: 1;
+            text-decoration: underline;
+        }
+
+        .header h1 a {
+            color: inherit;
+            text-decoration: none;
+        }
+
+        .tag-heading {
+            margin-top: 6px;
+            font-size: 15px;
+            opacity: 0.8;
+        }
+
+        .tag-heading a {
+            color: inherit;
+        }
+`
+
+// searchCSS styles the header search link and the search page
+const searchCSS = `
+        .header-search {
+            display: flex;
+            align-items: center;
+            color: inherit;
+            opacity: 0.7;
+        }
+
+        .header-search:hover {
+            opacity: 1;
+        }
+
+        .search-form {
+            display: flex;
+            gap: 8px;
+            margin-bottom: 24px;
+        }
+
+        .search-form input {
+            flex: 1;
+            padding: 12px 16px;
+            border: 1px solid rgba(127, 127, 127, 0.35);
+            border-radius: 8px;
+            font-size: 16px;
+            font-family: inherit;
+            background: transparent;
+            color: inherit;
+        }
+
+        .search-form button {
+            padding: 12px 20px;
+            border: none;
+            border-radius: 8px;
+            font-size: 14px;
+            font-weight: 600;
+            cursor: pointer;
+            background: rgba(127, 127, 127, 0.2);
+            color: inherit;
+        }
+
+        .search-summary {
+            font-size: 14px;
+            opacity: 0.7;
+            margin-bottom: 16px;
+        }
+
+        .search-result-title {
+            display: block;
+            font-weight: 600;
+            color: inherit;
+            text-decoration: none;
+            margin-bottom: 6px;
+        }
+
+        .search-result-title:hover {
+            text-decoration: underline;
+        }
+
+        .entry-content mark {
+            background: rgba(255, 213, 79, 0.5);
+            color: inherit;
+            border-radius: 2px;
+        }
+
+        .search-pagination {
+            display: flex;
+            justify-content: space-between;
+            margin-top: 24px;
+            font-size: 14px;
+        }
+
+        .search-pagination a {
+            color: inherit;
+        }
+`
+
+// photoPlaceholderCSS shows a photo's blurred placeholder behind it until the
+// photo has loaded
+const photoPlaceholderCSS = `
+        .entry-photo-container.has-placeholder {
+            background-size: cover;
+            background-position: center;
+        }
+`
+
+// galleryCSS styles the carousel shown for entries with several media items.
+// The item counter comes from a CSS counter, so it needs no script.
+const galleryCSS = `
+        .entry-gallery {
+            position: relative;
+        }
+
+        .entry-gallery-track {
+            display: flex;
+            overflow-x: auto;
+            scroll-snap-type: x mandatory;
+            scrollbar-width: none;
+            counter-reset: gallery-item;
+            border-radius: 18px;
+        }
+
+        .entry-gallery-track::-webkit-scrollbar {
+            display: none;
+        }
+
+        .entry-gallery-item {
+            flex: 0 0 100%;
+            scroll-snap-align: start;
+            counter-increment: gallery-item;
+            border-radius: 0;
+            box-shadow: none;
+        }
+
+        .entry:hover .entry-gallery-item {
+            transform: none;
+        }
+
+        .entry-gallery-item::after {
+            content: counter(gallery-item) " / " attr(data-count);
+            position: absolute;
+            top: 12px;
+            right: 12px;
+            padding: 2px 10px;
+            border-radius: 12px;
+            background: rgba(0, 0, 0, 0.6);
+            color: #fff;
+            font-size: 12px;
+        }
+
+        .entry-gallery-nav {
+            position: absolute;
+            top: 50%;
+            transform: translateY(-50%);
+            width: 32px;
+            height: 32px;
+            border: none;
+            border-radius: 50%;
+            background: rgba(255, 255, 255, 0.85);
+            color: #262626;
+            font-size: 22px;
+            line-height: 1;
+            cursor: pointer;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+        }
+
+        .entry-gallery-nav.prev {
+            left: 10px;
+        }
+
+        .entry-gallery-nav.next {
+            right: 10px;
+        }
+
+        @media (hover: none) {
+            .entry-gallery-nav {
+                display: none;
+            }
+        }
+`
+
+// entryGalleryTemplate renders the media items of an EntryDisplay as a
+// swipeable carousel. It is parsed together with the viewer and post templates.
+const entryGalleryTemplate = `{{define "entry-gallery"}}
+<div class="entry-gallery">
+    <div class="entry-gallery-track">
+        {{range .Media}}
+        <div class="entry-photo-container entry-gallery-item{{if .Placeholder}} has-placeholder{{end}}" data-count="{{len $.Media}}"{{if .Placeholder}} style="background-image: url('{{.Placeholder}}');"{{end}}>
+            {{if eq .MediaType "video"}}
+            <video controls preload="metadata" class="entry-photo" style="object-fit: contain;"{{if .Thumbnail}} poster="{{.Thumbnail}}"{{end}}>
+                <source src="{{.URL}}">
+                Your browser does not support the video element.
+            </video>
+            {{else if eq .MediaType "audio"}}
+            {{if .Thumbnail}}
+            <img src="{{.Thumbnail}}" alt="{{if .AltText}}{{.AltText}}{{else}}Audio cover{{end}}" class="entry-photo" style="object-fit: cover;">
+            {{else}}
+            <div style="position: absolute; top: 50%; left: 50%; transform: translate(-50%, -50%); text-align: center;">
+                <svg width="80" height="80" viewBox="0 0 24 24" fill="none" stroke="white" stroke-width="2" stroke-linecap="round" stroke-linejoin="round" style="opacity: 0.7;">
+                    <path d="M9 18V5l12-2v13"></path>
+                    <circle cx="6" cy="18" r="3"></circle>
+                    <circle cx="18" cy="16" r="3"></circle>
+                </svg>
+            </div>
+            {{end}}
+            <div style="position: absolute; bottom: 0; left: 0; right: 0; padding: 16px; background: linear-gradient(transparent, rgba(0,0,0,0.8));">
+                <audio controls style="width: 100%; filter: invert(1) hue-rotate(180deg);">
+                    <source src="{{.URL}}">
+                    Your browser does not support the audio element.
+                </audio>
+            </div>
+            {{else}}
+            <img src="{{.URL}}"{{if .SrcSet}} srcset="{{.SrcSet}}" sizes="{{$.ImageSizes}}"{{end}} alt="{{.AltText}}" class="entry-photo" loading="lazy" decoding="async">
+            {{end}}
+        </div>
+        {{end}}
+    </div>
+    <button type="button" class="entry-gallery-nav prev" aria-label="Previous" onclick="event.stopPropagation(); this.parentNode.firstElementChild.scrollBy({left: -this.parentNode.offsetWidth, behavior: 'smooth'});">&#8249;</button>
+    <button type="button" class="entry-gallery-nav next" aria-label="Next" onclick="event.stopPropagation(); this.parentNode.firstElementChild.scrollBy({left: this.parentNode.offsetWidth, behavior: 'smooth'});">&#8250;</button>
+</div>
+{{end}}`
+
 const viewerTemplate = `<!DOCTYPE html>
 <html lang="en">
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>{{.SiteTitle}}</title>
+    <title>{{if .Tag}}#{{.Tag}} - {{end}}{{.SiteTitle}}</title>
+    {{if .Tag}}<link rel="alternate" type="application/rss+xml" title="#{{.Tag}} - {{.SiteTitle}}" href="/tags/{{.Tag}}/rss">{{end}}
+    <link rel="alternate" type="application/rss+xml" title="{{.SiteTitle}}" href="/rss">
+    <link rel="alternate" type="application/atom+xml" title="{{.SiteTitle}}" href="/atom.xml">
+    <link rel="alternate" type="application/feed+json" title="{{.SiteTitle}}" href="/feed.json">
+    <link rel="micropub" href="/micropub">
+    <link rel="indieauth-metadata" href="/.well-known/oauth-authorization-server">
+    <link rel="authorization_endpoint" href="/indieauth/auth">
+    <link rel="token_endpoint" href="/indieauth/token">
     <style>
 {{.ThemeCSS}}
     </style>
@@ -875,9 +1199,17 @@ const viewerTemplate = `<!DOCTYPE html>
         <div class="header">
             <div class="header-content">
                 <div>
+                    {{if .Tag}}
+                    <h1><a href="/">{{.SiteTitle}}</a></h1>
+                    <div class="tag-heading">#{{.Tag}} &middot; {{.TotalEntries}} post{{if ne .TotalEntries 1}}s{{end}} &middot; <a href="/tags/{{.Tag}}/rss">RSS</a></div>
+                    {{else}}
                     <h1>{{.SiteTitle}}</h1>
                     {{if .EnableSubtitle}}<div class="subtitle">{{.SiteSubtitle}}</div>{{end}}
+                    {{end}}
                 </div>
+                <a href="/search" class="header-search" aria-label="Search">
+                    <svg width="22" height="22" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><circle cx="11" cy="11" r="8"></circle><line x1="21" y1="21" x2="16.65" y2="16.65"></line></svg>
+                </a>
             </div>
         </div>
 
@@ -894,9 +1226,12 @@ const viewerTemplate = `<!DOCTYPE html>
                             {{end}}
                         </div>
                     </div>
+                    {{if gt (len .Media) 1}}
+                    {{template "entry-gallery" .}}
+                    {{else}}
                     {{if .HasPhoto}}
-                    <div class="entry-photo-container">
-                        <img src="{{.Photo}}" alt="Entry photo" class="entry-photo">
+                    <div class="entry-photo-container{{if .PhotoPlaceholder}} has-placeholder{{end}}"{{if .PhotoPlaceholder}} style="background-image: url('{{.PhotoPlaceholder}}');"{{end}}>
+                        <img src="{{.Photo}}"{{if .PhotoSrcSet}} srcset="{{.PhotoSrcSet}}" sizes="{{.ImageSizes}}"{{end}} alt="{{.PhotoAlt}}" class="entry-photo" loading="lazy" decoding="async">
                     </div>
                     {{end}}
                     {{if .HasAudio}}
@@ -945,7 +1280,13 @@ const viewerTemplate = `<!DOCTYPE html>
                         {{end}}
                     </div>
                     {{end}}
+                    {{end}}
                     <div class="entry-content">{{.Content}}</div>
+                    {{if .Tags}}
+                    <div class="entry-tags">
+                        {{range .Tags}}<a href="/tags/{{.}}/" class="entry-tag" onclick="event.stopPropagation()">#{{.}}</a>{{end}}
+                    </div>
+                    {{end}}
                     <div class="entry-timestamp">{{.TimeAgo}}</div>
                 </div>
                 {{end}}
@@ -973,6 +1314,7 @@ const viewerTemplate = `<!DOCTYPE html>
         const userInitial = '{{.UserInitial}}';
         const avatarPath = '{{.AvatarPath}}';
         const avatarPreference = '{{.AvatarPreference}}';
+        const tagFilter = '{{.Tag}}';
 
         // Add click handlers to all entries
         document.addEventListener('DOMContentLoaded', function() {
@@ -1017,6 +1359,40 @@ const viewerTemplate = `<!DOCTYPE html>
             return days === 1 ? '1 day ago' : days + ' days ago';
         }
 
+        // Responsive image attributes and the blurred placeholder behind a photo
+        function photoAttributes(srcSet, sizes) {
+            return srcSet ? ' srcset="' + escapeAttribute(srcSet) + '" sizes="' + escapeAttribute(sizes) + '"' : '';
+        }
+
+        function placeholderAttributes(placeholder) {
+            return placeholder ? ' has-placeholder" style="background-image: url(\'' + escapeAttribute(placeholder) + '\');' : '';
+        }
+
+        function escapeAttribute(text) {
+            return String(text || '').replace(/&/g, '&amp;').replace(/"/g, '&quot;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+        }
+
+        // Same markup as the entry-gallery template
+        function createGalleryHtml(media, sizes) {
+            const items = media.map(function(item) {
+                let itemHtml;
+                if (item.MediaType === 'video') {
+                    itemHtml = '<video controls preload="metadata" class="entry-photo" style="object-fit: contain;"' + (item.Thumbnail ? ' poster="' + item.Thumbnail + '"' : '') + '><source src="' + item.URL + '">Your browser does not support the video element.</video>';
+                } else if (item.MediaType === 'audio') {
+                    itemHtml = item.Thumbnail
+                        ? '<img src="' + item.Thumbnail + '" alt="' + escapeAttribute(item.AltText || 'Audio cover') + '" class="entry-photo" style="object-fit: cover;">'
+                        : '<div style="position: absolute; top: 50%; left: 50%; transform: translate(-50%, -50%); text-align: center;"><svg width="80" height="80" viewBox="0 0 24 24" fill="none" stroke="white" stroke-width="2" stroke-linecap="round" stroke-linejoin="round" style="opacity: 0.7;"><path d="M9 18V5l12-2v13"></path><circle cx="6" cy="18" r="3"></circle><circle cx="18" cy="16" r="3"></circle></svg></div>';
+                    itemHtml += '<div style="position: absolute; bottom: 0; left: 0; right: 0; padding: 16px; background: linear-gradient(transparent, rgba(0,0,0,0.8));"><audio controls style="width: 100%; filter: invert(1) hue-rotate(180deg);"><source src="' + item.URL + '">Your browser does not support the audio element.</audio></div>';
+                } else {
+                    itemHtml = '<img src="' + item.URL + '"' + photoAttributes(item.SrcSet, sizes) + ' alt="' + escapeAttribute(item.AltText) + '" class="entry-photo" loading="lazy" decoding="async">';
+                }
+                return '<div class="entry-photo-container entry-gallery-item' + placeholderAttributes(item.Placeholder) + '" data-count="' + media.length + '">' + itemHtml + '</div>';
+            });
+            return '<div class="entry-gallery"><div class="entry-gallery-track">' + items.join('') + '</div>' +
+                '<button type="button" class="entry-gallery-nav prev" aria-label="Previous" onclick="event.stopPropagation(); this.parentNode.firstElementChild.scrollBy({left: -this.parentNode.offsetWidth, behavior: \'smooth\'});">&#8249;</button>' +
+                '<button type="button" class="entry-gallery-nav next" aria-label="Next" onclick="event.stopPropagation(); this.parentNode.firstElementChild.scrollBy({left: this.parentNode.offsetWidth, behavior: \'smooth\'});">&#8250;</button></div>';
+        }
+
         function createEntryElement(entry) {
             const entryDiv = document.createElement('div');
             entryDiv.className = 'entry';
@@ -1027,8 +1403,10 @@ const viewerTemplate = `<!DOCTYPE html>
             }
 
             let mediaHtml = '';
-            if (entry.HasPhoto && entry.Photo) {
-                mediaHtml = '<div class="entry-photo-container"><img src="' + entry.Photo + '" alt="Entry photo" class="entry-photo"></div>';
+            if (entry.Media && entry.Media.length > 1) {
+                mediaHtml = createGalleryHtml(entry.Media, entry.ImageSizes);
+            } else if (entry.HasPhoto && entry.Photo) {
+                mediaHtml = '<div class="entry-photo-container' + placeholderAttributes(entry.PhotoPlaceholder) + '"><img src="' + entry.Photo + '"' + photoAttributes(entry.PhotoSrcSet, entry.ImageSizes) + ' alt="' + escapeAttribute(entry.PhotoAlt) + '" class="entry-photo" loading="lazy" decoding="async"></div>';
             } else if (entry.HasAudio && entry.Photo) {
                 if (entry.HasThumbnail && entry.Thumbnail) {
                     mediaHtml = '<div class="entry-photo-container"><img src="' + entry.Thumbnail + '" alt="Audio cover" class="entry-photo" style="object-fit: cover;"><div style="position: absolute; bottom: 0; left: 0; right: 0; padding: 16px; background: linear-gradient(transparent, rgba(0,0,0,0.8));"><audio controls style="width: 100%; filter: invert(1) hue-rotate(180deg);"><source src="' + entry.Photo + '">Your browser does not support the audio element.</audio></div></div>';
@@ -1048,9 +1426,17 @@ const viewerTemplate = `<!DOCTYPE html>
                 ? '<img src="/uploads/' + avatarPath + '" alt="Avatar" style="width: 100%; height: 100%; border-radius: 50%; object-fit: cover;">'
                 : '<div class="avatar-inner">' + userInitial + '</div>';
 
+            let tagsHtml = '';
+            if (entry.Tags && entry.Tags.length > 0) {
+                tagsHtml = '<div class="entry-tags">' + entry.Tags.map(function(tag) {
+                    return '<a href="/tags/' + tag + '/" class="entry-tag" onclick="event.stopPropagation()">#' + tag + '</a>';
+                }).join('') + '</div>';
+            }
+
             entryDiv.innerHTML = '<div class="entry-header"><div class="avatar">' + avatarHtml + '</div></div>' +
                 mediaHtml +
                 '<div class="entry-content">' + entry.Content + '</div>' +
+                tagsHtml +
                 '<div class="entry-timestamp">' + entry.TimeAgo + '</div>';
 
             return entryDiv;
@@ -1064,7 +1450,11 @@ const viewerTemplate = `<!DOCTYPE html>
             loadingEl.classList.add('show');
 
             try {
-                const response = await fetch('/api/entries?offset=' + currentOffset + '&limit=10');
+                let url = '/api/entries?offset=' + currentOffset + '&limit=10';
+                if (tagFilter) {
+                    url += '&tag=' + encodeURIComponent(tagFilter);
+                }
+                const response = await fetch(url);
                 const data = await response.json();
 
                 if (data.entries && data.entries.length > 0) {
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures in testdata/webauthn.json are responses recorded from the
+// software authenticator below. Run `go test -run WebAuthn -update` to record
+// new ones. Those in testdata/webauthn_recorded.json come from real
+// authenticators, by way of the go-webauthn test suite (BSD-3-Clause).
+var updateWebAuthnFixtures = flag.Bool("update", false, "record new WebAuthn fixtures")
+
+const (
+	webauthnFixturesPath         = "testdata/webauthn.json"
+	webauthnRecordedFixturesPath = "testdata/webauthn_recorded.json"
+)
+
+// authDataExtensions flags authenticator data that ends with extension outputs
+const authDataExtensions = 0x80
+
+var fixtureRelyingParty = webauthnRelyingParty{ID: "blog.example", Origin: "https://blog.example"}
+
+type webauthnAssertion struct {
+	Challenge         []byte `json:"challenge"`
+	ClientDataJSON    []byte `json:"clientDataJSON"`
+	AuthenticatorData []byte `json:"authenticatorData"`
+	Signature         []byte `json:"signature"`
+	SignCount         uint32 `json:"signCount"`
+}
+
+// webauthnFixture is a recorded registration and a sign-in with the
+// registered credential, plus the same sign-in signed again with the user
+// verified or user present flag cleared
+type webauthnFixture struct {
+	Registration struct {
+		Challenge         []byte `json:"challenge"`
+		ClientDataJSON    []byte `json:"clientDataJSON"`
+		AttestationObject []byte `json:"attestationObject"`
+	} `json:"registration"`
+	Assertion   webauthnAssertion `json:"assertion"`
+	NotVerified webauthnAssertion `json:"notVerified"`
+	NotPresent  webauthnAssertion `json:"notPresent"`
+}
+
+var webauthnFixtureAlgorithms = map[string]int64{
+	"ES256": coseAlgES256,
+	"EdDSA": coseAlgEdDSA,
+	"RS256": coseAlgRS256,
+}
+
+func loadWebAuthnFixtures(t *testing.T) map[string]*webauthnFixture {
+	t.Helper()
+	if *updateWebAuthnFixtures {
+		recordWebAuthnFixtures(t)
+	}
+	data, err := os.ReadFile(webauthnFixturesPath)
+	if err != nil {
+		t.Fatalf("reading fixtures (record them with -update): %v", err)
+	}
+	var fixtures map[string]*webauthnFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatalf("parsing fixtures: %v", err)
+	}
+	for name := range webauthnFixtureAlgorithms {
+		if fixtures[name] == nil {
+			t.Fatalf("no %s fixture", name)
+		}
+	}
+	return fixtures
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	for name, f := range loadWebAuthnFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			reg := f.Registration
+			credential, err := verifyWebAuthnRegistration(fixtureRelyingParty, reg.Challenge, reg.ClientDataJSON, reg.AttestationObject)
+			if err != nil {
+				t.Fatalf("valid registration rejected: %v", err)
+			}
+			if len(credential.ID) == 0 {
+				t.Error("no credential ID")
+			}
+			if _, alg, err := parseCOSEKey(credential.PublicKey); err != nil || alg != webauthnFixtureAlgorithms[name] {
+				t.Errorf("public key: algorithm %d, %v", alg, err)
+			}
+		})
+	}
+}
+
+func TestWebAuthnRegistrationRejected(t *testing.T) {
+	reg := loadWebAuthnFixtures(t)["ES256"].Registration
+	truncated := reg.AttestationObject[:len(reg.AttestationObject)/2]
+
+	tests := []struct {
+		name              string
+		rp                webauthnRelyingParty
+		challenge         []byte
+		clientDataJSON    []byte
+		attestationObject []byte
+	}{
+		{"wrong origin", webauthnRelyingParty{ID: "blog.example", Origin: "https://evil.example"}, reg.Challenge, reg.ClientDataJSON, reg.AttestationObject},
+		{"wrong RP ID hash", webauthnRelyingParty{ID: "evil.example", Origin: "https://blog.example"}, reg.Challenge, reg.ClientDataJSON, reg.AttestationObject},
+		{"wrong challenge", fixtureRelyingParty, []byte("another challenge"), reg.ClientDataJSON, reg.AttestationObject},
+		{"malformed attestation object", fixtureRelyingParty, reg.Challenge, reg.ClientDataJSON, truncated},
+		{"malformed client data", fixtureRelyingParty, reg.Challenge, []byte("{"), reg.AttestationObject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifyWebAuthnRegistration(tt.rp, tt.challenge, tt.clientDataJSON, tt.attestationObject); err == nil {
+				t.Error("registration accepted")
+			}
+		})
+	}
+}
+
+// registeredKey returns the public key a fixture registered
+func registeredKey(t *testing.T, f *webauthnFixture) []byte {
+	t.Helper()
+	reg := f.Registration
+	credential, err := verifyWebAuthnRegistration(fixtureRelyingParty, reg.Challenge, reg.ClientDataJSON, reg.AttestationObject)
+	if err != nil {
+		t.Fatalf("registration: %v", err)
+	}
+	return credential.PublicKey
+}
+
+func TestWebAuthnAssertion(t *testing.T) {
+	for name, f := range loadWebAuthnFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			a := f.Assertion
+			count, err := verifyWebAuthnAssertion(fixtureRelyingParty, a.Challenge, registeredKey(t, f), 0, a.ClientDataJSON, a.AuthenticatorData, a.Signature)
+			if err != nil {
+				t.Fatalf("valid assertion rejected: %v", err)
+			}
+			if count != a.SignCount {
+				t.Errorf("sign count %d, want %d", count, a.SignCount)
+			}
+
+			badSignature := append([]byte{}, a.Signature...)
+			badSignature[len(badSignature)/2] ^= 0xff
+			if _, err := verifyWebAuthnAssertion(fixtureRelyingParty, a.Challenge, registeredKey(t, f), 0, a.ClientDataJSON, a.AuthenticatorData, badSignature); err == nil {
+				t.Error("bad signature accepted")
+			}
+		})
+	}
+}
+
+func TestWebAuthnAssertionRejected(t *testing.T) {
+	f := loadWebAuthnFixtures(t)["ES256"]
+	a := f.Assertion
+	key := registeredKey(t, f)
+	otherKey := registeredKey(t, loadWebAuthnFixtures(t)["EdDSA"])
+
+	tamperedAuthData := append([]byte{}, a.AuthenticatorData...)
+	binary.BigEndian.PutUint32(tamperedAuthData[33:37], a.SignCount+100)
+
+	tests := []struct {
+		name           string
+		rp             webauthnRelyingParty
+		challenge      []byte
+		publicKey      []byte
+		storedCount    uint32
+		clientDataJSON []byte
+		authData       []byte
+	}{
+		{"wrong origin", webauthnRelyingParty{ID: "blog.example", Origin: "https://evil.example"}, a.Challenge, key, 0, a.ClientDataJSON, a.AuthenticatorData},
+		{"wrong RP ID hash", webauthnRelyingParty{ID: "evil.example", Origin: "https://blog.example"}, a.Challenge, key, 0, a.ClientDataJSON, a.AuthenticatorData},
+		{"wrong challenge", fixtureRelyingParty, f.Registration.Challenge, key, 0, a.ClientDataJSON, a.AuthenticatorData},
+		{"registration client data", fixtureRelyingParty, f.Registration.Challenge, key, 0, f.Registration.ClientDataJSON, a.AuthenticatorData},
+		{"signed by another key", fixtureRelyingParty, a.Challenge, otherKey, 0, a.ClientDataJSON, a.AuthenticatorData},
+		{"tampered authenticator data", fixtureRelyingParty, a.Challenge, key, 0, a.ClientDataJSON, tamperedAuthData},
+		{"truncated authenticator data", fixtureRelyingParty, a.Challenge, key, 0, a.ClientDataJSON, a.AuthenticatorData[:20]},
+		{"counter repeated", fixtureRelyingParty, a.Challenge, key, a.SignCount, a.ClientDataJSON, a.AuthenticatorData},
+		{"counter went backwards", fixtureRelyingParty, a.Challenge, key, a.SignCount + 1, a.ClientDataJSON, a.AuthenticatorData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifyWebAuthnAssertion(tt.rp, tt.challenge, tt.publicKey, tt.storedCount, tt.clientDataJSON, tt.authData, a.Signature); err == nil {
+				t.Error("assertion accepted")
+			}
+		})
+	}
+}
+
+func TestWebAuthnAssertionNeedsUserVerification(t *testing.T) {
+	for name, f := range loadWebAuthnFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			key := registeredKey(t, f)
+			for flag, a := range map[string]webauthnAssertion{"user verified": f.NotVerified, "user present": f.NotPresent} {
+				_, err := verifyWebAuthnAssertion(fixtureRelyingParty, a.Challenge, key, 0, a.ClientDataJSON, a.AuthenticatorData, a.Signature)
+				if err == nil || err.Error() != "user wasn't verified" {
+					t.Errorf("assertion without the %s flag: %v", flag, err)
+				}
+			}
+		})
+	}
+}
+
+// recordedWebAuthnFixtures are responses from real authenticators, each
+// with the relying party it was made for
+type recordedWebAuthnFixtures struct {
+	Registrations map[string]struct {
+		Source            string `json:"source"`
+		RPID              string `json:"rpId"`
+		Origin            string `json:"origin"`
+		Algorithm         int64  `json:"algorithm"`
+		Challenge         []byte `json:"challenge"`
+		ClientDataJSON    []byte `json:"clientDataJSON"`
+		AttestationObject []byte `json:"attestationObject"`
+	} `json:"registrations"`
+	Assertions map[string]struct {
+		webauthnAssertion
+		Source    string `json:"source"`
+		RPID      string `json:"rpId"`
+		Origin    string `json:"origin"`
+		PublicKey []byte `json:"publicKey"`
+	} `json:"assertions"`
+}
+
+func TestWebAuthnRecordedResponses(t *testing.T) {
+	data, err := os.ReadFile(webauthnRecordedFixturesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded recordedWebAuthnFixtures
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		t.Fatalf("parsing fixtures: %v", err)
+	}
+	if len(recorded.Registrations) == 0 || len(recorded.Assertions) == 0 {
+		t.Fatal("no recorded responses")
+	}
+
+	for name, reg := range recorded.Registrations {
+		t.Run("registration/"+name, func(t *testing.T) {
+			rp := webauthnRelyingParty{ID: reg.RPID, Origin: reg.Origin}
+			credential, err := verifyWebAuthnRegistration(rp, reg.Challenge, reg.ClientDataJSON, reg.AttestationObject)
+			if err != nil {
+				t.Fatalf("registration from %s rejected: %v", reg.Source, err)
+			}
+			if _, alg, err := parseCOSEKey(credential.PublicKey); err != nil || alg != reg.Algorithm {
+				t.Errorf("public key: algorithm %d, %v", alg, err)
+			}
+			if _, err := verifyWebAuthnRegistration(fixtureRelyingParty, reg.Challenge, reg.ClientDataJSON, reg.AttestationObject); err == nil {
+				t.Error("registration accepted for another site")
+			}
+		})
+	}
+	for name, a := range recorded.Assertions {
+		t.Run("assertion/"+name, func(t *testing.T) {
+			rp := webauthnRelyingParty{ID: a.RPID, Origin: a.Origin}
+			count, err := verifyWebAuthnAssertion(rp, a.Challenge, a.PublicKey, 0, a.ClientDataJSON, a.AuthenticatorData, a.Signature)
+			if err != nil {
+				t.Fatalf("assertion from %s rejected: %v", a.Source, err)
+			}
+			if count != a.SignCount {
+				t.Errorf("sign count %d, want %d", count, a.SignCount)
+			}
+			if _, err := verifyWebAuthnAssertion(rp, a.Challenge, a.PublicKey, a.SignCount, a.ClientDataJSON, a.AuthenticatorData, a.Signature); err == nil {
+				t.Error("replayed assertion accepted")
+			}
+		})
+	}
+}
+
+func TestWebAuthnChallengeIsSingleUse(t *testing.T) {
+	challenge, err := newWebAuthnChallenge(1, true, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := webauthnClientData("webauthn.create", challenge)
+
+	if _, _, ok := takeWebAuthnChallenge(clientData, false); ok {
+		t.Error("registration challenge accepted for sign-in")
+	}
+	// A mismatched ceremony still uses the challenge up
+	if _, _, ok := takeWebAuthnChallenge(clientData, true); ok {
+		t.Error("challenge reused after a failed attempt")
+	}
+
+	challenge, err = newWebAuthnChallenge(1, true, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData = webauthnClientData("webauthn.create", challenge)
+	got, c, ok := takeWebAuthnChallenge(clientData, true)
+	if !ok || !bytes.Equal(got, challenge) || c.UserID != 1 {
+		t.Fatalf("challenge not found: %v %+v", ok, c)
+	}
+	if _, _, ok := takeWebAuthnChallenge(clientData, true); ok {
+		t.Error("challenge reused")
+	}
+	if _, _, ok := takeWebAuthnChallenge(webauthnClientData("webauthn.create", []byte("never issued")), true); ok {
+		t.Error("unknown challenge accepted")
+	}
+}
+
+func TestWebAuthnChallengesAreCapped(t *testing.T) {
+	webauthnMutex.Lock()
+	webauthnChallenges = make(map[string]*webauthnChallenge)
+	webauthnMutex.Unlock()
+	t.Cleanup(func() {
+		webauthnMutex.Lock()
+		webauthnChallenges = make(map[string]*webauthnChallenge)
+		webauthnMutex.Unlock()
+	})
+
+	// One address only keeps its newest few challenges
+	var first []byte
+	for i := 0; i < 3*webauthnChallengesPerIP; i++ {
+		challenge, err := newWebAuthnChallenge(0, false, "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = challenge
+		}
+	}
+	webauthnMutex.Lock()
+	pending := len(webauthnChallenges)
+	webauthnMutex.Unlock()
+	if pending != webauthnChallengesPerIP {
+		t.Errorf("%d challenges pending for one address, want %d", pending, webauthnChallengesPerIP)
+	}
+	if _, _, ok := takeWebAuthnChallenge(webauthnClientData("webauthn.get", first), false); ok {
+		t.Error("oldest challenge kept")
+	}
+
+	// Many addresses together hit the overall cap
+	var err error
+	for i := 0; i < maxWebAuthnChallenges && err == nil; i++ {
+		_, err = newWebAuthnChallenge(0, false, fmt.Sprintf("10.%d.%d.1", i/256, i%256))
+	}
+	if err != errWebAuthnBusy {
+		t.Errorf("no error once %d challenges are pending: %v", maxWebAuthnChallenges, err)
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	item, used, err := decodeCBOR([]byte{0xa3, 0x01, 0x02, 0x20, 0x43, 1, 2, 3, 0x61, 'k', 0x82, 0xf5, 0x39, 0x01, 0x00})
+	if err != nil || used != 15 {
+		t.Fatalf("decode: %v, used %d", err, used)
+	}
+	m := item.(map[interface{}]interface{})
+	if m[int64(1)] != int64(2) || !bytes.Equal(m[int64(-1)].([]byte), []byte{1, 2, 3}) {
+		t.Errorf("unexpected map %v", m)
+	}
+	if list := m["k"].([]interface{}); list[0] != true || list[1] != int64(-257) {
+		t.Errorf("unexpected array %v", list)
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":                    {},
+		"truncated length":         {0x19, 0x01},
+		"truncated byte string":    {0x44, 1, 2},
+		"truncated map":            {0xa2, 0x01, 0x02},
+		"huge byte string":         {0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"huge array":               {0x9b, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"huge map":                 {0xbb, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"integer out of range":     {0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"indefinite length array":  {0x9f, 0x01, 0xff},
+		"reserved length encoding": {0x1c},
+		"map with array key":       {0xa1, 0x80, 0x01},
+		"tag":                      {0xc0, 0x01},
+		"float":                    {0xf9, 0x3c, 0x00},
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(data); err == nil {
+				t.Error("malformed CBOR accepted")
+			}
+		})
+	}
+}
+
+func TestParseCOSEKeyRejectsBadKeys(t *testing.T) {
+	ec := cborEncode(cborMap{{1, 2}, {3, coseAlgES256}, {-1, 1}, {-2, make([]byte, 32)}, {-3, make([]byte, 32)}})
+	tests := map[string][]byte{
+		"not a map":         cborEncode([]byte{1}),
+		"malformed CBOR":    {0xa5, 0x01},
+		"point not on P256": ec,
+		"unknown algorithm": cborEncode(cborMap{{1, 2}, {3, -36}}),
+		"short Ed25519 key": cborEncode(cborMap{{1, 1}, {3, coseAlgEdDSA}, {-1, 6}, {-2, make([]byte, 31)}}),
+		"short RSA modulus": cborEncode(cborMap{{1, 3}, {3, coseAlgRS256}, {-1, make([]byte, 128)}, {-2, []byte{1, 0, 1}}}),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := parseCOSEKey(data); err == nil {
+				t.Error("bad key accepted")
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Software authenticator used to record the fixtures
+// ---------------------------------------------------------------------------
+
+type cborPair struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// cborMap is a CBOR map whose keys are encoded in the order given
+type cborMap []cborPair
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
+
+func cborEncode(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case cborMap:
+		out := cborHead(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, cborEncode(pair.Key)...)
+			out = append(out, cborEncode(pair.Value)...)
+		}
+		return out
+	}
+	panic("cborEncode: unsupported type")
+}
+
+func webauthnClientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      fixtureRelyingParty.Origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// recordWebAuthnFixtures registers a new key of each algorithm, signs in
+// with it and writes the responses to webauthnFixturesPath
+func recordWebAuthnFixtures(t *testing.T) {
+	rpIDHash := sha256.Sum256([]byte(fixtureRelyingParty.ID))
+	fixtures := make(map[string]*webauthnFixture)
+
+	for name, alg := range webauthnFixtureAlgorithms {
+		var coseKey []byte
+		var sign func(message []byte) []byte
+		switch alg {
+		case coseAlgES256:
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			coseKey = cborEncode(cborMap{{1, 2}, {3, coseAlgES256}, {-1, 1},
+				{-2, key.X.FillBytes(make([]byte, 32))}, {-3, key.Y.FillBytes(make([]byte, 32))}})
+			sign = func(message []byte) []byte {
+				digest := sha256.Sum256(message)
+				signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signature
+			}
+		case coseAlgEdDSA:
+			public, private, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			coseKey = cborEncode(cborMap{{1, 1}, {3, coseAlgEdDSA}, {-1, 6}, {-2, []byte(public)}})
+			sign = func(message []byte) []byte {
+				return ed25519.Sign(private, message)
+			}
+		case coseAlgRS256:
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			coseKey = cborEncode(cborMap{{1, 3}, {3, coseAlgRS256}, {-1, key.N.Bytes()}, {-2, big.NewInt(int64(key.E)).Bytes()}})
+			sign = func(message []byte) []byte {
+				digest := sha256.Sum256(message)
+				signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signature
+			}
+		}
+
+		f := &webauthnFixture{}
+		credentialID := randomBytes(t, 16)
+
+		// The EdDSA authenticator also returns extension outputs, as
+		// authenticators that support credProtect do
+		var extensions []byte
+		var extensionFlag byte
+		if alg == coseAlgEdDSA {
+			extensions = cborEncode(cborMap{{"credProtect", 2}})
+			extensionFlag = authDataExtensions
+		}
+
+		// Registration: attested credential data after the usual header
+		f.Registration.Challenge = randomBytes(t, 32)
+		f.Registration.ClientDataJSON = webauthnClientData("webauthn.create", f.Registration.Challenge)
+		authData := append(append([]byte{}, rpIDHash[:]...), authDataUserPresent|authDataUserVerified|authDataAttested|extensionFlag, 0, 0, 0, 0)
+		authData = append(authData, make([]byte, 16)...) // AAGUID
+		authData = binary.BigEndian.AppendUint16(authData, uint16(len(credentialID)))
+		authData = append(append(append(authData, credentialID...), coseKey...), extensions...)
+		f.Registration.AttestationObject = cborEncode(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}, {"authData", authData}})
+
+		// Sign-ins with the new credential
+		signIn := func(flags byte) webauthnAssertion {
+			a := webauthnAssertion{Challenge: randomBytes(t, 32), SignCount: 7}
+			a.ClientDataJSON = webauthnClientData("webauthn.get", a.Challenge)
+			a.AuthenticatorData = binary.BigEndian.AppendUint32(
+				append(append([]byte{}, rpIDHash[:]...), flags|extensionFlag), a.SignCount)
+			a.AuthenticatorData = append(a.AuthenticatorData, extensions...)
+			clientDataHash := sha256.Sum256(a.ClientDataJSON)
+			a.Signature = sign(append(append([]byte{}, a.AuthenticatorData...), clientDataHash[:]...))
+			return a
+		}
+		f.Assertion = signIn(authDataUserPresent | authDataUserVerified)
+		f.NotVerified = signIn(authDataUserPresent)
+		f.NotPresent = signIn(authDataUserVerified)
+
+		fixtures[name] = f
+	}
+
+	data, err := json.MarshalIndent(fixtures, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(webauthnFixturesPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(webauthnFixturesPath, append(data, '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::":    true,
+		"64:ff9b::5db8:d822":   true, // NAT64 of 93.184.216.34
+		"2002:5db8:d822::1":    true, // 6to4 of 93.184.216.34
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"100.127.255.254":      false,
+		"198.18.0.1":           false,
+		"198.19.255.255":       false,
+		"192.0.0.8":            false,
+		"192.0.2.1":            false,
+		"0.0.0.0":              false,
+		"255.255.255.255":      false,
+		"224.0.0.1":            false,
+		"::":                   false,
+		"::1":                  false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:10.0.0.1":      false,
+		"::127.0.0.1":          false,
+		"64:ff9b::7f00:1":      false, // NAT64 of 127.0.0.1
+		"64:ff9b::a9fe:a9fe":   false, // NAT64 of 169.254.169.254
+		"64:ff9b::6440:1":      false, // NAT64 of 100.64.0.1
+		"64:ff9b:1::a00:1":     false,
+		"2002:a00:1::1":        false, // 6to4 of 10.0.0.1
+		"2001:0:4136:e378::1":  false, // Teredo
+		"2001:db8::1":          false,
+		"fd00::1":              false,
+		"fe80::1":              false,
+		"ff02::1":              false,
+		"100::1":               false,
+		"fec0::1":              false,
+		"2001:4860:4860::8888": true,
+	}
+	for address, public := range tests {
+		if got := isPublicAddress(net.ParseIP(address)); got != public {
+			t.Errorf("isPublicAddress(%s) = %v, want %v", address, got, public)
+		}
+	}
+}